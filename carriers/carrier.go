@@ -0,0 +1,99 @@
+// Package carriers defines the interface every shipping carrier integration
+// implements and a registry the rest of the application looks carriers up
+// in.
+package carriers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+)
+
+var (
+	ErrUnknownCarrier = errors.New("unknown carrier")
+	ErrUnknownAWB     = errors.New("carrier does not know this tracking number")
+	ErrInvalidRequest = errors.New("carrier rejected the request")
+	ErrBadSignature   = errors.New("webhook signature is not valid")
+)
+
+// SignatureHeader is the request header webhook signatures are sent in.
+const SignatureHeader = "X-Webhook-Signature"
+
+// BookingRequest asks a carrier to pick up a parcel for an order.
+type BookingRequest struct {
+	OrderNumber string
+	Method      string
+	Address     *models.Address
+	Weight      uint
+	Value       money.Money
+}
+
+// Booking is a carrier's answer to a BookingRequest.
+type Booking struct {
+	AWB         string
+	TrackingURL string
+}
+
+// Update is a batch of tracking events for the shipment with AWB.
+// Carriers translate their own scan codes into models.ShipmentStatus.
+type Update struct {
+	AWB    string
+	Events []models.TrackingEvent
+}
+
+// Carrier is implemented by every carrier integration. Carriers that push
+// tracking events implement VerifyWebhook; Track is polled for the rest and
+// as a fallback for missed webhooks.
+type Carrier interface {
+	Name() string
+	Book(ctx context.Context, req BookingRequest) (Booking, error)
+	// Cancel withdraws a booking that will not be shipped, such as one the
+	// shipment could not be stored for.
+	Cancel(ctx context.Context, awb string) error
+	// Track returns every tracking event the carrier has for awb so far.
+	Track(ctx context.Context, awb string) ([]models.TrackingEvent, error)
+	VerifyWebhook(payload []byte, signature string) ([]Update, error)
+}
+
+var (
+	mu       sync.RWMutex
+	carriers = make(map[string]Carrier)
+)
+
+// Register makes c available under c.Name(), replacing any carrier
+// registered under the same name.
+func Register(c Carrier) {
+	mu.Lock()
+	defer mu.Unlock()
+	carriers[c.Name()] = c
+}
+
+func Lookup(name string) (Carrier, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := carriers[name]
+	if !ok {
+		return nil, ErrUnknownCarrier
+	}
+	return c, nil
+}
+
+// RegisterFromEnv registers the carriers enabled in the environment.
+// SIMULATED_CARRIER=true enables SimulatedCarrier, signing webhooks with
+// SIMULATED_CARRIER_WEBHOOK_SECRET and moving parcels on every
+// SIMULATED_CARRIER_STEP (a duration such as 2m, 10m by default).
+func RegisterFromEnv() {
+	if os.Getenv("SIMULATED_CARRIER") != "true" {
+		return
+	}
+	step, err := time.ParseDuration(os.Getenv("SIMULATED_CARRIER_STEP"))
+	if err != nil || step <= 0 {
+		step = 10 * time.Minute
+	}
+	Register(NewSimulatedCarrier(os.Getenv("SIMULATED_CARRIER_WEBHOOK_SECRET"), step))
+}
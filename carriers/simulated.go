@@ -0,0 +1,160 @@
+package carriers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+// simulatedJourney is the route every simulated parcel takes, one stop per
+// step after it was booked. Booking itself is recorded by the caller.
+var simulatedJourney = []struct {
+	status      models.ShipmentStatus
+	location    string
+	description string
+}{
+	{models.ShipmentPickedUp, "Seller warehouse", "Picked up by courier"},
+	{models.ShipmentInTransit, "Origin hub", "Arrived at origin hub"},
+	{models.ShipmentInTransit, "Destination hub", "Arrived at destination hub"},
+	{models.ShipmentOutForDelivery, "Destination hub", "Out for delivery"},
+	{models.ShipmentDelivered, "Customer address", "Delivered"},
+}
+
+// SimulatedCarrier is a carrier for local development and tests. It keeps
+// no state: the booking time is part of the AWB, and Track replays the
+// simulated journey up to the current step. Webhooks signed with Secret
+// can push any other event, such as a failed delivery.
+type SimulatedCarrier struct {
+	Secret string
+	Step   time.Duration
+	// Now is the clock Track reads; time.Now when nil.
+	Now func() time.Time
+}
+
+func NewSimulatedCarrier(secret string, step time.Duration) *SimulatedCarrier {
+	return &SimulatedCarrier{Secret: secret, Step: step}
+}
+
+func (s *SimulatedCarrier) Name() string {
+	return "simulated"
+}
+
+// Book hands out an AWB of the form SIM<unix seconds>-<4 digits>.
+func (s *SimulatedCarrier) Book(ctx context.Context, req BookingRequest) (Booking, error) {
+	if req.OrderNumber == "" {
+		return Booking{}, ErrInvalidRequest
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		return Booking{}, err
+	}
+	awb := fmt.Sprintf("SIM%d-%04d", s.now().Unix(), n.Int64())
+	return Booking{AWB: awb, TrackingURL: "https://simulated-carrier.local/track/" + awb}, nil
+}
+
+// Cancel accepts any AWB Book could have handed out; with no state kept,
+// there is nothing else to undo.
+func (s *SimulatedCarrier) Cancel(ctx context.Context, awb string) error {
+	if _, ok := s.bookedAt(awb); !ok {
+		return ErrUnknownAWB
+	}
+	return nil
+}
+
+func (s *SimulatedCarrier) Track(ctx context.Context, awb string) ([]models.TrackingEvent, error) {
+	booked, ok := s.bookedAt(awb)
+	if !ok {
+		return nil, ErrUnknownAWB
+	}
+	step := s.Step
+	if step <= 0 {
+		step = time.Minute
+	}
+	now := s.now()
+	events := make([]models.TrackingEvent, 0, len(simulatedJourney))
+	for i, stop := range simulatedJourney {
+		at := booked.Add(time.Duration(i+1) * step)
+		if at.After(now) {
+			break
+		}
+		events = append(events, models.TrackingEvent{
+			EventID:     awb + "-" + strconv.Itoa(i),
+			Status:      stop.status,
+			Location:    stop.location,
+			Description: stop.description,
+			At:          at,
+		})
+	}
+	return events, nil
+}
+
+// simulatedWebhook is the JSON body SimulatedCarrier sends to the webhook
+// endpoint.
+type simulatedWebhook struct {
+	AWB    string                 `json:"awb"`
+	Events []models.TrackingEvent `json:"events"`
+}
+
+// VerifyWebhook checks that signature is the hex HMAC-SHA256 of payload
+// under Secret and decodes the events.
+func (s *SimulatedCarrier) VerifyWebhook(payload []byte, signature string) ([]Update, error) {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || s.Secret == "" || !hmac.Equal(expected, s.sign(payload)) {
+		return nil, ErrBadSignature
+	}
+	var body simulatedWebhook
+	if err := json.Unmarshal(payload, &body); err != nil || body.AWB == "" {
+		return nil, ErrInvalidRequest
+	}
+	for i, event := range body.Events {
+		if event.EventID == "" || !event.Status.Valid() {
+			return nil, ErrInvalidRequest
+		}
+		if event.At.IsZero() {
+			body.Events[i].At = s.now()
+		}
+	}
+	return []Update{{AWB: body.AWB, Events: body.Events}}, nil
+}
+
+// Sign returns the signature SimulatedCarrier expects on payload, for
+// building webhook requests by hand.
+func (s *SimulatedCarrier) Sign(payload []byte) string {
+	return hex.EncodeToString(s.sign(payload))
+}
+
+func (s *SimulatedCarrier) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(s.Secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// bookedAt reads the booking time back out of awb.
+func (s *SimulatedCarrier) bookedAt(awb string) (time.Time, bool) {
+	seconds, _, found := strings.Cut(strings.TrimPrefix(awb, "SIM"), "-")
+	if !found || !strings.HasPrefix(awb, "SIM") {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+func (s *SimulatedCarrier) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
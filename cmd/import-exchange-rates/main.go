@@ -0,0 +1,87 @@
+// Command import-exchange-rates loads a table of exchange rates from a CSV
+// file of "currency,rate" lines, each rate being how much of the currency
+// one unit of the base currency buys. The table is in force from -date
+// until a later one is imported; importing a date again replaces it.
+//
+//	go run ./cmd/import-exchange-rates -file rates.csv
+//	go run ./cmd/import-exchange-rates -file rates.csv -date 2024-04-01 -base INR
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+func main() {
+	file := flag.String("file", "", "CSV file of currency,rate lines")
+	date := flag.String("date", "", "date the rates are in force from (YYYY-MM-DD), today by default")
+	base := flag.String("base", models.DefaultCurrency, "currency the rates are quoted against")
+	flag.Parse()
+
+	if *file == "" {
+		log.Fatal("file is required")
+	}
+	table := models.ExchangeRateTable{Base: *base, Source: *file}
+	if *date != "" {
+		day, err := time.Parse("2006-01-02", *date)
+		if err != nil {
+			log.Fatal("date must be a YYYY-MM-DD date")
+		}
+		table.EffectiveDate = day
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	table.Rates, err = readRates(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	table, err = database.ImportExchangeRates(ctx, database.Client.Database("Ecommerce").Collection("ExchangeRates"), table)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for currency, rate := range table.Rates {
+		log.Printf("1 %s = %s %s", table.Base, rate, currency)
+	}
+	log.Printf("imported %d rates in force from %s", len(table.Rates), table.EffectiveDate.Format("2006-01-02"))
+}
+
+// readRates reads "currency,rate" records, skipping blank lines, lines
+// starting with # and a "currency,rate" header.
+func readRates(r io.Reader) (map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	rates := make(map[string]string)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(record[0], "currency") {
+			continue
+		}
+		rates[record[0]] = record[1]
+	}
+}
@@ -0,0 +1,41 @@
+// Command migrate-categories builds the category tree out of the category
+// names products were filed under before there was one, and switches
+// return windows and promotions to category slugs. It is safe to run more
+// than once.
+//
+//	go run ./cmd/migrate-categories -dry-run
+//	go run ./cmd/migrate-categories
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/database"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "count the documents that need changing without changing them")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	counts, err := database.MigrateCategories(ctx, database.Client.Database("Ecommerce"), *dryRun)
+	collections := make([]string, 0, len(counts))
+	for collection := range counts {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	for _, collection := range collections {
+		if counts[collection] > 0 {
+			log.Printf("%s: %d", collection, counts[collection])
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,40 @@
+// Command migrate-money converts amounts stored as plain numbers, from
+// before amounts carried a currency, into minor units of the default
+// currency. It is safe to run more than once.
+//
+//	go run ./cmd/migrate-money -dry-run
+//	go run ./cmd/migrate-money
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/database"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "count the documents that need converting without changing them")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	counts, err := database.MigrateMoneyFields(ctx, database.Client.Database("Ecommerce"), *dryRun)
+	fields := make([]string, 0, len(counts))
+	for field := range counts {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if counts[field] > 0 {
+			log.Printf("%s: %d", field, counts[field])
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
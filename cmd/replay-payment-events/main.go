@@ -0,0 +1,72 @@
+// Command replay-payment-events reprocesses stored payment webhook events,
+// for example after fixing a bug that made them fail. Processing is
+// idempotent, so replaying an event that already succeeded is harmless.
+//
+//	go run ./cmd/replay-payment-events -failed
+//	go run ./cmd/replay-payment-events -provider fake -since 2024-01-31
+//	go run ./cmd/replay-payment-events -provider fake -event evt_123
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/payments"
+)
+
+func main() {
+	provider := flag.String("provider", "", "only replay events from this provider")
+	eventID := flag.String("event", "", "only replay the event with this provider event id")
+	since := flag.String("since", "", "only replay events received on or after this date (YYYY-MM-DD)")
+	failed := flag.Bool("failed", false, "only replay events whose last processing failed")
+	dryRun := flag.Bool("dry-run", false, "list the matching events without processing them")
+	flag.Parse()
+
+	filter := database.PaymentEventFilter{
+		Provider:   *provider,
+		EventID:    *eventID,
+		FailedOnly: *failed,
+	}
+	if *since != "" {
+		day, err := time.Parse("2006-01-02", *since)
+		if err != nil {
+			log.Fatal("since must be a YYYY-MM-DD date")
+		}
+		filter.Since = day
+	}
+
+	payments.RegisterFromEnv()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	db := database.Client.Database("Ecommerce")
+	orders := db.Collection("Orders")
+	intents := db.Collection("PaymentIntents")
+	ledger := db.Collection("Ledger")
+	events := db.Collection("PaymentEvents")
+
+	found, err := database.FindPaymentEvents(ctx, events, filter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var failures int
+	for i := range found {
+		event := &found[i]
+		if *dryRun {
+			log.Printf("%s %s %s %s (attempts %d) %s", event.Provider, event.EventID, event.Type, event.Reference, event.Attempts, event.ProcessError)
+			continue
+		}
+		if err := database.ProcessPaymentEvent(ctx, orders, intents, ledger, events, event); err != nil {
+			failures++
+			log.Printf("%s %s: %v", event.Provider, event.EventID, err)
+			continue
+		}
+		log.Printf("%s %s: processed", event.Provider, event.EventID)
+	}
+	log.Printf("%d events, %d failed", len(found), failures)
+}
@@ -171,6 +171,18 @@ func EditHomeAddress() gin.HandlerFunc {
 						Key:   "address.0.pincode",
 						Value: editaddress.Pincode,
 					},
+					bson.E{
+						Key:   "address.0.state",
+						Value: editaddress.State,
+					},
+					bson.E{
+						Key:   "address.0.country",
+						Value: editaddress.Country,
+					},
+					bson.E{
+						Key:   "address.0.postal_code",
+						Value: editaddress.Postcode,
+					},
 				},
 			},
 		}
@@ -236,6 +248,18 @@ func EditWorkAddress() gin.HandlerFunc {
 						Key:   "address.1.pincode",
 						Value: editaddress.Pincode,
 					},
+					bson.E{
+						Key:   "address.1.state",
+						Value: editaddress.State,
+					},
+					bson.E{
+						Key:   "address.1.country",
+						Value: editaddress.Country,
+					},
+					bson.E{
+						Key:   "address.1.postal_code",
+						Value: editaddress.Postcode,
+					},
 				},
 			},
 		}
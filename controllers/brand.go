@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+// brandErrorStatus maps the brand errors of package database to an HTTP
+// status.
+func brandErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidBrand):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindBrand),
+		errors.Is(err, database.ErrCantFindProduct):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrDuplicateBrand):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func ListBrands() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		brands, err := database.ListBrands(ctx, BrandCollection)
+		if err != nil {
+			c.IndentedJSON(brandErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"brands": brands})
+	}
+}
+
+// BrowseBrand is a brand's page: the brand and its products, priced and
+// with their availability like SearchProduct.
+func BrowseBrand() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		currency, err := database.ResolveCurrency(c.GetHeader(models.CurrencyHeader), "")
+		if err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		brand, products, total, err := database.ListBrandProducts(ctx, ProductCollection, BrandCollection, c.Param("slug"), page, limit)
+		if err != nil {
+			c.IndentedJSON(brandErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.PriceProducts(ctx, Pricing.Rates, currency, products); err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.AddAvailability(ctx, InventoryCollection, products); err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"brand":    brand,
+			"products": products,
+			"page":     page,
+			"limit":    limit,
+			"total":    total,
+		})
+	}
+}
+
+func (app *Application) CreateBrand() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.Brand
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		brand, err := database.CreateBrand(ctx, app.brandCollection, body)
+		if err != nil {
+			c.IndentedJSON(brandErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, brand)
+	}
+}
+
+func (app *Application) UpdateBrand() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.Brand
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		brand, err := database.UpdateBrand(ctx, app.brandCollection, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(brandErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, brand)
+	}
+}
+
+type productBrandRequest struct {
+	BrandID string `json:"brand_id"`
+}
+
+// SetProductBrand sets the brand a product is sold under, or clears it
+// with an empty brand_id.
+func (app *Application) SetProductBrand() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body productBrandRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		product, err := database.SetProductBrand(ctx, app.prodCollection, app.brandCollection, c.Param("id"), body.BrandID)
+		if err != nil {
+			c.IndentedJSON(brandErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, product)
+	}
+}
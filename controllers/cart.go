@@ -10,20 +10,71 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/kshzz24/ecomm-go/database"
 	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/promotions"
+	"github.com/kshzz24/ecomm-go/shipping"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Application struct {
-	prodCollection *mongo.Collection
-	userCollection *mongo.Collection
+	prodCollection  *mongo.Collection
+	userCollection  *mongo.Collection
+	orderCollection *mongo.Collection
+
+	returnCollection        *mongo.Collection
+	returnWindowCollection  *mongo.Collection
+	intentCollection        *mongo.Collection
+	paymentEventCollection  *mongo.Collection
+	refundCollection        *mongo.Collection
+	ledgerCollection        *mongo.Collection
+	invoiceCollection       *mongo.Collection
+	shipmentCollection      *mongo.Collection
+	inventoryCollection     *mongo.Collection
+	warehouseCollection     *mongo.Collection
+	transferCollection      *mongo.Collection
+	movementCollection      *mongo.Collection
+	countCollection         *mongo.Collection
+	replenishmentCollection *mongo.Collection
+	supplierCollection      *mongo.Collection
+	purchaseOrderCollection *mongo.Collection
+	categoryCollection      *mongo.Collection
+	brandCollection         *mongo.Collection
+	collectionCollection    *mongo.Collection
+	pricing                 database.PricingCollections
+	wallets                 database.WalletCollections
 }
 
+// NewApplication wires the handlers to prodCollection and userCollection.
+// The remaining collections are taken from the same database.
 func NewApplication(prodCollection, userCollection *mongo.Collection) *Application {
+	db := userCollection.Database()
 	return &Application{
-		prodCollection: prodCollection,
-		userCollection: userCollection,
+		prodCollection:  prodCollection,
+		userCollection:  userCollection,
+		orderCollection: db.Collection("Orders"),
+
+		returnCollection:        db.Collection("Returns"),
+		returnWindowCollection:  db.Collection("ReturnWindows"),
+		intentCollection:        db.Collection("PaymentIntents"),
+		paymentEventCollection:  db.Collection("PaymentEvents"),
+		refundCollection:        db.Collection("Refunds"),
+		ledgerCollection:        db.Collection("Ledger"),
+		invoiceCollection:       db.Collection("Invoices"),
+		shipmentCollection:      db.Collection("Shipments"),
+		inventoryCollection:     db.Collection("Inventory"),
+		warehouseCollection:     db.Collection("Warehouses"),
+		transferCollection:      db.Collection("Transfers"),
+		movementCollection:      db.Collection("StockMovements"),
+		countCollection:         db.Collection("CycleCounts"),
+		replenishmentCollection: db.Collection("Replenishment"),
+		supplierCollection:      db.Collection("Suppliers"),
+		purchaseOrderCollection: db.Collection("PurchaseOrders"),
+		categoryCollection:      db.Collection("Categories"),
+		brandCollection:         db.Collection("Brands"),
+		collectionCollection:    db.Collection("Collections"),
+		pricing:                 database.NewPricingCollections(db),
+		wallets:                 database.NewWalletCollections(db),
 	}
 }
 
@@ -37,14 +88,6 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 			return
 		}
 
-		userQueryId := c.Query("userId")
-		if userQueryId == "" {
-			log.Println("User id empty")
-
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("User id is empty"))
-			return
-		}
-
 		productId, err := primitive.ObjectIDFromHex(productQueryID)
 		if err != nil {
 			log.Println(err)
@@ -52,14 +95,28 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
+		variantId, err := variantQuery(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		err = database.AddProductToCart(ctx, app.prodCollection, app.userCollection, productId, userQueryId)
+		err = database.AddProductToCart(ctx, app.prodCollection, app.userCollection, app.inventoryCollection, productId, variantId, c.GetString("uid"))
 
+		if errors.Is(err, database.ErrOutOfStock) {
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, database.ErrCantFindProduct) || errors.Is(err, database.ErrCantFindVariant) || errors.Is(err, database.ErrVariantRequired) {
+			c.IndentedJSON(variantErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, err)
+			return
 		}
 
 		c.IndentedJSON(200, "successfully added to card")
@@ -77,14 +134,6 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 			return
 		}
 
-		userQueryId := c.Query("userId")
-		if userQueryId == "" {
-			log.Println("User id empty")
-
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("User id is empty"))
-			return
-		}
-
 		productId, err := primitive.ObjectIDFromHex(productQueryID)
 		if err != nil {
 			log.Println(err)
@@ -92,10 +141,15 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
+		variantId, err := variantQuery(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		err = database.RemoveCartItem(ctx, app.prodCollection, app.userCollection, productId, userQueryId)
+		err = database.RemoveCartItem(ctx, app.prodCollection, app.userCollection, productId, variantId, c.GetString("uid"))
 
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, err)
@@ -108,63 +162,50 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 
 func GetItemFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("userId")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "invalid id",
-			})
-			c.Abort()
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": database.ErrUserIdIsNotValid.Error()})
 			return
 		}
 
-		usert_id, _ := primitive.ObjectIDFromHex(user_id)
-
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
 		var filledcart models.User
 
-		err := UserCollection.FindOne(ctx, bson.D{{Key: "_id", Value: usert_id}}).Decode(&filledcart)
+		err = UserCollection.FindOne(ctx, bson.D{{Key: "_id", Value: usert_id}}).Decode(&filledcart)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(500, "not found")
 			return
 		}
 
-		filterMatch := bson.D{{
-			Key:   "$match",
-			Value: bson.D{{Key: "_id", Value: usert_id}},
-		}}
-
-		unwind := bson.D{{
-			Key:   "$unwind",
-			Value: bson.D{{Key: "path", Value: "$usercart"}},
-		}}
-
-		grouping := bson.D{{
-			Key: "$group",
-			Value: bson.D{
-				{Key: "_id", Value: "$_id"},
-				{Key: "total", Value: bson.D{{Key: "$sum", Value: "$usercart.price"}}},
-			},
-		}}
-
-		cursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{filterMatch, unwind, grouping})
+		currency, err := database.ResolveCurrency(c.GetHeader(models.CurrencyHeader), filledcart.Currency)
 		if err != nil {
-			log.Println(err)
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		var listing []bson.M
-		if err = cursor.All(ctx, &listing); err != nil {
-			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+		// The cart is priced like checkout prices it, shipping, promotions,
+		// coupons and tax included, so the total shown is what is charged.
+		priced, err := database.PriceCart(ctx, Pricing, filledcart, currency, c.Query("addressId"), c.QueryArray("coupon"), c.Query("shipping_method"))
+		if err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
 
-		for _, json := range listing {
-			c.IndentedJSON(200, json["total"])
-			c.IndentedJSON(200, filledcart.UserCart)
-		}
+		c.IndentedJSON(200, gin.H{
+			"currency":        priced.Currency,
+			"items":           priced.Items,
+			"subtotal":        priced.Subtotal,
+			"discount":        priced.Discount,
+			"promotions":      priced.Promotions,
+			"shipping":        priced.Shipping,
+			"shipping_method": priced.ShippingMethod,
+			"tax":             priced.Tax,
+			"tax_breakdown":   priced.TaxBreakdown,
+			"tax_inclusive":   priced.TaxInclusive,
+			"total":           priced.Total,
+		})
 
 		ctx.Done()
 	}
@@ -172,22 +213,25 @@ func GetItemFromCart() gin.HandlerFunc {
 
 func (app *Application) BuyFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userQueryId := c.Query("userId")
-		if userQueryId == "" {
-			log.Panic("User id empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("User id is empty"))
+		var checkout models.CheckoutRequest
+		if err := c.ShouldBind(&checkout); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if checkout.Currency == "" {
+			checkout.Currency = c.GetHeader(models.CurrencyHeader)
+		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		err := database.BuyItemFromCart(ctx, app.userCollection, userQueryId)
+		order, err := database.BuyItemFromCart(ctx, app.userCollection, app.orderCollection, app.ledgerCollection, app.pricing, app.wallets, c.GetString("uid"), checkout)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
 
-		c.IndentedJSON(http.StatusOK, "Successfully placed the order")
+		app.collectPayment(ctx, c, order, checkout)
 
 	}
 }
@@ -202,14 +246,6 @@ func (app *Application) Instantbuy() gin.HandlerFunc {
 			return
 		}
 
-		userQueryId := c.Query("userId")
-		if userQueryId == "" {
-			log.Println("User id empty")
-
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("User id is empty"))
-			return
-		}
-
 		productId, err := primitive.ObjectIDFromHex(productQueryID)
 		if err != nil {
 			log.Println(err)
@@ -217,16 +253,96 @@ func (app *Application) Instantbuy() gin.HandlerFunc {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
+		variantId, err := variantQuery(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var checkout models.CheckoutRequest
+		if err := c.ShouldBind(&checkout); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if checkout.Currency == "" {
+			checkout.Currency = c.GetHeader(models.CurrencyHeader)
+		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		err = database.InstantBuyer(ctx, app.prodCollection, app.userCollection, productId, userQueryId)
-
+		order, err := database.InstantBuyer(ctx, app.prodCollection, app.userCollection, app.orderCollection, app.ledgerCollection, app.pricing, app.wallets, productId, variantId, c.GetString("uid"), checkout)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		app.collectPayment(ctx, c, order, checkout)
+	}
+}
+
+// collectPayment starts the online payment of what store credit did not
+// cover on a freshly placed order and writes the checkout response. Cash on
+// delivery and fully paid orders are returned as is.
+func (app *Application) collectPayment(ctx context.Context, c *gin.Context, order *models.Order, checkout models.CheckoutRequest) {
+	if order.PaymentMethod.Method != models.PaymentOnline || order.AmountDue().IsZero() {
+		app.issueInvoices(ctx, order.ID)
+		c.IndentedJSON(http.StatusOK, gin.H{"order": order})
+		return
+	}
+
+	intent, err := database.StartPayment(ctx, app.orderCollection, app.intentCollection, app.ledgerCollection, order, checkout.PaymentToken)
+	if errors.Is(err, database.ErrPaymentDeclined) {
+		// The declined order was cancelled; give back any store credit
+		// spent on it.
+		if cancelled, err := database.GetOrder(ctx, app.orderCollection, order.ID.Hex()); err == nil {
+			order = &cancelled
+			app.refundLatestCancellation(ctx, order)
 		}
+		c.IndentedJSON(http.StatusPaymentRequired, gin.H{"error": err.Error(), "order": order, "payment": intent})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "order": order, "payment": intent})
+		return
+	}
+	app.issueInvoices(ctx, order.ID)
+
+	c.IndentedJSON(http.StatusOK, gin.H{"order": order, "payment": intent})
+}
 
-		c.IndentedJSON(200, "successfully placed the order")
+// checkoutErrorStatus maps the errors returned by the checkout functions in
+// package database to an HTTP status.
+func checkoutErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrCartIsEmpty),
+		errors.Is(err, database.ErrUserIdIsNotValid),
+		errors.Is(err, database.ErrUnknownPaymentMethod),
+		errors.Is(err, database.ErrGiftCardUnusable),
+		errors.Is(err, database.ErrInvalidAmount),
+		errors.Is(err, database.ErrUnsupportedCurrency),
+		errors.Is(err, database.ErrPromotionUsedUp),
+		errors.Is(err, promotions.ErrUnknownCoupon),
+		errors.Is(err, promotions.ErrCouponNotApplicable),
+		errors.Is(err, promotions.ErrCouponsDontStack),
+		errors.Is(err, shipping.ErrUnknownMethod),
+		errors.Is(err, database.ErrVariantRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrNoExchangeRate),
+		errors.Is(err, shipping.ErrNotShippable),
+		errors.Is(err, shipping.ErrMethodUnavailable):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, database.ErrOutOfStock):
+		return http.StatusConflict
+	case errors.Is(err, database.ErrInsufficientFunds):
+		return http.StatusPaymentRequired
+	case errors.Is(err, database.ErrCantFindUser),
+		errors.Is(err, database.ErrCantFindProduct),
+		errors.Is(err, database.ErrCantFindVariant),
+		errors.Is(err, database.ErrCantFindAddress),
+		errors.Is(err, database.ErrCantFindGiftCard):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
 	}
 }
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+// categoryErrorStatus maps the category errors of package database to an
+// HTTP status.
+func categoryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidCategory),
+		errors.Is(err, database.ErrInvalidAttribute):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindCategory),
+		errors.Is(err, database.ErrCantFindProduct):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrDuplicateCategory),
+		errors.Is(err, database.ErrCategoryCycle),
+		errors.Is(err, database.ErrCategoryInUse):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ListCategories returns the whole category tree, each category right
+// after its parent.
+func ListCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		categories, err := database.ListCategories(ctx, CategoryCollection)
+		if err != nil {
+			c.IndentedJSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"categories": categories})
+	}
+}
+
+// GetCategory returns a category with its ancestors, for breadcrumbs.
+func GetCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		category, ancestors, err := database.GetCategory(ctx, CategoryCollection, c.Param("slug"))
+		if err != nil {
+			c.IndentedJSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"category": category, "ancestors": ancestors})
+	}
+}
+
+// BrowseCategory lists the products of a category and of every category
+// below it, priced and with their availability like SearchProduct.
+func BrowseCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		currency, err := database.ResolveCurrency(c.GetHeader(models.CurrencyHeader), "")
+		if err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		category, products, total, err := database.ListCategoryProducts(ctx, ProductCollection, CategoryCollection, c.Param("slug"), page, limit)
+		if err != nil {
+			c.IndentedJSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.PriceProducts(ctx, Pricing.Rates, currency, products); err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.AddAvailability(ctx, InventoryCollection, products); err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"category": category,
+			"products": products,
+			"page":     page,
+			"limit":    limit,
+			"total":    total,
+		})
+	}
+}
+
+func (app *Application) CreateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.CategoryRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		category, err := database.CreateCategory(ctx, app.categoryCollection, body)
+		if err != nil {
+			c.IndentedJSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, category)
+	}
+}
+
+// UpdateCategory renames a category, replaces its attributes or moves it
+// under another parent, taking its subcategories along.
+func (app *Application) UpdateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.CategoryRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		category, err := database.UpdateCategory(ctx, app.categoryCollection, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, category)
+	}
+}
+
+func (app *Application) DeleteCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.DeleteCategory(ctx, app.categoryCollection, app.prodCollection, c.Param("id")); err != nil {
+			c.IndentedJSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// SetProductCategories lists a product in categories and sets the
+// attributes they ask for.
+func (app *Application) SetProductCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.ProductCategoriesRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		product, err := database.SetProductCategories(ctx, app.prodCollection, app.categoryCollection, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, product)
+	}
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/rules"
+)
+
+// collectionErrorStatus maps the collection errors of package database to
+// an HTTP status.
+func collectionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidCollection),
+		errors.Is(err, rules.ErrInvalidRule):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindCollection),
+		errors.Is(err, database.ErrCantFindProduct),
+		errors.Is(err, database.ErrCantFindCategory),
+		errors.Is(err, database.ErrCantFindBrand):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrDuplicateCollection):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ListCollections lists the collections customers can see now, newest
+// first.
+func ListCollections() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		collections, total, err := database.ListCollections(ctx, CollectionCollection, true, page, limit)
+		if err != nil {
+			c.IndentedJSON(collectionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"collections": collections,
+			"page":        page,
+			"limit":       limit,
+			"total":       total,
+		})
+	}
+}
+
+// BrowseCollection lists the products of a published collection, priced
+// and with their availability like SearchProduct.
+func BrowseCollection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		currency, err := database.ResolveCurrency(c.GetHeader(models.CurrencyHeader), "")
+		if err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		collection, products, total, err := database.ListCollectionProducts(ctx, CollectionCollection, ProductCollection, c.Param("slug"), page, limit)
+		if err != nil {
+			c.IndentedJSON(collectionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.PriceProducts(ctx, Pricing.Rates, currency, products); err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.AddAvailability(ctx, InventoryCollection, products); err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"collection": collection,
+			"products":   products,
+			"page":       page,
+			"limit":      limit,
+			"total":      total,
+		})
+	}
+}
+
+// AdminListCollections lists every collection, drafts and scheduled ones
+// included.
+func (app *Application) AdminListCollections() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		collections, total, err := database.ListCollections(ctx, app.collectionCollection, false, page, limit)
+		if err != nil {
+			c.IndentedJSON(collectionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"collections": collections,
+			"page":        page,
+			"limit":       limit,
+			"total":       total,
+		})
+	}
+}
+
+// PreviewCollection lists a collection's products as customers would see
+// them, whether or not it is published.
+func (app *Application) PreviewCollection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		collection, products, total, err := database.PreviewCollection(ctx, app.collectionCollection, app.prodCollection, c.Param("id"), page, limit)
+		if err != nil {
+			c.IndentedJSON(collectionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"collection": collection,
+			"products":   products,
+			"page":       page,
+			"limit":      limit,
+			"total":      total,
+		})
+	}
+}
+
+func (app *Application) CreateCollection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.Collection
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		collection, err := database.CreateCollection(ctx, app.collectionCollection, app.prodCollection, body)
+		if err != nil {
+			c.IndentedJSON(collectionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, collection)
+	}
+}
+
+func (app *Application) UpdateCollection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.Collection
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		collection, err := database.UpdateCollection(ctx, app.collectionCollection, app.prodCollection, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(collectionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, collection)
+	}
+}
+
+// SetCollectionPublished publishes a collection now or takes it down,
+// depending on published.
+func (app *Application) SetCollectionPublished(published bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		collection, err := database.SetCollectionPublished(ctx, app.collectionCollection, c.Param("id"), published)
+		if err != nil {
+			c.IndentedJSON(collectionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, collection)
+	}
+}
+
+func (app *Application) DeleteCollection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.DeleteCollection(ctx, app.collectionCollection, c.Param("id")); err != nil {
+			c.IndentedJSON(collectionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
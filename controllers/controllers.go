@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -23,6 +24,11 @@ import (
 var Validate = validator.New()
 var UserCollection *mongo.Collection = database.UserData(database.Client, "Users")
 var ProductCollection *mongo.Collection = database.ProductData(database.Client, "Products")
+var InventoryCollection *mongo.Collection = database.ProductData(database.Client, "Inventory")
+var CategoryCollection *mongo.Collection = database.ProductData(database.Client, "Categories")
+var BrandCollection *mongo.Collection = database.ProductData(database.Client, "Brands")
+var CollectionCollection *mongo.Collection = database.ProductData(database.Client, "Collections")
+var Pricing = database.NewPricingCollections(database.Client.Database("Ecommerce"))
 
 func HashPassword(password string) string {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
@@ -80,7 +86,7 @@ func Login() gin.HandlerFunc {
 			fmt.Println(msg)
 			return
 		}
-		token, refreshToken, _ := generate.TokenGenerator(founduser.Email, founduser.FirstName, founduser.LastName, founduser.UserID)
+		token, refreshToken, _ := generate.TokenGenerator(founduser.Email, founduser.FirstName, founduser.LastName, founduser.UserID, founduser.UserType)
 		defer cancel()
 		generate.UpdateAllTokens(token, refreshToken, founduser.UserID)
 		c.JSON(http.StatusFound, founduser)
@@ -153,14 +159,15 @@ func Signup() gin.HandlerFunc {
 
 		user.ID = primitive.NewObjectID()
 		user.UserID = user.ID.Hex()
+		// Admins are promoted directly in the database, never at signup.
+		user.UserType = models.UserTypeUser
 
-		token, refreshtoken, _ := generate.TokenGenerator(user.Email, user.FirstName, user.LastName, user.UserID)
+		token, refreshtoken, _ := generate.TokenGenerator(user.Email, user.FirstName, user.LastName, user.UserID, user.UserType)
 
 		user.Token = token
 		user.RefreshToken = refreshtoken
 		user.UserCart = make([]models.ProductUser, 0)
 		user.AddressDetails = make([]models.Address, 0)
-		user.OrderStatus = make([]models.Order, 0)
 
 		_, inserterr := UserCollection.InsertOne(ctx, user)
 
@@ -184,6 +191,12 @@ func SearchProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var productlist []models.Product
 
+		currency, err := database.ResolveCurrency(c.GetHeader(models.CurrencyHeader), "")
+		if err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
 		defer cancel()
@@ -209,6 +222,15 @@ func SearchProduct() gin.HandlerFunc {
 			c.IndentedJSON(400, "invalid")
 			return
 		}
+
+		if err := database.PriceProducts(ctx, Pricing.Rates, currency, productlist); err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.AddAvailability(ctx, InventoryCollection, productlist); err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
 		c.IndentedJSON(200, productlist)
 
 	}
@@ -232,10 +254,21 @@ func SearchProductByQuery() gin.HandlerFunc {
 			return
 		}
 
+		currency, err := database.ResolveCurrency(c.GetHeader(models.CurrencyHeader), "")
+		if err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		searchQuerydb, err := ProductCollection.Find(ctx, bson.M{"product_name": bson.M{"$regex": queryParam}})
+		// A SKU or barcode finds the product it is a variant of.
+		searchQuerydb, err := ProductCollection.Find(ctx, bson.M{"$or": bson.A{
+			bson.M{"product_name": bson.M{"$regex": queryParam}},
+			bson.M{"variants.sku": strings.ToUpper(strings.TrimSpace(queryParam))},
+			bson.M{"variants.barcode": strings.TrimSpace(queryParam)},
+		}})
 
 		if err != nil {
 			c.IndentedJSON(404, "something went wrong while fetching data")
@@ -258,6 +291,14 @@ func SearchProductByQuery() gin.HandlerFunc {
 			return
 		}
 
+		if err := database.PriceProducts(ctx, Pricing.Rates, currency, searchproducts); err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.AddAvailability(ctx, InventoryCollection, searchproducts); err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
 		c.IndentedJSON(200, searchproducts)
 
 	}
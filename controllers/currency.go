@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/promotions"
+	"github.com/kshzz24/ecomm-go/shipping"
+)
+
+// pricingErrorStatus maps the currency, exchange-rate and promotion errors
+// of cart pricing to an HTTP status.
+func pricingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrUnsupportedCurrency),
+		errors.Is(err, database.ErrInvalidPrices),
+		errors.Is(err, database.ErrUserIdIsNotValid),
+		errors.Is(err, database.ErrPromotionUsedUp),
+		errors.Is(err, promotions.ErrUnknownCoupon),
+		errors.Is(err, promotions.ErrCouponNotApplicable),
+		errors.Is(err, promotions.ErrCouponsDontStack),
+		errors.Is(err, shipping.ErrUnknownMethod):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindProduct),
+		errors.Is(err, database.ErrCantFindUser),
+		errors.Is(err, database.ErrCantFindAddress):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrNoExchangeRate),
+		errors.Is(err, shipping.ErrNotShippable),
+		errors.Is(err, shipping.ErrMethodUnavailable):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// SetCurrency changes the currency the signed-in user is priced in when a
+// request carries no X-Currency header.
+func (app *Application) SetCurrency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.CurrencyPreference
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.SetPreferredCurrency(ctx, app.userCollection, c.GetString("uid"), body.Currency); err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"currency": body.Currency})
+	}
+}
+
+// ListExchangeRates lets admins page through the imported exchange-rate
+// tables.
+func (app *Application) ListExchangeRates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		tables, total, err := database.ListExchangeRates(ctx, app.pricing.Rates, page, limit)
+		if err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"exchange_rates": tables, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// productPricesRequest is the body of SetProductPrices.
+type productPricesRequest struct {
+	Prices []money.Money `json:"prices"`
+}
+
+// SetProductPrices lets admins replace a product's explicit per-currency
+// prices.
+func (app *Application) SetProductPrices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body productPricesRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		product, err := database.SetProductPrices(ctx, app.prodCollection, c.Param("id"), body.Prices)
+		if err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, product)
+	}
+}
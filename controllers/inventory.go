@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+)
+
+// inventoryErrorStatus maps the inventory errors of package database to an
+// HTTP status.
+func inventoryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidMovement),
+		errors.Is(err, database.ErrInvalidCount),
+		errors.Is(err, database.ErrInvalidWarehouse),
+		errors.Is(err, database.ErrInvalidTransfer),
+		errors.Is(err, database.ErrVariantRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindProduct),
+		errors.Is(err, database.ErrCantFindVariant),
+		errors.Is(err, database.ErrCantFindStock),
+		errors.Is(err, database.ErrCantFindWarehouse),
+		errors.Is(err, database.ErrCantFindTransfer):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrStockBelowReserved),
+		errors.Is(err, database.ErrStockDrift),
+		errors.Is(err, database.ErrOutOfStock),
+		errors.Is(err, database.ErrDuplicateWarehouse),
+		errors.Is(err, database.ErrIllegalTransferTransition):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ListInventory lists stock records, least available first. It accepts
+// warehouse to list one warehouse's stock.
+func (app *Application) ListInventory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		stock, total, err := database.ListInventory(ctx, app.inventoryCollection, c.Query("warehouse"), page, limit)
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"inventory": stock,
+			"page":      page,
+			"limit":     limit,
+			"total":     total,
+		})
+	}
+}
+
+// GetStock shows a product's stock of each variant in every warehouse, and
+// how much of it can be sold.
+func (app *Application) GetStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		stock, available, err := database.GetStock(ctx, app.inventoryCollection, c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"stock": stock, "available": available})
+	}
+}
+
+// RecordMovement records a receipt, adjustment or damage against a
+// product's stock in a warehouse.
+func (app *Application) RecordMovement() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.MovementRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		movement, err := database.RecordMovement(ctx, app.prodCollection, app.inventoryCollection, c.Param("id"), body, c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, movement)
+	}
+}
+
+// ListMovements lists a product's stock movements, newest first. It
+// accepts variant and warehouse to list one variant's or one warehouse's
+// movements.
+func (app *Application) ListMovements() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		movements, total, err := database.ListMovements(ctx, app.movementCollection, c.Param("id"), c.Query("variant"), c.Query("warehouse"), page, limit)
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"movements": movements,
+			"page":      page,
+			"limit":     limit,
+			"total":     total,
+		})
+	}
+}
+
+// CountStock reconciles a cycle count of a warehouse against the ledger
+// and reports the discrepancies.
+func (app *Application) CountStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.CountRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		count, err := database.CountStock(ctx, app.inventoryCollection, app.countCollection, c.Param("id"), body, c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, count)
+	}
+}
+
+func (app *Application) ListCycleCounts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		counts, total, err := database.ListCycleCounts(ctx, app.countCollection, c.Param("id"), page, limit)
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"counts": counts,
+			"page":   page,
+			"limit":  limit,
+			"total":  total,
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/invoices"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// invoiceErrorStatus maps the invoice errors of package database to an
+// HTTP status.
+func invoiceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrOrderIdIsNotValid):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindOrder),
+		errors.Is(err, database.ErrCantFindInvoice):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrOrderNotPaid),
+		errors.Is(err, database.ErrNothingToInvoice),
+		errors.Is(err, database.ErrOrderChanged):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// issueInvoices issues the invoice and credit notes an order has just
+// become due. Failures are logged and left to the background job.
+func (app *Application) issueInvoices(ctx context.Context, orderID primitive.ObjectID) {
+	if err := database.IssueOrderInvoices(ctx, app.invoiceCollection, app.orderCollection, app.refundCollection, orderID); err != nil {
+		log.Println(err)
+	}
+}
+
+// ListInvoices lists the invoice and credit notes of one of the customer's
+// orders.
+func (app *Application) ListInvoices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		found, err := database.ListOrderInvoices(ctx, app.invoiceCollection, c.Param("id"), c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(invoiceErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, found)
+	}
+}
+
+// DownloadInvoice sends one of the customer's invoices or credit notes as
+// a PDF.
+func (app *Application) DownloadInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		invoice, err := database.GetInvoice(ctx, app.invoiceCollection, c.Param("id"), c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(invoiceErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="`+invoices.Filename(invoice)+`"`)
+		c.Data(http.StatusOK, "application/pdf", invoice.PDF)
+	}
+}
+
+func (app *Application) AdminListInvoices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		found, err := database.ListOrderInvoices(ctx, app.invoiceCollection, c.Param("id"), "")
+		if err != nil {
+			c.IndentedJSON(invoiceErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, found)
+	}
+}
+
+func (app *Application) AdminDownloadInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		invoice, err := database.GetInvoice(ctx, app.invoiceCollection, c.Param("id"), "")
+		if err != nil {
+			c.IndentedJSON(invoiceErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="`+invoices.Filename(invoice)+`"`)
+		c.Data(http.StatusOK, "application/pdf", invoice.PDF)
+	}
+}
+
+// IssueInvoice lets admins issue a paid order's invoice now rather than
+// wait for the background job. An order already invoiced gets its
+// existing invoice back.
+func (app *Application) IssueInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": database.ErrOrderIdIsNotValid.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		invoice, err := database.IssueInvoice(ctx, app.invoiceCollection, app.orderCollection, orderID)
+		if err != nil {
+			c.IndentedJSON(invoiceErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, invoice)
+	}
+}
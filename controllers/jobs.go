@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/database"
+)
+
+// RunJobs runs the background housekeeping every interval until ctx is
+// done: expiring gift cards and stock reservations of unpaid orders, paying
+// back cancellations whose refund is still pending, issuing invoices and
+// credit notes that were not issued when they fell due, polling carriers
+// for tracking events, snapshotting stock that moved and flagging products
+// that run low.
+func (app *Application) RunJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		app.runJobsOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *Application) runJobsOnce(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, time.Minute)
+	defer cancel()
+
+	if expired, err := database.ExpireGiftCards(ctx, app.wallets); err != nil {
+		log.Println(err)
+	} else if expired > 0 {
+		log.Printf("expired %d gift cards", expired)
+	}
+	if expired, err := database.ExpireReservations(ctx, app.orderCollection); err != nil {
+		log.Println(err)
+	} else if expired > 0 {
+		log.Printf("cancelled %d orders left unpaid", expired)
+	}
+	if refunded, err := database.RefundPendingCancellations(ctx, app.orderCollection, app.intentCollection, app.refundCollection, app.ledgerCollection, app.wallets); err != nil {
+		log.Println(err)
+	} else if refunded > 0 {
+		log.Printf("refunded %d pending cancellations", refunded)
+	}
+	if issued, err := database.IssuePendingInvoices(ctx, app.invoiceCollection, app.orderCollection, app.refundCollection); err != nil {
+		log.Println(err)
+	} else if issued > 0 {
+		log.Printf("issued %d invoices and credit notes", issued)
+	}
+	if tracked, err := database.PollShipments(ctx, app.shipmentCollection, app.orderCollection); err != nil {
+		log.Println(err)
+	} else if tracked > 0 {
+		log.Printf("recorded %d tracking events", tracked)
+	}
+	if taken, err := database.SnapshotStock(ctx, app.inventoryCollection); err != nil {
+		log.Println(err)
+	} else if taken > 0 {
+		log.Printf("snapshotted %d stock records", taken)
+	}
+	if flagged, err := database.CheckStockLevels(ctx, app.replenishmentCollection, app.orderCollection, app.inventoryCollection, app.prodCollection); err != nil {
+		log.Println(err)
+	} else if flagged > 0 {
+		log.Printf("flagged %d products low on stock", flagged)
+	}
+}
@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// maxPage keeps the number of skipped documents, (page-1)*limit,
+	// within an int64.
+	maxPage = math.MaxInt64 / maxPageSize
+)
+
+// pagination reads the 1-based page and limit query parameters, falling back
+// to the first page of defaultPageSize and capping page at maxPage and limit
+// at maxPageSize.
+func pagination(c *gin.Context) (page, limit int64) {
+	page, err := strconv.ParseInt(c.Query("page"), 10, 64)
+	if err != nil || page < 1 {
+		page = 1
+	}
+	if page > maxPage {
+		page = maxPage
+	}
+	limit, err = strconv.ParseInt(c.Query("limit"), 10, 64)
+	if err != nil || limit < 1 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return page, limit
+}
+
+func (app *Application) ListOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orders, total, err := database.ListUserOrders(ctx, app.orderCollection, c.GetString("uid"), page, limit)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"orders": orders,
+			"page":   page,
+			"limit":  limit,
+			"total":  total,
+		})
+	}
+}
+
+// orderView is an order as the order detail endpoints show it: the order's
+// own fields with the fulfilment of each line and its shipments, with
+// their tracking timelines, alongside.
+type orderView struct {
+	models.Order
+	Fulfilment []models.LineFulfilment `json:"fulfilment"`
+	Shipments  []models.Shipment       `json:"shipments"`
+}
+
+func (app *Application) GetOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.GetUserOrder(ctx, app.orderCollection, c.GetString("uid"), c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(orderErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		shipments, err := database.ListOrderShipments(ctx, app.shipmentCollection, order.ID, order.UserID)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, orderView{Order: order, Fulfilment: order.Fulfilment(), Shipments: shipments})
+	}
+}
+
+// SearchOrders lets admins look through every order. It accepts status,
+// from and to (YYYY-MM-DD, both inclusive) and customer, which is either a
+// user id or an email address.
+func (app *Application) SearchOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := models.OrderFilter{Status: models.OrderStatus(c.Query("status"))}
+		if filter.Status != "" && !filter.Status.Valid() {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "unknown order status"})
+			return
+		}
+
+		if from := c.Query("from"); from != "" {
+			day, err := time.Parse("2006-01-02", from)
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "from must be a YYYY-MM-DD date"})
+				return
+			}
+			filter.From = day
+		}
+		if to := c.Query("to"); to != "" {
+			day, err := time.Parse("2006-01-02", to)
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "to must be a YYYY-MM-DD date"})
+				return
+			}
+			filter.To = day.AddDate(0, 0, 1)
+		}
+
+		if warehouse := c.Query("warehouse"); warehouse != "" {
+			id, err := primitive.ObjectIDFromHex(warehouse)
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "warehouse must be a warehouse id"})
+				return
+			}
+			filter.WarehouseID = id
+		}
+
+		if customer := c.Query("customer"); customer != "" {
+			if _, err := primitive.ObjectIDFromHex(customer); err == nil {
+				filter.UserID = customer
+			} else {
+				var user models.User
+				err := app.userCollection.FindOne(ctx, bson.M{"email": customer}).Decode(&user)
+				if err != nil {
+					c.IndentedJSON(http.StatusOK, gin.H{"orders": []models.Order{}, "page": page, "limit": limit, "total": 0})
+					return
+				}
+				filter.UserID = user.UserID
+			}
+		}
+
+		orders, total, err := database.SearchOrders(ctx, app.orderCollection, filter, page, limit)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"orders": orders,
+			"page":   page,
+			"limit":  limit,
+			"total":  total,
+		})
+	}
+}
+
+func (app *Application) AdminGetOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.GetOrder(ctx, app.orderCollection, c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(orderErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		shipments, err := database.ListOrderShipments(ctx, app.shipmentCollection, order.ID, "")
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, orderView{Order: order, Fulfilment: order.Fulfilment(), Shipments: shipments})
+	}
+}
+
+type statusUpdate struct {
+	Status models.OrderStatus `json:"status"`
+	Note   string             `json:"note"`
+}
+
+// UpdateOrderStatus lets admins move an order along its lifecycle. Moves
+// the lifecycle does not allow are rejected with 409 Conflict, and
+// cancelling, returning or refunding with 400 Bad Request, since those go
+// through their own endpoints.
+func (app *Application) UpdateOrderStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body statusUpdate
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !body.Status.Valid() {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "unknown order status"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.AdvanceOrderStatus(ctx, app.orderCollection, app.ledgerCollection, c.Param("id"), body.Status, c.GetString("uid"), body.Note)
+		if err != nil {
+			c.IndentedJSON(orderErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if order.Status == models.OrderPaid {
+			app.issueInvoices(ctx, order.ID)
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+// orderErrorStatus maps the order errors returned by package database to an
+// HTTP status.
+func orderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrOrderIdIsNotValid),
+		errors.Is(err, database.ErrInvalidCancelLine),
+		errors.Is(err, database.ErrNotManualStatus):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindOrder):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrIllegalTransition),
+		errors.Is(err, database.ErrOrderChanged),
+		errors.Is(err, database.ErrCantCancelOrder),
+		errors.Is(err, database.ErrNothingToCancel):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// cancelRequest is the optional body of the cancel endpoints. Leaving out
+// lines cancels everything still active on the order.
+type cancelRequest struct {
+	Lines  []models.CancelLine `json:"lines"`
+	Reason string              `json:"reason"`
+}
+
+func bindCancelRequest(c *gin.Context) (cancelRequest, bool) {
+	var body cancelRequest
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return body, false
+	}
+	return body, true
+}
+
+func (app *Application) CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, ok := bindCancelRequest(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.CustomerCancelOrder(ctx, app.orderCollection, c.GetString("uid"), c.Param("id"), body.Lines, body.Reason)
+		if err != nil {
+			c.IndentedJSON(orderErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		app.refundLatestCancellation(ctx, &order)
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+func (app *Application) AdminCancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, ok := bindCancelRequest(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.AdminCancelOrder(ctx, app.orderCollection, c.Param("id"), body.Lines, c.GetString("uid"), body.Reason)
+		if err != nil {
+			c.IndentedJSON(orderErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		app.refundLatestCancellation(ctx, &order)
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/payments"
+)
+
+type confirmRequest struct {
+	Response string `json:"response"`
+}
+
+// paymentErrorStatus maps the payment errors of package database to an
+// HTTP status.
+func paymentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrIntentIdIsNotValid):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindIntent):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrIntentNotConfirmable):
+		return http.StatusConflict
+	case errors.Is(err, database.ErrPaymentDeclined):
+		return http.StatusPaymentRequired
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (app *Application) GetPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		intent, err := database.GetUserIntent(ctx, app.intentCollection, c.GetString("uid"), c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(paymentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, intent)
+	}
+}
+
+// ConfirmPayment completes a payment that is waiting on a 3-D Secure
+// challenge.
+func (app *Application) ConfirmPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body confirmRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		intent, err := database.ConfirmPayment(ctx, app.orderCollection, app.intentCollection, app.ledgerCollection, c.GetString("uid"), c.Param("id"), body.Response)
+		if err != nil {
+			c.IndentedJSON(paymentErrorStatus(err), gin.H{"error": err.Error(), "payment": intent})
+			return
+		}
+		app.issueInvoices(ctx, intent.OrderID)
+
+		c.IndentedJSON(http.StatusOK, intent)
+	}
+}
+
+// refundLatestCancellation pays back the cancellation just added to order.
+// A failed refund is recorded on the cancellation for staff to retry and
+// does not fail the request.
+func (app *Application) refundLatestCancellation(ctx context.Context, order *models.Order) {
+	if len(order.Cancellations) == 0 {
+		return
+	}
+	latest := order.Cancellations[len(order.Cancellations)-1]
+	if err := database.RefundCancellation(ctx, app.orderCollection, app.intentCollection, app.refundCollection, app.ledgerCollection, app.wallets, order, latest.ID); err != nil {
+		log.Println(err)
+	}
+	app.issueInvoices(ctx, order.ID)
+}
+
+// PaymentWebhook receives asynchronous payment results from a provider. It
+// is mounted outside the authenticated routes; the provider's signature is
+// what authenticates the request. Events that fail to process are still
+// acknowledged, since they are stored and can be replayed.
+func (app *Application) PaymentWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payload, err := c.GetRawData()
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		event, err := database.ReceivePaymentWebhook(ctx, app.orderCollection, app.intentCollection, app.ledgerCollection, app.paymentEventCollection,
+			c.Param("provider"), payload, c.GetHeader(payments.SignatureHeader))
+		switch {
+		case err == nil:
+			c.IndentedJSON(http.StatusOK, gin.H{"status": "processed", "event_id": event.EventID})
+		case errors.Is(err, payments.ErrUnknownProvider):
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, payments.ErrBadSignature):
+			c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, payments.ErrInvalidRequest):
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, database.ErrDuplicateEvent):
+			c.IndentedJSON(http.StatusOK, gin.H{"status": "duplicate", "event_id": event.EventID})
+		case errors.Is(err, database.ErrCantStoreEvent):
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		default:
+			log.Println(err)
+			c.IndentedJSON(http.StatusOK, gin.H{"status": "stored", "event_id": event.EventID, "error": err.Error()})
+		}
+	}
+}
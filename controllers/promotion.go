@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+// promotionErrorStatus maps the promotion errors of package database to an
+// HTTP status.
+func promotionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidPromotion),
+		errors.Is(err, database.ErrUnsupportedCurrency):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindPromotion):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrDuplicateCoupon):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CreatePromotion lets admins add a coupon or an automatic promotion.
+func (app *Application) CreatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.Promotion
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		promotion, err := database.CreatePromotion(ctx, app.pricing, body, c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(promotionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, promotion)
+	}
+}
+
+// ListPromotions lets admins page through the promotions and their use.
+func (app *Application) ListPromotions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		found, total, err := database.ListPromotions(ctx, app.pricing, page, limit)
+		if err != nil {
+			c.IndentedJSON(promotionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"promotions": found, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// SetPromotionActive returns a handler switching a promotion on or off.
+func (app *Application) SetPromotionActive(active bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		promotion, err := database.SetPromotionActive(ctx, app.pricing, c.Param("id"), active)
+		if err != nil {
+			c.IndentedJSON(promotionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, promotion)
+	}
+}
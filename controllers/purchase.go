@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// purchaseErrorStatus maps the supplier and purchase order errors of
+// package database to an HTTP status.
+func purchaseErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidSupplier),
+		errors.Is(err, database.ErrInvalidPurchaseOrder),
+		errors.Is(err, database.ErrInvalidReceipt),
+		errors.Is(err, database.ErrVariantRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindSupplier),
+		errors.Is(err, database.ErrCantFindPurchaseOrder),
+		errors.Is(err, database.ErrCantFindProduct),
+		errors.Is(err, database.ErrCantFindVariant),
+		errors.Is(err, database.ErrCantFindWarehouse):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrDuplicateSupplier),
+		errors.Is(err, database.ErrPurchaseOrderNotDraft),
+		errors.Is(err, database.ErrIllegalPurchaseOrderTransition):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ListSuppliers lists suppliers by name. It accepts product to list only
+// the suppliers selling it.
+func (app *Application) ListSuppliers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		suppliers, total, err := database.ListSuppliers(ctx, app.supplierCollection, c.Query("product"), page, limit)
+		if err != nil {
+			c.IndentedJSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"suppliers": suppliers,
+			"page":      page,
+			"limit":     limit,
+			"total":     total,
+		})
+	}
+}
+
+func (app *Application) CreateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.Supplier
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		supplier, err := database.CreateSupplier(ctx, app.supplierCollection, body)
+		if err != nil {
+			c.IndentedJSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, supplier)
+	}
+}
+
+func (app *Application) UpdateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.Supplier
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		supplier, err := database.UpdateSupplier(ctx, app.supplierCollection, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, supplier)
+	}
+}
+
+// ListPurchaseOrders lists purchase orders, newest first. It accepts
+// status, supplier and product filters, and overdue=true to list open
+// orders past their expected delivery.
+func (app *Application) ListPurchaseOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		filter := models.PurchaseOrderFilter{
+			Status:  models.PurchaseOrderStatus(c.Query("status")),
+			Overdue: c.Query("overdue") == "true",
+		}
+		if supplier := c.Query("supplier"); supplier != "" {
+			id, err := primitive.ObjectIDFromHex(supplier)
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "supplier must be a supplier id"})
+				return
+			}
+			filter.SupplierID = id
+		}
+		if product := c.Query("product"); product != "" {
+			id, err := primitive.ObjectIDFromHex(product)
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "product must be a product id"})
+				return
+			}
+			filter.ProductID = id
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orders, total, err := database.ListPurchaseOrders(ctx, app.purchaseOrderCollection, filter, page, limit)
+		if err != nil {
+			c.IndentedJSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"purchase_orders": orders,
+			"page":            page,
+			"limit":           limit,
+			"total":           total,
+		})
+	}
+}
+
+func (app *Application) GetPurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.GetPurchaseOrder(ctx, app.purchaseOrderCollection, c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+// CreatePurchaseOrder raises a draft purchase order.
+func (app *Application) CreatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.PurchaseOrderRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.CreatePurchaseOrder(ctx, app.prodCollection, app.purchaseOrderCollection, body, c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, order)
+	}
+}
+
+// UpdatePurchaseOrder replaces a draft purchase order.
+func (app *Application) UpdatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.PurchaseOrderRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.UpdatePurchaseOrder(ctx, app.prodCollection, app.purchaseOrderCollection, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+// SetPurchaseOrderStatus sends a purchase order to its supplier or closes
+// it.
+func (app *Application) SetPurchaseOrderStatus(status models.PurchaseOrderStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.SetPurchaseOrderStatus(ctx, app.purchaseOrderCollection, c.Param("id"), status)
+		if err != nil {
+			c.IndentedJSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+// ReceivePurchaseOrder books a delivery against a purchase order into its
+// warehouse's stock.
+func (app *Application) ReceivePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.ReceiptRequest
+		if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.ReceivePurchaseOrder(ctx, app.purchaseOrderCollection, c.Param("id"), body, c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(purchaseErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// refundErrorStatus maps the refund errors of package database to an HTTP
+// status.
+func refundErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrOrderIdIsNotValid),
+		errors.Is(err, database.ErrInvalidRefund):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindOrder):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrOrderNotPaid),
+		errors.Is(err, database.ErrRefundExceedsPaid):
+		return http.StatusConflict
+	case errors.Is(err, database.ErrCantRefund):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// IssueRefund lets admins refund an order in full, per line, its shipping
+// only, or by a goodwill amount, either to the original payment or as store
+// credit.
+func (app *Application) IssueRefund() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": database.ErrOrderIdIsNotValid.Error()})
+			return
+		}
+		var req models.RefundRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		refund, err := database.IssueRefund(ctx, app.orderCollection, app.intentCollection, app.refundCollection, app.ledgerCollection, app.wallets,
+			orderID, req, c.GetString("uid"), "admin")
+		if err != nil {
+			c.IndentedJSON(refundErrorStatus(err), gin.H{"error": err.Error(), "refund": refund})
+			return
+		}
+		app.issueInvoices(ctx, orderID)
+
+		c.IndentedJSON(http.StatusCreated, refund)
+	}
+}
+
+func (app *Application) ListRefunds() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		refunds, err := database.ListOrderRefunds(ctx, app.refundCollection, c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(refundErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, refunds)
+	}
+}
+
+// OrderLedger lists every money movement on an order with the running
+// balance still held for it.
+func (app *Application) OrderLedger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entries, err := database.ListOrderLedger(ctx, app.ledgerCollection, c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(refundErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		var balance money.Money
+		for _, entry := range entries {
+			balance = balance.Add(entry.Amount)
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"entries": entries, "balance": balance})
+	}
+}
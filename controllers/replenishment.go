@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+)
+
+// replenishmentErrorStatus maps the replenishment errors of package
+// database to an HTTP status.
+func replenishmentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidReorderPolicy),
+		errors.Is(err, database.ErrVariantRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindProduct),
+		errors.Is(err, database.ErrCantFindVariant):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// SetReorderPolicy sets a product's reorder point, lead time and minimum
+// order quantity.
+func (app *Application) SetReorderPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.ReorderPolicy
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		replenishment, err := database.SetReorderPolicy(ctx, app.prodCollection, app.replenishmentCollection, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(replenishmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, replenishment)
+	}
+}
+
+// ListReorderSuggestions lists the products at or below their reorder
+// point with how many of each to order, those running out soonest first.
+// It accepts all=true to list every tracked product.
+func (app *Application) ListReorderSuggestions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		suggestions, total, err := database.ListReorderSuggestions(ctx, app.replenishmentCollection, c.Query("all") != "true", page, limit)
+		if err != nil {
+			c.IndentedJSON(replenishmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"suggestions": suggestions,
+			"page":        page,
+			"limit":       limit,
+			"total":       total,
+		})
+	}
+}
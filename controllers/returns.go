@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+type returnRequest struct {
+	Lines []models.ReturnLine `json:"lines"`
+}
+
+type returnAction struct {
+	Lines      []models.ReturnLine `json:"lines"`
+	Resolution models.ReturnStatus `json:"resolution"`
+	Note       string              `json:"note"`
+}
+
+// returnErrorStatus maps the return errors of package database to an HTTP
+// status.
+func returnErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrOrderIdIsNotValid),
+		errors.Is(err, database.ErrReturnIdIsNotValid),
+		errors.Is(err, database.ErrInvalidReturnLine),
+		errors.Is(err, database.ErrInvalidReturnReason):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindOrder),
+		errors.Is(err, database.ErrCantFindReturn):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrOrderNotDelivered),
+		errors.Is(err, database.ErrReturnWindowClosed),
+		errors.Is(err, database.ErrIllegalReturnTransition),
+		errors.Is(err, database.ErrOutOfStock),
+		errors.Is(err, database.ErrOrderChanged):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (app *Application) CreateReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body returnRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		rma, err := database.CreateReturn(ctx, app.orderCollection, app.returnCollection, app.returnWindowCollection, c.GetString("uid"), c.Param("id"), body.Lines)
+		if err != nil {
+			c.IndentedJSON(returnErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, rma)
+	}
+}
+
+func (app *Application) ListReturns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		returns, total, err := database.ListUserReturns(ctx, app.returnCollection, c.GetString("uid"), page, limit)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"returns": returns, "page": page, "limit": limit, "total": total})
+	}
+}
+
+func (app *Application) GetReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		rma, err := database.GetUserReturn(ctx, app.returnCollection, c.GetString("uid"), c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(returnErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, rma)
+	}
+}
+
+func (app *Application) SearchReturns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		status := models.ReturnStatus(c.Query("status"))
+		if status != "" && !status.Valid() {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "unknown return status"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		returns, total, err := database.SearchReturns(ctx, app.returnCollection, status, page, limit)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"returns": returns, "page": page, "limit": limit, "total": total})
+	}
+}
+
+func (app *Application) AdminGetReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		rma, err := database.GetReturn(ctx, app.returnCollection, c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(returnErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, rma)
+	}
+}
+
+// AdvanceReturn returns a handler that moves a return to next: approve,
+// reject or receive.
+func (app *Application) AdvanceReturn(next models.ReturnStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body returnAction
+		if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		rma, err := database.AdvanceReturn(ctx, app.orderCollection, app.returnCollection, c.Param("id"), next, c.GetString("uid"), body.Note)
+		if err != nil {
+			c.IndentedJSON(returnErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, rma)
+	}
+}
+
+func (app *Application) InspectReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body returnAction
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		rma, err := database.InspectReturn(ctx, app.returnCollection, c.Param("id"), body.Lines, c.GetString("uid"), body.Note)
+		if err != nil {
+			c.IndentedJSON(returnErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, rma)
+	}
+}
+
+func (app *Application) ResolveReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body returnAction
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		rma, err := database.ResolveReturn(ctx, app.orderCollection, app.returnCollection, c.Param("id"), body.Resolution, c.GetString("uid"), body.Note)
+		if err != nil {
+			c.IndentedJSON(returnErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		// A failed refund is recorded on the return for staff to retry.
+		if err = database.RefundReturn(ctx, app.orderCollection, app.returnCollection, app.intentCollection, app.refundCollection, app.ledgerCollection, app.wallets, &rma); err != nil {
+			log.Println(err)
+		}
+		app.issueInvoices(ctx, rma.OrderID)
+
+		c.IndentedJSON(http.StatusOK, rma)
+	}
+}
+
+func (app *Application) ListReturnWindows() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		windows, err := database.ListReturnWindows(ctx, app.returnWindowCollection)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"default_days": models.DefaultReturnWindowDays, "windows": windows})
+	}
+}
+
+func (app *Application) SetReturnWindow() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var window models.ReturnWindow
+		if err := c.BindJSON(&window); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		window.Category = c.Param("category")
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.SetReturnWindow(ctx, app.returnWindowCollection, &window); err != nil {
+			c.IndentedJSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, window)
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/carriers"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+// shipmentErrorStatus maps the shipment errors of packages database and
+// carriers to an HTTP status.
+func shipmentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrOrderIdIsNotValid),
+		errors.Is(err, database.ErrInvalidShipment),
+		errors.Is(err, database.ErrInvalidShipmentLine),
+		errors.Is(err, carriers.ErrUnknownCarrier):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindOrder):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrOrderNotPacked),
+		errors.Is(err, database.ErrNothingToShip),
+		errors.Is(err, database.ErrCantBackorder),
+		errors.Is(err, database.ErrOrderChanged),
+		errors.Is(err, database.ErrDuplicateAWB):
+		return http.StatusConflict
+	case errors.Is(err, database.ErrCantCreateShipment):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CreateShipment hands lines of a packed order to a carrier. Without an
+// awb in the body the parcel is booked with the carrier; without lines it
+// takes everything not yet shipped or backordered.
+func (app *Application) CreateShipment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.ShipmentRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		shipment, err := database.CreateShipment(ctx, app.shipmentCollection, app.orderCollection, c.Param("id"), body, c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(shipmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, shipment)
+	}
+}
+
+type backorderRequest struct {
+	Lines []models.ShipmentLine `json:"lines"`
+}
+
+// SetBackorders marks quantities of an order's lines as waiting for stock.
+func (app *Application) SetBackorders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body backorderRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.SetBackorders(ctx, app.orderCollection, c.Param("id"), body.Lines)
+		if err != nil {
+			c.IndentedJSON(shipmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, orderView{Order: order, Fulfilment: order.Fulfilment()})
+	}
+}
+
+// CarrierWebhook receives tracking events pushed by a carrier.
+func (app *Application) CarrierWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payload, err := c.GetRawData()
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		recorded, err := database.ReceiveCarrierWebhook(ctx, app.shipmentCollection, app.orderCollection,
+			c.Param("carrier"), payload, c.GetHeader(carriers.SignatureHeader))
+		switch {
+		case err == nil:
+			c.IndentedJSON(http.StatusOK, gin.H{"status": "processed", "recorded": recorded})
+		case errors.Is(err, carriers.ErrUnknownCarrier),
+			errors.Is(err, database.ErrCantFindShipment):
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, carriers.ErrBadSignature):
+			c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, carriers.ErrInvalidRequest):
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	}
+}
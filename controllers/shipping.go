@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+// shippingErrorStatus maps the shipping zone errors of package database to
+// an HTTP status.
+func shippingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidShippingZone),
+		errors.Is(err, database.ErrUnsupportedCurrency):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindShippingZone):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ShippingRates lists the shipping methods the signed-in user's cart can
+// ship to an address by, with their prices. It accepts addressId, and the
+// currency like the cart.
+func (app *Application) ShippingRates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		quotes, err := database.QuoteShipping(ctx, app.userCollection, app.pricing, c.GetString("uid"), c.GetHeader(models.CurrencyHeader), c.Query("addressId"))
+		if err != nil {
+			c.IndentedJSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"rates": quotes})
+	}
+}
+
+func (app *Application) ListShippingZones() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		zones, total, err := database.ListShippingZones(ctx, app.pricing, page, limit)
+		if err != nil {
+			c.IndentedJSON(shippingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"zones": zones,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
+
+func (app *Application) CreateShippingZone() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.ShippingZone
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		zone, err := database.CreateShippingZone(ctx, app.pricing, body)
+		if err != nil {
+			c.IndentedJSON(shippingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, zone)
+	}
+}
+
+func (app *Application) UpdateShippingZone() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.ShippingZone
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		zone, err := database.UpdateShippingZone(ctx, app.pricing, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(shippingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, zone)
+	}
+}
+
+func (app *Application) DeleteShippingZone() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.DeleteShippingZone(ctx, app.pricing, c.Param("id")); err != nil {
+			c.IndentedJSON(shippingErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// variantErrorStatus maps the variant errors of package database to an
+// HTTP status.
+func variantErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidVariants),
+		errors.Is(err, database.ErrVariantRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindProduct),
+		errors.Is(err, database.ErrCantFindVariant):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrDuplicateSKU),
+		errors.Is(err, database.ErrVariantHasStock):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// variantQuery parses the optional variant query parameter naming the
+// variant of a product with variants.
+func variantQuery(c *gin.Context) (primitive.ObjectID, error) {
+	variant := c.Query("variant")
+	if variant == "" {
+		return primitive.NilObjectID, nil
+	}
+	id, err := primitive.ObjectIDFromHex(variant)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("variant must be a variant id")
+	}
+	return id, nil
+}
+
+// SetProductVariants replaces a product's options and variants.
+func (app *Application) SetProductVariants() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.VariantsRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		product, err := database.SetProductVariants(ctx, app.prodCollection, app.inventoryCollection, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(variantErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, product)
+	}
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+// walletErrorStatus maps the wallet and gift card errors of package
+// database to an HTTP status.
+func walletErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidAmount),
+		errors.Is(err, database.ErrUnknownPaymentMethod),
+		errors.Is(err, database.ErrGiftCardUnusable):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCantFindGiftCard):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrInsufficientFunds),
+		errors.Is(err, database.ErrPaymentDeclined),
+		errors.Is(err, database.ErrPaymentNotSettled):
+		return http.StatusPaymentRequired
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GetWallet returns the customer's store-credit balance and a page of its
+// history.
+func (app *Application) GetWallet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wallet, err := database.GetWallet(ctx, app.wallets, c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		entries, total, err := database.ListWalletEntries(ctx, app.wallets, c.GetString("uid"), page, limit)
+		if err != nil {
+			c.IndentedJSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"wallet": wallet, "entries": entries, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// TopUpWallet adds store credit paid for through a provider.
+func (app *Application) TopUpWallet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.StoredValuePurchase
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wallet, intent, err := database.TopUpWallet(ctx, app.intentCollection, app.wallets, c.GetString("uid"), body)
+		if err != nil {
+			c.IndentedJSON(walletErrorStatus(err), gin.H{"error": err.Error(), "payment": intent})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"wallet": wallet, "payment": intent})
+	}
+}
+
+// PurchaseGiftCard sells the customer a gift card paid for through a
+// provider.
+func (app *Application) PurchaseGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.StoredValuePurchase
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		card, intent, err := database.PurchaseGiftCard(ctx, app.intentCollection, app.wallets, c.GetString("uid"), body)
+		if err != nil {
+			c.IndentedJSON(walletErrorStatus(err), gin.H{"error": err.Error(), "payment": intent})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, gin.H{"gift_card": card, "payment": intent})
+	}
+}
+
+// GetGiftCard shows the balance and expiry of a gift card to whoever holds
+// its code.
+func (app *Application) GetGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		card, err := database.GetGiftCard(ctx, app.wallets, c.Param("code"))
+		if err != nil {
+			c.IndentedJSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"code": card.Code, "balance": card.Balance, "currency": card.Currency, "status": card.Status, "expires_at": card.ExpiresAt})
+	}
+}
+
+// RedeemGiftCard moves a gift card's balance into the customer's wallet.
+func (app *Application) RedeemGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wallet, err := database.RedeemGiftCard(ctx, app.wallets, c.GetString("uid"), c.Param("code"))
+		if err != nil {
+			c.IndentedJSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, wallet)
+	}
+}
+
+// IssueGiftCard lets admins issue a promotional gift card.
+func (app *Application) IssueGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.StoredValuePurchase
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		card, err := database.IssueGiftCard(ctx, app.wallets, body.Amount, body.ExpiresInDays, c.GetString("uid"), "", models.AccountPromotions)
+		if err != nil {
+			c.IndentedJSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, card)
+	}
+}
+
+// CreditWallet lets admins grant a customer promotional store credit.
+func (app *Application) CreditWallet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.StoredValuePurchase
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wallet, err := database.CreditWallet(ctx, app.wallets, c.Param("user"), body.Amount, models.AccountPromotions, database.WalletPromotion, body.Reason)
+		if err != nil {
+			c.IndentedJSON(walletErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, wallet)
+	}
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+func (app *Application) ListWarehouses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		warehouses, total, err := database.ListWarehouses(ctx, app.warehouseCollection, page, limit)
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"warehouses": warehouses,
+			"page":       page,
+			"limit":      limit,
+			"total":      total,
+		})
+	}
+}
+
+func (app *Application) CreateWarehouse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.Warehouse
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		warehouse, err := database.CreateWarehouse(ctx, app.warehouseCollection, body)
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, warehouse)
+	}
+}
+
+func (app *Application) UpdateWarehouse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body models.Warehouse
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		warehouse, err := database.UpdateWarehouse(ctx, app.warehouseCollection, c.Param("id"), body)
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, warehouse)
+	}
+}
+
+// ListTransfers lists stock transfers, newest first. It accepts status to
+// list only transfers in it.
+func (app *Application) ListTransfers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit := pagination(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		transfers, total, err := database.ListTransfers(ctx, app.transferCollection, c.Query("status"), page, limit)
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"transfers": transfers,
+			"page":      page,
+			"limit":     limit,
+			"total":     total,
+		})
+	}
+}
+
+// CreateTransfer sends units of a product from one warehouse to another.
+func (app *Application) CreateTransfer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body database.TransferRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		transfer, err := database.CreateTransfer(ctx, app.inventoryCollection, app.transferCollection, body, c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, transfer)
+	}
+}
+
+// SettleTransfer receives a transfer in transit at its destination or,
+// with models.TransferCancelled, sends its units back to the source.
+func (app *Application) SettleTransfer(status models.TransferStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		settle := database.ReceiveTransfer
+		if status == models.TransferCancelled {
+			settle = database.CancelTransfer
+		}
+		transfer, err := settle(ctx, app.inventoryCollection, app.transferCollection, c.Param("id"), c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(inventoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, transfer)
+	}
+}
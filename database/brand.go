@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindBrand   = errors.New("cannot find the requested brand")
+	ErrInvalidBrand    = errors.New("brand is not valid")
+	ErrDuplicateBrand  = errors.New("a brand with this slug already exists")
+	ErrCantUpdateBrand = errors.New("cannot update brand")
+	ErrCantListBrands  = errors.New("cannot list brands")
+)
+
+// brands is the collection brands are kept in, next to their products.
+func brands(db *mongo.Database) *mongo.Collection {
+	return db.Collection("Brands")
+}
+
+func findBrand(ctx context.Context, brandCollection *mongo.Collection, filter bson.M) (models.Brand, error) {
+	var brand models.Brand
+	err := brandCollection.FindOne(ctx, filter).Decode(&brand)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return brand, ErrCantFindBrand
+	}
+	if err != nil {
+		log.Println(err)
+		return brand, ErrCantFindBrand
+	}
+	return brand, nil
+}
+
+// ListBrands returns every brand by name.
+func ListBrands(ctx context.Context, brandCollection *mongo.Collection) ([]models.Brand, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := brandCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListBrands
+	}
+	list := make([]models.Brand, 0)
+	if err = cursor.All(ctx, &list); err != nil {
+		log.Println(err)
+		return nil, ErrCantListBrands
+	}
+	return list, nil
+}
+
+// CreateBrand stores a new brand. Its slug is made from its name unless
+// one is given.
+func CreateBrand(ctx context.Context, brandCollection *mongo.Collection, brand models.Brand) (models.Brand, error) {
+	if brand.Slug == "" {
+		brand.Slug = brand.Name
+	}
+	brand.Slug = Slugify(brand.Slug)
+	if err := normalizeBrand(&brand); err != nil || brand.Slug == "" {
+		return brand, ErrInvalidBrand
+	}
+	brand.ID = primitive.NewObjectID()
+	brand.CreatedAt = time.Now()
+	brand.UpdatedAt = brand.CreatedAt
+
+	_, err := brandCollection.InsertOne(ctx, brand)
+	if mongo.IsDuplicateKeyError(err) {
+		return brand, ErrDuplicateBrand
+	}
+	if err != nil {
+		log.Println(err)
+		return brand, ErrCantUpdateBrand
+	}
+	return brand, nil
+}
+
+// UpdateBrand replaces a brand's name, description and logo. Its slug
+// stays as it was, since collection rules name brands by it.
+func UpdateBrand(ctx context.Context, brandCollection *mongo.Collection, brandID string, brand models.Brand) (models.Brand, error) {
+	id, err := primitive.ObjectIDFromHex(brandID)
+	if err != nil {
+		return brand, ErrCantFindBrand
+	}
+	if err = normalizeBrand(&brand); err != nil {
+		return brand, err
+	}
+	update := bson.M{"$set": bson.M{
+		"name":        brand.Name,
+		"description": brand.Description,
+		"logo":        brand.Logo,
+		"updated_at":  time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = brandCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&brand)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return brand, ErrCantFindBrand
+	}
+	if err != nil {
+		log.Println(err)
+		return brand, ErrCantUpdateBrand
+	}
+	return brand, nil
+}
+
+func normalizeBrand(brand *models.Brand) error {
+	brand.Name = strings.TrimSpace(brand.Name)
+	brand.Description = strings.TrimSpace(brand.Description)
+	brand.Logo = strings.TrimSpace(brand.Logo)
+	if brand.Name == "" {
+		return ErrInvalidBrand
+	}
+	return nil
+}
+
+// SetProductBrand sets the brand a product is sold under; an empty
+// brandID clears it.
+func SetProductBrand(ctx context.Context, prodCollection, brandCollection *mongo.Collection, productID, brandID string) (models.Product, error) {
+	id, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return models.Product{}, ErrCantFindProduct
+	}
+	update := bson.M{"$unset": bson.M{"brand_id": ""}}
+	if brandID != "" {
+		bid, err := primitive.ObjectIDFromHex(brandID)
+		if err != nil {
+			return models.Product{}, ErrCantFindBrand
+		}
+		brand, err := findBrand(ctx, brandCollection, bson.M{"_id": bid})
+		if err != nil {
+			return models.Product{}, err
+		}
+		update = bson.M{"$set": bson.M{"brand_id": brand.ID}}
+	}
+	var product models.Product
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = prodCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return product, ErrCantFindProduct
+	}
+	if err != nil {
+		log.Println(err)
+		return product, ErrCantUpdateProduct
+	}
+	return product, nil
+}
+
+// ListBrandProducts returns the brand with slug and one page (1-based) of
+// its products by name, together with the total number of them.
+func ListBrandProducts(ctx context.Context, prodCollection, brandCollection *mongo.Collection, slug string, page, limit int64) (models.Brand, []models.Product, int64, error) {
+	brand, err := findBrand(ctx, brandCollection, bson.M{"slug": Slugify(slug)})
+	if err != nil {
+		return brand, nil, 0, err
+	}
+	products, total, err := findProducts(ctx, prodCollection, bson.M{"brand_id": brand.ID}, page, limit)
+	return brand, products, total, err
+}
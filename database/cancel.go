@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrCantCancelOrder   = errors.New("order can no longer be cancelled")
+	ErrInvalidCancelLine = errors.New("cancellation lines do not match the order")
+	ErrNothingToCancel   = errors.New("nothing left to cancel on this order")
+)
+
+// CustomerCancelOrder cancels lines of one of userID's own orders, or the
+// whole order when lines is empty. Customers can only cancel until the order
+// is packed.
+func CustomerCancelOrder(ctx context.Context, orderCollection *mongo.Collection, userID string, orderID string, lines []models.CancelLine, reason string) (models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return models.Order{}, ErrOrderIdIsNotValid
+	}
+	query := bson.M{"_id": id, "user_id": userID}
+	return cancelOrder(ctx, orderCollection, query, models.OrderStatus.CustomerCancellable, lines, userID, reason)
+}
+
+// AdminCancelOrder cancels lines of any order, or the whole order when lines
+// is empty, at any point before delivery.
+func AdminCancelOrder(ctx context.Context, orderCollection *mongo.Collection, orderID string, lines []models.CancelLine, actor string, reason string) (models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return models.Order{}, ErrOrderIdIsNotValid
+	}
+	query := bson.M{"_id": id}
+	return cancelOrder(ctx, orderCollection, query, models.OrderStatus.AdminCancellable, lines, actor, reason)
+}
+
+// cancelOrder records the cancellation of lines on the order matching query
+// and gives back the stock they held. When nothing remains active the order
+// itself moves to cancelled. A refund is flagged as pending whenever the
+// order had already been paid for; on an order still awaiting payment only
+// what store credit paid beyond the new total is refunded.
+func cancelOrder(ctx context.Context, orderCollection *mongo.Collection, query bson.M, allowed func(models.OrderStatus) bool, lines []models.CancelLine, actor string, reason string) (models.Order, error) {
+	var order models.Order
+	err := runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var err error
+		order, err = findOrder(sessCtx, orderCollection, query)
+		if err != nil {
+			return err
+		}
+		if !allowed(order.Status) {
+			return ErrCantCancelOrder
+		}
+
+		cancellation, err := applyCancellation(&order, lines)
+		if err != nil {
+			return err
+		}
+		if err = releaseStock(sessCtx, orderCollection.Database(), &order, cancellation.Lines, actor); err != nil {
+			return err
+		}
+		cancellation.Actor = actor
+		cancellation.Reason = reason
+		cancellation.RefundStatus = models.RefundNotRequired
+		if order.Status != models.OrderPendingPayment {
+			cancellation.RefundAmount = cancellation.Amount
+		} else if held, owed := heldAmount(order), order.Total.Sub(order.CancelledAmount).Sub(cancellation.Amount); held.GreaterThan(owed) {
+			cancellation.RefundAmount = held.Sub(owed)
+		}
+		if cancellation.RefundAmount.IsPositive() {
+			cancellation.RefundStatus = models.RefundPending
+		}
+
+		order.Cancellations = append(order.Cancellations, cancellation)
+		order.CancelledAmount = order.CancelledAmount.Add(cancellation.Amount)
+		order.UpdatedAt = cancellation.At
+
+		update := bson.M{"$set": bson.M{
+			"items":            order.Items,
+			"cancellations":    order.Cancellations,
+			"cancelled_amount": order.CancelledAmount,
+			"updated_at":       order.UpdatedAt,
+		}}
+		if _, err = orderCollection.UpdateOne(sessCtx, bson.M{"_id": order.ID}, update); err != nil {
+			return err
+		}
+
+		for _, item := range order.Items {
+			if item.ActiveQuantity() > 0 {
+				// Cancelling the units still to ship may leave the rest
+				// all shipped or delivered.
+				return advanceFulfilment(sessCtx, orderCollection, &order, actor, reason)
+			}
+		}
+		return setOrderStatus(sessCtx, orderCollection, &order, models.OrderCancelled, actor, reason)
+	})
+
+	if err == nil {
+		return order, nil
+	}
+	for _, known := range []error{ErrCantFindOrder, ErrCantCancelOrder, ErrInvalidCancelLine, ErrNothingToCancel, ErrOrderChanged} {
+		if errors.Is(err, known) {
+			return order, known
+		}
+	}
+	log.Println(err)
+	return order, ErrCantUpdateOrder
+}
+
+// heldAmount is what was paid for order and is neither refunded nor
+// already waiting to be refunded by an earlier cancellation.
+func heldAmount(order models.Order) money.Money {
+	held := order.RefundableAmount()
+	for _, cancellation := range order.Cancellations {
+		if cancellation.RefundStatus == models.RefundPending && !cancellation.RefundAmount.GreaterThan(held) {
+			held = held.Sub(cancellation.RefundAmount)
+		}
+	}
+	return held
+}
+
+// applyCancellation marks the requested quantities as cancelled on order's
+// items and returns the resulting cancellation. Empty lines means every
+// remaining unit, shipped or not; named lines can only cancel units not yet
+// in a shipment.
+func applyCancellation(order *models.Order, lines []models.CancelLine) (models.Cancellation, error) {
+	cancellation := models.Cancellation{
+		ID: primitive.NewObjectID(),
+		At: time.Now(),
+	}
+
+	explicit := len(lines) > 0
+	if !explicit {
+		for _, item := range order.Items {
+			if item.ActiveQuantity() > 0 {
+				lines = append(lines, models.CancelLine{LineID: item.LineID, Quantity: item.ActiveQuantity()})
+			}
+		}
+		if len(lines) == 0 {
+			return cancellation, ErrNothingToCancel
+		}
+	}
+
+	for _, line := range lines {
+		index := -1
+		for i, item := range order.Items {
+			if item.LineID == line.LineID {
+				index = i
+				break
+			}
+		}
+		if index < 0 || line.Quantity == 0 || line.Quantity > order.Items[index].ActiveQuantity() {
+			return cancellation, ErrInvalidCancelLine
+		}
+		if explicit && line.Quantity > order.Items[index].UnallocatedQuantity() {
+			return cancellation, ErrInvalidCancelLine
+		}
+		order.Items[index].Cancelled += line.Quantity
+		order.Items[index].Backordered = min(order.Items[index].Backordered, order.Items[index].UnallocatedQuantity())
+		cancellation.Amount = cancellation.Amount.Add(order.Items[index].NetAmount(line.Quantity))
+		cancellation.Lines = append(cancellation.Lines, line)
+	}
+	return cancellation, nil
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"errors"
 	"log"
-	"time"
 
 	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/promotions"
+	"github.com/kshzz24/ecomm-go/shipping"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -20,22 +21,19 @@ var (
 	ErrCantRemoveItem    = errors.New("cannot remove item from cart")
 	ErrCantGetItem       = errors.New("cannot get item from database")
 	ErrCantBuyCartItem   = errors.New("cannot process purchase from cart")
+	ErrCantFindUser      = errors.New("cannot find the requested user")
+	ErrCartIsEmpty       = errors.New("cart is empty")
 )
 
-func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
-	searchFromDb, err := prodCollection.Find(ctx, bson.M{"_id": productID})
+// AddProductToCart puts one unit of the product in the user's cart, as long
+// as the cart would not hold more of it than is in stock. A product with
+// variants goes in as its variant variantID.
+func AddProductToCart(ctx context.Context, prodCollection, userCollection, inventoryCollection *mongo.Collection, productID, variantID primitive.ObjectID, userID string) error {
+	item, err := cartItem(ctx, prodCollection, productID, variantID)
 	if err != nil {
-		log.Println(err)
-		return ErrCantFindProduct
-	}
-
-	var productCart []models.ProductUser
-
-	err = searchFromDb.All(ctx, &productCart)
-	if err != nil {
-		log.Println(err)
-		return ErrCantDecodeProduct
+		return err
 	}
+	productCart := []models.ProductUser{item}
 
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
@@ -43,6 +41,23 @@ func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo
 		return ErrUserIdIsNotValid
 	}
 
+	var user models.User
+	if err = userCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+		}
+		return ErrCantFindUser
+	}
+	inCart := uint(0)
+	for _, item := range user.UserCart {
+		if item.ProductID == productID && item.VariantID == variantID {
+			inCart++
+		}
+	}
+	if err = CheckStock(ctx, inventoryCollection, productID, variantID, item.ProductName, inCart+1); err != nil {
+		return err
+	}
+
 	filter := bson.D{primitive.E{
 		Key:   "_id",
 		Value: id,
@@ -66,14 +81,21 @@ func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo
 	}
 	return nil
 }
-func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+
+// RemoveCartItem takes the product out of the user's cart: only its variant
+// variantID when that is not zero, and every variant of it otherwise.
+func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID, variantID primitive.ObjectID, userID string) error {
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
 		return ErrUserIdIsNotValid
 	}
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.M{"$pull": bson.M{"usercart": bson.M{"_id": productID}}}
+	pull := bson.M{"product_id": productID}
+	if !variantID.IsZero() {
+		pull["variant_id"] = variantID
+	}
+	update := bson.M{"$pull": bson.M{"usercart": pull}}
 	_, err = userCollection.UpdateMany(ctx, filter, update)
 	if err != nil {
 		return ErrCantRemoveItem
@@ -81,147 +103,151 @@ func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.C
 	return nil
 
 }
-func BuyItemFromCart(ctx context.Context, userCollection *mongo.Collection, userID string) error {
+
+// BuyItemFromCart turns the user's cart into an order and empties the cart.
+// Any gift card or wallet credit chosen at checkout is spent in the same
+// transaction, so a failed checkout never takes store credit.
+func BuyItemFromCart(ctx context.Context, userCollection, orderCollection, ledgerCollection *mongo.Collection, pricing PricingCollections, wallets WalletCollections, userID string, checkout models.CheckoutRequest) (*models.Order, error) {
 
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
-		return ErrUserIdIsNotValid
-	}
-
-	var getcartitems models.User
-	var ordercart models.Order
-
-	ordercart.OrderID = primitive.NewObjectID()
-	ordercart.OrderedAt = time.Now()
-	ordercart.OrderCart = make([]models.ProductUser, 0)
-	ordercart.PaymentMethod.COD = true
-
-	unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{
-		Key:   "path",
-		Value: "$usercart",
-	}}}}
-	grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{
-		Key:   "_id",
-		Value: "$_id",
-	},
-		{
-			Key:   "total",
-			Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}},
-		}}}}
-	currentresults, err := userCollection.Aggregate(ctx, mongo.Pipeline{unwind, grouping})
-
-	if err != nil {
-		panic(err)
-
-	}
-
-	var getusercart []bson.M
-	if err = currentresults.All(ctx, &getusercart); err != nil {
-		panic(err)
-	}
-
-	var total_price int32
-
-	for _, user_item := range getusercart {
-		price := user_item["total"]
-		total_price = price.(int32)
-	}
-
-	ordercart.Price = uint64(total_price)
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: ordercart}}}}
+		return nil, ErrUserIdIsNotValid
+	}
+	payment, err := paymentMethod(checkout)
+	if err != nil {
+		return nil, err
+	}
+
+	var order models.Order
+	err = runInTransaction(ctx, userCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var getcartitems models.User
+		err := userCollection.FindOne(sessCtx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getcartitems)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrCantFindUser
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(getcartitems.UserCart) == 0 {
+			return ErrCartIsEmpty
+		}
+
+		currency, err := ResolveCurrency(checkout.Currency, getcartitems.Currency)
+		if err != nil {
+			return err
+		}
+		address, err := shippingAddress(getcartitems, checkout.AddressID)
+		if err != nil {
+			return err
+		}
+		p, err := newPricer(sessCtx, pricing.Rates, currency)
+		if err != nil {
+			return err
+		}
+		order, err = priceOrder(sessCtx, pricing, p, userID, getcartitems.UserCart, address, checkout.Coupons, checkout.ShippingMethod)
+		if err != nil {
+			return err
+		}
+		if err = redeemPromotions(sessCtx, pricing, order); err != nil {
+			return err
+		}
+		order.PaymentMethod = payment
+		if err = applyStoredValue(sessCtx, wallets, ledgerCollection, &order, checkout); err != nil {
+			return err
+		}
+		if err = insertOrder(sessCtx, orderCollection, &order); err != nil {
+			return err
+		}
+
+		filter := bson.D{primitive.E{Key: "_id", Value: id}}
+		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: make([]models.ProductUser, 0)}}}}
+		_, err = userCollection.UpdateOne(sessCtx, filter, update)
+		return err
+	})
+
+	if err == nil {
+		return &order, nil
+	}
+	return nil, checkoutError(err)
+}
 
-	_, err = userCollection.UpdateMany(ctx, filter, update)
+func InstantBuyer(ctx context.Context, prodCollection, userCollection, orderCollection, ledgerCollection *mongo.Collection, pricing PricingCollections, wallets WalletCollections, productID, variantID primitive.ObjectID, userID string, checkout models.CheckoutRequest) (*models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
-		// return
+		return nil, ErrUserIdIsNotValid
 	}
-
-	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getcartitems)
-	// userCollection.
+	payment, err := paymentMethod(checkout)
 	if err != nil {
-		log.Println(err)
-	}
-
-	filter1 := bson.D{
-		primitive.E{
-			Key:   "_id",
-			Value: id,
-		},
+		return nil, err
 	}
 
-	update2 := bson.M{
-		"$push": bson.M{"orders.$[].order_list": bson.M{
-			"$each": getcartitems.UserCart,
-		}},
+	product_details, err := cartItem(ctx, prodCollection, productID, variantID)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = userCollection.UpdateOne(ctx, filter1, update2)
-
+	var user models.User
+	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&user)
 	if err != nil {
 		log.Println(err)
+		return nil, ErrCantFindUser
 	}
 
-	usercart_empty := make([]models.ProductUser, 0)
-	filter3 := bson.D{
-		primitive.E{Key: "_id", Value: id},
-	}
-	update3 := bson.D{
-		primitive.E{
-			Key: "$set",
-			Value: bson.D{
-				primitive.E{
-					Key:   "usercart",
-					Value: usercart_empty,
-				},
-			},
-		},
-	}
-	_, err = userCollection.UpdateOne(ctx, filter3, update3)
-
+	currency, err := ResolveCurrency(checkout.Currency, user.Currency)
 	if err != nil {
-		return ErrCantBuyCartItem
+		return nil, err
 	}
-	return nil
-}
-
-func InstantBuyer(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
-	id, err := primitive.ObjectIDFromHex(userID)
+	p, err := newPricer(ctx, pricing.Rates, currency)
 	if err != nil {
-		log.Println(err)
-		return ErrUserIdIsNotValid
+		return nil, err
 	}
-	var product_details models.ProductUser
-
-	var orders_detail models.Order
-
-	orders_detail.OrderID = primitive.NewObjectID()
-	orders_detail.OrderedAt = time.Now()
-
-	orders_detail.OrderCart = make([]models.ProductUser, 0)
-
-	orders_detail.PaymentMethod.COD = true
-	err = prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&product_details)
+	address, err := shippingAddress(user, checkout.AddressID)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
-
-	orders_detail.Price = product_details.Price
-
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orders_detail}}}}
-
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+	orders_detail, err := priceOrder(ctx, pricing, p, userID, []models.ProductUser{product_details}, address, checkout.Coupons, checkout.ShippingMethod)
 	if err != nil {
-		log.Println(err)
+		return nil, checkoutError(err)
 	}
+	orders_detail.PaymentMethod = payment
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].order_list": product_details}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
+	err = runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		if err := redeemPromotions(sessCtx, pricing, orders_detail); err != nil {
+			return err
+		}
+		if err := applyStoredValue(sessCtx, wallets, ledgerCollection, &orders_detail, checkout); err != nil {
+			return err
+		}
+		return insertOrder(sessCtx, orderCollection, &orders_detail)
+	})
 	if err != nil {
-		log.Println(err)
+		return nil, checkoutError(err)
 	}
-	return nil
+	return &orders_detail, nil
+}
+
+func checkoutError(err error) error {
+	if errors.Is(err, ErrOutOfStock) {
+		// Keep the name of the product that ran out.
+		return err
+	}
+	knownErrors := []error{
+		ErrCantFindUser, ErrCartIsEmpty, ErrCantFindAddress,
+		ErrCantFindProduct, ErrCantFindVariant, ErrVariantRequired,
+		ErrInsufficientFunds, ErrCantFindGiftCard, ErrGiftCardUnusable, ErrInvalidAmount,
+		ErrUnsupportedCurrency, ErrNoExchangeRate, ErrCantListRates,
+		ErrPromotionUsedUp, ErrCantListPromotions, ErrCantListShippingZones,
+		shipping.ErrNotShippable, shipping.ErrMethodUnavailable, shipping.ErrUnknownMethod,
+		promotions.ErrUnknownCoupon, promotions.ErrCouponNotApplicable, promotions.ErrCouponsDontStack,
+	}
+	for _, known := range knownErrors {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+	log.Println(err)
+	return ErrCantBuyCartItem
 }
@@ -0,0 +1,286 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// These tests run against the MongoDB at MONGODB_URL, which must be a
+// replica set since checkout uses transactions; the bundled docker-compose
+// starts one. Each test works in a database of its own that is dropped
+// afterwards. Without a reachable replica set the tests are skipped.
+
+var (
+	replicaSetOnce sync.Once
+	replicaSetErr  error
+)
+
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	replicaSetOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var hello bson.M
+		replicaSetErr = Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if replicaSetErr == nil && hello["setName"] == nil {
+			replicaSetErr = errors.New("mongo is not running as a replica set")
+		}
+	})
+	if replicaSetErr != nil {
+		t.Skipf("no replica set reachable: %v", replicaSetErr)
+	}
+
+	db := Client.Database("ecomm_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := db.Drop(ctx); err != nil {
+			t.Logf("dropping %s: %v", db.Name(), err)
+		}
+	})
+	return db
+}
+
+// checkoutFixture is a customer with a cart, in a database of its own.
+type checkoutFixture struct {
+	db        *mongo.Database
+	users     *mongo.Collection
+	orders    *mongo.Collection
+	ledger    *mongo.Collection
+	pricing   PricingCollections
+	wallets   WalletCollections
+	userID    primitive.ObjectID
+	warehouse primitive.ObjectID
+}
+
+func newCheckoutFixture(t *testing.T, cart ...models.ProductUser) checkoutFixture {
+	t.Helper()
+	db := testDatabase(t)
+	f := checkoutFixture{
+		db:        db,
+		users:     db.Collection("Users"),
+		orders:    db.Collection("Orders"),
+		ledger:    db.Collection("Ledger"),
+		pricing:   NewPricingCollections(db),
+		wallets:   NewWalletCollections(db),
+		userID:    primitive.NewObjectID(),
+		warehouse: primitive.NewObjectID(),
+	}
+	ctx := context.Background()
+
+	// Transactions cannot create collections on every server version, so
+	// make the ones checkout writes to up front.
+	for _, name := range []string{"Users", "Orders", "Ledger", "Counters", "Inventory", "Warehouses"} {
+		if err := db.CreateCollection(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := warehouses(db).InsertOne(ctx, models.Warehouse{
+		ID:        f.warehouse,
+		Code:      "MAIN",
+		Name:      "Main",
+		Active:    true,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart == nil {
+		cart = []models.ProductUser{}
+	}
+	_, err = f.users.InsertOne(ctx, models.User{
+		ID:        f.userID,
+		FirstName: "Test",
+		LastName:  "Customer",
+		Email:     "customer@example.com",
+		UserCart:  cart,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f checkoutFixture) stock(t *testing.T, productID primitive.ObjectID, onHand int64) {
+	t.Helper()
+	_, err := inventory(f.db).InsertOne(context.Background(), models.Stock{
+		ID:          primitive.NewObjectID(),
+		ProductID:   productID,
+		WarehouseID: f.warehouse,
+		OnHand:      onHand,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f checkoutFixture) buy(userID string) (*models.Order, error) {
+	return f.checkout(userID, models.CheckoutRequest{})
+}
+
+func (f checkoutFixture) checkout(userID string, request models.CheckoutRequest) (*models.Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return BuyItemFromCart(ctx, f.users, f.orders, f.ledger, f.pricing, f.wallets, userID, request)
+}
+
+func (f checkoutFixture) count(t *testing.T, coll *mongo.Collection, filter bson.M) int64 {
+	t.Helper()
+	n, err := coll.CountDocuments(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func (f checkoutFixture) cart(t *testing.T) []models.ProductUser {
+	t.Helper()
+	var user models.User
+	if err := f.users.FindOne(context.Background(), bson.M{"_id": f.userID}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user.UserCart
+}
+
+func cartLine(name string, major int64) models.ProductUser {
+	return models.ProductUser{
+		ProductID:   primitive.NewObjectID(),
+		ProductName: name,
+		Price:       money.FromMajor(major, models.DefaultCurrency),
+	}
+}
+
+func TestBuyItemFromCartCreatesOneOrder(t *testing.T) {
+	shirt, mug := cartLine("Shirt", 500), cartLine("Mug", 250)
+	f := newCheckoutFixture(t, shirt, shirt, mug)
+	f.stock(t, shirt.ProductID, 5)
+
+	order, err := f.buy(f.userID.Hex())
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if got := f.count(t, f.orders, bson.M{"user_id": f.userID.Hex()}); got != 1 {
+		t.Fatalf("orders stored = %d, want 1", got)
+	}
+	if len(order.Items) != 2 {
+		t.Fatalf("order lines = %d, want 2", len(order.Items))
+	}
+	if want := money.FromMajor(1250, models.DefaultCurrency); order.Subtotal.Cmp(want) != 0 {
+		t.Errorf("subtotal = %s, want %s", order.Subtotal, want)
+	}
+	if order.OrderNumber == "" {
+		t.Error("order has no number")
+	}
+	var stock models.Stock
+	if err := inventory(f.db).FindOne(context.Background(), bson.M{"product_id": shirt.ProductID}).Decode(&stock); err != nil {
+		t.Fatal(err)
+	}
+	if stock.Reserved != 2 {
+		t.Errorf("reserved = %d, want 2", stock.Reserved)
+	}
+
+	// Buying again finds the cart empty rather than placing a second order.
+	if _, err := f.buy(f.userID.Hex()); !errors.Is(err, ErrCartIsEmpty) {
+		t.Errorf("second checkout err = %v, want %v", err, ErrCartIsEmpty)
+	}
+	if got := f.count(t, f.orders, bson.M{}); got != 1 {
+		t.Errorf("orders stored after second checkout = %d, want 1", got)
+	}
+}
+
+func TestBuyItemFromCartClearsCart(t *testing.T) {
+	f := newCheckoutFixture(t, cartLine("Shirt", 500))
+
+	if _, err := f.buy(f.userID.Hex()); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if cart := f.cart(t); len(cart) != 0 {
+		t.Errorf("cart after checkout has %d items, want none", len(cart))
+	}
+}
+
+func TestBuyItemFromCartWritesNothingOnFailure(t *testing.T) {
+	shirt, mug := cartLine("Shirt", 500), cartLine("Mug", 250)
+	f := newCheckoutFixture(t, shirt, mug)
+	// The shirt is reserved before the mug is found to be sold out, so the
+	// failure comes after checkout has started writing.
+	f.stock(t, shirt.ProductID, 5)
+	f.stock(t, mug.ProductID, 0)
+
+	if _, err := f.buy(f.userID.Hex()); !errors.Is(err, ErrOutOfStock) {
+		t.Fatalf("checkout err = %v, want %v", err, ErrOutOfStock)
+	}
+	if got := f.count(t, f.orders, bson.M{}); got != 0 {
+		t.Errorf("orders stored = %d, want 0", got)
+	}
+	if got := f.count(t, inventory(f.db), bson.M{"reserved": bson.M{"$ne": 0}}); got != 0 {
+		t.Errorf("stock records with a reservation = %d, want 0", got)
+	}
+	if got := f.count(t, f.db.Collection("Counters"), bson.M{}); got != 0 {
+		t.Errorf("order numbers used = %d, want 0", got)
+	}
+	if cart := f.cart(t); len(cart) != 2 {
+		t.Errorf("cart after failed checkout has %d items, want 2", len(cart))
+	}
+}
+
+func TestBuyItemFromCartEmptyCart(t *testing.T) {
+	f := newCheckoutFixture(t)
+
+	if _, err := f.buy(f.userID.Hex()); !errors.Is(err, ErrCartIsEmpty) {
+		t.Fatalf("checkout err = %v, want %v", err, ErrCartIsEmpty)
+	}
+	if got := f.count(t, f.orders, bson.M{}); got != 0 {
+		t.Errorf("orders stored = %d, want 0", got)
+	}
+}
+
+func TestBuyItemFromCartUnknownUser(t *testing.T) {
+	f := newCheckoutFixture(t, cartLine("Shirt", 500))
+
+	if _, err := f.buy(primitive.NewObjectID().Hex()); !errors.Is(err, ErrCantFindUser) {
+		t.Fatalf("checkout err = %v, want %v", err, ErrCantFindUser)
+	}
+	if _, err := f.buy("not-an-id"); !errors.Is(err, ErrUserIdIsNotValid) {
+		t.Errorf("checkout with a malformed id err = %v, want %v", err, ErrUserIdIsNotValid)
+	}
+	if got := f.count(t, f.orders, bson.M{}); got != 0 {
+		t.Errorf("orders stored = %d, want 0", got)
+	}
+	if cart := f.cart(t); len(cart) != 1 {
+		t.Errorf("other customer's cart has %d items, want 1", len(cart))
+	}
+}
+
+func TestCheckoutError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{ErrCartIsEmpty, ErrCartIsEmpty},
+		{fmt.Errorf("pricing line 2: %w", ErrCantFindProduct), ErrCantFindProduct},
+		{fmt.Errorf("pricing line 2: %w", ErrCantFindVariant), ErrCantFindVariant},
+		{ErrVariantRequired, ErrVariantRequired},
+		{errors.New("connection reset"), ErrCantBuyCartItem},
+	}
+	for _, tt := range tests {
+		if got := checkoutError(tt.err); got != tt.want {
+			t.Errorf("checkoutError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	// The product that ran out stays in the message.
+	outOfStock := fmt.Errorf("%w: Shirt", ErrOutOfStock)
+	if got := checkoutError(outOfStock); got != outOfStock {
+		t.Errorf("checkoutError(%v) = %v, want it unchanged", outOfStock, got)
+	}
+}
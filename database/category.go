@@ -0,0 +1,533 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindCategory   = errors.New("cannot find the requested category")
+	ErrInvalidCategory    = errors.New("category is not valid")
+	ErrDuplicateCategory  = errors.New("a category with this slug already exists")
+	ErrCategoryCycle      = errors.New("a category cannot be moved under itself")
+	ErrCategoryInUse      = errors.New("category still has subcategories or products")
+	ErrInvalidAttribute   = errors.New("product attribute is not valid")
+	ErrCantUpdateCategory = errors.New("cannot update category")
+	ErrCantListCategories = errors.New("cannot list categories")
+	ErrCantListProducts   = errors.New("cannot list products")
+)
+
+// categories is the collection the category tree is kept in, next to the
+// products listed in it.
+func categories(db *mongo.Database) *mongo.Collection {
+	return db.Collection("Categories")
+}
+
+// Slugify turns a category name into the slug it is known by in paths and
+// URLs: lower case letters and digits, with anything else between them
+// replaced by single dashes.
+func Slugify(name string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return slug.String()
+}
+
+func findCategory(ctx context.Context, categoryCollection *mongo.Collection, filter bson.M) (models.Category, error) {
+	var category models.Category
+	err := categoryCollection.FindOne(ctx, filter).Decode(&category)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return category, ErrCantFindCategory
+	}
+	if err != nil {
+		log.Println(err)
+		return category, ErrCantFindCategory
+	}
+	return category, nil
+}
+
+// descendantsFilter matches the categories below the one at path.
+func descendantsFilter(path string) bson.M {
+	return bson.M{"path": bson.M{"$regex": "^" + regexp.QuoteMeta(path) + "."}}
+}
+
+// ListCategories returns the whole category tree in path order, so every
+// category comes right after its parent and before its own descendants.
+func ListCategories(ctx context.Context, categoryCollection *mongo.Collection) ([]models.Category, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "path", Value: 1}})
+	cursor, err := categoryCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListCategories
+	}
+	list := make([]models.Category, 0)
+	if err = cursor.All(ctx, &list); err != nil {
+		log.Println(err)
+		return nil, ErrCantListCategories
+	}
+	return list, nil
+}
+
+// GetCategory returns the category with slug together with its ancestors,
+// root first.
+func GetCategory(ctx context.Context, categoryCollection *mongo.Collection, slug string) (models.Category, []models.Category, error) {
+	category, err := findCategory(ctx, categoryCollection, bson.M{"slug": Slugify(slug)})
+	if err != nil {
+		return category, nil, err
+	}
+	ancestors, err := lineage(ctx, categoryCollection, category)
+	if err != nil {
+		return category, nil, err
+	}
+	return category, ancestors[:len(ancestors)-1], nil
+}
+
+// lineage returns the categories on category's path, root first and
+// category last.
+func lineage(ctx context.Context, categoryCollection *mongo.Collection, category models.Category) ([]models.Category, error) {
+	cursor, err := categoryCollection.Find(ctx, bson.M{"slug": bson.M{"$in": category.Lineage()}},
+		options.Find().SetSort(bson.D{{Key: "depth", Value: 1}}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListCategories
+	}
+	var list []models.Category
+	if err = cursor.All(ctx, &list); err != nil {
+		log.Println(err)
+		return nil, ErrCantListCategories
+	}
+	return list, nil
+}
+
+// CategoryRequest creates a category or changes one. ParentID places it in
+// the tree; leaving it empty makes a root category.
+type CategoryRequest struct {
+	Slug       string                   `json:"slug"`
+	Name       string                   `json:"name"`
+	ParentID   string                   `json:"parent_id"`
+	Attributes []models.AttributeSchema `json:"attributes"`
+}
+
+// CreateCategory adds a category under the parent of request. Its slug is
+// made from its name unless one is given.
+func CreateCategory(ctx context.Context, categoryCollection *mongo.Collection, request CategoryRequest) (models.Category, error) {
+	if request.Slug == "" {
+		request.Slug = request.Name
+	}
+	category := models.Category{
+		ID:         primitive.NewObjectID(),
+		Slug:       Slugify(request.Slug),
+		Name:       strings.TrimSpace(request.Name),
+		Attributes: request.Attributes,
+	}
+	if category.Slug == "" || category.Name == "" {
+		return category, ErrInvalidCategory
+	}
+	if err := normalizeAttributeSchemas(category.Attributes); err != nil {
+		return category, err
+	}
+	if err := placeCategory(ctx, categoryCollection, &category, request.ParentID); err != nil {
+		return category, err
+	}
+	category.CreatedAt = time.Now()
+	category.UpdatedAt = category.CreatedAt
+
+	_, err := categoryCollection.InsertOne(ctx, category)
+	if mongo.IsDuplicateKeyError(err) {
+		return category, ErrDuplicateCategory
+	}
+	if err != nil {
+		log.Println(err)
+		return category, ErrCantUpdateCategory
+	}
+	return category, nil
+}
+
+// UpdateCategory renames a category, replaces its attributes and moves it,
+// with everything below it, under the parent of request. The slug stays
+// as it was: products, orders and return windows refer to categories by
+// it. Products already in the category keep their attributes until they
+// are assigned again.
+func UpdateCategory(ctx context.Context, categoryCollection *mongo.Collection, categoryID string, request CategoryRequest) (models.Category, error) {
+	id, err := primitive.ObjectIDFromHex(categoryID)
+	if err != nil {
+		return models.Category{}, ErrCantFindCategory
+	}
+	category, err := findCategory(ctx, categoryCollection, bson.M{"_id": id})
+	if err != nil {
+		return category, err
+	}
+	oldPath, oldDepth := category.Path, category.Depth
+	category.Name = strings.TrimSpace(request.Name)
+	category.Attributes = request.Attributes
+	if category.Name == "" {
+		return category, ErrInvalidCategory
+	}
+	if err = normalizeAttributeSchemas(category.Attributes); err != nil {
+		return category, err
+	}
+	if err = placeCategory(ctx, categoryCollection, &category, request.ParentID); err != nil {
+		return category, err
+	}
+	category.UpdatedAt = time.Now()
+
+	err = runInTransaction(ctx, categoryCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		set := bson.M{
+			"name":       category.Name,
+			"attributes": category.Attributes,
+			"path":       category.Path,
+			"depth":      category.Depth,
+			"updated_at": category.UpdatedAt,
+		}
+		update := bson.M{"$set": set, "$unset": bson.M{"parent_id": ""}}
+		if !category.ParentID.IsZero() {
+			set["parent_id"] = category.ParentID
+			delete(update, "$unset")
+		}
+		result, err := categoryCollection.UpdateOne(sessCtx, bson.M{"_id": id, "path": oldPath}, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrCantUpdateCategory
+		}
+		if category.Path == oldPath {
+			return nil
+		}
+		// Graft the descendants' paths onto the new one.
+		_, err = categoryCollection.UpdateMany(sessCtx, descendantsFilter(oldPath), mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"path": bson.M{"$concat": bson.A{
+				category.Path,
+				bson.M{"$substrCP": bson.A{"$path", len(oldPath), bson.M{"$strLenCP": "$path"}}},
+			}},
+			"depth":      bson.M{"$add": bson.A{"$depth", category.Depth - oldDepth}},
+			"updated_at": category.UpdatedAt,
+		}}}})
+		return err
+	})
+	if errors.Is(err, ErrCantUpdateCategory) {
+		return category, err
+	}
+	if err != nil {
+		log.Println(err)
+		return category, ErrCantUpdateCategory
+	}
+	return category, nil
+}
+
+// placeCategory sets the parent, path and depth of category for it to sit
+// under the category parentID, or at the root when parentID is empty.
+func placeCategory(ctx context.Context, categoryCollection *mongo.Collection, category *models.Category, parentID string) error {
+	if parentID == "" {
+		category.ParentID = primitive.NilObjectID
+		category.Path = "/" + category.Slug + "/"
+		category.Depth = 0
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(parentID)
+	if err != nil {
+		return ErrCantFindCategory
+	}
+	parent, err := findCategory(ctx, categoryCollection, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if category.Path != "" && strings.HasPrefix(parent.Path, category.Path) {
+		return ErrCategoryCycle
+	}
+	category.ParentID = parent.ID
+	category.Path = parent.Path + category.Slug + "/"
+	category.Depth = parent.Depth + 1
+	return nil
+}
+
+// DeleteCategory removes a category without subcategories that no product
+// is listed in.
+func DeleteCategory(ctx context.Context, categoryCollection, prodCollection *mongo.Collection, categoryID string) error {
+	id, err := primitive.ObjectIDFromHex(categoryID)
+	if err != nil {
+		return ErrCantFindCategory
+	}
+	category, err := findCategory(ctx, categoryCollection, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	children, err := categoryCollection.CountDocuments(ctx, bson.M{"parent_id": id})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	listed, err := prodCollection.CountDocuments(ctx, bson.M{"category_ids": id})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	if children > 0 || listed > 0 {
+		return ErrCategoryInUse
+	}
+	if _, err = categoryCollection.DeleteOne(ctx, bson.M{"_id": category.ID}); err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	return nil
+}
+
+// normalizeAttributeSchemas trims the attribute schemas of a category and
+// checks each has a name of its own and a known type, and that enums list
+// their values.
+func normalizeAttributeSchemas(schemas []models.AttributeSchema) error {
+	names := make(map[string]bool, len(schemas))
+	for i := range schemas {
+		schema := &schemas[i]
+		schema.Name = strings.TrimSpace(schema.Name)
+		schema.Unit = strings.TrimSpace(schema.Unit)
+		if schema.Name == "" || names[schema.Name] {
+			return ErrInvalidCategory
+		}
+		names[schema.Name] = true
+		switch schema.Type {
+		case models.AttributeText, models.AttributeNumber, models.AttributeBoolean:
+			schema.Values = nil
+		case models.AttributeEnum:
+			if len(schema.Values) == 0 {
+				return ErrInvalidCategory
+			}
+			for j, value := range schema.Values {
+				schema.Values[j] = strings.TrimSpace(value)
+				if schema.Values[j] == "" {
+					return ErrInvalidCategory
+				}
+			}
+		default:
+			return ErrInvalidCategory
+		}
+	}
+	return nil
+}
+
+// ProductCategoriesRequest lists a product in categories, the first being
+// its primary one, and gives the attributes they ask for.
+type ProductCategoriesRequest struct {
+	CategoryIDs []string               `json:"category_ids"`
+	Attributes  map[string]interface{} `json:"attributes"`
+}
+
+// SetProductCategories replaces the categories a product is listed in and
+// its attributes, which must follow the schemas of those categories and of
+// their ancestors. The primary category's slug becomes the product's
+// category, which promotions and return windows go by. An empty request
+// takes the product out of every category.
+func SetProductCategories(ctx context.Context, prodCollection, categoryCollection *mongo.Collection, productID string, request ProductCategoriesRequest) (models.Product, error) {
+	id, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return models.Product{}, ErrCantFindProduct
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(request.CategoryIDs))
+	listed := make([]models.Category, 0, len(request.CategoryIDs))
+	for _, categoryID := range request.CategoryIDs {
+		categoryID, err := primitive.ObjectIDFromHex(categoryID)
+		if err != nil {
+			return models.Product{}, ErrCantFindCategory
+		}
+		for _, seen := range ids {
+			if seen == categoryID {
+				return models.Product{}, ErrInvalidCategory
+			}
+		}
+		category, err := findCategory(ctx, categoryCollection, bson.M{"_id": categoryID})
+		if err != nil {
+			return models.Product{}, err
+		}
+		ids = append(ids, categoryID)
+		listed = append(listed, category)
+	}
+
+	schemas := make(map[string]models.AttributeSchema)
+	for _, category := range listed {
+		line, err := lineage(ctx, categoryCollection, category)
+		if err != nil {
+			return models.Product{}, err
+		}
+		for _, ancestor := range line {
+			for _, schema := range ancestor.Attributes {
+				if known, ok := schemas[schema.Name]; ok && known.Type != schema.Type {
+					return models.Product{}, fmt.Errorf("%w: categories disagree on the type of %s", ErrInvalidAttribute, schema.Name)
+				}
+				schemas[schema.Name] = schema
+			}
+		}
+	}
+	attributes, err := checkAttributes(schemas, request.Attributes)
+	if err != nil {
+		return models.Product{}, err
+	}
+
+	update := bson.M{"$unset": bson.M{"category_ids": "", "category": "", "attributes": ""}}
+	if len(ids) > 0 {
+		set := bson.M{"category_ids": ids, "category": listed[0].Slug}
+		update = bson.M{"$set": set, "$unset": bson.M{"attributes": ""}}
+		if len(attributes) > 0 {
+			set["attributes"] = attributes
+			delete(update, "$unset")
+		}
+	}
+	var product models.Product
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = prodCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return product, ErrCantFindProduct
+	}
+	if err != nil {
+		log.Println(err)
+		return product, ErrCantUpdateProduct
+	}
+	return product, nil
+}
+
+// checkAttributes checks the attributes of a product against schemas and
+// returns them as they are stored: text trimmed, and numbers as float64
+// whatever they were sent as.
+func checkAttributes(schemas map[string]models.AttributeSchema, attributes map[string]interface{}) (map[string]interface{}, error) {
+	checked := make(map[string]interface{}, len(attributes))
+	for name, value := range attributes {
+		schema, ok := schemas[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not an attribute of these categories", ErrInvalidAttribute, name)
+		}
+		if value == nil {
+			continue
+		}
+		switch schema.Type {
+		case models.AttributeNumber:
+			switch n := value.(type) {
+			case float64:
+				checked[name] = n
+			case int:
+				checked[name] = float64(n)
+			case int32:
+				checked[name] = float64(n)
+			case int64:
+				checked[name] = float64(n)
+			default:
+				return nil, fmt.Errorf("%w: %s must be a number", ErrInvalidAttribute, name)
+			}
+		case models.AttributeBoolean:
+			b, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s must be true or false", ErrInvalidAttribute, name)
+			}
+			checked[name] = b
+		case models.AttributeText, models.AttributeEnum:
+			s, ok := value.(string)
+			s = strings.TrimSpace(s)
+			if !ok || s == "" {
+				return nil, fmt.Errorf("%w: %s must be text", ErrInvalidAttribute, name)
+			}
+			if schema.Type == models.AttributeEnum && !containsString(schema.Values, s) {
+				return nil, fmt.Errorf("%w: %s must be one of %s", ErrInvalidAttribute, name, strings.Join(schema.Values, ", "))
+			}
+			checked[name] = s
+		}
+	}
+	for name, schema := range schemas {
+		if _, ok := checked[name]; schema.Required && !ok {
+			return nil, fmt.Errorf("%w: %s is required", ErrInvalidAttribute, name)
+		}
+	}
+	return checked, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ListCategoryProducts returns the category with slug and one page
+// (1-based) of the products listed in it or in any category below it, by
+// name, together with the total number of such products.
+func ListCategoryProducts(ctx context.Context, prodCollection, categoryCollection *mongo.Collection, slug string, page, limit int64) (models.Category, []models.Product, int64, error) {
+	category, err := findCategory(ctx, categoryCollection, bson.M{"slug": Slugify(slug)})
+	if err != nil {
+		return category, nil, 0, err
+	}
+	ids, err := subtree(ctx, categoryCollection, category)
+	if err != nil {
+		return category, nil, 0, err
+	}
+	products, total, err := findProducts(ctx, prodCollection, bson.M{"category_ids": bson.M{"$in": ids}}, page, limit)
+	return category, products, total, err
+}
+
+// subtree returns the ids of category and of every category below it.
+func subtree(ctx context.Context, categoryCollection *mongo.Collection, category models.Category) ([]primitive.ObjectID, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := categoryCollection.Find(ctx, descendantsFilter(category.Path), opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListCategories
+	}
+	var descendants []models.Category
+	if err = cursor.All(ctx, &descendants); err != nil {
+		log.Println(err)
+		return nil, ErrCantListCategories
+	}
+	ids := []primitive.ObjectID{category.ID}
+	for _, descendant := range descendants {
+		ids = append(ids, descendant.ID)
+	}
+	return ids, nil
+}
+
+// findProducts returns one page (1-based) of the products matching query,
+// by name, together with the total number of them.
+func findProducts(ctx context.Context, prodCollection *mongo.Collection, query bson.M, page, limit int64) ([]models.Product, int64, error) {
+	total, err := prodCollection.CountDocuments(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "product_name", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := prodCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+	defer cursor.Close(ctx)
+
+	products := make([]models.Product, 0)
+	if err = cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+	return products, total, nil
+}
@@ -0,0 +1,397 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/rules"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindCollection   = errors.New("cannot find the requested collection")
+	ErrInvalidCollection    = errors.New("collection is not valid")
+	ErrDuplicateCollection  = errors.New("a collection with this slug already exists")
+	ErrCantUpdateCollection = errors.New("cannot update collection")
+	ErrCantListCollections  = errors.New("cannot list collections")
+)
+
+// ruleResolver resolves the categories and brands collection rules name.
+// When strict, naming one that does not exist is an error, as when a rule
+// is saved; otherwise it matches no product, as when a category or brand
+// a saved rule names is gone.
+type ruleResolver struct {
+	ctx    context.Context
+	db     *mongo.Database
+	strict bool
+}
+
+func (r ruleResolver) Categories(slug string) ([]primitive.ObjectID, error) {
+	category, err := findCategory(r.ctx, categories(r.db), bson.M{"slug": Slugify(slug)})
+	if errors.Is(err, ErrCantFindCategory) && !r.strict {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return subtree(r.ctx, categories(r.db), category)
+}
+
+func (r ruleResolver) Brand(slug string) (primitive.ObjectID, error) {
+	brand, err := findBrand(r.ctx, brands(r.db), bson.M{"slug": Slugify(slug)})
+	if errors.Is(err, ErrCantFindBrand) && !r.strict {
+		return primitive.NilObjectID, nil
+	}
+	return brand.ID, err
+}
+
+// publishedFilter matches the collections customers see at now.
+func publishedFilter(now time.Time) bson.M {
+	return bson.M{
+		"publish_at": bson.M{"$lte": now},
+		"$or":        bson.A{bson.M{"unpublish_at": nil}, bson.M{"unpublish_at": bson.M{"$gt": now}}},
+	}
+}
+
+func findCollection(ctx context.Context, collectionCollection *mongo.Collection, filter bson.M) (models.Collection, error) {
+	var collection models.Collection
+	err := collectionCollection.FindOne(ctx, filter).Decode(&collection)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return collection, ErrCantFindCollection
+	}
+	if err != nil {
+		log.Println(err)
+		return collection, ErrCantFindCollection
+	}
+	collection.Published = collection.LiveAt(time.Now())
+	return collection, nil
+}
+
+func collectionByID(ctx context.Context, collectionCollection *mongo.Collection, collectionID string) (models.Collection, error) {
+	id, err := primitive.ObjectIDFromHex(collectionID)
+	if err != nil {
+		return models.Collection{}, ErrCantFindCollection
+	}
+	return findCollection(ctx, collectionCollection, bson.M{"_id": id})
+}
+
+// ListCollections returns one page (1-based) of collections, newest first,
+// together with the total number of them. With publishedOnly set it lists
+// only those customers see now.
+func ListCollections(ctx context.Context, collectionCollection *mongo.Collection, publishedOnly bool, page, limit int64) ([]models.Collection, int64, error) {
+	now := time.Now()
+	query := bson.M{}
+	if publishedOnly {
+		query = publishedFilter(now)
+	}
+	total, err := collectionCollection.CountDocuments(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListCollections
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := collectionCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListCollections
+	}
+	defer cursor.Close(ctx)
+
+	list := make([]models.Collection, 0)
+	if err = cursor.All(ctx, &list); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListCollections
+	}
+	for i := range list {
+		list[i].Published = list[i].LiveAt(now)
+	}
+	return list, total, nil
+}
+
+// CreateCollection validates and stores a new collection. Its slug is made
+// from its title unless one is given.
+func CreateCollection(ctx context.Context, collectionCollection, prodCollection *mongo.Collection, collection models.Collection) (models.Collection, error) {
+	if err := normalizeCollection(ctx, prodCollection, &collection); err != nil {
+		return collection, err
+	}
+	collection.ID = primitive.NewObjectID()
+	collection.CreatedAt = time.Now()
+	collection.UpdatedAt = collection.CreatedAt
+
+	_, err := collectionCollection.InsertOne(ctx, collection)
+	if mongo.IsDuplicateKeyError(err) {
+		return collection, ErrDuplicateCollection
+	}
+	if err != nil {
+		log.Println(err)
+		return collection, ErrCantUpdateCollection
+	}
+	collection.Published = collection.LiveAt(collection.CreatedAt)
+	return collection, nil
+}
+
+// UpdateCollection replaces everything about a collection, its schedule
+// included.
+func UpdateCollection(ctx context.Context, collectionCollection, prodCollection *mongo.Collection, collectionID string, collection models.Collection) (models.Collection, error) {
+	existing, err := collectionByID(ctx, collectionCollection, collectionID)
+	if err != nil {
+		return collection, err
+	}
+	if err = normalizeCollection(ctx, prodCollection, &collection); err != nil {
+		return collection, err
+	}
+	collection.ID = existing.ID
+	collection.CreatedAt = existing.CreatedAt
+	collection.UpdatedAt = time.Now()
+
+	result, err := collectionCollection.ReplaceOne(ctx, bson.M{"_id": existing.ID}, collection)
+	if mongo.IsDuplicateKeyError(err) {
+		return collection, ErrDuplicateCollection
+	}
+	if err != nil {
+		log.Println(err)
+		return collection, ErrCantUpdateCollection
+	}
+	if result.MatchedCount == 0 {
+		return collection, ErrCantFindCollection
+	}
+	collection.Published = collection.LiveAt(collection.UpdatedAt)
+	return collection, nil
+}
+
+// SetCollectionPublished publishes a collection now, dropping any end of
+// its schedule, or takes it down and back to a draft.
+func SetCollectionPublished(ctx context.Context, collectionCollection *mongo.Collection, collectionID string, published bool) (models.Collection, error) {
+	id, err := primitive.ObjectIDFromHex(collectionID)
+	if err != nil {
+		return models.Collection{}, ErrCantFindCollection
+	}
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"publish_at": now, "updated_at": now},
+		"$unset": bson.M{"unpublish_at": ""},
+	}
+	if !published {
+		update = bson.M{
+			"$set":   bson.M{"updated_at": now},
+			"$unset": bson.M{"publish_at": "", "unpublish_at": ""},
+		}
+	}
+	var collection models.Collection
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collectionCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&collection)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return collection, ErrCantFindCollection
+	}
+	if err != nil {
+		log.Println(err)
+		return collection, ErrCantUpdateCollection
+	}
+	collection.Published = collection.LiveAt(now)
+	return collection, nil
+}
+
+func DeleteCollection(ctx context.Context, collectionCollection *mongo.Collection, collectionID string) error {
+	id, err := primitive.ObjectIDFromHex(collectionID)
+	if err != nil {
+		return ErrCantFindCollection
+	}
+	result, err := collectionCollection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCollection
+	}
+	if result.DeletedCount == 0 {
+		return ErrCantFindCollection
+	}
+	return nil
+}
+
+// normalizeCollection trims collection and checks it is either a manual
+// collection of products or a valid rule, that its schedule ends after it
+// starts and that the products it names exist.
+func normalizeCollection(ctx context.Context, prodCollection *mongo.Collection, collection *models.Collection) error {
+	collection.Title = strings.TrimSpace(collection.Title)
+	collection.Description = strings.TrimSpace(collection.Description)
+	collection.Image = strings.TrimSpace(collection.Image)
+	collection.Rule = strings.TrimSpace(collection.Rule)
+	if collection.Slug == "" {
+		collection.Slug = collection.Title
+	}
+	collection.Slug = Slugify(collection.Slug)
+	if collection.Title == "" || collection.Slug == "" {
+		return ErrInvalidCollection
+	}
+	if collection.PublishAt != nil && collection.UnpublishAt != nil && !collection.UnpublishAt.After(*collection.PublishAt) {
+		return ErrInvalidCollection
+	}
+
+	switch collection.Kind {
+	case models.CollectionManual:
+		if collection.Rule != "" {
+			return ErrInvalidCollection
+		}
+	case models.CollectionRule:
+		if len(collection.ProductIDs) > 0 {
+			return ErrInvalidCollection
+		}
+		rule, err := rules.Parse(collection.Rule)
+		if err != nil {
+			return err
+		}
+		if _, err = rule.Filter(ruleResolver{ctx: ctx, db: prodCollection.Database(), strict: true}); err != nil {
+			return err
+		}
+		collection.Rule = rule.String()
+	default:
+		return ErrInvalidCollection
+	}
+
+	named := make(map[primitive.ObjectID]bool)
+	for _, ids := range [][]primitive.ObjectID{collection.ProductIDs, collection.Pinned} {
+		seen := make(map[primitive.ObjectID]bool, len(ids))
+		for _, id := range ids {
+			if seen[id] {
+				return ErrInvalidCollection
+			}
+			seen[id] = true
+			named[id] = true
+		}
+	}
+	boosted := make(map[primitive.ObjectID]bool, len(collection.Boosts))
+	for _, boost := range collection.Boosts {
+		if boosted[boost.ProductID] {
+			return ErrInvalidCollection
+		}
+		boosted[boost.ProductID] = true
+		named[boost.ProductID] = true
+	}
+	if len(named) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, 0, len(named))
+	for id := range named {
+		ids = append(ids, id)
+	}
+	found, err := prodCollection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCollection
+	}
+	if found != int64(len(ids)) {
+		return ErrCantFindProduct
+	}
+	return nil
+}
+
+// ListCollectionProducts returns the published collection with slug and
+// one page (1-based) of its products in the order merchandisers gave
+// them, together with the total number of them.
+func ListCollectionProducts(ctx context.Context, collectionCollection, prodCollection *mongo.Collection, slug string, page, limit int64) (models.Collection, []models.Product, int64, error) {
+	query := publishedFilter(time.Now())
+	query["slug"] = Slugify(slug)
+	collection, err := findCollection(ctx, collectionCollection, query)
+	if err != nil {
+		return collection, nil, 0, err
+	}
+	products, total, err := collectionProducts(ctx, prodCollection, collection, page, limit)
+	return collection, products, total, err
+}
+
+// PreviewCollection is ListCollectionProducts for merchandisers: it finds
+// the collection by id and whether or not it is published.
+func PreviewCollection(ctx context.Context, collectionCollection, prodCollection *mongo.Collection, collectionID string, page, limit int64) (models.Collection, []models.Product, int64, error) {
+	collection, err := collectionByID(ctx, collectionCollection, collectionID)
+	if err != nil {
+		return collection, nil, 0, err
+	}
+	products, total, err := collectionProducts(ctx, prodCollection, collection, page, limit)
+	return collection, products, total, err
+}
+
+// collectionProducts returns one page of the products of collection:
+// pinned products first, in order, then by boost, rating and name.
+func collectionProducts(ctx context.Context, prodCollection *mongo.Collection, collection models.Collection, page, limit int64) ([]models.Product, int64, error) {
+	var members bson.M
+	switch collection.Kind {
+	case models.CollectionRule:
+		rule, err := rules.Parse(collection.Rule)
+		if err == nil {
+			members, err = rule.Filter(ruleResolver{ctx: ctx, db: prodCollection.Database()})
+		}
+		if err != nil {
+			log.Println(err)
+			return nil, 0, ErrCantListProducts
+		}
+	default:
+		members = bson.M{"_id": bson.M{"$in": nonNil(collection.ProductIDs)}}
+	}
+	pinned := nonNil(collection.Pinned)
+	query := bson.M{"$or": bson.A{members, bson.M{"_id": bson.M{"$in": pinned}}}}
+
+	total, err := prodCollection.CountDocuments(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+
+	boosted := make(bson.A, len(collection.Boosts))
+	scores := make(bson.A, len(collection.Boosts))
+	for i, boost := range collection.Boosts {
+		boosted[i] = boost.ProductID
+		scores[i] = boost.Score
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$addFields", Value: bson.M{
+			"_pin": bson.M{"$indexOfArray": bson.A{pinned, "$_id"}},
+			"_boost": bson.M{"$let": bson.M{
+				"vars": bson.M{"i": bson.M{"$indexOfArray": bson.A{boosted, "$_id"}}},
+				"in":   bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$$i", 0}}, bson.M{"$arrayElemAt": bson.A{scores, "$$i"}}, 0}},
+			}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"_pinned": bson.M{"$gte": bson.A{"$_pin", 0}}}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "_pinned", Value: -1},
+			{Key: "_pin", Value: 1},
+			{Key: "_boost", Value: -1},
+			{Key: "rating", Value: -1},
+			{Key: "product_name", Value: 1},
+			{Key: "_id", Value: 1},
+		}}},
+		{{Key: "$skip", Value: (page - 1) * limit}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$unset", Value: bson.A{"_pin", "_pinned", "_boost"}}},
+	}
+	cursor, err := prodCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+	defer cursor.Close(ctx)
+
+	products := make([]models.Product, 0)
+	if err = cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListProducts
+	}
+	return products, total, nil
+}
+
+func nonNil(ids []primitive.ObjectID) []primitive.ObjectID {
+	if ids == nil {
+		return []primitive.ObjectID{}
+	}
+	return ids
+}
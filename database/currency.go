@@ -0,0 +1,351 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrUnsupportedCurrency = errors.New("currency is not supported")
+	ErrNoExchangeRate      = errors.New("no exchange rate to price in the requested currency")
+	ErrInvalidRateTable    = errors.New("exchange-rate table is not valid")
+	ErrCantListRates       = errors.New("cannot list exchange rates")
+	ErrCantImportRates     = errors.New("cannot import exchange rates")
+	ErrInvalidPrices       = errors.New("product prices are not valid")
+	ErrCantUpdateProduct   = errors.New("cannot update product")
+)
+
+// ResolveCurrency picks the currency to price in: requested when given,
+// otherwise the user's preferred currency, otherwise DefaultCurrency.
+func ResolveCurrency(requested string, preferred string) (string, error) {
+	currency := strings.ToUpper(strings.TrimSpace(requested))
+	if currency == "" {
+		currency = preferred
+	}
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
+	if !models.IsSupportedCurrency(currency) {
+		return "", ErrUnsupportedCurrency
+	}
+	return currency, nil
+}
+
+// pricer prices products in one currency. A product's explicit price in
+// that currency wins; otherwise its base price is converted through the
+// exchange-rate table in force when the pricer was made. The rates used are
+// kept so they can be locked onto an order.
+type pricer struct {
+	currency string
+	table    *models.ExchangeRateTable
+	applied  []models.AppliedRate
+}
+
+func newPricer(ctx context.Context, rateCollection *mongo.Collection, currency string) (*pricer, error) {
+	p := &pricer{currency: currency}
+	table, err := currentRateTable(ctx, rateCollection, time.Now())
+	if errors.Is(err, ErrNoExchangeRate) {
+		// Only conversions need a table; explicit prices still work.
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.table = &table
+	return p, nil
+}
+
+// price returns the price of a product with base price base and explicit
+// prices prices.
+func (p *pricer) price(base money.Money, prices []money.Money) (money.Money, error) {
+	for _, price := range prices {
+		if price.Currency == p.currency {
+			return price, nil
+		}
+	}
+	base = base.In(models.DefaultCurrency)
+	if base.Currency == p.currency {
+		return base, nil
+	}
+
+	rate, err := p.rate(base.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	// The rate converts major units; scale it to minor units of both
+	// currencies before applying it.
+	scaled := new(big.Rat).Mul(rate, new(big.Rat).SetFrac(pow10(money.Exponent(p.currency)), pow10(money.Exponent(base.Currency))))
+	return money.New(base.Amount, p.currency).MulRat(scaled, money.HalfUp), nil
+}
+
+// rate returns how much of the pricer's currency one unit of from buys,
+// reusing the rate already applied for from if there is one.
+func (p *pricer) rate(from string) (*big.Rat, error) {
+	for _, applied := range p.applied {
+		if applied.From == from {
+			rate, _ := new(big.Rat).SetString(applied.Rate)
+			return rate, nil
+		}
+	}
+	if p.table == nil {
+		return nil, ErrNoExchangeRate
+	}
+	fromRate, ok := tableRate(*p.table, from)
+	if !ok {
+		return nil, ErrNoExchangeRate
+	}
+	toRate, ok := tableRate(*p.table, p.currency)
+	if !ok {
+		return nil, ErrNoExchangeRate
+	}
+	rate := new(big.Rat).Quo(toRate, fromRate)
+	p.applied = append(p.applied, models.AppliedRate{
+		From:          from,
+		To:            p.currency,
+		Rate:          formatRate(rate),
+		TableID:       p.table.ID,
+		EffectiveDate: p.table.EffectiveDate,
+	})
+	return rate, nil
+}
+
+// priceCart returns a copy of cart with every Price in the pricer's
+// currency.
+func (p *pricer) priceCart(cart []models.ProductUser) ([]models.ProductUser, error) {
+	priced := make([]models.ProductUser, len(cart))
+	for i, product := range cart {
+		price, err := p.price(product.Price, product.Prices)
+		if err != nil {
+			return nil, err
+		}
+		priced[i] = product
+		priced[i].Price = price
+	}
+	return priced, nil
+}
+
+// PriceProducts sets the Price of each of products, and of each of their
+// variants with a price of its own, to its price in currency, for showing
+// the catalog.
+func PriceProducts(ctx context.Context, rateCollection *mongo.Collection, currency string, products []models.Product) error {
+	p, err := newPricer(ctx, rateCollection, currency)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		products[i].Price, err = p.price(products[i].Price, products[i].Prices)
+		if err != nil {
+			return err
+		}
+		for j := range products[i].Variants {
+			variant := &products[i].Variants[j]
+			if variant.Price.IsZero() {
+				continue
+			}
+			if variant.Price, err = p.price(variant.Price, variant.Prices); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// currentRateTable returns the exchange-rate table in force at at: the one
+// with the latest effective date not after it.
+func currentRateTable(ctx context.Context, rateCollection *mongo.Collection, at time.Time) (models.ExchangeRateTable, error) {
+	var table models.ExchangeRateTable
+	opts := options.FindOne().SetSort(bson.D{{Key: "effective_date", Value: -1}})
+	err := rateCollection.FindOne(ctx, bson.M{"effective_date": bson.M{"$lte": at}}, opts).Decode(&table)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return table, ErrNoExchangeRate
+	}
+	if err != nil {
+		log.Println(err)
+		return table, ErrCantListRates
+	}
+	return table, nil
+}
+
+// ImportExchangeRates stores table as the rates in force from its effective
+// date, which defaults to today. Importing a date again replaces that
+// date's rates; orders keep the rates locked onto them.
+func ImportExchangeRates(ctx context.Context, rateCollection *mongo.Collection, table models.ExchangeRateTable) (models.ExchangeRateTable, error) {
+	table.Base = strings.ToUpper(strings.TrimSpace(table.Base))
+	if table.Base == "" {
+		table.Base = models.DefaultCurrency
+	}
+	if len(table.Rates) == 0 {
+		return table, ErrInvalidRateTable
+	}
+	rates := make(map[string]string, len(table.Rates))
+	for currency, rate := range table.Rates {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		parsed, ok := new(big.Rat).SetString(strings.TrimSpace(rate))
+		if len(currency) != 3 || !ok || parsed.Sign() <= 0 {
+			return table, ErrInvalidRateTable
+		}
+		rates[currency] = formatRate(parsed)
+	}
+	if rate, ok := rates[table.Base]; ok && rate != "1" {
+		return table, ErrInvalidRateTable
+	}
+	delete(rates, table.Base)
+	table.Rates = rates
+
+	if table.EffectiveDate.IsZero() {
+		table.EffectiveDate = time.Now()
+	}
+	table.EffectiveDate = table.EffectiveDate.UTC().Truncate(24 * time.Hour)
+	table.ImportedAt = time.Now()
+	table.ID = primitive.NilObjectID
+
+	opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After)
+	err := rateCollection.FindOneAndReplace(ctx, bson.M{"effective_date": table.EffectiveDate}, table, opts).Decode(&table)
+	if err != nil {
+		log.Println(err)
+		return table, ErrCantImportRates
+	}
+	return table, nil
+}
+
+// ListExchangeRates returns one page (1-based) of exchange-rate tables,
+// newest effective date first, together with the total number of tables.
+func ListExchangeRates(ctx context.Context, rateCollection *mongo.Collection, page, limit int64) ([]models.ExchangeRateTable, int64, error) {
+	total, err := rateCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListRates
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "effective_date", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := rateCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListRates
+	}
+	defer cursor.Close(ctx)
+
+	tables := make([]models.ExchangeRateTable, 0)
+	if err = cursor.All(ctx, &tables); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListRates
+	}
+	return tables, total, nil
+}
+
+// SetProductPrices replaces the explicit per-currency prices of a product.
+// Each must be positive and in a supported currency other than the one of
+// the base price, at most one per currency.
+func SetProductPrices(ctx context.Context, prodCollection *mongo.Collection, productID string, prices []money.Money) (models.Product, error) {
+	var product models.Product
+	id, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return product, ErrCantFindProduct
+	}
+	if err = prodCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&product); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+		}
+		return product, ErrCantFindProduct
+	}
+
+	base := product.Price.In(models.DefaultCurrency).Currency
+	seen := make(map[string]bool, len(prices))
+	for _, price := range prices {
+		if !price.IsPositive() || !models.IsSupportedCurrency(price.Currency) || price.Currency == base || seen[price.Currency] {
+			return product, ErrInvalidPrices
+		}
+		seen[price.Currency] = true
+	}
+
+	_, err = prodCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"prices": prices}})
+	if err != nil {
+		log.Println(err)
+		return product, ErrCantUpdateProduct
+	}
+	product.Prices = prices
+	return product, nil
+}
+
+// SetPreferredCurrency changes the currency the user is priced in when a
+// request does not pick one.
+func SetPreferredCurrency(ctx context.Context, userCollection *mongo.Collection, userID string, currency string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if !models.IsSupportedCurrency(currency) {
+		return ErrUnsupportedCurrency
+	}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"currency": currency}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindUser
+	}
+	return nil
+}
+
+// PreferredCurrency returns the user's preferred currency, or "" if they
+// have not picked one.
+func PreferredCurrency(ctx context.Context, userCollection *mongo.Collection, userID string) (string, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return "", ErrUserIdIsNotValid
+	}
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"currency": 1})
+	err = userCollection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", ErrCantFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return "", ErrCantFindUser
+	}
+	return user.Currency, nil
+}
+
+func tableRate(table models.ExchangeRateTable, currency string) (*big.Rat, bool) {
+	if currency == table.Base {
+		return big.NewRat(1, 1), true
+	}
+	rate, ok := new(big.Rat).SetString(table.Rates[currency])
+	if !ok || rate.Sign() <= 0 {
+		return nil, false
+	}
+	return rate, true
+}
+
+// formatRate writes rate as a decimal when it has a short exact one, and as
+// a fraction otherwise, so parsing it back gives the same rate.
+func formatRate(rate *big.Rat) string {
+	for prec := 0; prec <= 12; prec++ {
+		s := rate.FloatString(prec)
+		if parsed, ok := new(big.Rat).SetString(s); ok && parsed.Cmp(rate) == 0 {
+			return s
+		}
+	}
+	return rate.RatString()
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
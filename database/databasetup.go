@@ -2,22 +2,32 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"time"
 
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+// DBSet connects to MONGODB_URL, read from .env when there is one. A
+// server that does not answer yet is only logged: the driver keeps trying,
+// and requests fail until it is up.
 func DBSet() *mongo.Client {
 	err := godotenv.Load(".env")
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Println("no .env file, reading settings from the environment")
 	}
 	MongoDB := os.Getenv("MONGODB_URL")
+	if MongoDB == "" {
+		MongoDB = "mongodb://localhost:27017"
+	}
 	client, err := mongo.NewClient(options.Client().ApplyURI(MongoDB))
 
 	if err != nil {
@@ -35,10 +45,12 @@ func DBSet() *mongo.Client {
 		// return
 	}
 
-	err = client.Ping(context.TODO(), nil)
+	pingCtx, pingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer pingCancel()
+	err = client.Ping(pingCtx, nil)
 	if err != nil {
 		log.Println("failed to connect to mongo")
-		return nil
+		return client
 	}
 
 	fmt.Println(" ########3 Connected to Mongo #######")
@@ -59,7 +71,194 @@ func ProductData(client *mongo.Client, collectionName string) *mongo.Collection
 	return collection
 }
 
+func OrderData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
 var (
 	UserCollection    *mongo.Collection = UserData(Client, "Users")
 	ProductCollection *mongo.Collection = ProductData(Client, "Products")
+	OrderCollection   *mongo.Collection = OrderData(Client, "Orders")
 )
+
+// EnsureIndexes creates the indexes the queries in this package rely on.
+// Creating an index that already exists is a no-op, so it is safe to call
+// on every start.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		"Orders": {
+			{Keys: bson.D{{Key: "order_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "ordered_at", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "ordered_at", Value: -1}}},
+			{Keys: bson.D{{Key: "invoice_number", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "reserved_until", Value: 1}}},
+			{Keys: bson.D{{Key: "items.warehouse_id", Value: 1}, {Key: "ordered_at", Value: -1}}},
+		},
+		"PaymentIntents": {
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "reference", Value: 1}}},
+		},
+		"PaymentEvents": {
+			{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "received_at", Value: 1}}},
+		},
+		"Refunds": {
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		},
+		"Invoices": {
+			{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "issued_at", Value: 1}}},
+			{Keys: bson.D{{Key: "refund_id", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		},
+		"Products": {
+			{Keys: bson.D{{Key: "variants.sku", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+			{Keys: bson.D{{Key: "category_ids", Value: 1}, {Key: "product_name", Value: 1}}},
+			{Keys: bson.D{{Key: "brand_id", Value: 1}, {Key: "product_name", Value: 1}}},
+		},
+		"Brands": {
+			{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"Collections": {
+			{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "publish_at", Value: 1}, {Key: "unpublish_at", Value: 1}}},
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		},
+		"Categories": {
+			{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "path", Value: 1}}},
+			{Keys: bson.D{{Key: "parent_id", Value: 1}}},
+		},
+		"Inventory": {
+			{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "variant_id", Value: 1}, {Key: "warehouse_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "warehouse_id", Value: 1}}},
+		},
+		"StockMovements": {
+			{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "variant_id", Value: 1}, {Key: "warehouse_id", Value: 1}, {Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "at", Value: -1}}},
+		},
+		"StockSnapshots": {
+			{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "variant_id", Value: 1}, {Key: "warehouse_id", Value: 1}, {Key: "seq", Value: -1}}},
+		},
+		"CycleCounts": {
+			{Keys: bson.D{{Key: "warehouse_id", Value: 1}, {Key: "counted_at", Value: -1}}},
+		},
+		"Suppliers": {
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "products.product_id", Value: 1}}},
+		},
+		"PurchaseOrders": {
+			{Keys: bson.D{{Key: "po_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expected_at", Value: 1}}},
+			{Keys: bson.D{{Key: "supplier_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "lines.product_id", Value: 1}, {Key: "status", Value: 1}}},
+		},
+		"Replenishment": {
+			{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "variant_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "low", Value: 1}, {Key: "days_of_stock", Value: 1}}},
+		},
+		"Warehouses": {
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "active", Value: 1}, {Key: "created_at", Value: 1}}},
+		},
+		"Transfers": {
+			{Keys: bson.D{{Key: "transfer_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		"Shipments": {
+			{Keys: bson.D{{Key: "carrier", Value: 1}, {Key: "awb", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "created_at", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "polled_at", Value: 1}}},
+		},
+		"Ledger": {
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "at", Value: 1}}},
+		},
+		"WalletEntries": {
+			{Keys: bson.D{{Key: "account", Value: 1}, {Key: "at", Value: -1}}},
+			{Keys: bson.D{{Key: "transaction_id", Value: 1}}},
+		},
+		"GiftCards": {
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+		},
+		"ExchangeRates": {
+			{Keys: bson.D{{Key: "effective_date", Value: -1}}, Options: options.Index().SetUnique(true)},
+		},
+		"Promotions": {
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+			{Keys: bson.D{{Key: "active", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		"ShippingZones": {
+			{Keys: bson.D{{Key: "active", Value: 1}, {Key: "created_at", Value: 1}}},
+		},
+		"PromotionRedemptions": {
+			{Keys: bson.D{{Key: "promotion_id", Value: 1}, {Key: "user_id", Value: 1}}},
+		},
+		"Returns": {
+			{Keys: bson.D{{Key: "rma_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "status", Value: 1}}},
+		},
+	}
+	for collectionName, names := range replacedIndexes {
+		for _, name := range names {
+			_, err := db.Collection(collectionName).Indexes().DropOne(ctx, name)
+			var cmdErr mongo.CommandError
+			if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound")) {
+				return fmt.Errorf("dropping index %s on %s: %w", name, collectionName, err)
+			}
+		}
+	}
+	for collectionName, models := range indexes {
+		if _, err := db.Collection(collectionName).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("creating indexes on %s: %w", collectionName, err)
+		}
+	}
+	return nil
+}
+
+// replacedIndexes lists, per collection, indexes earlier versions created
+// that would now get in the way. Stock was unique per product before it
+// was kept per warehouse, and per product and warehouse, like movements
+// and reorder suggestions per product, before it was kept per variant.
+var replacedIndexes = map[string][]string{
+	"Inventory":      {"product_id_1", "product_id_1_warehouse_id_1"},
+	"StockMovements": {"product_id_1_warehouse_id_1_seq_1"},
+	"Replenishment":  {"product_id_1"},
+}
+
+// nextSequence atomically increments and returns the named counter, creating
+// it at 1 on first use.
+func nextSequence(ctx context.Context, db *mongo.Database, name string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := db.Collection("Counters").FindOneAndUpdate(ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		opts,
+	).Decode(&counter)
+	return counter.Seq, err
+}
+
+// runInTransaction runs fn inside a multi-document transaction on client.
+// Transactions need MongoDB running as a replica set; fn may be retried by
+// the driver on transient errors so it must not have side effects outside
+// the session.
+func runInTransaction(ctx context.Context, client *mongo.Client, fn func(sessCtx mongo.SessionContext) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	txnOpts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.Majority())
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	}, txnOpts)
+	return err
+}
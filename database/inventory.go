@@ -0,0 +1,487 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/shipping"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrOutOfStock          = errors.New("not enough stock")
+	ErrStockBelowReserved  = errors.New("stock cannot drop below what is reserved for orders")
+	ErrCantFindStock       = errors.New("stock of this product is not tracked")
+	ErrStockDrift          = errors.New("stock record disagrees with its ledger, count it to put it right")
+	ErrCantUpdateInventory = errors.New("cannot update inventory")
+	ErrCantListInventory   = errors.New("cannot list inventory")
+)
+
+// ReservationTTL is how long an order waiting for an online payment holds
+// its stock. Orders still unpaid after that are cancelled by the
+// background job. Cash on delivery orders hold their stock until they are
+// paid or cancelled.
+var ReservationTTL = 30 * time.Minute
+
+// expiryBatch is how many orders one run of ExpireReservations cancels at
+// most.
+const expiryBatch = 100
+
+// inventory is the collection stock levels are kept in, next to the
+// orders that draw on them.
+func inventory(db *mongo.Database) *mongo.Collection {
+	return db.Collection("Inventory")
+}
+
+// stockItem is what stock is kept of: a product without variants, or one
+// variant of a product with them.
+type stockItem struct {
+	ProductID primitive.ObjectID
+	VariantID primitive.ObjectID
+}
+
+// itemOf is the stock item of a product, or of its variant variantID.
+func itemOf(productID, variantID primitive.ObjectID) stockItem {
+	return stockItem{ProductID: productID, VariantID: variantID}
+}
+
+// filter matches the stock records, movements and snapshots of item. Those
+// of products without variants have no variant_id at all, so an upsert
+// through the filter does not give them one.
+func (item stockItem) filter() bson.M {
+	filter := bson.M{"product_id": item.ProductID, "variant_id": bson.M{"$exists": false}}
+	if !item.VariantID.IsZero() {
+		filter["variant_id"] = item.VariantID
+	}
+	return filter
+}
+
+// stockLevels returns the units available of each item of the products
+// among productIDs, per warehouse. Items missing from it are not tracked.
+func stockLevels(ctx context.Context, db *mongo.Database, productIDs []primitive.ObjectID) (map[stockItem]map[primitive.ObjectID]int64, error) {
+	cursor, err := inventory(db).Find(ctx, bson.M{"product_id": bson.M{"$in": productIDs}})
+	if err != nil {
+		return nil, err
+	}
+	var records []models.Stock
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	levels := make(map[stockItem]map[primitive.ObjectID]int64)
+	for _, record := range records {
+		item := itemOf(record.ProductID, record.VariantID)
+		if levels[item] == nil {
+			levels[item] = make(map[primitive.ObjectID]int64)
+		}
+		levels[item][record.WarehouseID] = record.Available()
+	}
+	return levels, nil
+}
+
+// availability returns how many units of each tracked item of the
+// products among productIDs the active warehouses can still sell between
+// them.
+func availability(ctx context.Context, db *mongo.Database, productIDs []primitive.ObjectID) (map[stockItem]int64, error) {
+	levels, err := stockLevels(ctx, db, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	active, err := activeWarehouses(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[stockItem]int64, len(levels))
+	for item, perWarehouse := range levels {
+		available[item] = 0
+		for _, warehouse := range active {
+			if units := perWarehouse[warehouse.ID]; units > 0 {
+				available[item] += units
+			}
+		}
+	}
+	return available, nil
+}
+
+// CheckStock reports ErrOutOfStock if fewer than quantity units of the
+// product, or of its variant variantID, are available across the active
+// warehouses. Untracked items are always in stock.
+func CheckStock(ctx context.Context, inventoryCollection *mongo.Collection, productID, variantID primitive.ObjectID, name string, quantity uint) error {
+	available, err := availability(ctx, inventoryCollection.Database(), []primitive.ObjectID{productID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantFindStock
+	}
+	if units, tracked := available[itemOf(productID, variantID)]; tracked && units < int64(quantity) {
+		return fmt.Errorf("%w: %s", ErrOutOfStock, name)
+	}
+	return nil
+}
+
+// AddAvailability fills in the Availability of products, and of each of
+// their variants, from the stock of the active warehouses. A product with
+// variants has the units of its variants between them, and no count at all
+// if any of them is not tracked.
+func AddAvailability(ctx context.Context, inventoryCollection *mongo.Collection, products []models.Product) error {
+	ids := make([]primitive.ObjectID, len(products))
+	for i, product := range products {
+		ids[i] = product.ProductID
+	}
+	available, err := availability(ctx, inventoryCollection.Database(), ids)
+	if err != nil {
+		log.Println(err)
+		return ErrCantListInventory
+	}
+	for i := range products {
+		product := &products[i]
+		if len(product.Variants) == 0 {
+			product.Availability = availabilityOf(available, itemOf(product.ProductID, primitive.NilObjectID))
+			continue
+		}
+		var total int64
+		tracked := true
+		for j := range product.Variants {
+			variant := &product.Variants[j]
+			variant.Availability = availabilityOf(available, itemOf(product.ProductID, variant.VariantID))
+			if !variant.Active {
+				continue
+			}
+			if variant.Availability.Available == nil {
+				tracked = false
+			} else {
+				total += *variant.Availability.Available
+			}
+		}
+		if !tracked {
+			product.Availability = &models.Availability{InStock: true}
+			continue
+		}
+		product.Availability = &models.Availability{InStock: total > 0, Available: &total}
+	}
+	return nil
+}
+
+// availabilityOf is the Availability of item among available.
+func availabilityOf(available map[stockItem]int64, item stockItem) *models.Availability {
+	units, tracked := available[item]
+	if !tracked {
+		return &models.Availability{InStock: true}
+	}
+	return &models.Availability{InStock: units > 0, Available: &units}
+}
+
+// reserveStock holds stock for every line of order whose product is
+// tracked, failing with ErrOutOfStock if any of them runs short. It must
+// run inside the transaction that stores the order. An order already paid,
+// such as one paid in full with store credit, takes the stock at once.
+//
+// The order is fulfilled from the nearest active warehouse to its shipping
+// address that can fill every line. If none can, each line comes from the
+// nearest warehouse that can fill it; a line is never split between
+// warehouses.
+func reserveStock(ctx context.Context, db *mongo.Database, order *models.Order) error {
+	now := time.Now()
+	order.ReservedUntil = time.Time{}
+	needed := make(map[stockItem]int64)
+	var productIDs []primitive.ObjectID
+	for i := range order.Items {
+		item := &order.Items[i]
+		// Start afresh in case the transaction is being retried.
+		item.Reserved, item.Deducted, item.WarehouseID = 0, 0, primitive.NilObjectID
+		needed[itemOf(item.ProductID, item.VariantID)] += int64(item.ActiveQuantity())
+		productIDs = append(productIDs, item.ProductID)
+	}
+	levels, err := stockLevels(ctx, db, productIDs)
+	if err != nil || len(levels) == 0 {
+		return err
+	}
+	active, err := activeWarehouses(ctx, db)
+	if err != nil {
+		return err
+	}
+	var country, postalCode string
+	if order.ShippingAddress != nil {
+		country, postalCode = order.ShippingAddress.Country, order.ShippingAddress.PostalCode()
+	}
+	nearest := shipping.RankWarehouses(active, country, postalCode)
+
+	var whole primitive.ObjectID
+	for _, warehouse := range nearest {
+		fills := true
+		for stocked, units := range needed {
+			perWarehouse, tracked := levels[stocked]
+			if tracked && perWarehouse[warehouse.ID] < units {
+				fills = false
+				break
+			}
+		}
+		if fills {
+			whole = warehouse.ID
+			break
+		}
+	}
+
+	reserved := false
+	for i := range order.Items {
+		item := &order.Items[i]
+		stocked := itemOf(item.ProductID, item.VariantID)
+		perWarehouse, tracked := levels[stocked]
+		quantity := int64(item.ActiveQuantity())
+		if !tracked || quantity == 0 {
+			continue
+		}
+		from := whole
+		if from.IsZero() {
+			for _, warehouse := range nearest {
+				if perWarehouse[warehouse.ID] >= quantity {
+					from = warehouse.ID
+					break
+				}
+			}
+		}
+		if from.IsZero() {
+			return fmt.Errorf("%w: %s", ErrOutOfStock, item.Name())
+		}
+		filter := stocked.filter()
+		filter["warehouse_id"] = from
+		filter["$expr"] = bson.M{"$gte": bson.A{bson.M{"$subtract": bson.A{"$on_hand", "$reserved"}}, quantity}}
+		update := bson.M{"$inc": bson.M{"reserved": quantity}, "$set": bson.M{"updated_at": now}}
+		result, err := inventory(db).UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("%w: %s", ErrOutOfStock, item.Name())
+		}
+		perWarehouse[from] -= quantity
+		item.Reserved = uint(quantity)
+		item.WarehouseID = from
+		reserved = true
+	}
+
+	if order.Status == models.OrderPaid {
+		return takeReservedStock(ctx, db, order)
+	}
+	if reserved && order.Status == models.OrderPendingPayment && order.PaymentMethod.Method != models.PaymentCOD {
+		order.ReservedUntil = now.Add(ReservationTTL)
+	}
+	return nil
+}
+
+// takeReservedStock takes the units order has reserved out of stock, as
+// the order is now paid, recording them as sold. It updates order's items
+// but leaves storing them to the caller.
+func takeReservedStock(ctx context.Context, db *mongo.Database, order *models.Order) error {
+	for i := range order.Items {
+		item := &order.Items[i]
+		if item.Reserved == 0 {
+			continue
+		}
+		quantity := int64(item.Reserved)
+		sale := models.StockMovement{
+			ProductID:   item.ProductID,
+			VariantID:   item.VariantID,
+			WarehouseID: item.WarehouseID,
+			Kind:        models.MovementSale,
+			Quantity:    -quantity,
+			Reference:   order.OrderNumber,
+			Actor:       SystemActor,
+			Reason:      "order paid",
+		}
+		if _, _, err := moveStock(ctx, db, sale, -quantity, nil); err != nil {
+			return err
+		}
+		item.Deducted += item.Reserved
+		item.Reserved = 0
+	}
+	order.ReservedUntil = time.Time{}
+	return nil
+}
+
+// commitStock takes a newly paid order's reserved units out of stock and
+// stores the order's items. It must run inside the transaction that marks
+// the order paid.
+func commitStock(ctx context.Context, orderCollection *mongo.Collection, order *models.Order) error {
+	if err := takeReservedStock(ctx, orderCollection.Database(), order); err != nil {
+		return err
+	}
+	update := bson.M{
+		"$set":   bson.M{"items": order.Items},
+		"$unset": bson.M{"reserved_until": ""},
+	}
+	_, err := orderCollection.UpdateOne(ctx, bson.M{"_id": order.ID}, update)
+	return err
+}
+
+// releaseStock gives the stock held by the cancelled lines back: reserved
+// units are released, and units already taken out of stock that never left
+// the warehouse are put back as returned. It updates order's items but
+// leaves storing them to the caller.
+func releaseStock(ctx context.Context, db *mongo.Database, order *models.Order, lines []models.CancelLine, actor string) error {
+	for _, line := range lines {
+		index := orderLine(*order, line.LineID)
+		if index < 0 {
+			continue
+		}
+		item := &order.Items[index]
+		released := min(item.Reserved, line.Quantity)
+		restocked := min(line.Quantity-released, item.Deducted-min(item.Shipped, item.Deducted))
+		if released == 0 && restocked == 0 {
+			continue
+		}
+		restock := models.StockMovement{
+			ProductID:   item.ProductID,
+			VariantID:   item.VariantID,
+			WarehouseID: item.WarehouseID,
+			Kind:        models.MovementReturn,
+			Quantity:    int64(restocked),
+			Reference:   order.OrderNumber,
+			Actor:       actor,
+			Reason:      "order cancelled",
+		}
+		if _, _, err := moveStock(ctx, db, restock, -int64(released), nil); err != nil {
+			return err
+		}
+		item.Reserved -= released
+		item.Deducted -= restocked
+	}
+	return nil
+}
+
+// restockReturn puts the accepted units of rma's lines marked for restock
+// back into the stock of the warehouse they were shipped from.
+func restockReturn(ctx context.Context, orderCollection *mongo.Collection, rma models.Return, actor string) error {
+	var order models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"_id": rma.OrderID}).Decode(&order); err != nil {
+		return err
+	}
+	for _, line := range rma.Lines {
+		index := orderLine(order, line.LineID)
+		if !line.Restock || line.Accepted == 0 || index < 0 || order.Items[index].WarehouseID.IsZero() {
+			continue
+		}
+		restock := models.StockMovement{
+			ProductID:   line.ProductID,
+			VariantID:   order.Items[index].VariantID,
+			WarehouseID: order.Items[index].WarehouseID,
+			Kind:        models.MovementReturn,
+			Quantity:    int64(line.Accepted),
+			Reference:   rma.RMANumber,
+			Actor:       actor,
+			Reason:      "return accepted",
+		}
+		if _, _, err := moveStock(ctx, orderCollection.Database(), restock, 0, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireReservations cancels orders whose online payment was not completed
+// within ReservationTTL, which releases their stock, and returns how many
+// it cancelled.
+func ExpireReservations(ctx context.Context, orderCollection *mongo.Collection) (int, error) {
+	query := bson.M{"status": models.OrderPendingPayment, "reserved_until": bson.M{"$lte": time.Now()}}
+	cursor, err := orderCollection.Find(ctx, query, options.Find().SetLimit(expiryBatch))
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantListOrders
+	}
+	var orders []models.Order
+	if err = cursor.All(ctx, &orders); err != nil {
+		log.Println(err)
+		return 0, ErrCantListOrders
+	}
+
+	expired := 0
+	for _, order := range orders {
+		_, err := cancelOrder(ctx, orderCollection, bson.M{"_id": order.ID, "status": models.OrderPendingPayment},
+			models.OrderStatus.AdminCancellable, nil, SystemActor, "payment not completed in time")
+		if err != nil {
+			log.Printf("expiring order %s: %v", order.OrderNumber, err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// GetStock returns the stock records of a product, one per warehouse and
+// variant, and how many units the active warehouses can still sell between
+// them.
+func GetStock(ctx context.Context, inventoryCollection *mongo.Collection, productID string) ([]models.Stock, int64, error) {
+	id, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return nil, 0, ErrCantFindProduct
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "variant_id", Value: 1}, {Key: "warehouse_id", Value: 1}})
+	cursor, err := inventoryCollection.Find(ctx, bson.M{"product_id": id}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantFindStock
+	}
+	var stock []models.Stock
+	if err = cursor.All(ctx, &stock); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantFindStock
+	}
+	if len(stock) == 0 {
+		return nil, 0, ErrCantFindStock
+	}
+	available, err := availability(ctx, inventoryCollection.Database(), []primitive.ObjectID{id})
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantFindStock
+	}
+	var total int64
+	for _, units := range available {
+		total += units
+	}
+	return stock, total, nil
+}
+
+// ListInventory returns one page (1-based) of stock records, least
+// available first, together with the total number of records. A non-empty
+// warehouseID lists that warehouse's stock only.
+func ListInventory(ctx context.Context, inventoryCollection *mongo.Collection, warehouseID string, page, limit int64) ([]models.Stock, int64, error) {
+	filter := bson.M{}
+	if warehouseID != "" {
+		id, err := primitive.ObjectIDFromHex(warehouseID)
+		if err != nil {
+			return nil, 0, ErrCantFindWarehouse
+		}
+		filter["warehouse_id"] = id
+	}
+	total, err := inventoryCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListInventory
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{"available": bson.M{"$subtract": bson.A{"$on_hand", "$reserved"}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "available", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$skip", Value: (page - 1) * limit}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := inventoryCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListInventory
+	}
+	defer cursor.Close(ctx)
+
+	stock := make([]models.Stock, 0)
+	if err = cursor.All(ctx, &stock); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListInventory
+	}
+	return stock, total, nil
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/payments"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newReservationFixture places an online order for two of a shirt five
+// are in stock of, so that two are reserved until it is paid for.
+func newReservationFixture(t *testing.T) (paymentFixture, primitive.ObjectID) {
+	t.Helper()
+	provider := payments.NewFakeProvider(testWebhookSecret)
+	payments.Register(provider)
+	shirt := cartLine("Shirt", 500)
+	f := paymentFixture{checkoutFixture: newCheckoutFixture(t, shirt, shirt)}
+	f.intents = f.db.Collection("PaymentIntents")
+	for _, name := range []string{"PaymentIntents", "StockMovements"} {
+		if err := f.db.CreateCollection(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.stock(t, shirt.ProductID, 5)
+
+	order, err := f.checkout(f.userID.Hex(), models.CheckoutRequest{PaymentMethod: models.PaymentOnline, Provider: provider.Name()})
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	f.order = order
+	return f, shirt.ProductID
+}
+
+// stockOf returns the units of productID on hand and reserved.
+func (f paymentFixture) stockOf(t *testing.T, productID primitive.ObjectID) (int64, int64) {
+	t.Helper()
+	var stock models.Stock
+	if err := inventory(f.db).FindOne(context.Background(), bson.M{"product_id": productID}).Decode(&stock); err != nil {
+		t.Fatal(err)
+	}
+	return stock.OnHand, stock.Reserved
+}
+
+func TestReservationTakenWhenPaid(t *testing.T) {
+	f, shirt := newReservationFixture(t)
+	if onHand, reserved := f.stockOf(t, shirt); onHand != 5 || reserved != 2 {
+		t.Fatalf("after checkout %d on hand and %d reserved, want 5 and 2", onHand, reserved)
+	}
+	if order := f.stored(t); order.ReservedUntil.IsZero() || order.Items[0].Reserved != 2 {
+		t.Fatalf("order reserves %d until %v, want 2 with an expiry", order.Items[0].Reserved, order.ReservedUntil)
+	}
+
+	if err := f.pay(payments.FakeTokenSuccess); err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+	if onHand, reserved := f.stockOf(t, shirt); onHand != 3 || reserved != 0 {
+		t.Errorf("after payment %d on hand and %d reserved, want 3 and 0", onHand, reserved)
+	}
+	order := f.stored(t)
+	if !order.ReservedUntil.IsZero() || order.Items[0].Reserved != 0 || order.Items[0].Deducted != 2 {
+		t.Errorf("paid order reserves %d until %v and took %d, want 0, no expiry and 2",
+			order.Items[0].Reserved, order.ReservedUntil, order.Items[0].Deducted)
+	}
+	sales := f.count(t, movements(f.db), bson.M{"product_id": shirt, "kind": models.MovementSale, "quantity": -2, "reference": order.OrderNumber})
+	if sales != 1 {
+		t.Errorf("sale movements = %d, want 1", sales)
+	}
+
+	// A paid order is not expired, whatever its reservation said.
+	if expired, err := ExpireReservations(context.Background(), f.orders); err != nil || expired != 0 {
+		t.Errorf("ExpireReservations = %d, %v; want 0, nil", expired, err)
+	}
+}
+
+func TestExpireReservations(t *testing.T) {
+	f, shirt := newReservationFixture(t)
+
+	// Not due yet.
+	if expired, err := ExpireReservations(context.Background(), f.orders); err != nil || expired != 0 {
+		t.Fatalf("ExpireReservations before the expiry = %d, %v; want 0, nil", expired, err)
+	}
+	past := bson.M{"$set": bson.M{"reserved_until": time.Now().Add(-time.Minute)}}
+	if _, err := f.orders.UpdateOne(context.Background(), bson.M{"_id": f.order.ID}, past); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := ExpireReservations(context.Background(), f.orders)
+	if err != nil || expired != 1 {
+		t.Fatalf("ExpireReservations = %d, %v; want 1, nil", expired, err)
+	}
+	if order := f.stored(t); order.Status != models.OrderCancelled || order.Items[0].Reserved != 0 {
+		t.Errorf("expired order %s with %d reserved, want %s with none", order.Status, order.Items[0].Reserved, models.OrderCancelled)
+	}
+	if onHand, reserved := f.stockOf(t, shirt); onHand != 5 || reserved != 0 {
+		t.Errorf("after expiry %d on hand and %d reserved, want 5 and 0", onHand, reserved)
+	}
+}
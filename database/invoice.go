@@ -0,0 +1,474 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/invoices"
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/tax"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindInvoice  = errors.New("cannot find the requested invoice")
+	ErrNothingToInvoice = errors.New("order has nothing to invoice")
+	ErrNotInvoiced      = errors.New("order has not been invoiced yet")
+	ErrCantFindRefund   = errors.New("cannot find the requested refund")
+	ErrRefundNotDone    = errors.New("refund has not been paid out")
+	ErrCantIssueInvoice = errors.New("cannot issue invoice")
+	ErrCantListInvoices = errors.New("cannot list invoices")
+)
+
+// invoiceBatch caps how many documents one run of IssuePendingInvoices
+// issues of each type, so a backlog is worked off over several runs.
+const invoiceBatch = 100
+
+// IssueInvoice issues the invoice for a paid order. An order is invoiced
+// once; asking again returns the invoice already issued. The invoice
+// covers what was not cancelled before it was issued.
+func IssueInvoice(ctx context.Context, invoiceCollection, orderCollection *mongo.Collection, orderID primitive.ObjectID) (models.Invoice, error) {
+	var invoice models.Invoice
+	err := runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		order, err := findOrder(sessCtx, orderCollection, bson.M{"_id": orderID})
+		if err != nil {
+			return err
+		}
+		if order.InvoiceNumber != "" {
+			return invoiceCollection.FindOne(sessCtx, bson.M{"number": order.InvoiceNumber}).Decode(&invoice)
+		}
+		if !wasPaid(order) {
+			return ErrOrderNotPaid
+		}
+		invoice = orderInvoice(order)
+		if !invoice.Total.IsPositive() {
+			return ErrNothingToInvoice
+		}
+		if err = insertInvoice(sessCtx, invoiceCollection, &invoice); err != nil {
+			return err
+		}
+		result, err := orderCollection.UpdateOne(sessCtx,
+			bson.M{"_id": order.ID, "invoice_number": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"invoice_number": invoice.Number}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrOrderChanged
+		}
+		return nil
+	})
+	return invoice, invoiceError(err)
+}
+
+// IssueCreditNote issues the credit note for a refund that has been paid
+// out, in full or in part, against the invoice of its order. Like
+// invoices, each refund gets one credit note however often it is asked for.
+func IssueCreditNote(ctx context.Context, invoiceCollection, orderCollection, refundCollection *mongo.Collection, refundID primitive.ObjectID) (models.Invoice, error) {
+	var note models.Invoice
+	err := runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var refund models.Refund
+		err := refundCollection.FindOne(sessCtx, bson.M{"_id": refundID}).Decode(&refund)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrCantFindRefund
+		}
+		if err != nil {
+			return err
+		}
+		if refund.CreditNote != "" {
+			return invoiceCollection.FindOne(sessCtx, bson.M{"number": refund.CreditNote}).Decode(&note)
+		}
+		if refund.Status != models.RefundCompleted && refund.Status != models.RefundPartial {
+			return ErrRefundNotDone
+		}
+		order, err := findOrder(sessCtx, orderCollection, bson.M{"_id": refund.OrderID})
+		if err != nil {
+			return err
+		}
+		if order.InvoiceNumber == "" {
+			return ErrNotInvoiced
+		}
+		var invoice models.Invoice
+		err = invoiceCollection.FindOne(sessCtx, bson.M{"number": order.InvoiceNumber}).Decode(&invoice)
+		if err != nil {
+			return err
+		}
+
+		note = creditNote(invoice, order, refund)
+		if err = insertInvoice(sessCtx, invoiceCollection, &note); err != nil {
+			return err
+		}
+		result, err := refundCollection.UpdateOne(sessCtx,
+			bson.M{"_id": refund.ID, "credit_note": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"credit_note": note.Number}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrOrderChanged
+		}
+		return nil
+	})
+	return note, invoiceError(err)
+}
+
+// IssueOrderInvoices issues whatever documents an order is due: its
+// invoice once it has been paid, and a credit note for every refund paid
+// out against it. An order not paid for yet is not an error.
+func IssueOrderInvoices(ctx context.Context, invoiceCollection, orderCollection, refundCollection *mongo.Collection, orderID primitive.ObjectID) error {
+	_, err := IssueInvoice(ctx, invoiceCollection, orderCollection, orderID)
+	if errors.Is(err, ErrOrderNotPaid) || errors.Is(err, ErrNothingToInvoice) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	query := bson.M{"order_id": orderID, "status": bson.M{"$in": bson.A{models.RefundCompleted, models.RefundPartial}}, "credit_note": bson.M{"$exists": false}}
+	refunds, err := findRefundIDs(ctx, refundCollection, query)
+	if err != nil {
+		return err
+	}
+	for _, id := range refunds {
+		if _, err = IssueCreditNote(ctx, invoiceCollection, orderCollection, refundCollection, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IssuePendingInvoices catches up on documents that were not issued when
+// their order was paid or their refund paid out, and returns how many it
+// issued. Invoices go first so that credit notes have something to be
+// issued against. Documents that cannot be issued are logged and tried
+// again on the next run, except orders cancelled in full before they were
+// invoiced, which are marked as having nothing to invoice.
+func IssuePendingInvoices(ctx context.Context, invoiceCollection, orderCollection, refundCollection *mongo.Collection) (int, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.D{{Key: "ordered_at", Value: 1}}).SetLimit(invoiceBatch)
+	cursor, err := orderCollection.Find(ctx, bson.M{
+		"invoice_number":        bson.M{"$exists": false},
+		"nothing_to_invoice":    bson.M{"$ne": true},
+		"status_history.status": models.OrderPaid,
+		"total_price.amount":    bson.M{"$gt": 0},
+	}, opts)
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantListOrders
+	}
+	var orders []models.Order
+	if err = cursor.All(ctx, &orders); err != nil {
+		log.Println(err)
+		return 0, ErrCantListOrders
+	}
+
+	issued := 0
+	for _, order := range orders {
+		_, err := IssueInvoice(ctx, invoiceCollection, orderCollection, order.ID)
+		if errors.Is(err, ErrNothingToInvoice) {
+			_, err = orderCollection.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": bson.M{"nothing_to_invoice": true}})
+			if err != nil {
+				log.Printf("marking order %s as having nothing to invoice: %v", order.ID.Hex(), err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("invoicing order %s: %v", order.ID.Hex(), err)
+			continue
+		}
+		issued++
+	}
+
+	refunds, err := findRefundIDs(ctx, refundCollection, bson.M{"status": bson.M{"$in": bson.A{models.RefundCompleted, models.RefundPartial}}, "credit_note": bson.M{"$exists": false}})
+	if err != nil {
+		return issued, err
+	}
+	for _, id := range refunds {
+		_, err := IssueCreditNote(ctx, invoiceCollection, orderCollection, refundCollection, id)
+		if err != nil {
+			// An order not invoiced yet gets its invoice in a later run.
+			if !errors.Is(err, ErrNotInvoiced) {
+				log.Printf("issuing credit note for refund %s: %v", id.Hex(), err)
+			}
+			continue
+		}
+		issued++
+	}
+	return issued, nil
+}
+
+// ListOrderInvoices returns the invoice and credit notes of an order, in
+// the order they were issued, without their PDFs. A userID other than ""
+// limits them to that customer's orders.
+func ListOrderInvoices(ctx context.Context, invoiceCollection *mongo.Collection, orderID string, userID string) ([]models.Invoice, error) {
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return nil, ErrOrderIdIsNotValid
+	}
+	query := bson.M{"order_id": id}
+	if userID != "" {
+		query["user_id"] = userID
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "issued_at", Value: 1}}).
+		SetProjection(bson.M{"pdf": 0})
+	cursor, err := invoiceCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListInvoices
+	}
+	defer cursor.Close(ctx)
+
+	found := make([]models.Invoice, 0)
+	if err = cursor.All(ctx, &found); err != nil {
+		log.Println(err)
+		return nil, ErrCantListInvoices
+	}
+	return found, nil
+}
+
+// GetInvoice returns an invoice or credit note with its PDF. A userID
+// other than "" only finds that customer's documents.
+func GetInvoice(ctx context.Context, invoiceCollection *mongo.Collection, invoiceID string, userID string) (models.Invoice, error) {
+	var invoice models.Invoice
+	id, err := primitive.ObjectIDFromHex(invoiceID)
+	if err != nil {
+		return invoice, ErrCantFindInvoice
+	}
+	query := bson.M{"_id": id}
+	if userID != "" {
+		query["user_id"] = userID
+	}
+	err = invoiceCollection.FindOne(ctx, query).Decode(&invoice)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return invoice, ErrCantFindInvoice
+	}
+	if err != nil {
+		log.Println(err)
+		return invoice, ErrCantFindInvoice
+	}
+	return invoice, nil
+}
+
+// insertInvoice numbers invoice in the series of its type and fiscal year,
+// prints it and stores it. It must run inside the transaction that marks
+// what it was issued for, so a failure does not leave a gap in the series.
+func insertInvoice(sessCtx mongo.SessionContext, invoiceCollection *mongo.Collection, invoice *models.Invoice) error {
+	invoice.ID = primitive.NewObjectID()
+	invoice.IssuedAt = time.Now()
+	invoice.FiscalYear = invoices.FiscalYear(invoice.IssuedAt)
+	seq, err := nextSequence(sessCtx, invoiceCollection.Database(), invoice.Type+":"+invoice.FiscalYear)
+	if err != nil {
+		return err
+	}
+	invoice.Sequence = seq
+	invoice.Number = invoices.Number(invoice.Type, invoice.FiscalYear, seq)
+	invoice.PDF, err = invoices.Render(*invoice)
+	if err != nil {
+		return err
+	}
+	_, err = invoiceCollection.InsertOne(sessCtx, invoice)
+	return err
+}
+
+// orderInvoice builds the invoice for what is left of order after
+// cancellations, with its seller and address copied in.
+func orderInvoice(order models.Order) models.Invoice {
+	invoice := models.Invoice{
+		Type:        models.InvoiceTypeInvoice,
+		OrderID:     order.ID,
+		OrderNumber: order.OrderNumber,
+		UserID:      order.UserID,
+		Seller:      invoices.Seller(),
+		BillTo:      order.ShippingAddress,
+		Currency:    order.Currency,
+	}
+
+	for _, item := range order.Items {
+		quantity := item.ActiveQuantity()
+		if quantity == 0 {
+			continue
+		}
+		invoice.Lines = append(invoice.Lines, invoiceLine(item.Name(), quantity, item.Price,
+			item.Discount.MulFrac(int64(quantity), int64(item.Quantity), money.HalfUp),
+			item.NetAmount(quantity), scaleTaxes(item.Taxes, int64(quantity), int64(item.Quantity))))
+	}
+	if len(invoice.Lines) > 0 {
+		if line, ok := shippingLine(order); ok {
+			invoice.Lines = append(invoice.Lines, line)
+		}
+	}
+	totalInvoice(&invoice)
+	return invoice
+}
+
+// shippingLine is the invoice line for order's shipping after what
+// promotions took off it, if any of it was charged.
+func shippingLine(order models.Order) (models.InvoiceLine, bool) {
+	charged := order.ShippingCharged()
+	if !charged.IsPositive() {
+		return models.InvoiceLine{}, false
+	}
+	discount := order.Shipping.In(order.Currency).Sub(charged)
+	amount := charged
+	if !order.TaxInclusive {
+		amount = amount.Add(order.ShippingTax)
+	}
+	return invoiceLine("Shipping", 1, order.Shipping, discount, amount, order.ShippingTaxes), true
+}
+
+// creditNote builds the credit note for refund against invoice. Refunded
+// lines reverse their share of the line's tax; a shipping refund reverses
+// the tax on shipping, and any other refund reverses the invoice's taxes
+// in proportion to how much of it was refunded.
+func creditNote(invoice models.Invoice, order models.Order, refund models.Refund) models.Invoice {
+	note := models.Invoice{
+		Type:            models.InvoiceTypeCreditNote,
+		OrderID:         order.ID,
+		OrderNumber:     order.OrderNumber,
+		RefundID:        refund.ID,
+		OriginalInvoice: invoice.Number,
+		UserID:          order.UserID,
+		Seller:          invoices.Seller(),
+		BillTo:          invoice.BillTo,
+		Currency:        invoice.Currency,
+		Reason:          refund.Reason,
+	}
+
+	// A refund paid only in part credits what was paid, whatever it was for.
+	kind, amount := refund.Kind, refund.Amount
+	if refund.Status == models.RefundPartial {
+		kind, amount = models.RefundGoodwill, refund.PaidAmount()
+	}
+	switch kind {
+	case models.RefundLines:
+		for _, refunded := range refund.Lines {
+			index := orderLineIndex(order, refunded.LineID)
+			if index < 0 {
+				continue
+			}
+			item := order.Items[index]
+			note.Lines = append(note.Lines, invoiceLine(item.Name(), refunded.Quantity, item.Price,
+				item.Discount.MulFrac(int64(refunded.Quantity), int64(item.Quantity), money.HalfUp),
+				refunded.Amount, scaleTaxes(item.Taxes, int64(refunded.Quantity), int64(item.Quantity))))
+		}
+	case models.RefundShipping:
+		var shipping models.InvoiceLine
+		for _, line := range invoice.Lines {
+			if line.Description == "Shipping" {
+				shipping = line
+			}
+		}
+		note.Lines = append(note.Lines, proportionalLine("Shipping refund", refund.Amount, shipping.Amount, shipping.Taxes))
+	default:
+		note.Lines = append(note.Lines, proportionalLine("Refund", amount, invoice.Total, invoice.TaxBreakdown))
+	}
+	totalInvoice(&note)
+	return note
+}
+
+// proportionalLine is a single credit line of amount, reversing the share
+// of taxes that amount is of whole.
+func proportionalLine(description string, amount, whole money.Money, taxes []models.TaxComponent) models.InvoiceLine {
+	if !whole.IsPositive() {
+		taxes = nil
+	} else {
+		taxes = scaleTaxes(taxes, amount.Amount, whole.Amount)
+	}
+	return invoiceLine(description, 1, amount, money.Zero(amount.Currency), amount, taxes)
+}
+
+func invoiceLine(description string, quantity uint, unitPrice, discount, amount money.Money, taxes []models.TaxComponent) models.InvoiceLine {
+	line := models.InvoiceLine{
+		Description: description,
+		Quantity:    quantity,
+		UnitPrice:   unitPrice,
+		Discount:    discount,
+		Tax:         money.Zero(amount.Currency),
+		Taxes:       taxes,
+		Amount:      amount,
+	}
+	for _, component := range taxes {
+		line.Tax = line.Tax.Add(component.Amount)
+	}
+	line.Taxable = amount.Sub(line.Tax)
+	return line
+}
+
+// totalInvoice adds up invoice's lines and their taxes.
+func totalInvoice(invoice *models.Invoice) {
+	invoice.Taxable = money.Zero(invoice.Currency)
+	invoice.Tax = money.Zero(invoice.Currency)
+	invoice.Total = money.Zero(invoice.Currency)
+	taxes := make([][]models.TaxComponent, 0, len(invoice.Lines))
+	for _, line := range invoice.Lines {
+		invoice.Taxable = invoice.Taxable.Add(line.Taxable)
+		invoice.Tax = invoice.Tax.Add(line.Tax)
+		invoice.Total = invoice.Total.Add(line.Amount)
+		taxes = append(taxes, line.Taxes)
+	}
+	invoice.TaxBreakdown = tax.Summarize(taxes...)
+}
+
+// scaleTaxes returns num/den of each of components.
+func scaleTaxes(components []models.TaxComponent, num, den int64) []models.TaxComponent {
+	if num == den || den == 0 {
+		return components
+	}
+	scaled := make([]models.TaxComponent, len(components))
+	for i, component := range components {
+		scaled[i] = component
+		scaled[i].Taxable = component.Taxable.MulFrac(num, den, money.HalfUp)
+		scaled[i].Amount = component.Amount.MulFrac(num, den, money.HalfUp)
+	}
+	return scaled
+}
+
+// wasPaid reports whether order was ever marked paid.
+func wasPaid(order models.Order) bool {
+	for _, change := range order.StatusHistory {
+		if change.Status == models.OrderPaid {
+			return true
+		}
+	}
+	return false
+}
+
+func findRefundIDs(ctx context.Context, refundCollection *mongo.Collection, query bson.M) ([]primitive.ObjectID, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(invoiceBatch)
+	cursor, err := refundCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListRefunds
+	}
+	var refunds []models.Refund
+	if err = cursor.All(ctx, &refunds); err != nil {
+		log.Println(err)
+		return nil, ErrCantListRefunds
+	}
+	ids := make([]primitive.ObjectID, len(refunds))
+	for i, refund := range refunds {
+		ids[i] = refund.ID
+	}
+	return ids, nil
+}
+
+// invoiceError passes on the errors callers can act on and hides the rest
+// behind ErrCantIssueInvoice.
+func invoiceError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, known := range []error{ErrCantFindOrder, ErrOrderNotPaid, ErrNothingToInvoice, ErrNotInvoiced, ErrCantFindRefund, ErrRefundNotDone, ErrOrderChanged} {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+	log.Println(err)
+	return ErrCantIssueInvoice
+}
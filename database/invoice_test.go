@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/invoices"
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/payments"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestInsertInvoiceNumbersWithoutGaps(t *testing.T) {
+	db := testDatabase(t)
+	ctx := context.Background()
+	for _, name := range []string{"Invoices", "Counters"} {
+		if err := db.CreateCollection(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	invoiceCollection := db.Collection("Invoices")
+	year := invoices.FiscalYear(time.Now())
+	// Last year's numbering has no bearing on this year's.
+	lastYear := invoices.FiscalYear(time.Now().AddDate(-1, 0, 0))
+	if _, err := db.Collection("Counters").InsertOne(ctx, bson.M{"_id": models.InvoiceTypeInvoice + ":" + lastYear, "seq": 41}); err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(kind string, fail bool) (models.Invoice, error) {
+		invoice := models.Invoice{Type: kind, Currency: models.DefaultCurrency}
+		err := runInTransaction(ctx, db.Client(), func(sessCtx mongo.SessionContext) error {
+			if err := insertInvoice(sessCtx, invoiceCollection, &invoice); err != nil {
+				return err
+			}
+			if fail {
+				return errors.New("order changed")
+			}
+			return nil
+		})
+		return invoice, err
+	}
+
+	var numbers []string
+	for i := 0; i < 4; i++ {
+		// The second invoice's transaction is rolled back, so its number
+		// goes to the next one.
+		if i == 1 {
+			if _, err := insert(models.InvoiceTypeInvoice, true); err == nil {
+				t.Fatal("failed transaction reported no error")
+			}
+		}
+		invoice, err := insert(models.InvoiceTypeInvoice, false)
+		if err != nil {
+			t.Fatalf("invoice %d: %v", i+1, err)
+		}
+		if invoice.FiscalYear != year || invoice.Sequence != int64(i+1) {
+			t.Errorf("invoice %d is number %d of %s, want %d of %s", i+1, invoice.Sequence, invoice.FiscalYear, i+1, year)
+		}
+		numbers = append(numbers, invoice.Number)
+	}
+	if want := invoices.Number(models.InvoiceTypeInvoice, year, 4); numbers[3] != want {
+		t.Errorf("fourth invoice number = %s, want %s", numbers[3], want)
+	}
+
+	// Credit notes are numbered in a series of their own.
+	note, err := insert(models.InvoiceTypeCreditNote, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := invoices.Number(models.InvoiceTypeCreditNote, year, 1); note.Number != want {
+		t.Errorf("credit note number = %s, want %s", note.Number, want)
+	}
+
+	n, err := invoiceCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("documents stored = %d, want 5", n)
+	}
+}
+
+func TestIssueInvoiceOnce(t *testing.T) {
+	f := newPaymentFixture(t, payments.NewFakeProvider(testWebhookSecret))
+	invoiceCollection := f.db.Collection("Invoices")
+	if err := f.db.CreateCollection(context.Background(), "Invoices"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := IssueInvoice(context.Background(), invoiceCollection, f.orders, f.order.ID); !errors.Is(err, ErrOrderNotPaid) {
+		t.Fatalf("invoicing an unpaid order err = %v, want %v", err, ErrOrderNotPaid)
+	}
+	if err := f.pay(payments.FakeTokenSuccess); err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+
+	first, err := IssueInvoice(context.Background(), invoiceCollection, f.orders, f.order.ID)
+	if err != nil {
+		t.Fatalf("invoice: %v", err)
+	}
+	second, err := IssueInvoice(context.Background(), invoiceCollection, f.orders, f.order.ID)
+	if err != nil {
+		t.Fatalf("invoice again: %v", err)
+	}
+	if first.Number == "" || second.Number != first.Number {
+		t.Errorf("invoice numbers %q and %q, want the same one twice", first.Number, second.Number)
+	}
+	if order := f.stored(t); order.InvoiceNumber != first.Number {
+		t.Errorf("order invoice number = %q, want %q", order.InvoiceNumber, first.Number)
+	}
+	if got := f.count(t, invoiceCollection, bson.M{}); got != 1 {
+		t.Errorf("invoices stored = %d, want 1", got)
+	}
+}
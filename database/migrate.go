@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// moneyFields lists, per collection, the fields that hold amounts. A field
+// inside an array of documents is written "array.field".
+var moneyFields = map[string][]string{
+	"Products":       {"price"},
+	"Users":          {"usercart.price"},
+	"Orders":         {"subtotal", "discount", "shipping", "total_price", "cancelled_amount", "paid_amount", "refunded_amount", "refunded_shipping", "items.price", "items.line_total", "cancellations.amount", "cancellations.refund_amount", "tenders.amount", "tenders.refunded"},
+	"PaymentIntents": {"amount", "captured", "refunded"},
+	"PaymentEvents":  {"amount"},
+	"Refunds":        {"amount", "shipping", "lines.amount", "payouts.amount"},
+	"Ledger":         {"amount"},
+	"Returns":        {"refund_amount", "lines.price"},
+	"Wallets":        {"balance"},
+	"WalletEntries":  {"amount"},
+	"GiftCards":      {"initial_value", "balance"},
+}
+
+// MigrateMoneyFields rewrites amounts stored as plain numbers, which were
+// whole units of money.DefaultCurrency, into the {amount, currency}
+// documents Money is stored as. Decoding already understands the old
+// numbers, but updates that address "<field>.amount" do not. It returns
+// how many documents each collection.field needed converting; with dryRun
+// set nothing is written.
+func MigrateMoneyFields(ctx context.Context, db *mongo.Database, dryRun bool) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for collectionName, fields := range moneyFields {
+		collection := db.Collection(collectionName)
+		for _, field := range fields {
+			filter := bson.M{field: bson.M{"$type": "number"}}
+			key := collectionName + "." + field
+			if dryRun {
+				n, err := collection.CountDocuments(ctx, filter)
+				if err != nil {
+					return counts, fmt.Errorf("counting %s: %w", key, err)
+				}
+				counts[key] = n
+				continue
+			}
+			result, err := collection.UpdateMany(ctx, filter, mongo.Pipeline{{{Key: "$set", Value: legacyMoneySet(field)}}})
+			if err != nil {
+				return counts, fmt.Errorf("migrating %s: %w", key, err)
+			}
+			counts[key] = result.ModifiedCount
+		}
+	}
+	return counts, nil
+}
+
+// legacyMoneySet builds the $set stage converting field, mapping over the
+// array for "array.field".
+func legacyMoneySet(field string) bson.M {
+	array, inner, nested := strings.Cut(field, ".")
+	if !nested {
+		return bson.M{field: legacyMoneyExpr("$" + field)}
+	}
+	return bson.M{array: bson.M{"$map": bson.M{
+		"input": "$" + array,
+		"as":    "element",
+		"in": bson.M{"$mergeObjects": bson.A{
+			"$$element",
+			bson.M{inner: legacyMoneyExpr("$$element." + inner)},
+		}},
+	}}}
+}
+
+// legacyMoneyExpr converts the number at path to minor units of
+// money.DefaultCurrency and leaves anything else as it is.
+func legacyMoneyExpr(path string) bson.M {
+	scale := money.FromMajor(1, money.DefaultCurrency).Amount
+	return bson.M{"$cond": bson.A{
+		bson.M{"$isNumber": path},
+		bson.M{
+			"amount":   bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{path, scale}}, 0}}},
+			"currency": money.DefaultCurrency,
+		},
+		path,
+	}}
+}
+
+// MigrateCategories builds the category tree out of the category names
+// products were filed under before there was one. Every name becomes a
+// root category, unless a category with its slug already exists, and the
+// products filed under it are listed in that category. Return windows and
+// promotions naming categories as they were typed are switched to slugs.
+// It returns how many documents of each collection needed changing; with
+// dryRun set nothing is written.
+func MigrateCategories(ctx context.Context, db *mongo.Database, dryRun bool) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	products := db.Collection("Products")
+	unlisted := bson.M{"category": bson.M{"$nin": bson.A{nil, ""}}, "category_ids": bson.M{"$exists": false}}
+	names, err := products.Distinct(ctx, "category", unlisted)
+	if err != nil {
+		return counts, fmt.Errorf("listing product categories: %w", err)
+	}
+	for _, value := range names {
+		name, ok := value.(string)
+		slug := Slugify(name)
+		if !ok || slug == "" {
+			continue
+		}
+		filter := bson.M{"category": name, "category_ids": bson.M{"$exists": false}}
+		category, err := findCategory(ctx, categories(db), bson.M{"slug": slug})
+		if errors.Is(err, ErrCantFindCategory) {
+			counts["Categories"]++
+			if !dryRun {
+				category, err = CreateCategory(ctx, categories(db), CategoryRequest{Slug: slug, Name: name})
+			} else {
+				err = nil
+			}
+		}
+		if err != nil {
+			return counts, fmt.Errorf("creating category %s: %w", slug, err)
+		}
+		if dryRun {
+			n, err := products.CountDocuments(ctx, filter)
+			if err != nil {
+				return counts, fmt.Errorf("counting products in %s: %w", name, err)
+			}
+			counts["Products"] += n
+			continue
+		}
+		result, err := products.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+			"category":     slug,
+			"category_ids": bson.A{category.ID},
+		}})
+		if err != nil {
+			return counts, fmt.Errorf("listing products in %s: %w", slug, err)
+		}
+		counts["Products"] += result.ModifiedCount
+	}
+
+	windows, err := ListReturnWindows(ctx, db.Collection("ReturnWindows"))
+	if err != nil {
+		return counts, fmt.Errorf("listing return windows: %w", err)
+	}
+	for _, window := range windows {
+		slug := Slugify(window.Category)
+		if slug == window.Category || slug == "" {
+			continue
+		}
+		counts["ReturnWindows"]++
+		if dryRun {
+			continue
+		}
+		// A window already set for the slug wins over the one typed by name.
+		_, err := db.Collection("ReturnWindows").UpdateOne(ctx, bson.M{"_id": slug},
+			bson.M{"$setOnInsert": bson.M{"days": window.Days}}, options.Update().SetUpsert(true))
+		if err == nil {
+			_, err = db.Collection("ReturnWindows").DeleteOne(ctx, bson.M{"_id": window.Category})
+		}
+		if err != nil {
+			return counts, fmt.Errorf("migrating return window %s: %w", window.Category, err)
+		}
+	}
+
+	cursor, err := db.Collection("Promotions").Find(ctx, bson.M{"categories.0": bson.M{"$exists": true}})
+	if err != nil {
+		return counts, fmt.Errorf("listing promotions: %w", err)
+	}
+	var promotions []models.Promotion
+	if err = cursor.All(ctx, &promotions); err != nil {
+		return counts, fmt.Errorf("listing promotions: %w", err)
+	}
+	for _, promotion := range promotions {
+		slugs := make([]string, len(promotion.Categories))
+		changed := false
+		for i, category := range promotion.Categories {
+			slugs[i] = Slugify(category)
+			changed = changed || slugs[i] != category
+		}
+		if !changed {
+			continue
+		}
+		counts["Promotions"]++
+		if dryRun {
+			continue
+		}
+		_, err := db.Collection("Promotions").UpdateOne(ctx, bson.M{"_id": promotion.ID}, bson.M{"$set": bson.M{"categories": slugs}})
+		if err != nil {
+			return counts, fmt.Errorf("migrating promotion %s: %w", promotion.ID.Hex(), err)
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,574 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidMovement   = errors.New("stock movement is not valid")
+	ErrCantListMovements = errors.New("cannot list stock movements")
+	ErrInvalidCount      = errors.New("cycle count is not valid")
+	ErrCantCountStock    = errors.New("cannot reconcile cycle count")
+	ErrCantListCounts    = errors.New("cannot list cycle counts")
+)
+
+// snapshotBatch is how many stock records one run of SnapshotStock
+// snapshots at most.
+const snapshotBatch = 500
+
+// movements is the collection stock movements are recorded in, next to
+// the stock they change.
+func movements(db *mongo.Database) *mongo.Collection {
+	return db.Collection("StockMovements")
+}
+
+// snapshots is the collection stock snapshots are kept in.
+func snapshots(db *mongo.Database) *mongo.Collection {
+	return db.Collection("StockSnapshots")
+}
+
+// moveStock applies movement to the stock record it names, releasing
+// reserved units along with it, and records the movement. Only a record
+// also matching guard is changed, and moveStock reports whether there was
+// one. A movement adding units without a guard starts tracking the product,
+// or its variant, in the warehouse if need be. A movement of no units only
+// releases reserved units and is not recorded. It must run inside a
+// transaction.
+//
+// The ledger is what the units on hand are: the record's OnHand is a cache
+// of it, kept in the same transaction so stock can be reserved against it
+// in one update. A record whose cache no longer agrees with its ledger
+// fails with ErrStockDrift rather than drifting further.
+func moveStock(ctx context.Context, db *mongo.Database, movement models.StockMovement, reserved int64, guard bson.M) (models.StockMovement, bool, error) {
+	filter := itemOf(movement.ProductID, movement.VariantID).filter()
+	filter["warehouse_id"] = movement.WarehouseID
+	for key, value := range guard {
+		filter[key] = value
+	}
+	if movement.At.IsZero() {
+		movement.At = time.Now()
+	}
+	inc := bson.M{"on_hand": movement.Quantity, "reserved": reserved}
+	if movement.Quantity != 0 {
+		inc["seq"] = int64(1)
+	}
+	update := bson.M{"$inc": inc, "$set": bson.M{"updated_at": movement.At}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetUpsert(guard == nil && reserved == 0 && movement.Quantity > 0)
+	var stock models.Stock
+	err := inventory(db).FindOneAndUpdate(ctx, filter, update, opts).Decode(&stock)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return movement, false, nil
+	}
+	if err != nil || movement.Quantity == 0 {
+		return movement, err == nil, err
+	}
+
+	if stock.Seq == 1 && stock.OnHand != movement.Quantity {
+		// The record held stock before movements were recorded: open its
+		// ledger with what it held.
+		opening := models.StockSnapshot{
+			ID:          primitive.NewObjectID(),
+			ProductID:   stock.ProductID,
+			VariantID:   stock.VariantID,
+			WarehouseID: stock.WarehouseID,
+			OnHand:      stock.OnHand - movement.Quantity,
+			TakenAt:     movement.At,
+		}
+		if _, err = snapshots(db).InsertOne(ctx, opening); err != nil {
+			return movement, false, err
+		}
+	}
+	balance, err := ledgerOnHand(ctx, db, stock)
+	if err != nil {
+		return movement, false, err
+	}
+	if balance.OnHand+movement.Quantity != stock.OnHand {
+		return movement, false, fmt.Errorf("%w: stock %s holds %d on hand but its ledger says %d",
+			ErrStockDrift, stock.ID.Hex(), stock.OnHand, balance.OnHand+movement.Quantity)
+	}
+	movement.ID = primitive.NewObjectID()
+	movement.Seq = stock.Seq
+	movement.OnHand = stock.OnHand
+	_, err = movements(db).InsertOne(ctx, movement)
+	return movement, err == nil, err
+}
+
+// ledgerBalance is a stock record as derived from the ledger: OnHand after
+// the movements up to Seq, of which those up to SnapshotSeq are summed up
+// in the latest snapshot.
+type ledgerBalance struct {
+	OnHand      int64
+	Seq         int64
+	SnapshotSeq int64
+}
+
+// ledgerOnHand derives the units stock has on hand from its latest
+// snapshot and the movements recorded after it. A record no movement was
+// ever recorded against stands as it is.
+func ledgerOnHand(ctx context.Context, db *mongo.Database, stock models.Stock) (ledgerBalance, error) {
+	if stock.Seq == 0 {
+		return ledgerBalance{OnHand: stock.OnHand}, nil
+	}
+	key := itemOf(stock.ProductID, stock.VariantID).filter()
+	key["warehouse_id"] = stock.WarehouseID
+	var snapshot models.StockSnapshot
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+	err := snapshots(db).FindOne(ctx, key, opts).Decode(&snapshot)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return ledgerBalance{}, err
+	}
+
+	match := itemOf(stock.ProductID, stock.VariantID).filter()
+	match["warehouse_id"] = stock.WarehouseID
+	match["seq"] = bson.M{"$gt": snapshot.Seq}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"quantity": bson.M{"$sum": "$quantity"},
+			"seq":      bson.M{"$max": "$seq"},
+		}}},
+	}
+	cursor, err := movements(db).Aggregate(ctx, pipeline)
+	if err != nil {
+		return ledgerBalance{}, err
+	}
+	var sums []struct {
+		Quantity int64 `bson:"quantity"`
+		Seq      int64 `bson:"seq"`
+	}
+	if err = cursor.All(ctx, &sums); err != nil {
+		return ledgerBalance{}, err
+	}
+	balance := ledgerBalance{OnHand: snapshot.OnHand, Seq: snapshot.Seq, SnapshotSeq: snapshot.Seq}
+	if len(sums) > 0 {
+		balance.OnHand += sums[0].Quantity
+		balance.Seq = sums[0].Seq
+	}
+	return balance, nil
+}
+
+// awaitingShipment counts the units of item that paid orders have taken
+// out of a warehouse's stock but no carrier has picked up yet. They are
+// still on the shelf.
+func awaitingShipment(ctx context.Context, db *mongo.Database, item stockItem, warehouseID primitive.ObjectID) (int64, error) {
+	line := item.filter()
+	line["warehouse_id"] = warehouseID
+	unwound := bson.M{}
+	for key, value := range line {
+		unwound["items."+key] = value
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"items": bson.M{"$elemMatch": line}}}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$match", Value: unwound}},
+		{{Key: "$group", Value: bson.M{
+			"_id": nil,
+			"units": bson.M{"$sum": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{
+				bson.M{"$ifNull": bson.A{"$items.deducted_quantity", 0}},
+				bson.M{"$ifNull": bson.A{"$items.shipped_quantity", 0}},
+			}}}}},
+		}}},
+	}
+	cursor, err := db.Collection("Orders").Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var sums []struct {
+		Units int64 `bson:"units"`
+	}
+	if err = cursor.All(ctx, &sums); err != nil || len(sums) == 0 {
+		return 0, err
+	}
+	return sums[0].Units, nil
+}
+
+// MovementRequest records a receipt, adjustment or damage by hand.
+// Quantity is the signed change to the units on hand: receipts add units,
+// damage takes them out and adjustments go either way. VariantID names the
+// variant moved, and is required for products that have variants.
+type MovementRequest struct {
+	VariantID   string              `json:"variant_id"`
+	WarehouseID string              `json:"warehouse_id"`
+	Kind        models.MovementKind `json:"kind"`
+	Quantity    int64               `json:"quantity"`
+	Reference   string              `json:"reference"`
+	Reason      string              `json:"reason"`
+}
+
+// RecordMovement applies a movement recorded by hand to a product's stock
+// in a warehouse. Units reserved for orders cannot be taken out.
+func RecordMovement(ctx context.Context, prodCollection, inventoryCollection *mongo.Collection, productID string, request MovementRequest, actor string) (models.StockMovement, error) {
+	request.Reason = strings.TrimSpace(request.Reason)
+	switch {
+	case !request.Kind.Manual(), request.Reason == "", request.Quantity == 0,
+		request.Kind == models.MovementReceipt && request.Quantity < 0,
+		request.Kind == models.MovementDamage && request.Quantity > 0:
+		return models.StockMovement{}, ErrInvalidMovement
+	}
+	item, err := productItem(ctx, prodCollection, productID, request.VariantID)
+	if err != nil {
+		return models.StockMovement{}, err
+	}
+	db := inventoryCollection.Database()
+	warehouse, err := GetWarehouse(ctx, warehouses(db), request.WarehouseID)
+	if err != nil {
+		return models.StockMovement{}, err
+	}
+
+	movement := models.StockMovement{
+		ProductID:   item.ProductID,
+		VariantID:   item.VariantID,
+		WarehouseID: warehouse.ID,
+		Kind:        request.Kind,
+		Quantity:    request.Quantity,
+		Reference:   request.Reference,
+		Actor:       actor,
+		Reason:      request.Reason,
+	}
+	var recorded models.StockMovement
+	err = runInTransaction(ctx, db.Client(), func(sessCtx mongo.SessionContext) error {
+		var guard bson.M
+		if movement.Quantity < 0 {
+			guard = bson.M{"$expr": bson.M{"$gte": bson.A{bson.M{"$subtract": bson.A{"$on_hand", "$reserved"}}, -movement.Quantity}}}
+		}
+		var applied bool
+		var err error
+		recorded, applied, err = moveStock(sessCtx, db, movement, 0, guard)
+		if err != nil || applied {
+			return err
+		}
+		filter := item.filter()
+		filter["warehouse_id"] = warehouse.ID
+		tracked, err := inventoryCollection.CountDocuments(sessCtx, filter)
+		if err != nil {
+			return err
+		}
+		if tracked == 0 {
+			return ErrCantFindStock
+		}
+		return ErrStockBelowReserved
+	})
+	return recorded, inventoryError(err)
+}
+
+// ListMovements returns one page (1-based) of a product's stock movements,
+// newest first, together with the total number matching. A non-empty
+// variantID lists that variant's movements only, and a non-empty
+// warehouseID that warehouse's.
+func ListMovements(ctx context.Context, movementCollection *mongo.Collection, productID, variantID, warehouseID string, page, limit int64) ([]models.StockMovement, int64, error) {
+	id, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return nil, 0, ErrCantFindProduct
+	}
+	filter := bson.M{"product_id": id}
+	if variantID != "" {
+		variant, err := primitive.ObjectIDFromHex(variantID)
+		if err != nil {
+			return nil, 0, ErrCantFindVariant
+		}
+		filter["variant_id"] = variant
+	}
+	if warehouseID != "" {
+		warehouse, err := primitive.ObjectIDFromHex(warehouseID)
+		if err != nil {
+			return nil, 0, ErrCantFindWarehouse
+		}
+		filter["warehouse_id"] = warehouse
+	}
+	total, err := movementCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListMovements
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "at", Value: -1}, {Key: "seq", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := movementCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListMovements
+	}
+	defer cursor.Close(ctx)
+
+	list := make([]models.StockMovement, 0)
+	if err = cursor.All(ctx, &list); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListMovements
+	}
+	return list, total, nil
+}
+
+// SnapshotStock snapshots the stock records that moved since their last
+// snapshot, so deriving them from the ledger stays cheap, and returns how
+// many it snapshotted. A record that disagrees with its ledger is not
+// snapshotted and fails with ErrStockDrift until a cycle count puts it
+// right.
+func SnapshotStock(ctx context.Context, inventoryCollection *mongo.Collection) (int, error) {
+	query := bson.M{"$expr": bson.M{"$gt": bson.A{"$seq", "$snapshot_seq"}}}
+	cursor, err := inventoryCollection.Find(ctx, query, options.Find().SetLimit(snapshotBatch))
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantListInventory
+	}
+	var records []models.Stock
+	if err = cursor.All(ctx, &records); err != nil {
+		log.Println(err)
+		return 0, ErrCantListInventory
+	}
+
+	db := inventoryCollection.Database()
+	taken := 0
+	for _, record := range records {
+		err := runInTransaction(ctx, db.Client(), func(sessCtx mongo.SessionContext) error {
+			var stock models.Stock
+			if err := inventoryCollection.FindOne(sessCtx, bson.M{"_id": record.ID}).Decode(&stock); err != nil {
+				return err
+			}
+			balance, err := ledgerOnHand(sessCtx, db, stock)
+			if err != nil {
+				return err
+			}
+			if balance.OnHand != stock.OnHand {
+				return fmt.Errorf("%w: stock %s of product %s variant %s in warehouse %s holds %d on hand but its ledger says %d",
+					ErrStockDrift, stock.ID.Hex(), stock.ProductID.Hex(), stock.VariantID.Hex(), stock.WarehouseID.Hex(), stock.OnHand, balance.OnHand)
+			}
+			if balance.Seq > balance.SnapshotSeq {
+				snapshot := models.StockSnapshot{
+					ID:          primitive.NewObjectID(),
+					ProductID:   stock.ProductID,
+					VariantID:   stock.VariantID,
+					WarehouseID: stock.WarehouseID,
+					OnHand:      balance.OnHand,
+					Seq:         balance.Seq,
+					TakenAt:     time.Now(),
+				}
+				if _, err = snapshots(db).InsertOne(sessCtx, snapshot); err != nil {
+					return err
+				}
+			}
+			_, err = inventoryCollection.UpdateOne(sessCtx, bson.M{"_id": stock.ID}, bson.M{"$set": bson.M{"snapshot_seq": balance.Seq}})
+			return err
+		})
+		if err != nil {
+			log.Printf("snapshotting stock %s: %v", record.ID.Hex(), err)
+			continue
+		}
+		taken++
+	}
+	return taken, nil
+}
+
+// CountedProduct is how many units of a product, or of one of its
+// variants, staff found on the shelf.
+type CountedProduct struct {
+	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id"`
+	Counted   int64  `json:"counted"`
+}
+
+// CountRequest is a cycle count of some products in one warehouse. With
+// Apply set, every discrepancy is recorded as an adjustment.
+type CountRequest struct {
+	Lines  []CountedProduct `json:"lines"`
+	Apply  bool             `json:"apply"`
+	Reason string           `json:"reason"`
+}
+
+// CountStock reconciles a cycle count against the ledger, stores it and
+// returns the discrepancies it found.
+func CountStock(ctx context.Context, inventoryCollection, countCollection *mongo.Collection, warehouseID string, request CountRequest, actor string) (models.CycleCount, error) {
+	db := inventoryCollection.Database()
+	warehouse, err := GetWarehouse(ctx, warehouses(db), warehouseID)
+	if err != nil {
+		return models.CycleCount{}, err
+	}
+	if len(request.Lines) == 0 {
+		return models.CycleCount{}, ErrInvalidCount
+	}
+	count := models.CycleCount{
+		ID:          primitive.NewObjectID(),
+		WarehouseID: warehouse.ID,
+		Applied:     request.Apply,
+		Actor:       actor,
+		Reason:      strings.TrimSpace(request.Reason),
+		CountedAt:   time.Now(),
+	}
+	if count.Reason == "" {
+		count.Reason = "cycle count"
+	}
+	seen := make(map[stockItem]bool)
+	for _, line := range request.Lines {
+		id, err := primitive.ObjectIDFromHex(line.ProductID)
+		if err != nil || line.Counted < 0 {
+			return models.CycleCount{}, ErrInvalidCount
+		}
+		var variantID primitive.ObjectID
+		if line.VariantID != "" {
+			if variantID, err = primitive.ObjectIDFromHex(line.VariantID); err != nil {
+				return models.CycleCount{}, ErrInvalidCount
+			}
+		}
+		item := itemOf(id, variantID)
+		if seen[item] {
+			return models.CycleCount{}, ErrInvalidCount
+		}
+		seen[item] = true
+		count.Lines = append(count.Lines, models.CountLine{ProductID: id, VariantID: variantID, Counted: line.Counted})
+	}
+
+	err = runInTransaction(ctx, db.Client(), func(sessCtx mongo.SessionContext) error {
+		for i := range count.Lines {
+			line := &count.Lines[i]
+			item := itemOf(line.ProductID, line.VariantID)
+			stock := models.Stock{ProductID: line.ProductID, VariantID: line.VariantID, WarehouseID: warehouse.ID}
+			filter := item.filter()
+			filter["warehouse_id"] = warehouse.ID
+			err := inventoryCollection.FindOne(sessCtx, filter).Decode(&stock)
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return err
+			}
+			balance, err := ledgerOnHand(sessCtx, db, stock)
+			if err != nil {
+				return err
+			}
+			awaiting, err := awaitingShipment(sessCtx, db, item, warehouse.ID)
+			if err != nil {
+				return err
+			}
+			line.LedgerOnHand = balance.OnHand
+			line.RecordedOnHand = stock.OnHand
+			line.AwaitingShipment = awaiting
+			line.Expected = balance.OnHand + awaiting
+			line.Discrepancy = line.Counted - line.Expected
+			line.Drift = balance.OnHand != stock.OnHand
+			if !request.Apply {
+				continue
+			}
+			if line.Drift {
+				// The ledger is right; bring the record back in line with
+				// it before recording what the count found.
+				reset := models.StockMovement{
+					Reference: count.ID.Hex(),
+					Actor:     actor,
+					Reason:    fmt.Sprintf("%s: stock record reset from %d to %d on hand to match its ledger", count.Reason, stock.OnHand, balance.OnHand),
+					At:        count.CountedAt,
+				}
+				if err = resetToLedger(sessCtx, db, stock, balance.OnHand, reset); err != nil {
+					return err
+				}
+			}
+			if line.Discrepancy == 0 {
+				continue
+			}
+			adjustment := models.StockMovement{
+				ProductID:   line.ProductID,
+				VariantID:   line.VariantID,
+				WarehouseID: warehouse.ID,
+				Kind:        models.MovementAdjustment,
+				Quantity:    line.Discrepancy,
+				Reference:   count.ID.Hex(),
+				Actor:       actor,
+				Reason:      count.Reason,
+				At:          count.CountedAt,
+			}
+			// What is on the shelf is recorded even if it leaves less than
+			// is reserved.
+			if _, _, err = moveStock(sessCtx, db, adjustment, 0, nil); err != nil {
+				return err
+			}
+		}
+		_, err := countCollection.InsertOne(sessCtx, count)
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		return count, ErrCantCountStock
+	}
+	return count, nil
+}
+
+// resetToLedger sets the units on hand of stock, a record that disagrees
+// with its ledger, to onHand, what the ledger says. The reset is recorded
+// as an adjustment of no units, so the ledger shows who put the record
+// right and why without its balance changing.
+func resetToLedger(ctx context.Context, db *mongo.Database, stock models.Stock, onHand int64, movement models.StockMovement) error {
+	update := bson.M{"$set": bson.M{"on_hand": onHand, "updated_at": movement.At}, "$inc": bson.M{"seq": int64(1)}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := inventory(db).FindOneAndUpdate(ctx, bson.M{"_id": stock.ID, "on_hand": stock.OnHand}, update, opts).Decode(&stock)
+	if err != nil {
+		return err
+	}
+	movement.ID = primitive.NewObjectID()
+	movement.ProductID = stock.ProductID
+	movement.VariantID = stock.VariantID
+	movement.WarehouseID = stock.WarehouseID
+	movement.Kind = models.MovementAdjustment
+	movement.Seq = stock.Seq
+	movement.OnHand = stock.OnHand
+	_, err = movements(db).InsertOne(ctx, movement)
+	return err
+}
+
+// ListCycleCounts returns one page (1-based) of a warehouse's cycle
+// counts, newest first, together with the total number of them.
+func ListCycleCounts(ctx context.Context, countCollection *mongo.Collection, warehouseID string, page, limit int64) ([]models.CycleCount, int64, error) {
+	id, err := primitive.ObjectIDFromHex(warehouseID)
+	if err != nil {
+		return nil, 0, ErrCantFindWarehouse
+	}
+	filter := bson.M{"warehouse_id": id}
+	total, err := countCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListCounts
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "counted_at", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := countCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListCounts
+	}
+	defer cursor.Close(ctx)
+
+	counts := make([]models.CycleCount, 0)
+	if err = cursor.All(ctx, &counts); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListCounts
+	}
+	return counts, total, nil
+}
+
+// inventoryError passes the known inventory errors through and hides
+// anything else behind ErrCantUpdateInventory.
+func inventoryError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, known := range []error{ErrOutOfStock, ErrCantFindStock, ErrStockBelowReserved, ErrStockDrift} {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+	log.Println(err)
+	return ErrCantUpdateInventory
+}
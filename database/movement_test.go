@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// receive records units of productID received into the fixture's
+// warehouse.
+func (f checkoutFixture) receive(t *testing.T, productID primitive.ObjectID, units int64) error {
+	t.Helper()
+	receipt := models.StockMovement{
+		ProductID:   productID,
+		WarehouseID: f.warehouse,
+		Kind:        models.MovementReceipt,
+		Quantity:    units,
+		Actor:       "admin",
+		Reason:      "delivery",
+	}
+	return runInTransaction(context.Background(), f.db.Client(), func(sessCtx mongo.SessionContext) error {
+		_, _, err := moveStock(sessCtx, f.db, receipt, 0, nil)
+		return err
+	})
+}
+
+func TestCountStockResetsDriftWithAnAdjustment(t *testing.T) {
+	f := newCheckoutFixture(t)
+	for _, name := range []string{"StockMovements", "StockSnapshots", "CycleCounts"} {
+		if err := f.db.CreateCollection(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts := f.db.Collection("CycleCounts")
+	product := primitive.NewObjectID()
+	if err := f.receive(t, product, 10); err != nil {
+		t.Fatalf("receipt: %v", err)
+	}
+	// Someone edits the record behind the ledger's back.
+	_, err := inventory(f.db).UpdateOne(context.Background(), bson.M{"product_id": product}, bson.M{"$inc": bson.M{"on_hand": 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.receive(t, product, 1); !errors.Is(err, ErrStockDrift) {
+		t.Fatalf("receipt into a drifted record err = %v, want %v", err, ErrStockDrift)
+	}
+
+	request := CountRequest{Lines: []CountedProduct{{ProductID: product.Hex(), Counted: 10}}, Reason: "weekly count"}
+	count, err := CountStock(context.Background(), inventory(f.db), counts, f.warehouse.Hex(), request, "auditor")
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if line := count.Lines[0]; !line.Drift || line.LedgerOnHand != 10 || line.RecordedOnHand != 13 || line.Discrepancy != 0 {
+		t.Fatalf("count line %+v, want drift from 13 to a ledger of 10 and no discrepancy", line)
+	}
+	// Without apply, nothing changes.
+	if got := f.count(t, movements(f.db), bson.M{"kind": models.MovementAdjustment}); got != 0 {
+		t.Fatalf("adjustments after an unapplied count = %d, want 0", got)
+	}
+
+	request.Apply = true
+	count, err = CountStock(context.Background(), inventory(f.db), counts, f.warehouse.Hex(), request, "auditor")
+	if err != nil {
+		t.Fatalf("applied count: %v", err)
+	}
+	var stock models.Stock
+	if err := inventory(f.db).FindOne(context.Background(), bson.M{"product_id": product}).Decode(&stock); err != nil {
+		t.Fatal(err)
+	}
+	if stock.OnHand != 10 {
+		t.Errorf("on hand after the count = %d, want the ledger's 10", stock.OnHand)
+	}
+	var reset models.StockMovement
+	err = movements(f.db).FindOne(context.Background(), bson.M{"kind": models.MovementAdjustment}).Decode(&reset)
+	if err != nil {
+		t.Fatalf("finding the reset: %v", err)
+	}
+	if reset.Quantity != 0 || reset.OnHand != 10 || reset.Actor != "auditor" || reset.Reference != count.ID.Hex() || !strings.Contains(reset.Reason, "from 13 to 10") {
+		t.Errorf("reset movement %+v, want no units, 10 on hand, by auditor for the count, giving the old and new figures", reset)
+	}
+
+	// The record moves again.
+	if err := f.receive(t, product, 1); err != nil {
+		t.Errorf("receipt after the count: %v", err)
+	}
+}
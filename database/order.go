@@ -0,0 +1,298 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/shipping"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindOrder     = errors.New("cannot find the requested order")
+	ErrOrderIdIsNotValid = errors.New("order id is not valid")
+	ErrCantListOrders    = errors.New("cannot list orders")
+	ErrCantFindAddress   = errors.New("cannot find the requested address")
+	ErrIllegalTransition = errors.New("order cannot move to the requested status")
+	ErrOrderChanged      = errors.New("order was changed by someone else, try again")
+	ErrCantUpdateOrder   = errors.New("cannot update order")
+	ErrNotManualStatus   = errors.New("order status can only be set by cancelling, returning or refunding the order")
+)
+
+// SystemActor is recorded as the actor of changes not made by a user.
+const SystemActor = "system"
+
+// newOrder builds a pending order for userID out of cart, priced by p,
+// grouping repeated cart entries for the same product, or the same variant
+// of it, into a single line.
+// Any exchange rates the prices were converted with are locked onto the
+// order.
+func newOrder(userID string, cart []models.ProductUser, p *pricer) (models.Order, error) {
+	var order models.Order
+	cart, err := p.priceCart(cart)
+	if err != nil {
+		return order, err
+	}
+	order.ID = primitive.NewObjectID()
+	order.UserID = userID
+	order.OrderedAt = time.Now()
+	order.UpdatedAt = order.OrderedAt
+	order.Status = models.OrderPendingPayment
+	order.StatusHistory = []models.StatusChange{{
+		Status: order.Status,
+		At:     order.OrderedAt,
+		Actor:  userID,
+	}}
+	order.PaymentMethod.Method = models.PaymentCOD
+	order.Currency = p.currency
+	order.ExchangeRates = p.applied
+	order.Subtotal = money.Zero(order.Currency)
+	order.Items = make([]models.OrderItem, 0, len(cart))
+
+	lines := make(map[stockItem]int)
+	for _, product := range cart {
+		item := itemOf(product.ProductID, product.VariantID)
+		if i, ok := lines[item]; ok {
+			order.Items[i].Quantity++
+			order.Items[i].LineTotal = order.Items[i].LineTotal.Add(product.Price)
+		} else {
+			lines[item] = len(order.Items)
+			order.Items = append(order.Items, models.OrderItem{
+				LineID:      primitive.NewObjectID(),
+				ProductID:   product.ProductID,
+				VariantID:   product.VariantID,
+				SKU:         product.SKU,
+				ProductName: product.ProductName,
+				VariantName: product.VariantName,
+				Image:       product.Image,
+				Category:    product.Category,
+				TaxClass:    product.TaxClass,
+				Weight:      shipping.UnitWeight(product.Weight, product.Dimensions),
+				Price:       product.Price,
+				Quantity:    1,
+				LineTotal:   product.Price,
+			})
+		}
+		order.Subtotal = order.Subtotal.Add(product.Price)
+		order.Weight += shipping.UnitWeight(product.Weight, product.Dimensions)
+	}
+	order.Total = orderTotal(order)
+	return order, nil
+}
+
+// orderTotal is what the customer pays for order: its lines less discounts,
+// plus shipping, plus tax unless the prices already include it.
+func orderTotal(order models.Order) money.Money {
+	total := order.Subtotal.Sub(order.Discount).Add(order.Shipping)
+	if !order.TaxInclusive {
+		total = total.Add(order.Tax)
+	}
+	return total
+}
+
+// shippingAddress picks the address to snapshot onto an order: the one
+// matching addressID, or the user's first address when addressID is empty.
+// A user without addresses gets an order without a shipping address.
+func shippingAddress(user models.User, addressID string) (*models.Address, error) {
+	if addressID == "" {
+		if len(user.AddressDetails) == 0 {
+			return nil, nil
+		}
+		address := user.AddressDetails[0]
+		return &address, nil
+	}
+	for _, address := range user.AddressDetails {
+		if address.AddressID.Hex() == addressID {
+			return &address, nil
+		}
+	}
+	return nil, ErrCantFindAddress
+}
+
+// insertOrder assigns the next order number, reserves stock for order and
+// stores it. It must run inside a transaction so a failed checkout neither
+// holds stock nor burns a number.
+func insertOrder(sessCtx mongo.SessionContext, orderCollection *mongo.Collection, order *models.Order) error {
+	seq, err := nextSequence(sessCtx, orderCollection.Database(), "order_number")
+	if err != nil {
+		return err
+	}
+	order.OrderNumber = fmt.Sprintf("ORD-%08d", seq)
+	if err = reserveStock(sessCtx, orderCollection.Database(), order); err != nil {
+		return err
+	}
+	_, err = orderCollection.InsertOne(sessCtx, order)
+	return err
+}
+
+func ListUserOrders(ctx context.Context, orderCollection *mongo.Collection, userID string, page, limit int64) ([]models.Order, int64, error) {
+	return findOrders(ctx, orderCollection, bson.M{"user_id": userID}, page, limit)
+}
+
+func GetUserOrder(ctx context.Context, orderCollection *mongo.Collection, userID string, orderID string) (models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return models.Order{}, ErrOrderIdIsNotValid
+	}
+	return findOrder(ctx, orderCollection, bson.M{"_id": id, "user_id": userID})
+}
+
+func GetOrder(ctx context.Context, orderCollection *mongo.Collection, orderID string) (models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return models.Order{}, ErrOrderIdIsNotValid
+	}
+	return findOrder(ctx, orderCollection, bson.M{"_id": id})
+}
+
+func findOrder(ctx context.Context, orderCollection *mongo.Collection, query bson.M) (models.Order, error) {
+	var order models.Order
+	err := orderCollection.FindOne(ctx, query).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, ErrCantFindOrder
+	}
+	if err != nil {
+		log.Println(err)
+		return order, ErrCantFindOrder
+	}
+	return order, nil
+}
+
+// AdvanceOrderStatus moves the order to next if the lifecycle allows it and
+// appends the change to its status history. The update only applies if the
+// order is still in the status it was read in, so two concurrent changes
+// cannot both succeed.
+//
+// Marking an order paid takes its reserved stock, and for a cash on
+// delivery order books the cash collected, whatever store credit did not
+// already cover, on the ledger. Cancelled, returned and refunded are
+// refused with ErrNotManualStatus; see AdminCancelOrder, IssueRefund and the
+// return flow.
+func AdvanceOrderStatus(ctx context.Context, orderCollection, ledgerCollection *mongo.Collection, orderID string, next models.OrderStatus, actor string, note string) (models.Order, error) {
+	if !next.Manual() {
+		return models.Order{}, ErrNotManualStatus
+	}
+	order, err := GetOrder(ctx, orderCollection, orderID)
+	if err != nil {
+		return order, err
+	}
+	if !order.Status.CanTransitionTo(next) {
+		return order, ErrIllegalTransition
+	}
+	if next != models.OrderPaid {
+		return order, setOrderStatus(ctx, orderCollection, &order, next, actor, note)
+	}
+
+	err = runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		paid := order
+		paid.Items = append([]models.OrderItem(nil), order.Items...)
+		if err := setOrderStatus(sessCtx, orderCollection, &paid, next, actor, note); err != nil {
+			return err
+		}
+		if err := commitStock(sessCtx, orderCollection, &paid); err != nil {
+			return err
+		}
+		if amount := order.AmountDue(); amount.IsPositive() && order.PaymentMethod.Method == models.PaymentCOD {
+			if err := bookPayment(sessCtx, orderCollection, ledgerCollection, order.ID, amount, models.PaymentCOD, order.ID, actor); err != nil {
+				return err
+			}
+			paid.PaidAmount = paid.PaidAmount.Add(amount)
+			paid.Tenders = append(paid.Tenders, models.Tender{Method: models.PaymentCOD, Amount: amount, Reference: order.ID.Hex()})
+		}
+		order = paid
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrOrderChanged) {
+		log.Println(err)
+		return order, ErrCantUpdateOrder
+	}
+	return order, err
+}
+
+// setOrderStatus writes the transition of order to next, guarded on the
+// order still being in order.Status, and updates order to match.
+func setOrderStatus(ctx context.Context, orderCollection *mongo.Collection, order *models.Order, next models.OrderStatus, actor string, note string) error {
+	change := models.StatusChange{
+		Status: next,
+		At:     time.Now(),
+		Actor:  actor,
+		Note:   note,
+	}
+	filter := bson.M{"_id": order.ID, "status": order.Status}
+	update := bson.M{
+		"$set":  bson.M{"status": next, "updated_at": change.At},
+		"$push": bson.M{"status_history": change},
+	}
+	result, err := orderCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateOrder
+	}
+	if result.MatchedCount == 0 {
+		return ErrOrderChanged
+	}
+	order.Status = next
+	order.UpdatedAt = change.At
+	order.StatusHistory = append(order.StatusHistory, change)
+	return nil
+}
+
+func SearchOrders(ctx context.Context, orderCollection *mongo.Collection, filter models.OrderFilter, page, limit int64) ([]models.Order, int64, error) {
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.UserID != "" {
+		query["user_id"] = filter.UserID
+	}
+	if !filter.WarehouseID.IsZero() {
+		query["items.warehouse_id"] = filter.WarehouseID
+	}
+	orderedAt := bson.M{}
+	if !filter.From.IsZero() {
+		orderedAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		orderedAt["$lt"] = filter.To
+	}
+	if len(orderedAt) > 0 {
+		query["ordered_at"] = orderedAt
+	}
+	return findOrders(ctx, orderCollection, query, page, limit)
+}
+
+// findOrders returns one page (1-based) of orders matching query, newest
+// first, together with the total number of matches.
+func findOrders(ctx context.Context, orderCollection *mongo.Collection, query bson.M, page, limit int64) ([]models.Order, int64, error) {
+	total, err := orderCollection.CountDocuments(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListOrders
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "ordered_at", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := orderCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListOrders
+	}
+	defer cursor.Close(ctx)
+
+	orders := make([]models.Order, 0)
+	if err = cursor.All(ctx, &orders); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListOrders
+	}
+	return orders, total, nil
+}
@@ -0,0 +1,421 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/payments"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrUnknownPaymentMethod = errors.New("unknown payment method")
+	ErrPaymentDeclined      = errors.New("payment was declined")
+	ErrCantStartPayment     = errors.New("cannot start payment")
+	ErrIntentIdIsNotValid   = errors.New("payment id is not valid")
+	ErrCantFindIntent       = errors.New("cannot find the requested payment")
+	ErrIntentNotConfirmable = errors.New("payment is not waiting for confirmation")
+	ErrCantRefund           = errors.New("cannot refund payment")
+)
+
+// paymentMethod validates the payment choice made at checkout. An empty
+// method means cash on delivery.
+func paymentMethod(checkout models.CheckoutRequest) (models.Payment, error) {
+	switch checkout.PaymentMethod {
+	case "", models.PaymentCOD:
+		return models.Payment{Method: models.PaymentCOD}, nil
+	case models.PaymentOnline:
+		if _, err := payments.Lookup(checkout.Provider); err != nil {
+			return models.Payment{}, ErrUnknownPaymentMethod
+		}
+		return models.Payment{Method: models.PaymentOnline, Provider: checkout.Provider}, nil
+	default:
+		return models.Payment{}, ErrUnknownPaymentMethod
+	}
+}
+
+// StartPayment creates a payment intent for an online order and asks the
+// order's provider to authorize it with token. Authorized payments are
+// captured straight away and the order marked paid; declined payments
+// cancel the order; a 3-D Secure challenge leaves the intent waiting for
+// ConfirmPayment; a provider timeout leaves it processing until a webhook
+// reports the outcome.
+func StartPayment(ctx context.Context, orderCollection, intentCollection, ledgerCollection *mongo.Collection, order *models.Order, token string) (models.PaymentIntent, error) {
+	provider, err := payments.Lookup(order.PaymentMethod.Provider)
+	if err != nil {
+		return models.PaymentIntent{}, ErrUnknownPaymentMethod
+	}
+
+	now := time.Now()
+	intent := models.PaymentIntent{
+		ID:        primitive.NewObjectID(),
+		OrderID:   order.ID,
+		UserID:    order.UserID,
+		Provider:  provider.Name(),
+		Amount:    order.AmountDue(),
+		Currency:  order.Currency,
+		Status:    models.IntentCreated,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err = intentCollection.InsertOne(ctx, intent); err != nil {
+		log.Println(err)
+		return intent, ErrCantStartPayment
+	}
+	_, err = orderCollection.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": bson.M{"payment_method.intent_id": intent.ID}})
+	if err != nil {
+		log.Println(err)
+		return intent, ErrCantStartPayment
+	}
+	order.PaymentMethod.IntentID = intent.ID
+
+	result, err := provider.Authorize(ctx, payments.AuthorizeRequest{
+		IntentID: intent.ID.Hex(),
+		Amount:   intent.Amount,
+		Token:    token,
+	})
+	return intent, settleAuthorization(ctx, orderCollection, intentCollection, ledgerCollection, provider, &intent, result, err)
+}
+
+// ConfirmPayment finishes a payment that was waiting on a 3-D Secure
+// challenge, using the customer's response.
+func ConfirmPayment(ctx context.Context, orderCollection, intentCollection, ledgerCollection *mongo.Collection, userID string, intentID string, response string) (models.PaymentIntent, error) {
+	id, err := primitive.ObjectIDFromHex(intentID)
+	if err != nil {
+		return models.PaymentIntent{}, ErrIntentIdIsNotValid
+	}
+	intent, err := findIntent(ctx, intentCollection, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return intent, err
+	}
+	if intent.Status != models.IntentRequiresAction {
+		return intent, ErrIntentNotConfirmable
+	}
+	provider, err := payments.Lookup(intent.Provider)
+	if err != nil {
+		return intent, ErrUnknownPaymentMethod
+	}
+
+	result, err := provider.Confirm(ctx, intent.Reference, response)
+	return intent, settleAuthorization(ctx, orderCollection, intentCollection, ledgerCollection, provider, &intent, result, err)
+}
+
+func GetUserIntent(ctx context.Context, intentCollection *mongo.Collection, userID string, intentID string) (models.PaymentIntent, error) {
+	id, err := primitive.ObjectIDFromHex(intentID)
+	if err != nil {
+		return models.PaymentIntent{}, ErrIntentIdIsNotValid
+	}
+	return findIntent(ctx, intentCollection, bson.M{"_id": id, "user_id": userID})
+}
+
+// settleAuthorization records the outcome of an authorize or confirm call
+// on intent and moves the order along with it. An authorization that
+// cannot be captured is voided and the intent failed.
+func settleAuthorization(ctx context.Context, orderCollection, intentCollection, ledgerCollection *mongo.Collection, provider payments.PaymentProvider, intent *models.PaymentIntent, result payments.Result, err error) error {
+	if result.Reference != "" {
+		intent.Reference = result.Reference
+	}
+	if errors.Is(err, payments.ErrTimeout) {
+		return updateIntent(ctx, intentCollection, intent, models.IntentProcessing, bson.M{})
+	}
+	if err != nil {
+		log.Println(err)
+		intent.FailureReason = err.Error()
+		if err := updateIntent(ctx, intentCollection, intent, models.IntentFailed, bson.M{}); err != nil {
+			return err
+		}
+		return ErrCantStartPayment
+	}
+
+	switch result.Status {
+	case payments.StatusRequiresAction:
+		intent.ActionURL = result.ActionURL
+		return updateIntent(ctx, intentCollection, intent, models.IntentRequiresAction, bson.M{})
+	case payments.StatusDeclined:
+		return failPayment(ctx, orderCollection, intentCollection, intent, result.DeclineReason)
+	case payments.StatusAuthorized:
+		if err := updateIntent(ctx, intentCollection, intent, models.IntentAuthorized, bson.M{}); err != nil {
+			return err
+		}
+		captured, err := provider.Capture(ctx, intent.Reference, intent.Amount)
+		if errors.Is(err, payments.ErrTimeout) {
+			return nil
+		}
+		if err != nil || captured.Status != payments.StatusCaptured {
+			// Release the hold on the customer's funds rather than leave
+			// them held for a payment that is not going to be taken.
+			if err != nil {
+				log.Println(err)
+				intent.FailureReason = err.Error()
+			} else {
+				intent.FailureReason = "capture " + string(captured.Status)
+			}
+			if _, err := provider.Void(ctx, intent.Reference); err != nil {
+				log.Println(err)
+			}
+			if err := updateIntent(ctx, intentCollection, intent, models.IntentFailed, bson.M{}); err != nil {
+				return err
+			}
+			return ErrCantStartPayment
+		}
+		return capturePayment(ctx, orderCollection, intentCollection, ledgerCollection, intent, intent.Amount)
+	default:
+		return ErrCantStartPayment
+	}
+}
+
+// capturePayment records amount as captured on intent, books it on the
+// order and the ledger and marks the order paid. The intent is only moved
+// to captured if it was not already, inside the same transaction as the
+// booking, so a webhook repeating a capture, or racing the synchronous one,
+// is harmless.
+func capturePayment(ctx context.Context, orderCollection, intentCollection, ledgerCollection *mongo.Collection, intent *models.PaymentIntent, amount money.Money) error {
+	if intent.Status == models.IntentCaptured {
+		return nil
+	}
+	return runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		now := time.Now()
+		result, err := intentCollection.UpdateOne(sessCtx,
+			bson.M{"_id": intent.ID, "status": bson.M{"$ne": models.IntentCaptured}},
+			bson.M{"$set": bson.M{
+				"status":     models.IntentCaptured,
+				"captured":   amount,
+				"reference":  intent.Reference,
+				"updated_at": now,
+			}},
+		)
+		if err != nil {
+			return err
+		}
+		intent.Status = models.IntentCaptured
+		if result.MatchedCount == 0 {
+			return nil
+		}
+		intent.Captured = amount
+		intent.UpdatedAt = now
+		return recordPayment(sessCtx, orderCollection, ledgerCollection, intent.OrderID, amount, intent.Provider, intent.ID)
+	})
+}
+
+// recordPayment books amount as received for the order and marks it paid,
+// taking its reserved stock, once nothing is left to pay. Money that
+// arrives for an order already cancelled, such as a capture reported after
+// the payment was given up on, is flagged to be refunded.
+func recordPayment(ctx context.Context, orderCollection, ledgerCollection *mongo.Collection, orderID primitive.ObjectID, amount money.Money, method string, reference primitive.ObjectID) error {
+	if err := bookPayment(ctx, orderCollection, ledgerCollection, orderID, amount, method, reference, SystemActor); err != nil {
+		return err
+	}
+	order, err := findOrder(ctx, orderCollection, bson.M{"_id": orderID})
+	if err != nil {
+		return err
+	}
+	if order.Status == models.OrderCancelled {
+		return refundLatePayment(ctx, orderCollection, &order, amount)
+	}
+	if order.Status != models.OrderPendingPayment || order.AmountDue().IsPositive() {
+		return nil
+	}
+	if err = setOrderStatus(ctx, orderCollection, &order, models.OrderPaid, SystemActor, "payment captured"); err != nil {
+		return err
+	}
+	return commitStock(ctx, orderCollection, &order)
+}
+
+// refundLatePayment records amount, received after order was cancelled,
+// as a pending refund on a cancellation of no lines, so the pending refunds
+// job pays it back to the customer.
+func refundLatePayment(ctx context.Context, orderCollection *mongo.Collection, order *models.Order, amount money.Money) error {
+	cancellation := models.Cancellation{
+		ID:           primitive.NewObjectID(),
+		Actor:        SystemActor,
+		Reason:       "payment received after cancellation",
+		RefundStatus: models.RefundPending,
+		RefundAmount: amount,
+		At:           time.Now(),
+	}
+	_, err := orderCollection.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{
+		"$push": bson.M{"cancellations": cancellation},
+		"$set":  bson.M{"updated_at": cancellation.At},
+	})
+	if err != nil {
+		return err
+	}
+	order.Cancellations = append(order.Cancellations, cancellation)
+	return nil
+}
+
+// bookPayment adds amount to what was paid for the order, as a tender of
+// method, and writes it to the ledger.
+func bookPayment(ctx context.Context, orderCollection, ledgerCollection *mongo.Collection, orderID primitive.ObjectID, amount money.Money, method string, reference primitive.ObjectID, actor string) error {
+	tender := models.Tender{Method: method, Amount: amount, Reference: reference.Hex()}
+	_, err := orderCollection.UpdateOne(ctx, bson.M{"_id": orderID}, bson.M{
+		"$inc":  bson.M{"paid_amount.amount": amount.Amount},
+		"$set":  bson.M{"paid_amount.currency": amount.Currency},
+		"$push": bson.M{"tenders": tender},
+	})
+	if err != nil {
+		return err
+	}
+	return recordLedgerEntry(ctx, ledgerCollection, models.LedgerEntry{
+		OrderID:   orderID,
+		Type:      models.LedgerPaymentCaptured,
+		Amount:    amount,
+		Method:    method,
+		Reference: reference,
+		Actor:     actor,
+	})
+}
+
+// failPayment marks intent failed and cancels its still-unpaid order.
+func failPayment(ctx context.Context, orderCollection, intentCollection *mongo.Collection, intent *models.PaymentIntent, reason string) error {
+	intent.FailureReason = reason
+	if err := updateIntent(ctx, intentCollection, intent, models.IntentFailed, bson.M{}); err != nil {
+		return err
+	}
+	_, err := cancelOrder(ctx, orderCollection, bson.M{"_id": intent.OrderID, "status": models.OrderPendingPayment},
+		models.OrderStatus.AdminCancellable, nil, SystemActor, "payment declined: "+reason)
+	if err != nil && !errors.Is(err, ErrCantFindOrder) {
+		return err
+	}
+	return ErrPaymentDeclined
+}
+
+func updateIntent(ctx context.Context, intentCollection *mongo.Collection, intent *models.PaymentIntent, status models.IntentStatus, set bson.M) error {
+	intent.Status = status
+	intent.UpdatedAt = time.Now()
+	set["status"] = intent.Status
+	set["reference"] = intent.Reference
+	set["action_url"] = intent.ActionURL
+	set["failure_reason"] = intent.FailureReason
+	set["updated_at"] = intent.UpdatedAt
+	_, err := intentCollection.UpdateOne(ctx, bson.M{"_id": intent.ID}, bson.M{"$set": set})
+	if err != nil {
+		log.Println(err)
+		return ErrCantStartPayment
+	}
+	return nil
+}
+
+func findIntent(ctx context.Context, intentCollection *mongo.Collection, query bson.M) (models.PaymentIntent, error) {
+	var intent models.PaymentIntent
+	err := intentCollection.FindOne(ctx, query).Decode(&intent)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return intent, ErrCantFindIntent
+	}
+	if err != nil {
+		log.Println(err)
+		return intent, ErrCantFindIntent
+	}
+	return intent, nil
+}
+
+// RefundCancellation pays back a cancellation whose refund is pending and
+// records the outcome on it. A cancellation made before the order was paid
+// for refunds only the store credit it left unused.
+func RefundCancellation(ctx context.Context, orderCollection, intentCollection, refundCollection, ledgerCollection *mongo.Collection, wallets WalletCollections, order *models.Order, cancellationID primitive.ObjectID) error {
+	index := -1
+	for i, cancellation := range order.Cancellations {
+		if cancellation.ID == cancellationID {
+			index = i
+		}
+	}
+	if index < 0 || order.Cancellations[index].RefundStatus != models.RefundPending {
+		return nil
+	}
+	cancellation := order.Cancellations[index]
+
+	req := models.RefundRequest{Kind: models.RefundLines, Reason: cancellation.Reason}
+	for _, line := range cancellation.Lines {
+		req.Lines = append(req.Lines, models.RefundLine{LineID: line.LineID, Quantity: line.Quantity})
+	}
+	if cancellation.RefundAmount.IsPositive() && cancellation.RefundAmount.Cmp(cancellation.Amount) != 0 {
+		req = models.RefundRequest{Kind: models.RefundGoodwill, Amount: cancellation.RefundAmount, Reason: cancellation.Reason}
+	}
+	refund, refundErr := IssueRefund(ctx, orderCollection, intentCollection, refundCollection, ledgerCollection, wallets, order.ID, req, cancellation.Actor, "cancellation:"+cancellation.ID.Hex())
+	status := models.RefundCompleted
+	if refundErr != nil {
+		status = models.RefundFailed
+		if refund.Status == models.RefundPartial {
+			status = models.RefundPartial
+		}
+	}
+
+	_, err := orderCollection.UpdateOne(ctx,
+		bson.M{"_id": order.ID, "cancellations._id": cancellationID},
+		bson.M{"$set": bson.M{"cancellations.$.refund_status": status}},
+	)
+	if err != nil {
+		log.Println(err)
+		return ErrCantRefund
+	}
+	order.Cancellations[index].RefundStatus = status
+	order.RefundedAmount = order.RefundedAmount.Add(refund.PaidAmount())
+	return refundErr
+}
+
+// RefundReturn pays back a refunded return and records the outcome on it.
+func RefundReturn(ctx context.Context, orderCollection, returnCollection, intentCollection, refundCollection, ledgerCollection *mongo.Collection, wallets WalletCollections, rma *models.Return) error {
+	if rma.RefundStatus != models.RefundPending || rma.RefundAmount.IsZero() {
+		return nil
+	}
+
+	req := models.RefundRequest{Kind: models.RefundLines, Reason: "return " + rma.RMANumber}
+	for _, line := range rma.Lines {
+		if line.Accepted > 0 {
+			req.Lines = append(req.Lines, models.RefundLine{LineID: line.LineID, Quantity: line.Accepted})
+		}
+	}
+	refund, refundErr := IssueRefund(ctx, orderCollection, intentCollection, refundCollection, ledgerCollection, wallets, rma.OrderID, req, SystemActor, "return:"+rma.RMANumber)
+	status := models.RefundCompleted
+	if refundErr != nil {
+		status = models.RefundFailed
+		if refund.Status == models.RefundPartial {
+			status = models.RefundPartial
+		}
+	}
+
+	_, err := returnCollection.UpdateOne(ctx, bson.M{"_id": rma.ID}, bson.M{"$set": bson.M{"refund_status": status}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantRefund
+	}
+	rma.RefundStatus = status
+	return refundErr
+}
+
+// RefundPendingCancellations pays back every cancellation whose refund is
+// still pending, such as those of orders a provider declined after store
+// credit had been spent on them. It returns how many were refunded.
+func RefundPendingCancellations(ctx context.Context, orderCollection, intentCollection, refundCollection, ledgerCollection *mongo.Collection, wallets WalletCollections) (int, error) {
+	cursor, err := orderCollection.Find(ctx, bson.M{"cancellations.refund_status": models.RefundPending})
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantListOrders
+	}
+	var orders []models.Order
+	if err = cursor.All(ctx, &orders); err != nil {
+		log.Println(err)
+		return 0, ErrCantListOrders
+	}
+
+	refunded := 0
+	for _, order := range orders {
+		for _, cancellation := range order.Cancellations {
+			if cancellation.RefundStatus != models.RefundPending {
+				continue
+			}
+			if err := RefundCancellation(ctx, orderCollection, intentCollection, refundCollection, ledgerCollection, wallets, &order, cancellation.ID); err != nil {
+				log.Println(err)
+				continue
+			}
+			refunded++
+		}
+	}
+	return refunded, nil
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/payments"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// paymentFixture is an order placed for online payment through provider,
+// not yet paid.
+type paymentFixture struct {
+	checkoutFixture
+	intents *mongo.Collection
+	order   *models.Order
+}
+
+func newPaymentFixture(t *testing.T, provider payments.PaymentProvider) paymentFixture {
+	t.Helper()
+	payments.Register(provider)
+	f := paymentFixture{checkoutFixture: newCheckoutFixture(t, cartLine("Shirt", 500))}
+	f.intents = f.db.Collection("PaymentIntents")
+	if err := f.db.CreateCollection(context.Background(), "PaymentIntents"); err != nil {
+		t.Fatal(err)
+	}
+	order, err := f.checkout(f.userID.Hex(), models.CheckoutRequest{
+		PaymentMethod: models.PaymentOnline,
+		Provider:      provider.Name(),
+	})
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	f.order = order
+	return f
+}
+
+func (f paymentFixture) pay(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := StartPayment(ctx, f.orders, f.intents, f.ledger, f.order, token)
+	return err
+}
+
+func (f paymentFixture) intent(t *testing.T) models.PaymentIntent {
+	t.Helper()
+	intent, err := findIntent(context.Background(), f.intents, bson.M{"order_id": f.order.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return intent
+}
+
+func (f paymentFixture) stored(t *testing.T) models.Order {
+	t.Helper()
+	order, err := findOrder(context.Background(), f.orders, bson.M{"_id": f.order.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestStartPaymentWithFakeProvider(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		err    error
+		intent models.IntentStatus
+		order  models.OrderStatus
+	}{
+		{"success", payments.FakeTokenSuccess, nil, models.IntentCaptured, models.OrderPaid},
+		{"decline", payments.FakeTokenDecline, ErrPaymentDeclined, models.IntentFailed, models.OrderCancelled},
+		{"3-D Secure challenge", payments.FakeToken3DS, nil, models.IntentRequiresAction, models.OrderPendingPayment},
+		{"timeout", payments.FakeTokenTimeout, nil, models.IntentProcessing, models.OrderPendingPayment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFixture(t, payments.NewFakeProvider(testWebhookSecret))
+
+			if err := f.pay(tt.token); !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if intent := f.intent(t); intent.Status != tt.intent {
+				t.Errorf("intent status = %s, want %s", intent.Status, tt.intent)
+			}
+			order := f.stored(t)
+			if order.Status != tt.order {
+				t.Errorf("order status = %s, want %s", order.Status, tt.order)
+			}
+			var wantPaid money.Money
+			if tt.order == models.OrderPaid {
+				wantPaid = order.Total
+			}
+			if order.PaidAmount.Amount != wantPaid.Amount {
+				t.Errorf("paid = %s, want %s", order.PaidAmount, wantPaid)
+			}
+			entries := f.count(t, f.ledger, bson.M{"order_id": order.ID, "type": models.LedgerPaymentCaptured})
+			if want := int64(len(order.Tenders)); entries != want {
+				t.Errorf("ledger entries = %d, want one per tender (%d)", entries, want)
+			}
+		})
+	}
+}
+
+func TestConfirmPayment(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		err      error
+		order    models.OrderStatus
+	}{
+		{"challenge passed", payments.FakeChallengePass, nil, models.OrderPaid},
+		{"challenge failed", "fail", ErrPaymentDeclined, models.OrderCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFixture(t, payments.NewFakeProvider(testWebhookSecret))
+			if err := f.pay(payments.FakeToken3DS); err != nil {
+				t.Fatalf("start: %v", err)
+			}
+			intent := f.intent(t)
+			if intent.ActionURL == "" {
+				t.Error("challenge has no action URL")
+			}
+
+			_, err := ConfirmPayment(context.Background(), f.orders, f.intents, f.ledger, f.userID.Hex(), intent.ID.Hex(), tt.response)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("confirm err = %v, want %v", err, tt.err)
+			}
+			if order := f.stored(t); order.Status != tt.order {
+				t.Errorf("order status = %s, want %s", order.Status, tt.order)
+			}
+			_, err = ConfirmPayment(context.Background(), f.orders, f.intents, f.ledger, f.userID.Hex(), intent.ID.Hex(), tt.response)
+			if !errors.Is(err, ErrIntentNotConfirmable) {
+				t.Errorf("second confirm err = %v, want %v", err, ErrIntentNotConfirmable)
+			}
+		})
+	}
+}
+
+// captureFailingProvider authorizes like FakeProvider but refuses every
+// capture, and remembers the payments it voided.
+type captureFailingProvider struct {
+	*payments.FakeProvider
+	voided []string
+}
+
+func (p *captureFailingProvider) Name() string {
+	return "fake-capture-fails"
+}
+
+func (p *captureFailingProvider) Capture(ctx context.Context, reference string, amount money.Money) (payments.Result, error) {
+	return payments.Result{}, payments.ErrInvalidRequest
+}
+
+func (p *captureFailingProvider) Void(ctx context.Context, reference string) (payments.Result, error) {
+	p.voided = append(p.voided, reference)
+	return p.FakeProvider.Void(ctx, reference)
+}
+
+func TestStartPaymentVoidsWhenCaptureFails(t *testing.T) {
+	provider := &captureFailingProvider{FakeProvider: payments.NewFakeProvider(testWebhookSecret)}
+	f := newPaymentFixture(t, provider)
+
+	if err := f.pay(payments.FakeTokenSuccess); !errors.Is(err, ErrCantStartPayment) {
+		t.Fatalf("err = %v, want %v", err, ErrCantStartPayment)
+	}
+	intent := f.intent(t)
+	if intent.Status != models.IntentFailed {
+		t.Errorf("intent status = %s, want %s", intent.Status, models.IntentFailed)
+	}
+	if len(provider.voided) != 1 || provider.voided[0] != intent.Reference {
+		t.Errorf("voided %v, want the authorization %s", provider.voided, intent.Reference)
+	}
+	if order := f.stored(t); order.Status != models.OrderPendingPayment || order.PaidAmount.IsPositive() {
+		t.Errorf("order %s with %s paid, want it still waiting for payment", order.Status, order.PaidAmount)
+	}
+}
@@ -0,0 +1,320 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/promotions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindPromotion   = errors.New("cannot find the requested promotion")
+	ErrInvalidPromotion    = errors.New("promotion is not valid")
+	ErrDuplicateCoupon     = errors.New("a promotion with this coupon code already exists")
+	ErrPromotionUsedUp     = errors.New("promotion has reached its usage limit")
+	ErrCantListPromotions  = errors.New("cannot list promotions")
+	ErrCantUpdatePromotion = errors.New("cannot update promotion")
+)
+
+// PricingCollections are the collections cart and checkout pricing read:
+// the exchange-rate tables, the promotions and the record of their use,
+// and the shipping zones.
+type PricingCollections struct {
+	Rates       *mongo.Collection
+	Promotions  *mongo.Collection
+	Redemptions *mongo.Collection
+	Zones       *mongo.Collection
+}
+
+func NewPricingCollections(db *mongo.Database) PricingCollections {
+	return PricingCollections{
+		Rates:       db.Collection("ExchangeRates"),
+		Promotions:  db.Collection("Promotions"),
+		Redemptions: db.Collection("PromotionRedemptions"),
+		Zones:       db.Collection("ShippingZones"),
+	}
+}
+
+// PriceCart prices user's cart in currency the way checkout would, with
+// the promotions it qualifies for, the coupons in codes and the shipping
+// and tax due shipping to the address addressID by method, without placing
+// an order or using the promotions up.
+func PriceCart(ctx context.Context, pricing PricingCollections, user models.User, currency string, addressID string, codes []string, method string) (models.Order, error) {
+	address, err := shippingAddress(user, addressID)
+	if err != nil {
+		return models.Order{}, err
+	}
+	p, err := newPricer(ctx, pricing.Rates, currency)
+	if err != nil {
+		return models.Order{}, err
+	}
+	return priceOrder(ctx, pricing, p, user.ID.Hex(), user.UserCart, address, codes, method)
+}
+
+// priceOrder builds the order for cart shipping to address by method,
+// priced by p, charges its shipping, applies the promotions it qualifies
+// for and taxes it. Line discounts and taxes are kept on the lines so
+// later refunds give back what was actually paid for them.
+func priceOrder(ctx context.Context, pricing PricingCollections, p *pricer, userID string, cart []models.ProductUser, address *models.Address, codes []string, method string) (models.Order, error) {
+	order, err := newOrder(userID, cart, p)
+	if err != nil {
+		return order, err
+	}
+	order.ShippingAddress = address
+	if err = shipOrder(ctx, pricing, p, &order, method); err != nil {
+		return order, err
+	}
+	candidates, err := availablePromotions(ctx, pricing, userID, codes)
+	if err != nil {
+		return order, err
+	}
+
+	promoCart := promotions.Cart{
+		Currency: order.Currency,
+		Shipping: order.Shipping,
+		Codes:    codes,
+		Now:      order.OrderedAt,
+		Convert: func(amount money.Money) (money.Money, error) {
+			return p.price(amount, nil)
+		},
+	}
+	for _, item := range order.Items {
+		promoCart.Lines = append(promoCart.Lines, promotions.Line{
+			ProductID: item.ProductID,
+			Category:  item.Category,
+			UnitPrice: item.Price,
+			Quantity:  item.Quantity,
+		})
+	}
+	result, err := promotions.Evaluate(promoCart, candidates)
+	if err != nil {
+		return order, err
+	}
+
+	for i := range order.Items {
+		order.Items[i].Discount = result.LineDiscounts[i]
+	}
+	order.Promotions = result.Applied
+	order.Discount = result.Discount()
+	// Conversions of promotion amounts are locked onto the order too.
+	order.ExchangeRates = p.applied
+
+	applyTax(&order, result.ShippingDiscount)
+	return order, nil
+}
+
+// availablePromotions loads the automatic promotions and the coupons in
+// codes that userID may still use. A coupon entered after its limits are
+// spent fails with ErrPromotionUsedUp; spent automatic promotions are left
+// out.
+func availablePromotions(ctx context.Context, pricing PricingCollections, userID string, codes []string) ([]models.Promotion, error) {
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		normalized = append(normalized, promotions.NormalizeCode(code))
+	}
+	query := bson.M{
+		"active": true,
+		"$or": bson.A{
+			bson.M{"code": bson.M{"$exists": false}},
+			bson.M{"code": bson.M{"$in": normalized}},
+		},
+	}
+	cursor, err := pricing.Promotions.Find(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListPromotions
+	}
+	defer cursor.Close(ctx)
+	var found []models.Promotion
+	if err = cursor.All(ctx, &found); err != nil {
+		log.Println(err)
+		return nil, ErrCantListPromotions
+	}
+
+	available := make([]models.Promotion, 0, len(found))
+	for _, promotion := range found {
+		usable, err := promotionUsable(ctx, pricing, promotion, userID)
+		if err != nil {
+			return nil, err
+		}
+		if usable {
+			available = append(available, promotion)
+		} else if promotion.Code != "" {
+			return nil, ErrPromotionUsedUp
+		}
+	}
+	return available, nil
+}
+
+// promotionUsable reports whether promotion's global and per-user limits
+// leave userID another use.
+func promotionUsable(ctx context.Context, pricing PricingCollections, promotion models.Promotion, userID string) (bool, error) {
+	if promotion.UsageLimit > 0 && promotion.Uses >= promotion.UsageLimit {
+		return false, nil
+	}
+	if promotion.PerUserLimit == 0 {
+		return true, nil
+	}
+	used, err := pricing.Redemptions.CountDocuments(ctx, bson.M{"promotion_id": promotion.ID, "user_id": userID})
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantListPromotions
+	}
+	return used < int64(promotion.PerUserLimit), nil
+}
+
+// redeemPromotions counts a use of each promotion on order against its
+// limits and records it. It must run inside the transaction that places
+// the order, so that concurrent checkouts cannot both take the last use.
+func redeemPromotions(sessCtx mongo.SessionContext, pricing PricingCollections, order models.Order) error {
+	for _, applied := range order.Promotions {
+		filter := bson.M{
+			"_id":    applied.PromotionID,
+			"active": true,
+			"$or": bson.A{
+				bson.M{"usage_limit": bson.M{"$exists": false}},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$usage_limit"}}},
+			},
+		}
+		var promotion models.Promotion
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := pricing.Promotions.FindOneAndUpdate(sessCtx, filter, bson.M{"$inc": bson.M{"uses": 1}}, opts).Decode(&promotion)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrPromotionUsedUp
+		}
+		if err != nil {
+			return err
+		}
+		if promotion.PerUserLimit > 0 {
+			used, err := pricing.Redemptions.CountDocuments(sessCtx, bson.M{"promotion_id": promotion.ID, "user_id": order.UserID})
+			if err != nil {
+				return err
+			}
+			if used >= int64(promotion.PerUserLimit) {
+				return ErrPromotionUsedUp
+			}
+		}
+		_, err = pricing.Redemptions.InsertOne(sessCtx, models.PromotionRedemption{
+			PromotionID: applied.PromotionID,
+			UserID:      order.UserID,
+			OrderID:     order.ID,
+			Amount:      applied.Amount,
+			At:          order.OrderedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreatePromotion validates and stores a new promotion. Coupon codes are
+// stored upper-case and must be unique.
+func CreatePromotion(ctx context.Context, pricing PricingCollections, promotion models.Promotion, actor string) (models.Promotion, error) {
+	promotion.Code = promotions.NormalizeCode(promotion.Code)
+	if err := validatePromotion(promotion); err != nil {
+		return promotion, err
+	}
+	promotion.ID = primitive.NewObjectID()
+	promotion.Uses = 0
+	promotion.CreatedBy = actor
+	promotion.CreatedAt = time.Now()
+
+	_, err := pricing.Promotions.InsertOne(ctx, promotion)
+	if mongo.IsDuplicateKeyError(err) {
+		return promotion, ErrDuplicateCoupon
+	}
+	if err != nil {
+		log.Println(err)
+		return promotion, ErrCantUpdatePromotion
+	}
+	return promotion, nil
+}
+
+func validatePromotion(promotion models.Promotion) error {
+	if promotion.Name == "" || promotion.AmountOff.IsNegative() || promotion.MinSubtotal.IsNegative() {
+		return ErrInvalidPromotion
+	}
+	if !promotion.StartsAt.IsZero() && !promotion.EndsAt.IsZero() && !promotion.EndsAt.After(promotion.StartsAt) {
+		return ErrInvalidPromotion
+	}
+	for _, amount := range []money.Money{promotion.AmountOff, promotion.MinSubtotal} {
+		if !amount.IsZero() && !models.IsSupportedCurrency(amount.Currency) {
+			return ErrUnsupportedCurrency
+		}
+	}
+	switch promotion.Type {
+	case models.PromotionPercentOff:
+		if promotion.PercentOff == 0 || promotion.PercentOff > 100 {
+			return ErrInvalidPromotion
+		}
+	case models.PromotionAmountOff:
+		if !promotion.AmountOff.IsPositive() {
+			return ErrInvalidPromotion
+		}
+	case models.PromotionBuyXGetY:
+		if promotion.BuyQuantity == 0 || promotion.GetQuantity == 0 || promotion.GetPercent > 100 {
+			return ErrInvalidPromotion
+		}
+	case models.PromotionFreeShipping:
+	default:
+		return ErrInvalidPromotion
+	}
+	return nil
+}
+
+// ListPromotions returns one page (1-based) of promotions, newest first,
+// together with the total number of promotions.
+func ListPromotions(ctx context.Context, pricing PricingCollections, page, limit int64) ([]models.Promotion, int64, error) {
+	total, err := pricing.Promotions.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListPromotions
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := pricing.Promotions.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListPromotions
+	}
+	defer cursor.Close(ctx)
+
+	found := make([]models.Promotion, 0)
+	if err = cursor.All(ctx, &found); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListPromotions
+	}
+	return found, total, nil
+}
+
+// SetPromotionActive switches a promotion on or off. Orders already placed
+// keep their discounts.
+func SetPromotionActive(ctx context.Context, pricing PricingCollections, promotionID string, active bool) (models.Promotion, error) {
+	var promotion models.Promotion
+	id, err := primitive.ObjectIDFromHex(promotionID)
+	if err != nil {
+		return promotion, ErrCantFindPromotion
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = pricing.Promotions.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"active": active}}, opts).Decode(&promotion)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return promotion, ErrCantFindPromotion
+	}
+	if err != nil {
+		log.Println(err)
+		return promotion, ErrCantUpdatePromotion
+	}
+	return promotion, nil
+}
@@ -0,0 +1,641 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindSupplier               = errors.New("cannot find the requested supplier")
+	ErrInvalidSupplier                = errors.New("supplier is not valid")
+	ErrDuplicateSupplier              = errors.New("a supplier with this code already exists")
+	ErrCantUpdateSupplier             = errors.New("cannot update supplier")
+	ErrCantListSuppliers              = errors.New("cannot list suppliers")
+	ErrCantFindPurchaseOrder          = errors.New("cannot find the requested purchase order")
+	ErrInvalidPurchaseOrder           = errors.New("purchase order is not valid")
+	ErrPurchaseOrderNotDraft          = errors.New("purchase order is no longer a draft")
+	ErrIllegalPurchaseOrderTransition = errors.New("purchase order cannot move to the requested status")
+	ErrInvalidReceipt                 = errors.New("receipt is not valid")
+	ErrCantUpdatePurchaseOrder        = errors.New("cannot update purchase order")
+	ErrCantListPurchaseOrders         = errors.New("cannot list purchase orders")
+)
+
+// suppliers is the collection suppliers are kept in.
+func suppliers(db *mongo.Database) *mongo.Collection {
+	return db.Collection("Suppliers")
+}
+
+// purchaseOrders is the collection purchase orders are kept in, next to
+// the stock they restock.
+func purchaseOrders(db *mongo.Database) *mongo.Collection {
+	return db.Collection("PurchaseOrders")
+}
+
+func GetSupplier(ctx context.Context, supplierCollection *mongo.Collection, supplierID string) (models.Supplier, error) {
+	var supplier models.Supplier
+	id, err := primitive.ObjectIDFromHex(supplierID)
+	if err != nil {
+		return supplier, ErrCantFindSupplier
+	}
+	err = supplierCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&supplier)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return supplier, ErrCantFindSupplier
+	}
+	if err != nil {
+		log.Println(err)
+		return supplier, ErrCantFindSupplier
+	}
+	return supplier, nil
+}
+
+// CreateSupplier validates and stores a new supplier.
+func CreateSupplier(ctx context.Context, supplierCollection *mongo.Collection, supplier models.Supplier) (models.Supplier, error) {
+	if err := normalizeSupplier(&supplier); err != nil {
+		return supplier, err
+	}
+	supplier.ID = primitive.NewObjectID()
+	supplier.CreatedAt = time.Now()
+	supplier.UpdatedAt = supplier.CreatedAt
+
+	_, err := supplierCollection.InsertOne(ctx, supplier)
+	if mongo.IsDuplicateKeyError(err) {
+		return supplier, ErrDuplicateSupplier
+	}
+	if err != nil {
+		log.Println(err)
+		return supplier, ErrCantUpdateSupplier
+	}
+	return supplier, nil
+}
+
+// UpdateSupplier replaces a supplier's details and cost prices. Purchase
+// orders already raised keep the costs they were priced with.
+func UpdateSupplier(ctx context.Context, supplierCollection *mongo.Collection, supplierID string, supplier models.Supplier) (models.Supplier, error) {
+	id, err := primitive.ObjectIDFromHex(supplierID)
+	if err != nil {
+		return supplier, ErrCantFindSupplier
+	}
+	if err = normalizeSupplier(&supplier); err != nil {
+		return supplier, err
+	}
+	supplier.UpdatedAt = time.Now()
+	update := bson.M{"$set": bson.M{
+		"code":           supplier.Code,
+		"name":           supplier.Name,
+		"email":          supplier.Email,
+		"phone":          supplier.Phone,
+		"address":        supplier.Address,
+		"currency":       supplier.Currency,
+		"lead_time_days": supplier.LeadTimeDays,
+		"products":       supplier.Products,
+		"active":         supplier.Active,
+		"updated_at":     supplier.UpdatedAt,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = supplierCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&supplier)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return supplier, ErrCantFindSupplier
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return supplier, ErrDuplicateSupplier
+	}
+	if err != nil {
+		log.Println(err)
+		return supplier, ErrCantUpdateSupplier
+	}
+	return supplier, nil
+}
+
+// ListSuppliers returns one page (1-based) of suppliers, by name, together
+// with the total number of suppliers. A non-empty productID lists only the
+// suppliers who sell that product.
+func ListSuppliers(ctx context.Context, supplierCollection *mongo.Collection, productID string, page, limit int64) ([]models.Supplier, int64, error) {
+	filter := bson.M{}
+	if productID != "" {
+		id, err := primitive.ObjectIDFromHex(productID)
+		if err != nil {
+			return nil, 0, ErrCantFindProduct
+		}
+		filter["products.product_id"] = id
+	}
+	total, err := supplierCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListSuppliers
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := supplierCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListSuppliers
+	}
+	defer cursor.Close(ctx)
+
+	list := make([]models.Supplier, 0)
+	if err = cursor.All(ctx, &list); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListSuppliers
+	}
+	return list, total, nil
+}
+
+// normalizeSupplier upper-cases supplier's code and currency and checks
+// that each product is listed once, at a cost in the supplier's currency.
+func normalizeSupplier(supplier *models.Supplier) error {
+	supplier.Code = strings.ToUpper(strings.TrimSpace(supplier.Code))
+	supplier.Name = strings.TrimSpace(supplier.Name)
+	supplier.Email = strings.TrimSpace(supplier.Email)
+	supplier.Currency = strings.ToUpper(strings.TrimSpace(supplier.Currency))
+	if supplier.Currency == "" {
+		supplier.Currency = money.DefaultCurrency
+	}
+	if supplier.Code == "" || supplier.Name == "" || len(supplier.Currency) != 3 || supplier.LeadTimeDays < 0 {
+		return ErrInvalidSupplier
+	}
+	seen := make(map[stockItem]bool, len(supplier.Products))
+	for i := range supplier.Products {
+		product := &supplier.Products[i]
+		item := itemOf(product.ProductID, product.VariantID)
+		product.Cost = product.Cost.In(supplier.Currency)
+		if product.ProductID.IsZero() || seen[item] ||
+			product.Cost.Currency != supplier.Currency || product.Cost.IsNegative() ||
+			product.MinOrderQuantity < 0 {
+			return ErrInvalidSupplier
+		}
+		seen[item] = true
+	}
+	return nil
+}
+
+// PurchaseOrderRequest is what buyers send to raise or change a draft
+// purchase order. A line without a unit cost is priced at the supplier's
+// cost for the product. ExpectedAt defaults to the supplier's lead time
+// from when the order is sent.
+type PurchaseOrderRequest struct {
+	SupplierID      string                     `json:"supplier_id"`
+	WarehouseID     string                     `json:"warehouse_id"`
+	Lines           []PurchaseOrderLineRequest `json:"lines"`
+	AdditionalCosts money.Money                `json:"additional_costs"`
+	ExpectedAt      time.Time                  `json:"expected_at"`
+	Note            string                     `json:"note"`
+}
+
+// PurchaseOrderLineRequest orders units of a product, or of the variant
+// VariantID of a product with variants.
+type PurchaseOrderLineRequest struct {
+	ProductID string      `json:"product_id"`
+	VariantID string      `json:"variant_id"`
+	Quantity  int64       `json:"quantity"`
+	UnitCost  money.Money `json:"unit_cost"`
+}
+
+// buildPurchaseOrder prices request into the fields of a purchase order
+// that buyers choose. The supplier must be active and sell every product
+// at no fewer units than it takes an order for.
+func buildPurchaseOrder(ctx context.Context, prodCollection *mongo.Collection, db *mongo.Database, request PurchaseOrderRequest) (models.PurchaseOrder, error) {
+	var order models.PurchaseOrder
+	supplier, err := GetSupplier(ctx, suppliers(db), request.SupplierID)
+	if err != nil {
+		return order, err
+	}
+	if !supplier.Active || len(request.Lines) == 0 {
+		return order, ErrInvalidPurchaseOrder
+	}
+	warehouse, err := GetWarehouse(ctx, warehouses(db), request.WarehouseID)
+	if err != nil {
+		return order, err
+	}
+
+	ids := make([]primitive.ObjectID, len(request.Lines))
+	variantIDs := make([]primitive.ObjectID, len(request.Lines))
+	for i, line := range request.Lines {
+		if ids[i], err = primitive.ObjectIDFromHex(line.ProductID); err != nil {
+			return order, ErrCantFindProduct
+		}
+		if variantIDs[i], err = parseVariantID(line.VariantID); err != nil {
+			return order, err
+		}
+	}
+	cursor, err := prodCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"product_name": 1, "variants.variant_id": 1, "variants.sku": 1}))
+	if err != nil {
+		log.Println(err)
+		return order, ErrCantFindProduct
+	}
+	var products []models.Product
+	if err = cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return order, ErrCantFindProduct
+	}
+	byID := make(map[primitive.ObjectID]models.Product, len(products))
+	for _, product := range products {
+		byID[product.ProductID] = product
+	}
+
+	order = models.PurchaseOrder{
+		SupplierID:      supplier.ID,
+		WarehouseID:     warehouse.ID,
+		Currency:        supplier.Currency,
+		Subtotal:        money.Zero(supplier.Currency),
+		AdditionalCosts: request.AdditionalCosts.In(supplier.Currency),
+		ExpectedAt:      request.ExpectedAt,
+		Note:            strings.TrimSpace(request.Note),
+	}
+	if order.AdditionalCosts.Currency != order.Currency || order.AdditionalCosts.IsNegative() {
+		return order, ErrInvalidPurchaseOrder
+	}
+	seen := make(map[stockItem]bool, len(ids))
+	weights := make([]int64, len(ids))
+	for i, line := range request.Lines {
+		product, ok := byID[ids[i]]
+		if !ok {
+			return order, ErrCantFindProduct
+		}
+		variant, err := pickVariant(product, variantIDs[i])
+		if err != nil {
+			return order, err
+		}
+		item := itemOf(ids[i], variantIDs[i])
+		offer, sells := supplier.CostOf(ids[i], variantIDs[i])
+		unitCost := line.UnitCost.In(order.Currency)
+		if unitCost.IsZero() {
+			unitCost = offer.Cost.In(order.Currency)
+		}
+		if seen[item] || line.Quantity <= 0 || line.Quantity < offer.MinOrderQuantity ||
+			(!sells && line.UnitCost.IsZero()) ||
+			unitCost.Currency != order.Currency || unitCost.IsNegative() {
+			return order, ErrInvalidPurchaseOrder
+		}
+		seen[item] = true
+		po := models.PurchaseOrderLine{
+			LineID:      primitive.NewObjectID(),
+			ProductID:   ids[i],
+			VariantID:   variantIDs[i],
+			ProductName: product.ProductName,
+			SupplierSKU: offer.SupplierSKU,
+			Quantity:    line.Quantity,
+			UnitCost:    unitCost,
+			LineTotal:   unitCost.Mul(line.Quantity),
+		}
+		if variant != nil {
+			po.SKU = variant.SKU
+		}
+		order.Lines = append(order.Lines, po)
+		order.Subtotal = order.Subtotal.Add(po.LineTotal)
+		weights[i] = po.LineTotal.Amount
+	}
+
+	// Spread the additional costs over the lines by value; a unit's landed
+	// cost carries its share, rounded to a whole minor unit.
+	for i, share := range order.AdditionalCosts.Allocate(weights...) {
+		line := &order.Lines[i]
+		line.LandedUnitCost = line.UnitCost.Add(share.MulFrac(1, line.Quantity, money.HalfUp))
+	}
+	order.Total = order.Subtotal.Add(order.AdditionalCosts)
+	return order, nil
+}
+
+// CreatePurchaseOrder raises a draft purchase order from request.
+func CreatePurchaseOrder(ctx context.Context, prodCollection, purchaseOrderCollection *mongo.Collection, request PurchaseOrderRequest, actor string) (models.PurchaseOrder, error) {
+	db := purchaseOrderCollection.Database()
+	order, err := buildPurchaseOrder(ctx, prodCollection, db, request)
+	if err != nil {
+		return order, err
+	}
+	seq, err := nextSequence(ctx, db, "po_number")
+	if err != nil {
+		log.Println(err)
+		return order, ErrCantUpdatePurchaseOrder
+	}
+	order.ID = primitive.NewObjectID()
+	order.PONumber = fmt.Sprintf("PO-%08d", seq)
+	order.Status = models.PurchaseOrderDraft
+	order.Actor = actor
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+
+	if _, err = purchaseOrderCollection.InsertOne(ctx, order); err != nil {
+		log.Println(err)
+		return order, ErrCantUpdatePurchaseOrder
+	}
+	return order, nil
+}
+
+// UpdatePurchaseOrder replaces the supplier, warehouse, lines and costs
+// of a purchase order that is still a draft.
+func UpdatePurchaseOrder(ctx context.Context, prodCollection, purchaseOrderCollection *mongo.Collection, purchaseOrderID string, request PurchaseOrderRequest) (models.PurchaseOrder, error) {
+	id, err := primitive.ObjectIDFromHex(purchaseOrderID)
+	if err != nil {
+		return models.PurchaseOrder{}, ErrCantFindPurchaseOrder
+	}
+	order, err := buildPurchaseOrder(ctx, prodCollection, purchaseOrderCollection.Database(), request)
+	if err != nil {
+		return order, err
+	}
+	update := bson.M{"$set": bson.M{
+		"supplier_id":      order.SupplierID,
+		"warehouse_id":     order.WarehouseID,
+		"currency":         order.Currency,
+		"lines":            order.Lines,
+		"subtotal":         order.Subtotal,
+		"additional_costs": order.AdditionalCosts,
+		"total":            order.Total,
+		"expected_at":      order.ExpectedAt,
+		"note":             order.Note,
+		"updated_at":       time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = purchaseOrderCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": models.PurchaseOrderDraft}, update, opts).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, missingPurchaseOrder(ctx, purchaseOrderCollection, id, ErrPurchaseOrderNotDraft)
+	}
+	if err != nil {
+		log.Println(err)
+		return order, ErrCantUpdatePurchaseOrder
+	}
+	return order, nil
+}
+
+// SetPurchaseOrderStatus sends a draft purchase order to its supplier or
+// closes a purchase order, following models.PurchaseOrderTransitions.
+// Sending sets the expected delivery from the supplier's lead time when
+// none was given. Closing an order not yet fully received gives up on the
+// units still outstanding.
+func SetPurchaseOrderStatus(ctx context.Context, purchaseOrderCollection *mongo.Collection, purchaseOrderID string, status models.PurchaseOrderStatus) (models.PurchaseOrder, error) {
+	order, err := GetPurchaseOrder(ctx, purchaseOrderCollection, purchaseOrderID)
+	if err != nil {
+		return order, err
+	}
+	if !order.Status.CanTransition(status) {
+		return order, ErrIllegalPurchaseOrderTransition
+	}
+
+	now := time.Now()
+	set := bson.M{"status": status, "updated_at": now}
+	switch status {
+	case models.PurchaseOrderSent:
+		set["sent_at"] = now
+		if order.ExpectedAt.IsZero() {
+			supplier, err := GetSupplier(ctx, suppliers(purchaseOrderCollection.Database()), order.SupplierID.Hex())
+			if err != nil {
+				return order, err
+			}
+			set["expected_at"] = now.AddDate(0, 0, int(supplier.LeadTimeDays))
+		}
+	case models.PurchaseOrderClosed:
+		set["closed_at"] = now
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = purchaseOrderCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": order.ID, "status": order.Status}, bson.M{"$set": set}, opts).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Someone else moved it on first.
+		return order, ErrIllegalPurchaseOrderTransition
+	}
+	if err != nil {
+		log.Println(err)
+		return order, ErrCantUpdatePurchaseOrder
+	}
+	return order, nil
+}
+
+// ReceiptRequest lists the units of a delivery against a purchase order.
+// Without lines, everything still outstanding arrived.
+type ReceiptRequest struct {
+	Lines []models.ReceiptLine `json:"lines"`
+	Note  string               `json:"note"`
+}
+
+// ReceivePurchaseOrder books a delivery against a sent purchase order:
+// each line's units are recorded as a receipt into the order's warehouse
+// at their landed cost, and the order becomes partially_received, or
+// received once nothing is outstanding. More units than are outstanding
+// cannot be received.
+func ReceivePurchaseOrder(ctx context.Context, purchaseOrderCollection *mongo.Collection, purchaseOrderID string, request ReceiptRequest, actor string) (models.PurchaseOrder, error) {
+	id, err := primitive.ObjectIDFromHex(purchaseOrderID)
+	if err != nil {
+		return models.PurchaseOrder{}, ErrCantFindPurchaseOrder
+	}
+	db := purchaseOrderCollection.Database()
+	var order models.PurchaseOrder
+	err = runInTransaction(ctx, db.Client(), func(sessCtx mongo.SessionContext) error {
+		err := purchaseOrderCollection.FindOne(sessCtx, bson.M{"_id": id}).Decode(&order)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrCantFindPurchaseOrder
+		}
+		if err != nil {
+			return err
+		}
+		if !order.Status.Open() {
+			return ErrIllegalPurchaseOrderTransition
+		}
+
+		lines := request.Lines
+		if len(lines) == 0 {
+			for _, line := range order.Lines {
+				if line.Outstanding() > 0 {
+					lines = append(lines, models.ReceiptLine{LineID: line.LineID, Quantity: line.Outstanding()})
+				}
+			}
+		}
+		if len(lines) == 0 {
+			return ErrInvalidReceipt
+		}
+		index := make(map[primitive.ObjectID]int, len(order.Lines))
+		for i, line := range order.Lines {
+			index[line.LineID] = i
+		}
+		now := time.Now()
+		for _, received := range lines {
+			i, ok := index[received.LineID]
+			if !ok || received.Quantity <= 0 || received.Quantity > order.Lines[i].Outstanding() {
+				return ErrInvalidReceipt
+			}
+			line := &order.Lines[i]
+			line.ReceivedQuantity += received.Quantity
+			movement := models.StockMovement{
+				ProductID:   line.ProductID,
+				VariantID:   line.VariantID,
+				WarehouseID: order.WarehouseID,
+				Kind:        models.MovementReceipt,
+				Quantity:    received.Quantity,
+				Reference:   order.PONumber,
+				Actor:       actor,
+				Reason:      "purchase order received",
+				UnitCost:    line.LandedUnitCost,
+				At:          now,
+			}
+			if _, _, err = moveStock(sessCtx, db, movement, 0, nil); err != nil {
+				return err
+			}
+		}
+
+		order.Status = models.PurchaseOrderReceived
+		for _, line := range order.Lines {
+			if line.Outstanding() > 0 {
+				order.Status = models.PurchaseOrderPartiallyReceived
+			}
+		}
+		receipt := models.PurchaseReceipt{
+			ID:         primitive.NewObjectID(),
+			Lines:      lines,
+			Actor:      actor,
+			Note:       strings.TrimSpace(request.Note),
+			ReceivedAt: now,
+		}
+		order.Receipts = append(order.Receipts, receipt)
+		order.UpdatedAt = now
+		set := bson.M{"lines": order.Lines, "status": order.Status, "updated_at": now}
+		if order.Status == models.PurchaseOrderReceived {
+			order.ReceivedAt = now
+			set["received_at"] = now
+		}
+		_, err = purchaseOrderCollection.UpdateOne(sessCtx, bson.M{"_id": id},
+			bson.M{"$set": set, "$push": bson.M{"receipts": receipt}})
+		return err
+	})
+	return order, purchaseError(err)
+}
+
+func GetPurchaseOrder(ctx context.Context, purchaseOrderCollection *mongo.Collection, purchaseOrderID string) (models.PurchaseOrder, error) {
+	var order models.PurchaseOrder
+	id, err := primitive.ObjectIDFromHex(purchaseOrderID)
+	if err != nil {
+		return order, ErrCantFindPurchaseOrder
+	}
+	err = purchaseOrderCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, ErrCantFindPurchaseOrder
+	}
+	if err != nil {
+		log.Println(err)
+		return order, ErrCantFindPurchaseOrder
+	}
+	return order, nil
+}
+
+// ListPurchaseOrders returns one page (1-based) of purchase orders, newest
+// first, together with the total number matching filter.
+func ListPurchaseOrders(ctx context.Context, purchaseOrderCollection *mongo.Collection, filter models.PurchaseOrderFilter, page, limit int64) ([]models.PurchaseOrder, int64, error) {
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if !filter.SupplierID.IsZero() {
+		query["supplier_id"] = filter.SupplierID
+	}
+	if !filter.ProductID.IsZero() {
+		query["lines.product_id"] = filter.ProductID
+	}
+	if filter.Overdue {
+		query["expected_at"] = bson.M{"$lt": time.Now()}
+		if filter.Status == "" {
+			query["status"] = bson.M{"$in": bson.A{models.PurchaseOrderSent, models.PurchaseOrderPartiallyReceived}}
+		}
+	}
+	total, err := purchaseOrderCollection.CountDocuments(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListPurchaseOrders
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := purchaseOrderCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListPurchaseOrders
+	}
+	defer cursor.Close(ctx)
+
+	orders := make([]models.PurchaseOrder, 0)
+	if err = cursor.All(ctx, &orders); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListPurchaseOrders
+	}
+	return orders, total, nil
+}
+
+// onOrder returns the units of each item of the products among productIDs
+// that open purchase orders are still waiting for.
+func onOrder(ctx context.Context, db *mongo.Database, productIDs []primitive.ObjectID) (map[stockItem]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"status":           bson.M{"$in": bson.A{models.PurchaseOrderSent, models.PurchaseOrderPartiallyReceived}},
+			"lines.product_id": bson.M{"$in": productIDs},
+		}}},
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$match", Value: bson.M{"lines.product_id": bson.M{"$in": productIDs}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"product_id": "$lines.product_id", "variant_id": "$lines.variant_id"},
+			"units": bson.M{"$sum": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{
+				"$lines.quantity",
+				bson.M{"$ifNull": bson.A{"$lines.received_quantity", 0}},
+			}}}}},
+		}}},
+	}
+	cursor, err := purchaseOrders(db).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var sums []struct {
+		Item  soldItem `bson:"_id"`
+		Units int64    `bson:"units"`
+	}
+	if err = cursor.All(ctx, &sums); err != nil {
+		return nil, err
+	}
+	units := make(map[stockItem]int64, len(sums))
+	for _, sum := range sums {
+		units[itemOf(sum.Item.ProductID, sum.Item.VariantID)] = sum.Units
+	}
+	return units, nil
+}
+
+// missingPurchaseOrder tells a purchase order that does not exist from one
+// an update skipped because of its status, which is reported as
+// otherwise.
+func missingPurchaseOrder(ctx context.Context, purchaseOrderCollection *mongo.Collection, id primitive.ObjectID, otherwise error) error {
+	count, err := purchaseOrderCollection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePurchaseOrder
+	}
+	if count == 0 {
+		return ErrCantFindPurchaseOrder
+	}
+	return otherwise
+}
+
+// purchaseError passes the known purchase order errors through and hides
+// anything else behind ErrCantUpdatePurchaseOrder.
+func purchaseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, known := range []error{ErrCantFindPurchaseOrder, ErrIllegalPurchaseOrderTransition, ErrInvalidReceipt} {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+	log.Println(err)
+	return ErrCantUpdatePurchaseOrder
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReceivePurchaseOrderRecordsReceipts(t *testing.T) {
+	f := newCheckoutFixture(t)
+	ctx := context.Background()
+	for _, name := range []string{"PurchaseOrders", "StockMovements", "StockSnapshots"} {
+		if err := f.db.CreateCollection(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shirts, mugs := primitive.NewObjectID(), primitive.NewObjectID()
+	// Mugs are already on the shelf; shirts are new to the warehouse.
+	f.stock(t, mugs, 4)
+	order := models.PurchaseOrder{
+		ID:          primitive.NewObjectID(),
+		PONumber:    "PO-000001",
+		WarehouseID: f.warehouse,
+		Currency:    models.DefaultCurrency,
+		Status:      models.PurchaseOrderSent,
+		Lines: []models.PurchaseOrderLine{
+			{LineID: primitive.NewObjectID(), ProductID: shirts, Quantity: 10, LandedUnitCost: money.FromMajor(120, models.DefaultCurrency)},
+			{LineID: primitive.NewObjectID(), ProductID: mugs, Quantity: 6, LandedUnitCost: money.FromMajor(40, models.DefaultCurrency)},
+		},
+		CreatedAt: time.Now(),
+	}
+	if _, err := purchaseOrders(f.db).InsertOne(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	receive := func(lines ...models.ReceiptLine) (models.PurchaseOrder, error) {
+		return ReceivePurchaseOrder(ctx, purchaseOrders(f.db), order.ID.Hex(), ReceiptRequest{Lines: lines}, "storekeeper")
+	}
+	onHand := func(productID primitive.ObjectID) int64 {
+		t.Helper()
+		var stock models.Stock
+		if err := inventory(f.db).FindOne(ctx, bson.M{"product_id": productID}).Decode(&stock); err != nil {
+			t.Fatal(err)
+		}
+		return stock.OnHand
+	}
+
+	got, err := receive(models.ReceiptLine{LineID: order.Lines[0].LineID, Quantity: 4})
+	if err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+	if got.Status != models.PurchaseOrderPartiallyReceived || got.Lines[0].ReceivedQuantity != 4 {
+		t.Errorf("after the first delivery %s with %d shirts received, want %s with 4", got.Status, got.Lines[0].ReceivedQuantity, models.PurchaseOrderPartiallyReceived)
+	}
+	if n := onHand(shirts); n != 4 {
+		t.Errorf("shirts on hand = %d, want 4", n)
+	}
+
+	// More than is outstanding is refused, and nothing of it is booked.
+	if _, err := receive(models.ReceiptLine{LineID: order.Lines[0].LineID, Quantity: 7}); !errors.Is(err, ErrInvalidReceipt) {
+		t.Fatalf("over-delivery err = %v, want %v", err, ErrInvalidReceipt)
+	}
+
+	// Without lines, everything outstanding arrives.
+	got, err = receive()
+	if err != nil {
+		t.Fatalf("second delivery: %v", err)
+	}
+	if got.Status != models.PurchaseOrderReceived || got.ReceivedAt.IsZero() || len(got.Receipts) != 2 {
+		t.Errorf("after the second delivery %s with %d receipts, want %s with 2", got.Status, len(got.Receipts), models.PurchaseOrderReceived)
+	}
+	if n := onHand(shirts); n != 10 {
+		t.Errorf("shirts on hand = %d, want 10", n)
+	}
+	if n := onHand(mugs); n != 10 {
+		t.Errorf("mugs on hand = %d, want 10", n)
+	}
+
+	var receipts []models.StockMovement
+	cursor, err := movements(f.db).Find(ctx, bson.M{"kind": models.MovementReceipt, "reference": order.PONumber})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.All(ctx, &receipts); err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 3 {
+		t.Fatalf("receipt movements = %d, want 3", len(receipts))
+	}
+	for _, movement := range receipts {
+		want := order.Lines[0].LandedUnitCost
+		if movement.ProductID == mugs {
+			want = order.Lines[1].LandedUnitCost
+		}
+		if movement.Actor != "storekeeper" || movement.UnitCost.Cmp(want) != 0 {
+			t.Errorf("receipt %+v, want it booked by storekeeper at %s", movement, want)
+		}
+	}
+
+	if _, err := receive(); !errors.Is(err, ErrIllegalPurchaseOrderTransition) {
+		t.Errorf("receiving a received order err = %v, want %v", err, ErrIllegalPurchaseOrderTransition)
+	}
+}
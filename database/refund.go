@@ -0,0 +1,446 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/payments"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidRefund     = errors.New("refund request is not valid")
+	ErrOrderNotPaid      = errors.New("order has not been paid for")
+	ErrRefundExceedsPaid = errors.New("refund is more than what is left to refund")
+	ErrCantListRefunds   = errors.New("cannot list refunds")
+	ErrCantRecordLedger  = errors.New("cannot record ledger entry")
+)
+
+const (
+	// RefundMethodManual marks refunds of cash on delivery payments, which
+	// staff pay back outside the system.
+	RefundMethodManual = "manual"
+	// RefundMethodSplit marks refunds paid out to more than one method.
+	RefundMethodSplit = "split"
+)
+
+// IssueRefund refunds part or all of what was paid for an order. The amount
+// is first reserved against the order, so concurrent refunds can never add
+// up to more than was paid, and spread over the order's tenders. Provider
+// payments are then paid back through the provider that took them, each
+// payout recorded on the refund as soon as the provider answers, and store
+// credit is paid back to the customer's wallet. When a payout fails, the
+// payouts already paid stand and the refund is marked partially refunded;
+// the rest of the reservation is released so it can be retried. A refund
+// with nothing paid is marked failed. What was paid is written to the
+// ledger.
+func IssueRefund(ctx context.Context, orderCollection, intentCollection, refundCollection, ledgerCollection *mongo.Collection, wallets WalletCollections, orderID primitive.ObjectID, req models.RefundRequest, actor string, source string) (models.Refund, error) {
+	refund, order, err := reserveRefund(ctx, orderCollection, refundCollection, orderID, req, actor, source)
+	if err != nil {
+		return refund, err
+	}
+
+	var payoutErr error
+	for i, payout := range refund.Payouts {
+		if payout.Method == RefundMethodManual || payout.Method == models.TenderWallet {
+			continue
+		}
+		payoutErr = payOutRefund(ctx, intentCollection, order.Tenders[payout.Tender], payout.Amount, refundPayoutKey(refund, payout))
+		refund.Payouts[i].Status = models.RefundCompleted
+		if payoutErr != nil {
+			refund.Payouts[i].Status = models.RefundFailed
+		}
+		recordPayout(ctx, refundCollection, refund, i)
+		if payoutErr != nil {
+			break
+		}
+	}
+
+	err = runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		if payoutErr != nil {
+			return failRefund(sessCtx, orderCollection, intentCollection, refundCollection, ledgerCollection, wallets, &refund, order)
+		}
+		return completeRefund(sessCtx, orderCollection, intentCollection, refundCollection, ledgerCollection, wallets, &refund, order)
+	})
+	if err != nil {
+		log.Println(err)
+		return refund, ErrCantRefund
+	}
+	if payoutErr != nil {
+		log.Println(payoutErr)
+		return refund, ErrCantRefund
+	}
+	return refund, nil
+}
+
+// refundPayoutKey is the idempotency key payout is paid out under, so that
+// a provider asked twice for the same payout pays it once.
+func refundPayoutKey(refund models.Refund, payout models.RefundPayout) string {
+	return refund.ID.Hex() + "-" + strconv.Itoa(payout.Tender)
+}
+
+// recordPayout stores the outcome of the refund's payout at index. It is
+// written straight away, outside any transaction, so that a payout the
+// provider has made is on record even if the refund goes no further.
+func recordPayout(ctx context.Context, refundCollection *mongo.Collection, refund models.Refund, index int) {
+	field := "payouts." + strconv.Itoa(index) + ".status"
+	_, err := refundCollection.UpdateOne(ctx, bson.M{"_id": refund.ID}, bson.M{"$set": bson.M{field: refund.Payouts[index].Status, "updated_at": time.Now()}})
+	if err != nil {
+		log.Printf("recording payout %d of refund %s: %v", index, refund.ID.Hex(), err)
+	}
+}
+
+// reserveRefund works out the refund amount for req, checks it against
+// what is left to refund, spreads it over the order's tenders and records
+// it on the order and as a pending refund, all in one transaction.
+func reserveRefund(ctx context.Context, orderCollection, refundCollection *mongo.Collection, orderID primitive.ObjectID, req models.RefundRequest, actor string, source string) (models.Refund, models.Order, error) {
+	var refund models.Refund
+	var order models.Order
+	err := runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var err error
+		order, err = findOrder(sessCtx, orderCollection, bson.M{"_id": orderID})
+		if err != nil {
+			return err
+		}
+		if order.PaidAmount.IsZero() {
+			return ErrOrderNotPaid
+		}
+
+		now := time.Now()
+		refund = models.Refund{
+			ID:        primitive.NewObjectID(),
+			OrderID:   order.ID,
+			Kind:      req.Kind,
+			Method:    RefundMethodManual,
+			Status:    models.RefundPending,
+			Reason:    req.Reason,
+			Source:    source,
+			Actor:     actor,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		switch req.Kind {
+		case models.RefundFull:
+			refund.Amount = order.RefundableAmount()
+		case models.RefundLines:
+			if len(req.Lines) == 0 {
+				return ErrInvalidRefund
+			}
+			for _, line := range req.Lines {
+				index := orderLineIndex(order, line.LineID)
+				if index < 0 || line.Quantity == 0 || order.Items[index].Refunded+line.Quantity > order.Items[index].Quantity {
+					return ErrInvalidRefund
+				}
+				order.Items[index].Refunded += line.Quantity
+				line.Amount = order.Items[index].NetAmount(line.Quantity)
+				refund.Lines = append(refund.Lines, line)
+				refund.Amount = refund.Amount.Add(line.Amount)
+			}
+		case models.RefundShipping:
+			refund.Shipping = order.ShippingCharged().Sub(order.RefundedShipping)
+			refund.Amount = refund.Shipping
+			if !order.TaxInclusive && refund.Shipping.IsPositive() {
+				refund.Amount = refund.Amount.Add(order.ShippingTax)
+			}
+			order.RefundedShipping = order.RefundedShipping.Add(refund.Shipping)
+		case models.RefundGoodwill:
+			refund.Amount = req.Amount
+		default:
+			return ErrInvalidRefund
+		}
+		if !refund.Amount.IsPositive() || !refund.Amount.SameCurrency(order.PaidAmount) {
+			return ErrInvalidRefund
+		}
+		if refund.Amount.GreaterThan(order.RefundableAmount()) {
+			return ErrRefundExceedsPaid
+		}
+		order.RefundedAmount = order.RefundedAmount.Add(refund.Amount)
+
+		if len(order.Tenders) == 0 {
+			order.Tenders = legacyTenders(order)
+		}
+		refund.Payouts, err = allocateRefund(&order, refund.Amount, req.ToWallet)
+		if err != nil {
+			return err
+		}
+		refund.Method = refund.Payouts[0].Method
+		for _, payout := range refund.Payouts {
+			if payout.Method != refund.Method {
+				refund.Method = RefundMethodSplit
+			}
+			if payout.Method != RefundMethodManual && payout.Method != models.TenderWallet && refund.IntentID.IsZero() {
+				refund.IntentID, _ = primitive.ObjectIDFromHex(order.Tenders[payout.Tender].Reference)
+			}
+		}
+
+		update := bson.M{"$set": bson.M{
+			"items":             order.Items,
+			"tenders":           order.Tenders,
+			"refunded_amount":   order.RefundedAmount,
+			"refunded_shipping": order.RefundedShipping,
+			"updated_at":        now,
+		}}
+		if _, err = orderCollection.UpdateOne(sessCtx, bson.M{"_id": order.ID}, update); err != nil {
+			return err
+		}
+		_, err = refundCollection.InsertOne(sessCtx, refund)
+		return err
+	})
+	return refund, order, refundError(err)
+}
+
+// legacyTenders describes the payment of an order placed before orders
+// recorded their tenders: everything paid went through its one payment
+// method.
+func legacyTenders(order models.Order) []models.Tender {
+	if order.PaymentMethod.Method == models.PaymentOnline {
+		return []models.Tender{{Method: order.PaymentMethod.Provider, Amount: order.PaidAmount, Reference: order.PaymentMethod.IntentID.Hex()}}
+	}
+	return []models.Tender{{Method: models.PaymentCOD, Amount: order.PaidAmount, Reference: order.ID.Hex()}}
+}
+
+// allocateRefund spreads amount over the order's tenders, money paid
+// through a provider or in cash first and store credit last, and marks it
+// refunded on them. Store credit is always paid back to the wallet, as is
+// everything when toWallet is set.
+func allocateRefund(order *models.Order, amount money.Money, toWallet bool) ([]models.RefundPayout, error) {
+	var payouts []models.RefundPayout
+	for _, storeCredit := range []bool{false, true} {
+		for i := range order.Tenders {
+			tender := &order.Tenders[i]
+			isStoreCredit := tender.Method == models.TenderWallet || tender.Method == models.TenderGiftCard
+			left := tender.Amount.Sub(tender.Refunded)
+			if isStoreCredit != storeCredit || amount.IsZero() || !left.IsPositive() {
+				continue
+			}
+			part := money.Min(amount, left)
+			tender.Refunded = tender.Refunded.Add(part)
+			amount = amount.Sub(part)
+
+			method := tender.Method
+			switch {
+			case toWallet || isStoreCredit:
+				method = models.TenderWallet
+			case method == models.PaymentCOD:
+				method = RefundMethodManual
+			}
+			payouts = append(payouts, models.RefundPayout{Tender: i, Method: method, Amount: part, Status: models.RefundPending})
+		}
+	}
+	if amount.IsPositive() || len(payouts) == 0 {
+		return nil, ErrRefundExceedsPaid
+	}
+	return payouts, nil
+}
+
+// payOutRefund pays amount back through the provider that took tender,
+// under idempotencyKey.
+func payOutRefund(ctx context.Context, intentCollection *mongo.Collection, tender models.Tender, amount money.Money, idempotencyKey string) error {
+	intentID, err := primitive.ObjectIDFromHex(tender.Reference)
+	if err != nil {
+		return ErrCantFindIntent
+	}
+	intent, err := findIntent(ctx, intentCollection, bson.M{"_id": intentID})
+	if err != nil {
+		return err
+	}
+	provider, err := payments.Lookup(intent.Provider)
+	if err != nil {
+		return err
+	}
+	_, err = provider.Refund(ctx, intent.Reference, amount, idempotencyKey)
+	return err
+}
+
+// completeRefund marks refund as paid out, books each payout on its intent
+// or the customer's wallet and on the ledger, and moves a cancelled or
+// returned order that has now been paid back in full to refunded.
+func completeRefund(sessCtx mongo.SessionContext, orderCollection, intentCollection, refundCollection, ledgerCollection *mongo.Collection, wallets WalletCollections, refund *models.Refund, order models.Order) error {
+	for i := range refund.Payouts {
+		refund.Payouts[i].Status = models.RefundCompleted
+	}
+	if err := bookRefund(sessCtx, intentCollection, refundCollection, ledgerCollection, wallets, refund, order, models.RefundCompleted); err != nil {
+		return err
+	}
+
+	order, err := findOrder(sessCtx, orderCollection, bson.M{"_id": refund.OrderID})
+	if err != nil {
+		return err
+	}
+	if order.RefundableAmount().IsPositive() || !order.Status.CanTransitionTo(models.OrderRefunded) {
+		return nil
+	}
+	return setOrderStatus(sessCtx, orderCollection, &order, models.OrderRefunded, SystemActor, "payment fully refunded")
+}
+
+// failRefund books the payouts of refund that were paid and gives the
+// reservation of every other payout back to the order so it can be
+// retried. A refund with nothing paid is marked failed and releases its
+// lines and shipping too; one partly paid keeps them, as the customer has
+// had some money back for them, and the rest can be refunded as goodwill.
+func failRefund(sessCtx mongo.SessionContext, orderCollection, intentCollection, refundCollection, ledgerCollection *mongo.Collection, wallets WalletCollections, refund *models.Refund, order models.Order) error {
+	status := models.RefundFailed
+	if refund.PaidAmount().IsPositive() {
+		status = models.RefundPartial
+	}
+	for i := range refund.Payouts {
+		if refund.Payouts[i].Status == models.RefundPending {
+			refund.Payouts[i].Status = models.RefundFailed
+		}
+	}
+	if err := bookRefund(sessCtx, intentCollection, refundCollection, ledgerCollection, wallets, refund, order, status); err != nil {
+		return err
+	}
+
+	order, err := findOrder(sessCtx, orderCollection, bson.M{"_id": refund.OrderID})
+	if err != nil {
+		return err
+	}
+	if status == models.RefundFailed {
+		for _, line := range refund.Lines {
+			if index := orderLineIndex(order, line.LineID); index >= 0 {
+				order.Items[index].Refunded -= line.Quantity
+			}
+		}
+		order.RefundedShipping = order.RefundedShipping.Sub(refund.Shipping)
+	}
+	for _, payout := range refund.Payouts {
+		if payout.Status != models.RefundFailed {
+			continue
+		}
+		if payout.Tender < len(order.Tenders) {
+			order.Tenders[payout.Tender].Refunded = order.Tenders[payout.Tender].Refunded.Sub(payout.Amount)
+		}
+		order.RefundedAmount = order.RefundedAmount.Sub(payout.Amount)
+	}
+	update := bson.M{"$set": bson.M{
+		"items":             order.Items,
+		"tenders":           order.Tenders,
+		"refunded_amount":   order.RefundedAmount,
+		"refunded_shipping": order.RefundedShipping,
+		"updated_at":        refund.UpdatedAt,
+	}}
+	_, err = orderCollection.UpdateOne(sessCtx, bson.M{"_id": order.ID}, update)
+	return err
+}
+
+// bookRefund stores refund with status and its payouts, and books each
+// paid payout on its intent or the customer's wallet and on the ledger.
+func bookRefund(sessCtx mongo.SessionContext, intentCollection, refundCollection, ledgerCollection *mongo.Collection, wallets WalletCollections, refund *models.Refund, order models.Order, status string) error {
+	refund.Status = status
+	refund.UpdatedAt = time.Now()
+	_, err := refundCollection.UpdateOne(sessCtx, bson.M{"_id": refund.ID}, bson.M{"$set": bson.M{"status": refund.Status, "payouts": refund.Payouts, "updated_at": refund.UpdatedAt}})
+	if err != nil {
+		return err
+	}
+	for _, payout := range refund.Payouts {
+		if payout.Status != models.RefundCompleted {
+			continue
+		}
+		switch payout.Method {
+		case RefundMethodManual:
+		case models.TenderWallet:
+			err = postWalletTransaction(sessCtx, wallets, WalletRefund, refund.ID.Hex(),
+				models.WalletEntry{Account: models.AccountRefunds, Amount: payout.Amount.Neg()},
+				models.WalletEntry{Account: models.WalletAccount(order.UserID), Amount: payout.Amount},
+			)
+		default:
+			intentID, _ := primitive.ObjectIDFromHex(order.Tenders[payout.Tender].Reference)
+			_, err = intentCollection.UpdateOne(sessCtx, bson.M{"_id": intentID}, bson.M{
+				"$inc": bson.M{"refunded.amount": payout.Amount.Amount},
+				"$set": bson.M{"refunded.currency": payout.Amount.Currency, "updated_at": refund.UpdatedAt},
+			})
+		}
+		if err != nil {
+			return err
+		}
+		err = recordLedgerEntry(sessCtx, ledgerCollection, models.LedgerEntry{
+			OrderID:   refund.OrderID,
+			Type:      models.LedgerRefund,
+			Amount:    payout.Amount.Neg(),
+			Method:    payout.Method,
+			Reference: refund.ID,
+			Actor:     refund.Actor,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLedgerEntry appends entry to the ledger, stamping its id, time and
+// currency.
+func recordLedgerEntry(ctx context.Context, ledgerCollection *mongo.Collection, entry models.LedgerEntry) error {
+	entry.ID = primitive.NewObjectID()
+	entry.At = time.Now()
+	if entry.Currency == "" {
+		entry.Currency = entry.Amount.Currency
+	}
+	if entry.Currency == "" {
+		entry.Currency = models.DefaultCurrency
+	}
+	if _, err := ledgerCollection.InsertOne(ctx, entry); err != nil {
+		log.Println(err)
+		return ErrCantRecordLedger
+	}
+	return nil
+}
+
+func ListOrderRefunds(ctx context.Context, refundCollection *mongo.Collection, orderID string) ([]models.Refund, error) {
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return nil, ErrOrderIdIsNotValid
+	}
+	refunds := make([]models.Refund, 0)
+	cursor, err := refundCollection.Find(ctx, bson.M{"order_id": id}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListRefunds
+	}
+	if err = cursor.All(ctx, &refunds); err != nil {
+		log.Println(err)
+		return nil, ErrCantListRefunds
+	}
+	return refunds, nil
+}
+
+func ListOrderLedger(ctx context.Context, ledgerCollection *mongo.Collection, orderID string) ([]models.LedgerEntry, error) {
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return nil, ErrOrderIdIsNotValid
+	}
+	entries := make([]models.LedgerEntry, 0)
+	cursor, err := ledgerCollection.Find(ctx, bson.M{"order_id": id}, options.Find().SetSort(bson.D{{Key: "at", Value: 1}}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListRefunds
+	}
+	if err = cursor.All(ctx, &entries); err != nil {
+		log.Println(err)
+		return nil, ErrCantListRefunds
+	}
+	return entries, nil
+}
+
+func refundError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, known := range []error{ErrCantFindOrder, ErrOrderNotPaid, ErrInvalidRefund, ErrRefundExceedsPaid, ErrCantFindIntent} {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+	log.Println(err)
+	return ErrCantRefund
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/payments"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// refundFailingProvider is FakeProvider with refunds that fail once calls
+// runs out, and that remembers the idempotency keys it was asked to pay.
+type refundFailingProvider struct {
+	*payments.FakeProvider
+	calls int
+	keys  []string
+}
+
+func (p *refundFailingProvider) Name() string {
+	return "fake-refund-fails"
+}
+
+func (p *refundFailingProvider) Refund(ctx context.Context, reference string, amount money.Money, idempotencyKey string) (payments.Result, error) {
+	if p.calls == 0 {
+		return payments.Result{}, payments.ErrInvalidRequest
+	}
+	p.calls--
+	p.keys = append(p.keys, idempotencyKey)
+	return p.FakeProvider.Refund(ctx, reference, amount, idempotencyKey)
+}
+
+// refundFixture is an order paid online in two instalments through the
+// same payment, so that a refund is paid out in two payouts.
+type refundFixture struct {
+	paymentFixture
+	provider *refundFailingProvider
+	refunds  *mongo.Collection
+}
+
+func newRefundFixture(t *testing.T) refundFixture {
+	t.Helper()
+	provider := &refundFailingProvider{FakeProvider: payments.NewFakeProvider(testWebhookSecret), calls: 2}
+	f := refundFixture{paymentFixture: newPaymentFixture(t, provider), provider: provider}
+	f.refunds = f.db.Collection("Refunds")
+	if err := f.db.CreateCollection(context.Background(), "Refunds"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.pay(payments.FakeTokenSuccess); err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+
+	order := f.stored(t)
+	if len(order.Tenders) != 1 {
+		t.Fatalf("tenders = %d, want 1", len(order.Tenders))
+	}
+	first := order.Tenders[0]
+	first.Amount = order.PaidAmount.MulFrac(1, 2, money.HalfUp)
+	second := first
+	second.Amount = order.PaidAmount.Sub(first.Amount)
+	_, err := f.orders.UpdateOne(context.Background(), bson.M{"_id": order.ID}, bson.M{"$set": bson.M{"tenders": []models.Tender{first, second}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f refundFixture) refund(req models.RefundRequest) (models.Refund, error) {
+	return IssueRefund(context.Background(), f.orders, f.intents, f.refunds, f.ledger, f.wallets, f.order.ID, req, "admin", "test")
+}
+
+func (f refundFixture) storedRefund(t *testing.T, refund models.Refund) models.Refund {
+	t.Helper()
+	var stored models.Refund
+	if err := f.refunds.FindOne(context.Background(), bson.M{"_id": refund.ID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestIssueRefundPaysEveryTender(t *testing.T) {
+	f := newRefundFixture(t)
+
+	refund, err := f.refund(models.RefundRequest{Kind: models.RefundFull})
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	stored := f.storedRefund(t, refund)
+	if stored.Status != models.RefundCompleted || len(stored.Payouts) != 2 {
+		t.Fatalf("refund %s with %d payouts, want refunded with 2", stored.Status, len(stored.Payouts))
+	}
+	for _, payout := range stored.Payouts {
+		if payout.Status != models.RefundCompleted {
+			t.Errorf("payout of tender %d is %s, want %s", payout.Tender, payout.Status, models.RefundCompleted)
+		}
+	}
+	order := f.stored(t)
+	if order.RefundedAmount.Cmp(order.PaidAmount) != 0 {
+		t.Errorf("refunded %s, want everything paid (%s)", order.RefundedAmount, order.PaidAmount)
+	}
+	if intent := f.intent(t); intent.Refunded.Cmp(order.PaidAmount) != 0 {
+		t.Errorf("intent refunded %s, want %s", intent.Refunded, order.PaidAmount)
+	}
+	if got := f.count(t, f.ledger, bson.M{"order_id": order.ID, "type": models.LedgerRefund}); got != 2 {
+		t.Errorf("refund ledger entries = %d, want 2", got)
+	}
+
+	// Each payout has a key of its own, and asking again with it pays
+	// nothing more.
+	if len(f.provider.keys) != 2 || f.provider.keys[0] == f.provider.keys[1] {
+		t.Fatalf("idempotency keys %v, want two different ones", f.provider.keys)
+	}
+	_, err = f.provider.FakeProvider.Refund(context.Background(), f.intent(t).Reference, stored.Payouts[0].Amount, f.provider.keys[0])
+	if err != nil {
+		t.Errorf("repeated payout: %v", err)
+	}
+}
+
+func TestIssueRefundKeepsPaidPayouts(t *testing.T) {
+	f := newRefundFixture(t)
+	f.provider.calls = 1
+
+	refund, err := f.refund(models.RefundRequest{Kind: models.RefundFull})
+	if !errors.Is(err, ErrCantRefund) {
+		t.Fatalf("refund err = %v, want %v", err, ErrCantRefund)
+	}
+	stored := f.storedRefund(t, refund)
+	if stored.Status != models.RefundPartial {
+		t.Errorf("refund status = %s, want %s", stored.Status, models.RefundPartial)
+	}
+	if len(stored.Payouts) != 2 || stored.Payouts[0].Status != models.RefundCompleted || stored.Payouts[1].Status != models.RefundFailed {
+		t.Fatalf("payouts %+v, want the first paid and the second failed", stored.Payouts)
+	}
+	paid := stored.Payouts[0].Amount
+	order := f.stored(t)
+	if order.RefundedAmount.Cmp(paid) != 0 {
+		t.Errorf("refunded %s, want the paid payout (%s)", order.RefundedAmount, paid)
+	}
+	if !order.Tenders[1].Refunded.IsZero() {
+		t.Errorf("failed tender still has %s reserved", order.Tenders[1].Refunded)
+	}
+	if got := f.count(t, f.ledger, bson.M{"order_id": order.ID, "type": models.LedgerRefund}); got != 1 {
+		t.Errorf("refund ledger entries = %d, want 1", got)
+	}
+
+	// Retrying refunds only what was not paid.
+	f.provider.calls = 1
+	retry, err := f.refund(models.RefundRequest{Kind: models.RefundFull})
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if retry.Amount.Cmp(order.PaidAmount.Sub(paid)) != 0 {
+		t.Errorf("retry refunded %s, want %s", retry.Amount, order.PaidAmount.Sub(paid))
+	}
+	if order := f.stored(t); order.RefundedAmount.Cmp(order.PaidAmount) != 0 {
+		t.Errorf("refunded %s after the retry, want %s", order.RefundedAmount, order.PaidAmount)
+	}
+}
+
+func TestIssueRefundReleasesFailedRefund(t *testing.T) {
+	f := newRefundFixture(t)
+	f.provider.calls = 0
+
+	refund, err := f.refund(models.RefundRequest{Kind: models.RefundFull})
+	if !errors.Is(err, ErrCantRefund) {
+		t.Fatalf("refund err = %v, want %v", err, ErrCantRefund)
+	}
+	stored := f.storedRefund(t, refund)
+	if stored.Status != models.RefundFailed {
+		t.Errorf("refund status = %s, want %s", stored.Status, models.RefundFailed)
+	}
+	for _, payout := range stored.Payouts {
+		if payout.Status != models.RefundFailed {
+			t.Errorf("payout of tender %d is %s, want %s", payout.Tender, payout.Status, models.RefundFailed)
+		}
+	}
+	order := f.stored(t)
+	if order.RefundedAmount.IsPositive() {
+		t.Errorf("refunded %s, want nothing", order.RefundedAmount)
+	}
+	for i, tender := range order.Tenders {
+		if !tender.Refunded.IsZero() {
+			t.Errorf("tender %d still has %s reserved", i, tender.Refunded)
+		}
+	}
+	if got := f.count(t, f.ledger, bson.M{"order_id": order.ID, "type": models.LedgerRefund}); got != 0 {
+		t.Errorf("refund ledger entries = %d, want 0", got)
+	}
+}
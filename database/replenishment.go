@@ -0,0 +1,371 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/notify"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidReorderPolicy    = errors.New("reorder policy is not valid")
+	ErrCantUpdateReorderPolicy = errors.New("cannot update reorder policy")
+	ErrCantCheckStockLevels    = errors.New("cannot check stock levels")
+	ErrCantListSuggestions     = errors.New("cannot list reorder suggestions")
+)
+
+// VelocityWindow is how far back sales are counted to work out how fast a
+// product sells.
+var VelocityWindow = 28 * 24 * time.Hour
+
+// DefaultLeadTimeDays is how many days a product takes to restock when its
+// reorder policy does not say.
+var DefaultLeadTimeDays int64 = 7
+
+// ReorderCoverDays is how many days of sales a suggested order should
+// cover on top of the reorder point.
+var ReorderCoverDays int64 = 30
+
+// ReorderPolicy is what buyers set on a product's replenishment. Products
+// with variants are replenished per variant, which VariantID names.
+type ReorderPolicy struct {
+	VariantID        string `json:"variant_id"`
+	ReorderPoint     int64  `json:"reorder_point"`
+	LeadTimeDays     int64  `json:"lead_time_days"`
+	MinOrderQuantity int64  `json:"min_order_quantity"`
+}
+
+// SetReorderPolicy sets a product's reorder point, lead time and minimum
+// order quantity. The stock level job applies them on its next run.
+func SetReorderPolicy(ctx context.Context, prodCollection, replenishmentCollection *mongo.Collection, productID string, policy ReorderPolicy) (models.Replenishment, error) {
+	var replenishment models.Replenishment
+	id, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return replenishment, ErrCantFindProduct
+	}
+	variantID, err := parseVariantID(policy.VariantID)
+	if err != nil {
+		return replenishment, err
+	}
+	if policy.ReorderPoint < 0 || policy.LeadTimeDays < 0 || policy.MinOrderQuantity < 0 {
+		return replenishment, ErrInvalidReorderPolicy
+	}
+	var product models.Product
+	err = prodCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return replenishment, ErrCantFindProduct
+	}
+	if err != nil {
+		log.Println(err)
+		return replenishment, ErrCantFindProduct
+	}
+	variant, err := pickVariant(product, variantID)
+	if err != nil {
+		return replenishment, err
+	}
+
+	set := bson.M{
+		"product_name":       product.ProductName,
+		"reorder_point":      policy.ReorderPoint,
+		"lead_time_days":     policy.LeadTimeDays,
+		"min_order_quantity": policy.MinOrderQuantity,
+		"updated_at":         time.Now(),
+	}
+	if variant != nil {
+		set["sku"] = variant.SKU
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = replenishmentCollection.FindOneAndUpdate(ctx, itemOf(id, variantID).filter(), bson.M{"$set": set}, opts).Decode(&replenishment)
+	if err != nil {
+		log.Println(err)
+		return replenishment, ErrCantUpdateReorderPolicy
+	}
+	return replenishment, nil
+}
+
+// salesVelocity returns how many units of each product, or variant, sold
+// per day since since. Cancelled units are left out, and so are orders
+// still waiting for an online payment; cash on delivery orders count from
+// the start.
+func salesVelocity(ctx context.Context, orderCollection *mongo.Collection, since time.Time, days float64) (map[stockItem]float64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"ordered_at": bson.M{"$gte": since},
+			"$nor": bson.A{bson.M{
+				"status":                models.OrderPendingPayment,
+				"payment_method.method": bson.M{"$ne": models.PaymentCOD},
+			}},
+		}}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"product_id": "$items.product_id", "variant_id": "$items.variant_id"},
+			"units": bson.M{"$sum": bson.M{"$subtract": bson.A{
+				bson.M{"$ifNull": bson.A{"$items.quantity", 0}},
+				bson.M{"$ifNull": bson.A{"$items.cancelled_quantity", 0}},
+			}}},
+		}}},
+	}
+	cursor, err := orderCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var sales []struct {
+		Item  soldItem `bson:"_id"`
+		Units int64    `bson:"units"`
+	}
+	if err = cursor.All(ctx, &sales); err != nil {
+		return nil, err
+	}
+	velocity := make(map[stockItem]float64, len(sales))
+	for _, sale := range sales {
+		velocity[itemOf(sale.Item.ProductID, sale.Item.VariantID)] = float64(sale.Units) / days
+	}
+	return velocity, nil
+}
+
+// soldItem is a stock item as orders and purchase orders are grouped by.
+type soldItem struct {
+	ProductID primitive.ObjectID `bson:"product_id"`
+	VariantID primitive.ObjectID `bson:"variant_id"`
+}
+
+// replenish works out r's reorder point, whether it is low and what to
+// order from its policy, its velocity, the units available and those
+// already on order.
+func replenish(r *models.Replenishment, velocity float64, available, onOrder int64) {
+	r.Velocity = velocity
+	r.Available = available
+	r.OnOrder = onOrder
+	r.DaysOfStock = nil
+	if velocity > 0 {
+		days := math.Max(float64(available), 0) / velocity
+		r.DaysOfStock = &days
+	}
+
+	lead := r.LeadTimeDays
+	if lead == 0 {
+		lead = DefaultLeadTimeDays
+	}
+	r.EffectiveReorderPoint = r.ReorderPoint
+	if r.EffectiveReorderPoint == 0 {
+		r.EffectiveReorderPoint = int64(math.Ceil(velocity * float64(lead)))
+	}
+	r.Low = available <= r.EffectiveReorderPoint
+
+	r.SuggestedQuantity = 0
+	if r.Low {
+		// Units already on order count towards the target; once they
+		// cover it there is nothing more to order.
+		target := r.EffectiveReorderPoint + int64(math.Ceil(velocity*float64(ReorderCoverDays)))
+		if need := target - available - onOrder; need > 0 || onOrder == 0 {
+			r.SuggestedQuantity = max(need, r.MinOrderQuantity, 1)
+		}
+	}
+}
+
+// CheckStockLevels works out the sales velocity, reorder point and
+// suggested order of every tracked product, or variant of one, flags those
+// at or below their reorder point and sends one alert listing the products
+// newly flagged. It returns how many were newly flagged. A product leaves
+// the alert list once it is back above its reorder point.
+func CheckStockLevels(ctx context.Context, replenishmentCollection, orderCollection, inventoryCollection, prodCollection *mongo.Collection) (int, error) {
+	now := time.Now()
+	db := inventoryCollection.Database()
+	distinct, err := inventoryCollection.Distinct(ctx, "product_id", bson.M{})
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantCheckStockLevels
+	}
+	ids := make([]primitive.ObjectID, 0, len(distinct))
+	for _, value := range distinct {
+		if id, ok := value.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	available, err := availability(ctx, db, ids)
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantCheckStockLevels
+	}
+	items := make([]stockItem, 0, len(available))
+	for item := range available {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].ProductID != items[j].ProductID {
+			return items[i].ProductID.Hex() < items[j].ProductID.Hex()
+		}
+		return items[i].VariantID.Hex() < items[j].VariantID.Hex()
+	})
+	ordered, err := onOrder(ctx, db, ids)
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantCheckStockLevels
+	}
+	velocity, err := salesVelocity(ctx, orderCollection, now.Add(-VelocityWindow), VelocityWindow.Hours()/24)
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantCheckStockLevels
+	}
+
+	policies := make(map[stockItem]models.Replenishment)
+	cursor, err := replenishmentCollection.Find(ctx, bson.M{"product_id": bson.M{"$in": ids}})
+	if err == nil {
+		var list []models.Replenishment
+		err = cursor.All(ctx, &list)
+		for _, r := range list {
+			policies[itemOf(r.ProductID, r.VariantID)] = r
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantCheckStockLevels
+	}
+	names := make(map[primitive.ObjectID]string)
+	skus := make(map[stockItem]string)
+	cursor, err = prodCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"product_name": 1, "variants.variant_id": 1, "variants.sku": 1}))
+	if err == nil {
+		var products []models.Product
+		err = cursor.All(ctx, &products)
+		for _, product := range products {
+			names[product.ProductID] = product.ProductName
+			for _, variant := range product.Variants {
+				skus[itemOf(product.ProductID, variant.VariantID)] = variant.SKU
+			}
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantCheckStockLevels
+	}
+
+	var flagged []models.Replenishment
+	for _, item := range items {
+		r := policies[item]
+		r.ProductID, r.VariantID = item.ProductID, item.VariantID
+		if name, ok := names[item.ProductID]; ok {
+			r.ProductName = name
+		}
+		if sku, ok := skus[item]; ok {
+			r.SKU = sku
+		}
+		wasLow := r.Low
+		replenish(&r, velocity[item], available[item], ordered[item])
+		switch {
+		case r.Low && !wasLow:
+			r.LowSince = now
+			r.NotifiedAt = time.Time{}
+		case !r.Low:
+			r.LowSince = time.Time{}
+			r.NotifiedAt = time.Time{}
+		}
+		if r.Low && r.NotifiedAt.IsZero() {
+			flagged = append(flagged, r)
+		}
+
+		set := bson.M{
+			"product_name":            r.ProductName,
+			"sku":                     r.SKU,
+			"velocity":                r.Velocity,
+			"available":               r.Available,
+			"on_order":                r.OnOrder,
+			"effective_reorder_point": r.EffectiveReorderPoint,
+			"days_of_stock":           r.DaysOfStock,
+			"suggested_quantity":      r.SuggestedQuantity,
+			"low":                     r.Low,
+			"computed_at":             now,
+		}
+		update := bson.M{"$set": set}
+		if r.Low {
+			set["low_since"] = r.LowSince
+			if r.NotifiedAt.IsZero() {
+				update["$unset"] = bson.M{"notified_at": ""}
+			}
+		} else {
+			update["$unset"] = bson.M{"low_since": "", "notified_at": ""}
+		}
+		_, err := replenishmentCollection.UpdateOne(ctx, item.filter(), update, options.Update().SetUpsert(true))
+		if err != nil {
+			log.Println(err)
+			return 0, ErrCantCheckStockLevels
+		}
+	}
+	if len(flagged) == 0 {
+		return 0, nil
+	}
+
+	message := notify.Message{
+		Topic: "low_stock",
+		Title: fmt.Sprintf("%d products are at or below their reorder point", len(flagged)),
+	}
+	notified := make(bson.A, len(flagged))
+	for i, r := range flagged {
+		notified[i] = itemOf(r.ProductID, r.VariantID).filter()
+		name := r.ProductName
+		if r.SKU != "" {
+			name += " [" + r.SKU + "]"
+		}
+		message.Lines = append(message.Lines, fmt.Sprintf("%s: %d available, %d on order, reorder point %d, suggested order %d",
+			name, r.Available, r.OnOrder, r.EffectiveReorderPoint, r.SuggestedQuantity))
+	}
+	if err := notify.Notify(ctx, message); err != nil {
+		// Left unnotified, they are alerted again on the next run.
+		log.Printf("sending low stock alert: %v", err)
+		return len(flagged), nil
+	}
+	_, err = replenishmentCollection.UpdateMany(ctx, bson.M{"$or": notified}, bson.M{"$set": bson.M{"notified_at": now}})
+	if err != nil {
+		log.Println(err)
+	}
+	return len(flagged), nil
+}
+
+// ListReorderSuggestions returns one page (1-based) of products' reorder
+// suggestions, those running out soonest first, together with the total
+// number matching. With lowOnly it lists only products at or below their
+// reorder point.
+func ListReorderSuggestions(ctx context.Context, replenishmentCollection *mongo.Collection, lowOnly bool, page, limit int64) ([]models.Replenishment, int64, error) {
+	filter := bson.M{"computed_at": bson.M{"$exists": true}}
+	if lowOnly {
+		filter["low"] = true
+	}
+	total, err := replenishmentCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListSuggestions
+	}
+
+	// Products that do not sell never run out by themselves: list them
+	// after the rest, emptiest first.
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{"runs_out_in": bson.M{"$ifNull": bson.A{"$days_of_stock", math.MaxInt32}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "runs_out_in", Value: 1}, {Key: "available", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$skip", Value: (page - 1) * limit}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := replenishmentCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListSuggestions
+	}
+	defer cursor.Close(ctx)
+
+	suggestions := make([]models.Replenishment, 0)
+	if err = cursor.All(ctx, &suggestions); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListSuggestions
+	}
+	return suggestions, total, nil
+}
@@ -0,0 +1,524 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrReturnIdIsNotValid      = errors.New("return id is not valid")
+	ErrCantFindReturn          = errors.New("cannot find the requested return")
+	ErrCantListReturns         = errors.New("cannot list returns")
+	ErrCantUpdateReturn        = errors.New("cannot update return")
+	ErrOrderNotDelivered       = errors.New("only delivered orders can be returned")
+	ErrReturnWindowClosed      = errors.New("the return window for this item has closed")
+	ErrInvalidReturnLine       = errors.New("return lines do not match the order")
+	ErrInvalidReturnReason     = errors.New("unknown return reason code")
+	ErrIllegalReturnTransition = errors.New("return cannot move to the requested status")
+)
+
+// CreateReturn raises a return for lines of userID's delivered order. Every
+// line must be within its category's return window and cannot exceed the
+// units not already cancelled or returned. The returned units are held on
+// the order until the return is rejected or settled.
+func CreateReturn(ctx context.Context, orderCollection, returnCollection, windowCollection *mongo.Collection, userID string, orderID string, lines []models.ReturnLine) (models.Return, error) {
+	var rma models.Return
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return rma, ErrOrderIdIsNotValid
+	}
+	if len(lines) == 0 {
+		return rma, ErrInvalidReturnLine
+	}
+
+	err = runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		order, err := findOrder(sessCtx, orderCollection, bson.M{"_id": id, "user_id": userID})
+		if err != nil {
+			return err
+		}
+		if order.Status != models.OrderDelivered {
+			return ErrOrderNotDelivered
+		}
+
+		now := time.Now()
+		rma = models.Return{
+			ID:          primitive.NewObjectID(),
+			OrderID:     order.ID,
+			OrderNumber: order.OrderNumber,
+			UserID:      userID,
+			Status:      models.ReturnRequested,
+			StatusHistory: []models.ReturnStatusChange{{
+				Status: models.ReturnRequested,
+				At:     now,
+				Actor:  userID,
+			}},
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		for _, line := range lines {
+			if !models.ReturnReasons[line.ReasonCode] {
+				return ErrInvalidReturnReason
+			}
+			index := orderLineIndex(order, line.LineID)
+			if index < 0 || line.Quantity == 0 || line.Quantity > order.Items[index].ReturnableQuantity() {
+				return ErrInvalidReturnLine
+			}
+			item := &order.Items[index]
+
+			days, err := returnWindowDays(sessCtx, windowCollection, item.Category)
+			if err != nil {
+				return err
+			}
+			if now.After(order.DeliveredAt().AddDate(0, 0, int(days))) {
+				return ErrReturnWindowClosed
+			}
+
+			item.Returned += line.Quantity
+			rma.Lines = append(rma.Lines, models.ReturnLine{
+				LineID:      item.LineID,
+				ProductID:   item.ProductID,
+				ProductName: item.Name(),
+				Price:       item.NetAmount(1),
+				Quantity:    line.Quantity,
+				ReasonCode:  line.ReasonCode,
+				Comment:     line.Comment,
+				Photos:      line.Photos,
+			})
+		}
+
+		seq, err := nextSequence(sessCtx, returnCollection.Database(), "rma_number")
+		if err != nil {
+			return err
+		}
+		rma.RMANumber = fmt.Sprintf("RMA-%08d", seq)
+
+		update := bson.M{"$set": bson.M{"items": order.Items, "updated_at": now}}
+		if _, err = orderCollection.UpdateOne(sessCtx, bson.M{"_id": order.ID}, update); err != nil {
+			return err
+		}
+		_, err = returnCollection.InsertOne(sessCtx, rma)
+		return err
+	})
+	return rma, returnError(err)
+}
+
+func ListUserReturns(ctx context.Context, returnCollection *mongo.Collection, userID string, page, limit int64) ([]models.Return, int64, error) {
+	return findReturns(ctx, returnCollection, bson.M{"user_id": userID}, page, limit)
+}
+
+func SearchReturns(ctx context.Context, returnCollection *mongo.Collection, status models.ReturnStatus, page, limit int64) ([]models.Return, int64, error) {
+	query := bson.M{}
+	if status != "" {
+		query["status"] = status
+	}
+	return findReturns(ctx, returnCollection, query, page, limit)
+}
+
+func GetUserReturn(ctx context.Context, returnCollection *mongo.Collection, userID string, returnID string) (models.Return, error) {
+	id, err := primitive.ObjectIDFromHex(returnID)
+	if err != nil {
+		return models.Return{}, ErrReturnIdIsNotValid
+	}
+	return findReturn(ctx, returnCollection, bson.M{"_id": id, "user_id": userID})
+}
+
+func GetReturn(ctx context.Context, returnCollection *mongo.Collection, returnID string) (models.Return, error) {
+	id, err := primitive.ObjectIDFromHex(returnID)
+	if err != nil {
+		return models.Return{}, ErrReturnIdIsNotValid
+	}
+	return findReturn(ctx, returnCollection, bson.M{"_id": id})
+}
+
+// AdvanceReturn approves, rejects or marks a return as received. Rejecting
+// gives the held units back to the order so they can be returned again.
+func AdvanceReturn(ctx context.Context, orderCollection, returnCollection *mongo.Collection, returnID string, next models.ReturnStatus, actor string, note string) (models.Return, error) {
+	if next != models.ReturnApproved && next != models.ReturnRejected && next != models.ReturnReceived {
+		return models.Return{}, ErrIllegalReturnTransition
+	}
+	var rma models.Return
+	err := runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var err error
+		rma, err = GetReturn(sessCtx, returnCollection, returnID)
+		if err != nil {
+			return err
+		}
+		if !rma.Status.CanTransitionTo(next) {
+			return ErrIllegalReturnTransition
+		}
+		if next == models.ReturnRejected {
+			if err = releaseReturnedUnits(sessCtx, orderCollection, rma, false); err != nil {
+				return err
+			}
+		}
+		return setReturnStatus(sessCtx, returnCollection, &rma, next, actor, note, bson.M{})
+	})
+	return rma, returnError(err)
+}
+
+// InspectReturn records how many units of each line passed inspection and
+// whether they can go back into stock. Lines left out accept nothing.
+func InspectReturn(ctx context.Context, returnCollection *mongo.Collection, returnID string, inspected []models.ReturnLine, actor string, note string) (models.Return, error) {
+	rma, err := GetReturn(ctx, returnCollection, returnID)
+	if err != nil {
+		return rma, err
+	}
+	if !rma.Status.CanTransitionTo(models.ReturnInspected) {
+		return rma, ErrIllegalReturnTransition
+	}
+
+	for i := range rma.Lines {
+		rma.Lines[i].Accepted = 0
+		rma.Lines[i].Restock = false
+	}
+	for _, result := range inspected {
+		index := -1
+		for i, line := range rma.Lines {
+			if line.LineID == result.LineID {
+				index = i
+				break
+			}
+		}
+		if index < 0 || result.Accepted > rma.Lines[index].Quantity {
+			return rma, ErrInvalidReturnLine
+		}
+		rma.Lines[index].Accepted = result.Accepted
+		rma.Lines[index].Restock = result.Restock && result.Accepted > 0
+	}
+
+	err = setReturnStatus(ctx, returnCollection, &rma, models.ReturnInspected, actor, note, bson.M{"lines": rma.Lines})
+	return rma, returnError(err)
+}
+
+// ResolveReturn settles an inspected return. A refund flags the accepted
+// units' value as a pending refund; a replacement places a free order for
+// the accepted units; a rejection accepts nothing. Accepted units marked
+// for restock go back into stock. Units not accepted are
+// given back to the order. Once nothing on the order is left to return and
+// no other return is open, the order itself moves to returned.
+func ResolveReturn(ctx context.Context, orderCollection, returnCollection *mongo.Collection, returnID string, resolution models.ReturnStatus, actor string, note string) (models.Return, error) {
+	if resolution != models.ReturnRefunded && resolution != models.ReturnReplaced && resolution != models.ReturnRejected {
+		return models.Return{}, ErrIllegalReturnTransition
+	}
+	var rma models.Return
+	err := runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var err error
+		rma, err = GetReturn(sessCtx, returnCollection, returnID)
+		if err != nil {
+			return err
+		}
+		if !rma.Status.CanTransitionTo(resolution) {
+			return ErrIllegalReturnTransition
+		}
+
+		accepted := resolution != models.ReturnRejected
+		if err = releaseReturnedUnits(sessCtx, orderCollection, rma, accepted); err != nil {
+			return err
+		}
+		if accepted {
+			if err = restockReturn(sessCtx, orderCollection, rma, actor); err != nil {
+				return err
+			}
+		}
+
+		set := bson.M{}
+		switch resolution {
+		case models.ReturnRefunded:
+			for _, line := range rma.Lines {
+				rma.RefundAmount = rma.RefundAmount.Add(line.Price.Mul(int64(line.Accepted)))
+			}
+			rma.RefundStatus = models.RefundPending
+			set["refund_amount"] = rma.RefundAmount
+			set["refund_status"] = rma.RefundStatus
+		case models.ReturnReplaced:
+			replacement, err := placeReplacementOrder(sessCtx, orderCollection, rma, actor)
+			if err != nil {
+				return err
+			}
+			rma.ReplacementOrderID = replacement.ID
+			set["replacement_order_id"] = rma.ReplacementOrderID
+		}
+		if err = setReturnStatus(sessCtx, returnCollection, &rma, resolution, actor, note, set); err != nil {
+			return err
+		}
+
+		if resolution == models.ReturnRefunded {
+			return markOrderReturnedIfComplete(sessCtx, orderCollection, returnCollection, rma.OrderID, actor)
+		}
+		return nil
+	})
+	return rma, returnError(err)
+}
+
+// releaseReturnedUnits gives the units of rma that will not come off the
+// order back to it: everything when accepted is false, otherwise only the
+// units that failed inspection.
+func releaseReturnedUnits(ctx context.Context, orderCollection *mongo.Collection, rma models.Return, accepted bool) error {
+	order, err := findOrder(ctx, orderCollection, bson.M{"_id": rma.OrderID})
+	if err != nil {
+		return err
+	}
+	for _, line := range rma.Lines {
+		index := orderLineIndex(order, line.LineID)
+		if index < 0 {
+			continue
+		}
+		release := line.Quantity
+		if accepted {
+			release -= line.Accepted
+		}
+		order.Items[index].Returned -= release
+	}
+	update := bson.M{"$set": bson.M{"items": order.Items, "updated_at": time.Now()}}
+	_, err = orderCollection.UpdateOne(ctx, bson.M{"_id": order.ID}, update)
+	return err
+}
+
+// placeReplacementOrder creates an already-paid, zero-total order shipping
+// the accepted units of rma to the original order's address.
+func placeReplacementOrder(sessCtx mongo.SessionContext, orderCollection *mongo.Collection, rma models.Return, actor string) (models.Order, error) {
+	original, err := findOrder(sessCtx, orderCollection, bson.M{"_id": rma.OrderID})
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	cart := make([]models.ProductUser, 0)
+	for _, line := range rma.Lines {
+		index := orderLineIndex(original, line.LineID)
+		for i := uint(0); i < line.Accepted; i++ {
+			item := original.Items[index]
+			cart = append(cart, models.ProductUser{
+				ProductID:   line.ProductID,
+				VariantID:   item.VariantID,
+				SKU:         item.SKU,
+				ProductName: item.ProductName,
+				VariantName: item.VariantName,
+				Price:       line.Price,
+				Image:       item.Image,
+				Category:    item.Category,
+				Weight:      item.Weight,
+			})
+		}
+	}
+
+	// The lines carry the original order's prices, so nothing is
+	// converted; the original's rates are kept for reference.
+	order, err := newOrder(rma.UserID, cart, &pricer{currency: original.Currency, applied: original.ExchangeRates})
+	if err != nil {
+		return models.Order{}, err
+	}
+	order.ShippingAddress = original.ShippingAddress
+	order.ShippingMethod = original.ShippingMethod
+	order.ShippingZone = original.ShippingZone
+	order.Discount = order.Subtotal
+	order.Total = money.Zero(order.Currency)
+	order.Status = models.OrderPaid
+	order.StatusHistory = append(order.StatusHistory, models.StatusChange{
+		Status: models.OrderPaid,
+		At:     order.OrderedAt,
+		Actor:  actor,
+		Note:   "replacement for " + rma.RMANumber,
+	})
+	return order, insertOrder(sessCtx, orderCollection, &order)
+}
+
+// markOrderReturnedIfComplete moves a delivered order to returned once all
+// its units have come back and no other return is still open.
+func markOrderReturnedIfComplete(sessCtx mongo.SessionContext, orderCollection, returnCollection *mongo.Collection, orderID primitive.ObjectID, actor string) error {
+	order, err := findOrder(sessCtx, orderCollection, bson.M{"_id": orderID})
+	if err != nil {
+		return err
+	}
+	if order.Status != models.OrderDelivered {
+		return nil
+	}
+	for _, item := range order.Items {
+		if item.ReturnableQuantity() > 0 {
+			return nil
+		}
+	}
+	open, err := returnCollection.CountDocuments(sessCtx, bson.M{
+		"order_id": orderID,
+		"status":   bson.M{"$in": []models.ReturnStatus{models.ReturnRequested, models.ReturnApproved, models.ReturnReceived, models.ReturnInspected}},
+	})
+	if err != nil || open > 0 {
+		return err
+	}
+	return setOrderStatus(sessCtx, orderCollection, &order, models.OrderReturned, actor, "all items returned")
+}
+
+// setReturnStatus moves rma to next, guarded on it still being in its
+// current status, and applies set in the same update.
+func setReturnStatus(ctx context.Context, returnCollection *mongo.Collection, rma *models.Return, next models.ReturnStatus, actor string, note string, set bson.M) error {
+	change := models.ReturnStatusChange{
+		Status: next,
+		At:     time.Now(),
+		Actor:  actor,
+		Note:   note,
+	}
+	set["status"] = next
+	set["updated_at"] = change.At
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"status_history": change},
+	}
+	result, err := returnCollection.UpdateOne(ctx, bson.M{"_id": rma.ID, "status": rma.Status}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateReturn
+	}
+	if result.MatchedCount == 0 {
+		return ErrOrderChanged
+	}
+	rma.Status = next
+	rma.UpdatedAt = change.At
+	rma.StatusHistory = append(rma.StatusHistory, change)
+	return nil
+}
+
+// SetReturnWindow sets how many days after delivery products in category,
+// and in the categories below it without a window of their own, can be
+// returned. Categories are named by slug.
+func SetReturnWindow(ctx context.Context, windowCollection *mongo.Collection, window *models.ReturnWindow) error {
+	window.Category = Slugify(window.Category)
+	if window.Category == "" {
+		return ErrInvalidCategory
+	}
+	_, err := windowCollection.ReplaceOne(ctx, bson.M{"_id": window.Category}, window, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateReturn
+	}
+	return nil
+}
+
+func ListReturnWindows(ctx context.Context, windowCollection *mongo.Collection) ([]models.ReturnWindow, error) {
+	windows := make([]models.ReturnWindow, 0)
+	cursor, err := windowCollection.Find(ctx, bson.M{})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListReturns
+	}
+	if err = cursor.All(ctx, &windows); err != nil {
+		log.Println(err)
+		return nil, ErrCantListReturns
+	}
+	return windows, nil
+}
+
+// returnWindowDays looks up the return window of category, else of the
+// nearest category above it that has one, falling back to
+// models.DefaultReturnWindowDays. Order lines placed before categories
+// were a tree name theirs as it was typed, so category is looked up by
+// its slug too.
+func returnWindowDays(ctx context.Context, windowCollection *mongo.Collection, category string) (uint, error) {
+	names := []string{category, Slugify(category)}
+	var node models.Category
+	err := categories(windowCollection.Database()).FindOne(ctx, bson.M{"slug": Slugify(category)}).Decode(&node)
+	if err == nil {
+		names = append(node.Lineage(), category)
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, err
+	}
+
+	cursor, err := windowCollection.Find(ctx, bson.M{"_id": bson.M{"$in": names}})
+	if err != nil {
+		return 0, err
+	}
+	var windows []models.ReturnWindow
+	if err = cursor.All(ctx, &windows); err != nil {
+		return 0, err
+	}
+	days := make(map[string]uint, len(windows))
+	for _, window := range windows {
+		days[window.Category] = window.Days
+	}
+	// The deepest category with a window wins.
+	for i := len(names) - 1; i >= 0; i-- {
+		if d, ok := days[names[i]]; ok {
+			return d, nil
+		}
+	}
+	return models.DefaultReturnWindowDays, nil
+}
+
+func orderLineIndex(order models.Order, lineID primitive.ObjectID) int {
+	for i, item := range order.Items {
+		if item.LineID == lineID {
+			return i
+		}
+	}
+	return -1
+}
+
+func findReturn(ctx context.Context, returnCollection *mongo.Collection, query bson.M) (models.Return, error) {
+	var rma models.Return
+	err := returnCollection.FindOne(ctx, query).Decode(&rma)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return rma, ErrCantFindReturn
+	}
+	if err != nil {
+		log.Println(err)
+		return rma, ErrCantFindReturn
+	}
+	return rma, nil
+}
+
+func findReturns(ctx context.Context, returnCollection *mongo.Collection, query bson.M, page, limit int64) ([]models.Return, int64, error) {
+	total, err := returnCollection.CountDocuments(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListReturns
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := returnCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListReturns
+	}
+	defer cursor.Close(ctx)
+
+	returns := make([]models.Return, 0)
+	if err = cursor.All(ctx, &returns); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListReturns
+	}
+	return returns, total, nil
+}
+
+// returnError passes the package's own errors through and logs and hides
+// anything else.
+func returnError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrOutOfStock) {
+		return err
+	}
+	for _, known := range []error{
+		ErrCantFindOrder, ErrOrderNotDelivered, ErrReturnWindowClosed, ErrInvalidReturnLine,
+		ErrInvalidReturnReason, ErrCantFindReturn, ErrReturnIdIsNotValid, ErrIllegalReturnTransition,
+		ErrOrderChanged, ErrOrderIdIsNotValid,
+	} {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+	log.Println(err)
+	return ErrCantUpdateReturn
+}
@@ -0,0 +1,521 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/carriers"
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindShipment    = errors.New("cannot find the requested shipment")
+	ErrInvalidShipment     = errors.New("shipment needs a carrier")
+	ErrInvalidShipmentLine = errors.New("shipment lines do not match the order")
+	ErrOrderNotPacked      = errors.New("order is not packed for shipping")
+	ErrNothingToShip       = errors.New("nothing left to ship on this order")
+	ErrCantBackorder       = errors.New("order lines can no longer be backordered")
+	ErrDuplicateAWB        = errors.New("this carrier already has a shipment with that tracking number")
+	ErrCantCreateShipment  = errors.New("cannot create shipment")
+	ErrCantUpdateShipment  = errors.New("cannot update shipment")
+	ErrCantListShipments   = errors.New("cannot list shipments")
+)
+
+// pollBatch is how many shipments one run of PollShipments asks carriers
+// about.
+const pollBatch = 100
+
+// ShipmentRequest is what an admin sends to ship a packed order. Without
+// AWB the shipment is booked with the carrier, which must be registered;
+// with it, a parcel already booked elsewhere is recorded as is. Without
+// Lines the shipment takes every unit not yet shipped or backordered.
+type ShipmentRequest struct {
+	Carrier     string                `json:"carrier"`
+	AWB         string                `json:"awb"`
+	TrackingURL string                `json:"tracking_url"`
+	Lines       []models.ShipmentLine `json:"lines"`
+}
+
+// shippable reports whether shipments can still be created for an order in
+// status s. Shipped orders qualify for re-shipping parcels that came back.
+func shippable(s models.OrderStatus) bool {
+	return s == models.OrderPacked || s == models.OrderPartiallyShipped || s == models.OrderShipped
+}
+
+// CreateShipment puts lines of a packed order in a parcel and hands it to a
+// carrier. The order moves to partially shipped or shipped once the carrier
+// reports the parcel picked up. A booking made for a shipment that then
+// cannot be stored is cancelled with the carrier.
+func CreateShipment(ctx context.Context, shipmentCollection, orderCollection *mongo.Collection, orderID string, req ShipmentRequest, actor string) (models.Shipment, error) {
+	req.Carrier = strings.TrimSpace(req.Carrier)
+	req.AWB = strings.TrimSpace(req.AWB)
+	if req.Carrier == "" {
+		return models.Shipment{}, ErrInvalidShipment
+	}
+	order, err := GetOrder(ctx, orderCollection, orderID)
+	if err != nil {
+		return models.Shipment{}, err
+	}
+	if !shippable(order.Status) {
+		return models.Shipment{}, ErrOrderNotPacked
+	}
+	// Resolve the lines before booking, so the carrier is only called for
+	// a shipment that can be stored.
+	lines, err := allocateShipment(&order, req.Lines)
+	if err != nil {
+		return models.Shipment{}, err
+	}
+
+	booking := carriers.Booking{AWB: req.AWB, TrackingURL: req.TrackingURL}
+	var booked carriers.Carrier
+	if booking.AWB == "" {
+		carrier, err := carriers.Lookup(req.Carrier)
+		if err != nil {
+			return models.Shipment{}, err
+		}
+		booking, err = carrier.Book(ctx, carriers.BookingRequest{
+			OrderNumber: order.OrderNumber,
+			Method:      order.ShippingMethod,
+			Address:     order.ShippingAddress,
+			Weight:      shipmentWeight(order, lines),
+			Value:       shipmentValue(order, lines),
+		})
+		if err != nil {
+			log.Println(err)
+			return models.Shipment{}, fmt.Errorf("%w: %v", ErrCantCreateShipment, err)
+		}
+		booked = carrier
+	}
+
+	now := time.Now()
+	shipment := models.Shipment{
+		ID:          primitive.NewObjectID(),
+		OrderID:     order.ID,
+		OrderNumber: order.OrderNumber,
+		UserID:      order.UserID,
+		Carrier:     req.Carrier,
+		AWB:         booking.AWB,
+		TrackingURL: booking.TrackingURL,
+		Status:      models.ShipmentLabelCreated,
+		Lines:       lines,
+		Events: []models.TrackingEvent{{
+			EventID:     "created",
+			Status:      models.ShipmentLabelCreated,
+			Description: "Shipment created",
+			At:          now,
+		}},
+		Actor:     actor,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		order, err := findOrder(sessCtx, orderCollection, bson.M{"_id": order.ID})
+		if err != nil {
+			return err
+		}
+		if !shippable(order.Status) {
+			return ErrOrderNotPacked
+		}
+		if _, err := allocateShipment(&order, lines); err != nil {
+			return err
+		}
+		update := bson.M{"$set": bson.M{"items": order.Items, "updated_at": now}}
+		if _, err := orderCollection.UpdateOne(sessCtx, bson.M{"_id": order.ID}, update); err != nil {
+			return err
+		}
+		_, err = shipmentCollection.InsertOne(sessCtx, shipment)
+		return err
+	})
+	if err == nil {
+		return shipment, nil
+	}
+	if booked != nil {
+		if cancelErr := booked.Cancel(ctx, booking.AWB); cancelErr != nil {
+			log.Printf("cancelling booking %s with %s: %v", booking.AWB, booked.Name(), cancelErr)
+		}
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return shipment, ErrDuplicateAWB
+	}
+	for _, known := range []error{ErrCantFindOrder, ErrOrderNotPacked, ErrInvalidShipmentLine, ErrNothingToShip} {
+		if errors.Is(err, known) {
+			return shipment, known
+		}
+	}
+	log.Println(err)
+	return shipment, ErrCantCreateShipment
+}
+
+// allocateShipment counts lines as allocated on order's items and returns
+// them with their product names. Empty lines means every shippable unit.
+// Explicitly shipping backordered units takes them off backorder.
+func allocateShipment(order *models.Order, lines []models.ShipmentLine) ([]models.ShipmentLine, error) {
+	if len(lines) == 0 {
+		for _, item := range order.Items {
+			if item.ShippableQuantity() > 0 {
+				lines = append(lines, models.ShipmentLine{LineID: item.LineID, Quantity: item.ShippableQuantity()})
+			}
+		}
+		if len(lines) == 0 {
+			return nil, ErrNothingToShip
+		}
+	}
+
+	allocated := make([]models.ShipmentLine, 0, len(lines))
+	for _, line := range lines {
+		index := orderLine(*order, line.LineID)
+		if index < 0 || line.Quantity == 0 || line.Quantity > order.Items[index].UnallocatedQuantity() {
+			return nil, ErrInvalidShipmentLine
+		}
+		item := &order.Items[index]
+		item.Allocated += line.Quantity
+		item.Backordered = min(item.Backordered, item.UnallocatedQuantity())
+		allocated = append(allocated, models.ShipmentLine{LineID: item.LineID, ProductName: item.Name(), Quantity: line.Quantity})
+	}
+	return allocated, nil
+}
+
+// orderLine returns the index of the item with lineID in order, or -1.
+func orderLine(order models.Order, lineID primitive.ObjectID) int {
+	for i, item := range order.Items {
+		if item.LineID == lineID {
+			return i
+		}
+	}
+	return -1
+}
+
+// shipmentWeight is what lines weigh, in grams.
+func shipmentWeight(order models.Order, lines []models.ShipmentLine) uint {
+	var weight uint
+	for _, line := range lines {
+		if index := orderLine(order, line.LineID); index >= 0 {
+			weight += order.Items[index].Weight * line.Quantity
+		}
+	}
+	return weight
+}
+
+// shipmentValue is what the customer paid for lines, for the carrier's
+// declared value.
+func shipmentValue(order models.Order, lines []models.ShipmentLine) money.Money {
+	value := money.Zero(order.Currency)
+	for _, line := range lines {
+		if index := orderLine(order, line.LineID); index >= 0 {
+			value = value.Add(order.Items[index].NetAmount(line.Quantity))
+		}
+	}
+	return value
+}
+
+// SetBackorders marks quantities of an order's lines as waiting for stock,
+// replacing what was backordered on those lines before. A quantity of zero
+// takes the line off backorder. Backordered units are left out of
+// shipments created without explicit lines.
+func SetBackorders(ctx context.Context, orderCollection *mongo.Collection, orderID string, lines []models.ShipmentLine) (models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return models.Order{}, ErrOrderIdIsNotValid
+	}
+	if len(lines) == 0 {
+		return models.Order{}, ErrInvalidShipmentLine
+	}
+	var order models.Order
+	err = runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var err error
+		order, err = findOrder(sessCtx, orderCollection, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if order.Status != models.OrderPaid && !shippable(order.Status) {
+			return ErrCantBackorder
+		}
+		for _, line := range lines {
+			index := orderLine(order, line.LineID)
+			if index < 0 || line.Quantity > order.Items[index].UnallocatedQuantity() {
+				return ErrInvalidShipmentLine
+			}
+			order.Items[index].Backordered = line.Quantity
+		}
+		order.UpdatedAt = time.Now()
+		update := bson.M{"$set": bson.M{"items": order.Items, "updated_at": order.UpdatedAt}}
+		_, err = orderCollection.UpdateOne(sessCtx, bson.M{"_id": order.ID}, update)
+		return err
+	})
+	if err == nil {
+		return order, nil
+	}
+	for _, known := range []error{ErrCantFindOrder, ErrCantBackorder, ErrInvalidShipmentLine} {
+		if errors.Is(err, known) {
+			return order, known
+		}
+	}
+	log.Println(err)
+	return order, ErrCantUpdateOrder
+}
+
+// ListOrderShipments returns an order's shipments, oldest first. With a
+// userID only that customer's shipments are returned.
+func ListOrderShipments(ctx context.Context, shipmentCollection *mongo.Collection, orderID primitive.ObjectID, userID string) ([]models.Shipment, error) {
+	query := bson.M{"order_id": orderID}
+	if userID != "" {
+		query["user_id"] = userID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := shipmentCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListShipments
+	}
+	defer cursor.Close(ctx)
+
+	shipments := make([]models.Shipment, 0)
+	if err = cursor.All(ctx, &shipments); err != nil {
+		log.Println(err)
+		return nil, ErrCantListShipments
+	}
+	return shipments, nil
+}
+
+// ReceiveCarrierWebhook verifies payload against carrierName's signature
+// scheme and records the tracking events it carries. It returns how many
+// events were new; redelivered events are ignored, as are updates for
+// AWBs with no shipment here, which are logged and do not hold up the
+// rest of the batch.
+func ReceiveCarrierWebhook(ctx context.Context, shipmentCollection, orderCollection *mongo.Collection, carrierName string, payload []byte, signature string) (int, error) {
+	carrier, err := carriers.Lookup(carrierName)
+	if err != nil {
+		return 0, err
+	}
+	updates, err := carrier.VerifyWebhook(payload, signature)
+	if err != nil {
+		return 0, err
+	}
+
+	recorded := 0
+	for _, update := range updates {
+		var shipment models.Shipment
+		err := shipmentCollection.FindOne(ctx, bson.M{"carrier": carrier.Name(), "awb": update.AWB}).Decode(&shipment)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("%s webhook: no shipment with AWB %s", carrier.Name(), update.AWB)
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			return recorded, ErrCantFindShipment
+		}
+		n, err := recordTracking(ctx, shipmentCollection, orderCollection, &shipment, update.Events)
+		recorded += n
+		if err != nil {
+			return recorded, err
+		}
+	}
+	return recorded, nil
+}
+
+// PollShipments asks carriers for the tracking events of shipments that
+// are not yet delivered or returned, least recently polled first, and
+// returns how many new events were recorded. Shipments with carriers that
+// are not registered are skipped.
+func PollShipments(ctx context.Context, shipmentCollection, orderCollection *mongo.Collection) (int, error) {
+	query := bson.M{"status": bson.M{"$nin": bson.A{models.ShipmentDelivered, models.ShipmentReturned}}}
+	opts := options.Find().SetSort(bson.D{{Key: "polled_at", Value: 1}}).SetLimit(pollBatch)
+	cursor, err := shipmentCollection.Find(ctx, query, opts)
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantListShipments
+	}
+	var shipments []models.Shipment
+	if err = cursor.All(ctx, &shipments); err != nil {
+		log.Println(err)
+		return 0, ErrCantListShipments
+	}
+
+	recorded := 0
+	for i := range shipments {
+		shipment := &shipments[i]
+		if _, err := shipmentCollection.UpdateOne(ctx, bson.M{"_id": shipment.ID}, bson.M{"$set": bson.M{"polled_at": time.Now()}}); err != nil {
+			log.Println(err)
+		}
+		carrier, err := carriers.Lookup(shipment.Carrier)
+		if err != nil {
+			continue
+		}
+		events, err := carrier.Track(ctx, shipment.AWB)
+		if err != nil {
+			log.Printf("tracking %s %s: %v", shipment.Carrier, shipment.AWB, err)
+			continue
+		}
+		n, err := recordTracking(ctx, shipmentCollection, orderCollection, shipment, events)
+		recorded += n
+		if err != nil {
+			log.Printf("tracking %s %s: %v", shipment.Carrier, shipment.AWB, err)
+		}
+	}
+	return recorded, nil
+}
+
+// recordTracking merges events into shipment's timeline, skipping those
+// already recorded, and moves the order along with it. The write is
+// guarded on the shipment not having changed since it was read, and
+// retried on a fresh copy if it has.
+func recordTracking(ctx context.Context, shipmentCollection, orderCollection *mongo.Collection, shipment *models.Shipment, events []models.TrackingEvent) (int, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		seen := make(map[string]bool, len(shipment.Events))
+		for _, event := range shipment.Events {
+			seen[event.EventID] = true
+		}
+		timeline := append([]models.TrackingEvent(nil), shipment.Events...)
+		for _, event := range events {
+			if event.EventID == "" || seen[event.EventID] || !event.Status.Valid() {
+				continue
+			}
+			seen[event.EventID] = true
+			timeline = append(timeline, event)
+		}
+		added := len(timeline) - len(shipment.Events)
+		if added == 0 {
+			return 0, applyFulfilment(ctx, shipmentCollection, orderCollection, shipment.ID)
+		}
+		sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].At.Before(timeline[j].At) })
+
+		status := timeline[len(timeline)-1].Status
+		now := time.Now()
+		filter := bson.M{"_id": shipment.ID, "updated_at": shipment.UpdatedAt}
+		update := bson.M{"$set": bson.M{"events": timeline, "status": status, "updated_at": now}}
+		result, err := shipmentCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Println(err)
+			return 0, ErrCantUpdateShipment
+		}
+		if result.MatchedCount == 0 {
+			if err := shipmentCollection.FindOne(ctx, bson.M{"_id": shipment.ID}).Decode(shipment); err != nil {
+				log.Println(err)
+				return 0, ErrCantFindShipment
+			}
+			continue
+		}
+		shipment.Events = timeline
+		shipment.Status = status
+		shipment.UpdatedAt = now
+		return added, applyFulfilment(ctx, shipmentCollection, orderCollection, shipment.ID)
+	}
+	return 0, ErrCantUpdateShipment
+}
+
+// applyFulfilment counts the lines of the shipment with shipmentID as
+// shipped, delivered or back in stock on its order as the shipment reaches
+// each stage, then moves the order along. Each stage is counted once,
+// whatever order events arrive in; a parcel that comes back to origin
+// frees its lines to be shipped again.
+func applyFulfilment(ctx context.Context, shipmentCollection, orderCollection *mongo.Collection, shipmentID primitive.ObjectID) error {
+	err := runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var shipment models.Shipment
+		if err := shipmentCollection.FindOne(sessCtx, bson.M{"_id": shipmentID}).Decode(&shipment); err != nil {
+			return err
+		}
+		order, err := findOrder(sessCtx, orderCollection, bson.M{"_id": shipment.OrderID})
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		set := bson.M{}
+		count := func(apply func(item *models.OrderItem, quantity uint)) {
+			for _, line := range shipment.Lines {
+				if index := orderLine(order, line.LineID); index >= 0 {
+					apply(&order.Items[index], line.Quantity)
+				}
+			}
+		}
+		if shipment.ReturnedAt.IsZero() {
+			if shipment.Status.InTransit() && shipment.ShippedAt.IsZero() {
+				count(func(item *models.OrderItem, quantity uint) { item.Shipped += quantity })
+				shipment.ShippedAt = now
+				set["shipped_at"] = now
+			}
+			if shipment.Status == models.ShipmentDelivered && shipment.DeliveredAt.IsZero() {
+				count(func(item *models.OrderItem, quantity uint) { item.Delivered += quantity })
+				set["delivered_at"] = now
+			}
+			if shipment.Status == models.ShipmentReturned {
+				shipped := !shipment.ShippedAt.IsZero()
+				count(func(item *models.OrderItem, quantity uint) {
+					item.Allocated -= min(item.Allocated, quantity)
+					if shipped {
+						item.Shipped -= min(item.Shipped, quantity)
+					}
+				})
+				set["returned_at"] = now
+			}
+		}
+
+		if len(set) > 0 {
+			if _, err := shipmentCollection.UpdateOne(sessCtx, bson.M{"_id": shipment.ID}, bson.M{"$set": set}); err != nil {
+				return err
+			}
+			order.UpdatedAt = now
+			update := bson.M{"$set": bson.M{"items": order.Items, "updated_at": now}}
+			if _, err := orderCollection.UpdateOne(sessCtx, bson.M{"_id": order.ID}, update); err != nil {
+				return err
+			}
+		}
+		note := fmt.Sprintf("%s %s: %s", shipment.Carrier, shipment.AWB, shipment.Status)
+		return advanceFulfilment(sessCtx, orderCollection, &order, SystemActor, note)
+	})
+	if err != nil && !errors.Is(err, ErrCantFindOrder) {
+		log.Println(err)
+		return ErrCantUpdateOrder
+	}
+	return err
+}
+
+// fulfilmentSteps is the part of the lifecycle shipments drive, in order.
+var fulfilmentSteps = []models.OrderStatus{
+	models.OrderPacked,
+	models.OrderPartiallyShipped,
+	models.OrderShipped,
+	models.OrderDelivered,
+}
+
+// advanceFulfilment moves order forward to the status its shipped and
+// delivered lines put it in, one allowed step at a time. Orders anywhere
+// else in their lifecycle, cancelled ones for example, are left alone.
+func advanceFulfilment(ctx context.Context, orderCollection *mongo.Collection, order *models.Order, actor string, note string) error {
+	target := order.FulfilledStatus()
+	if target == "" {
+		return nil
+	}
+	for order.Status != target {
+		var next models.OrderStatus
+		reached := false
+		for _, step := range fulfilmentSteps {
+			if reached && order.Status.CanTransitionTo(step) {
+				next = step
+			}
+			if step == target {
+				break
+			}
+			if step == order.Status {
+				reached = true
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		if err := setOrderStatus(ctx, orderCollection, order, next, actor, note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/carriers"
+	"github.com/kshzz24/ecomm-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fixedCarrier books every parcel under the same AWB, remembers the
+// bookings it was asked to cancel, and delivers webhooks as updates for an
+// unknown AWB followed by the fixed one.
+type fixedCarrier struct {
+	*carriers.SimulatedCarrier
+	awb       string
+	cancelled []string
+}
+
+func (c *fixedCarrier) Name() string {
+	return "fixed"
+}
+
+func (c *fixedCarrier) Book(ctx context.Context, req carriers.BookingRequest) (carriers.Booking, error) {
+	return carriers.Booking{AWB: c.awb}, nil
+}
+
+func (c *fixedCarrier) Cancel(ctx context.Context, awb string) error {
+	c.cancelled = append(c.cancelled, awb)
+	return nil
+}
+
+func (c *fixedCarrier) VerifyWebhook(payload []byte, signature string) ([]carriers.Update, error) {
+	events := []models.TrackingEvent{{EventID: "pickup", Status: models.ShipmentPickedUp, At: time.Now()}}
+	return []carriers.Update{{AWB: "SIM0-0000", Events: events}, {AWB: c.awb, Events: events}}, nil
+}
+
+// shipmentFixture is a packed order of two shirts.
+type shipmentFixture struct {
+	checkoutFixture
+	order     *models.Order
+	carrier   *fixedCarrier
+	shipments *mongo.Collection
+}
+
+func newShipmentFixture(t *testing.T) shipmentFixture {
+	t.Helper()
+	shirt := cartLine("Shirt", 500)
+	f := shipmentFixture{
+		checkoutFixture: newCheckoutFixture(t, shirt, shirt),
+		carrier:         &fixedCarrier{SimulatedCarrier: carriers.NewSimulatedCarrier("", time.Minute), awb: "SIM1-0001"},
+	}
+	carriers.Register(f.carrier)
+	f.shipments = f.db.Collection("Shipments")
+	ctx := context.Background()
+	// The unique index on carrier and AWB is what rejects a second parcel
+	// under the same AWB.
+	if err := EnsureIndexes(ctx, f.db); err != nil {
+		t.Fatal(err)
+	}
+	order, err := f.buy(f.userID.Hex())
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if _, err = f.orders.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": bson.M{"status": models.OrderPacked}}); err != nil {
+		t.Fatal(err)
+	}
+	f.order = order
+	return f
+}
+
+func (f shipmentFixture) ship(quantity uint) (models.Shipment, error) {
+	req := ShipmentRequest{
+		Carrier: f.carrier.Name(),
+		Lines:   []models.ShipmentLine{{LineID: f.order.Items[0].LineID, Quantity: quantity}},
+	}
+	return CreateShipment(context.Background(), f.shipments, f.orders, f.order.ID.Hex(), req, "admin")
+}
+
+func TestCreateShipmentCancelsUnstoredBooking(t *testing.T) {
+	f := newShipmentFixture(t)
+
+	if _, err := f.ship(1); err != nil {
+		t.Fatalf("first parcel: %v", err)
+	}
+	if len(f.carrier.cancelled) != 0 {
+		t.Fatalf("cancelled %v after a stored shipment, want nothing", f.carrier.cancelled)
+	}
+	if _, err := f.ship(1); !errors.Is(err, ErrDuplicateAWB) {
+		t.Fatalf("second parcel err = %v, want %v", err, ErrDuplicateAWB)
+	}
+	if len(f.carrier.cancelled) != 1 || f.carrier.cancelled[0] != f.carrier.awb {
+		t.Errorf("cancelled %v, want the booking %s", f.carrier.cancelled, f.carrier.awb)
+	}
+	if got := f.count(t, f.shipments, bson.M{}); got != 1 {
+		t.Errorf("shipments stored = %d, want 1", got)
+	}
+}
+
+func TestReceiveCarrierWebhookSkipsUnknownAWB(t *testing.T) {
+	f := newShipmentFixture(t)
+	if _, err := f.ship(2); err != nil {
+		t.Fatalf("ship: %v", err)
+	}
+
+	recorded, err := ReceiveCarrierWebhook(context.Background(), f.shipments, f.orders, f.carrier.Name(), []byte("{}"), "")
+	if err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+	if recorded != 1 {
+		t.Errorf("events recorded = %d, want 1", recorded)
+	}
+	var shipment models.Shipment
+	if err := f.shipments.FindOne(context.Background(), bson.M{"awb": f.carrier.awb}).Decode(&shipment); err != nil {
+		t.Fatal(err)
+	}
+	if shipment.Status != models.ShipmentPickedUp {
+		t.Errorf("shipment status = %s, want %s", shipment.Status, models.ShipmentPickedUp)
+	}
+}
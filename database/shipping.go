@@ -0,0 +1,267 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/shipping"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindShippingZone   = errors.New("cannot find the requested shipping zone")
+	ErrInvalidShippingZone    = errors.New("shipping zone is not valid")
+	ErrCantListShippingZones  = errors.New("cannot list shipping zones")
+	ErrCantUpdateShippingZone = errors.New("cannot update shipping zone")
+)
+
+// QuoteShipping prices every shipping method available for the user's
+// cart shipping to the address addressID. The quotes are in the requested
+// currency, or the user's preferred one.
+func QuoteShipping(ctx context.Context, userCollection *mongo.Collection, pricing PricingCollections, userID string, requested string, addressID string) ([]models.ShippingQuote, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrUserIdIsNotValid
+	}
+	var user models.User
+	if err = userCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+		}
+		return nil, ErrCantFindUser
+	}
+	currency, err := ResolveCurrency(requested, user.Currency)
+	if err != nil {
+		return nil, err
+	}
+	address, err := shippingAddress(user, addressID)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newPricer(ctx, pricing.Rates, currency)
+	if err != nil {
+		return nil, err
+	}
+	order, err := newOrder(user.ID.Hex(), user.UserCart, p)
+	if err != nil {
+		return nil, err
+	}
+	order.ShippingAddress = address
+	zones, err := activeShippingZones(ctx, pricing)
+	if err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return []models.ShippingQuote{}, nil
+	}
+	return shipping.Quote(zones, parcel(order, p))
+}
+
+// shipOrder prices order's shipping with method and records the method and
+// zone on it. It must run before promotions and tax, which both work on
+// the shipping charge. Until any shipping zones are set up, orders ship
+// for free.
+func shipOrder(ctx context.Context, pricing PricingCollections, p *pricer, order *models.Order, method string) error {
+	if method == "" {
+		method = models.ShippingStandard
+	}
+	zones, err := activeShippingZones(ctx, pricing)
+	if err != nil {
+		return err
+	}
+	if len(zones) == 0 {
+		if !models.IsShippingMethod(method) {
+			return shipping.ErrUnknownMethod
+		}
+		order.ShippingMethod = method
+		order.Shipping = money.Zero(order.Currency)
+		return nil
+	}
+	quotes, err := shipping.Quote(zones, parcel(*order, p))
+	if err != nil {
+		return err
+	}
+	quote, err := shipping.Select(quotes, method)
+	if err != nil {
+		return err
+	}
+	order.ShippingMethod = quote.Method
+	order.ShippingZone = quote.Zone
+	order.Shipping = quote.Price
+	order.Total = orderTotal(*order)
+	return nil
+}
+
+// parcel describes order to package shipping, converting rates through p.
+func parcel(order models.Order, p *pricer) shipping.Parcel {
+	parcel := shipping.Parcel{
+		Weight:   order.Weight,
+		Subtotal: order.Subtotal,
+		Convert: func(amount money.Money) (money.Money, error) {
+			return p.price(amount, nil)
+		},
+	}
+	if order.ShippingAddress != nil {
+		parcel.Country = order.ShippingAddress.Country
+		parcel.PostalCode = order.ShippingAddress.PostalCode()
+	}
+	return parcel
+}
+
+func activeShippingZones(ctx context.Context, pricing PricingCollections) ([]models.ShippingZone, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := pricing.Zones.Find(ctx, bson.M{"active": true}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListShippingZones
+	}
+	defer cursor.Close(ctx)
+	var zones []models.ShippingZone
+	if err = cursor.All(ctx, &zones); err != nil {
+		log.Println(err)
+		return nil, ErrCantListShippingZones
+	}
+	return zones, nil
+}
+
+// CreateShippingZone validates and stores a new shipping zone.
+func CreateShippingZone(ctx context.Context, pricing PricingCollections, zone models.ShippingZone) (models.ShippingZone, error) {
+	if err := normalizeShippingZone(&zone); err != nil {
+		return zone, err
+	}
+	zone.ID = primitive.NewObjectID()
+	zone.CreatedAt = time.Now()
+	zone.UpdatedAt = zone.CreatedAt
+
+	if _, err := pricing.Zones.InsertOne(ctx, zone); err != nil {
+		log.Println(err)
+		return zone, ErrCantUpdateShippingZone
+	}
+	return zone, nil
+}
+
+// UpdateShippingZone replaces a shipping zone's countries, prefixes, rates
+// and threshold. Orders already placed keep the shipping they were quoted.
+func UpdateShippingZone(ctx context.Context, pricing PricingCollections, zoneID string, zone models.ShippingZone) (models.ShippingZone, error) {
+	id, err := primitive.ObjectIDFromHex(zoneID)
+	if err != nil {
+		return zone, ErrCantFindShippingZone
+	}
+	if err = normalizeShippingZone(&zone); err != nil {
+		return zone, err
+	}
+	zone.UpdatedAt = time.Now()
+	update := bson.M{"$set": bson.M{
+		"name":            zone.Name,
+		"countries":       zone.Countries,
+		"postal_prefixes": zone.PostalPrefixes,
+		"rates":           zone.Rates,
+		"free_over":       zone.FreeOver,
+		"active":          zone.Active,
+		"updated_at":      zone.UpdatedAt,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = pricing.Zones.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&zone)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return zone, ErrCantFindShippingZone
+	}
+	if err != nil {
+		log.Println(err)
+		return zone, ErrCantUpdateShippingZone
+	}
+	return zone, nil
+}
+
+// DeleteShippingZone removes a shipping zone.
+func DeleteShippingZone(ctx context.Context, pricing PricingCollections, zoneID string) error {
+	id, err := primitive.ObjectIDFromHex(zoneID)
+	if err != nil {
+		return ErrCantFindShippingZone
+	}
+	result, err := pricing.Zones.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateShippingZone
+	}
+	if result.DeletedCount == 0 {
+		return ErrCantFindShippingZone
+	}
+	return nil
+}
+
+// ListShippingZones returns one page (1-based) of shipping zones, oldest
+// first, together with the total number of zones.
+func ListShippingZones(ctx context.Context, pricing PricingCollections, page, limit int64) ([]models.ShippingZone, int64, error) {
+	total, err := pricing.Zones.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListShippingZones
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := pricing.Zones.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListShippingZones
+	}
+	defer cursor.Close(ctx)
+
+	zones := make([]models.ShippingZone, 0)
+	if err = cursor.All(ctx, &zones); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListShippingZones
+	}
+	return zones, total, nil
+}
+
+// normalizeShippingZone upper-cases zone's countries and prefixes and
+// checks its rate table.
+func normalizeShippingZone(zone *models.ShippingZone) error {
+	zone.Name = strings.TrimSpace(zone.Name)
+	if zone.Name == "" || len(zone.Rates) == 0 {
+		return ErrInvalidShippingZone
+	}
+	for i, country := range zone.Countries {
+		zone.Countries[i] = strings.ToUpper(strings.TrimSpace(country))
+		if len(zone.Countries[i]) != 2 {
+			return ErrInvalidShippingZone
+		}
+	}
+	if len(zone.PostalPrefixes) > 0 && len(zone.Countries) == 0 {
+		return ErrInvalidShippingZone
+	}
+	for i, prefix := range zone.PostalPrefixes {
+		zone.PostalPrefixes[i] = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(prefix), " ", ""))
+		if zone.PostalPrefixes[i] == "" {
+			return ErrInvalidShippingZone
+		}
+	}
+	for _, rate := range zone.Rates {
+		if !models.IsShippingMethod(rate.Method) || rate.Price.IsNegative() || rate.PerKg.IsNegative() {
+			return ErrInvalidShippingZone
+		}
+		if rate.MaxWeight > 0 && rate.MaxWeight < rate.MinWeight {
+			return ErrInvalidShippingZone
+		}
+		for _, amount := range []money.Money{rate.Price, rate.PerKg, rate.MinSubtotal, rate.MaxSubtotal} {
+			if !amount.IsZero() && !models.IsSupportedCurrency(amount.Currency) {
+				return ErrUnsupportedCurrency
+			}
+		}
+	}
+	if zone.FreeOver.IsNegative() || (!zone.FreeOver.IsZero() && !models.IsSupportedCurrency(zone.FreeOver.Currency)) {
+		return ErrInvalidShippingZone
+	}
+	return nil
+}
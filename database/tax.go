@@ -0,0 +1,29 @@
+package database
+
+import (
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/tax"
+)
+
+// applyTax taxes order's lines, after their discounts, and its shipping,
+// after shippingDiscount, for its shipping address, and updates the total.
+func applyTax(order *models.Order, shippingDiscount money.Money) {
+	lines := make([]tax.Line, len(order.Items))
+	for i, item := range order.Items {
+		lines[i] = tax.Line{Class: item.TaxClass, Amount: item.LineTotal.Sub(item.Discount)}
+	}
+	result := tax.Calculate(order.Currency, order.ShippingAddress, lines, order.Shipping.Sub(shippingDiscount))
+
+	for i := range order.Items {
+		order.Items[i].Tax = result.LineTax[i]
+		order.Items[i].Taxes = result.Lines[i]
+		order.Items[i].TaxInclusive = result.Inclusive
+	}
+	order.Tax = result.Total
+	order.ShippingTax = result.ShippingTax
+	order.ShippingTaxes = result.Shipping
+	order.TaxBreakdown = result.Breakdown
+	order.TaxInclusive = result.Inclusive
+	order.Total = orderTotal(*order)
+}
@@ -0,0 +1,235 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindVariant    = errors.New("cannot find the requested variant")
+	ErrVariantRequired    = errors.New("choose one of the product's variants")
+	ErrInvalidVariants    = errors.New("product options or variants are not valid")
+	ErrDuplicateSKU       = errors.New("a variant with this sku already exists")
+	ErrVariantHasStock    = errors.New("stock is still held of a variant being removed")
+	ErrCantUpdateVariants = errors.New("cannot update product variants")
+)
+
+// parseVariantID parses the id of a variant, which may be left empty for
+// products without variants.
+func parseVariantID(variantID string) (primitive.ObjectID, error) {
+	if variantID == "" {
+		return primitive.NilObjectID, nil
+	}
+	id, err := primitive.ObjectIDFromHex(variantID)
+	if err != nil {
+		return primitive.NilObjectID, ErrCantFindVariant
+	}
+	return id, nil
+}
+
+// pickVariant returns the variant of product with id variantID. Products
+// with variants are only stocked, sold and bought as one of them, so
+// variantID is required for them and must be zero for the others, which
+// get a nil variant.
+func pickVariant(product models.Product, variantID primitive.ObjectID) (*models.Variant, error) {
+	if len(product.Variants) == 0 {
+		if !variantID.IsZero() {
+			return nil, ErrCantFindVariant
+		}
+		return nil, nil
+	}
+	if variantID.IsZero() {
+		return nil, ErrVariantRequired
+	}
+	variant, ok := product.Variant(variantID)
+	if !ok {
+		return nil, ErrCantFindVariant
+	}
+	return &variant, nil
+}
+
+// productItem returns the stock item named by productID and variantID,
+// checking that the product exists and the variant is one of its own.
+func productItem(ctx context.Context, prodCollection *mongo.Collection, productID, variantID string) (stockItem, error) {
+	id, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return stockItem{}, ErrCantFindProduct
+	}
+	variant, err := parseVariantID(variantID)
+	if err != nil {
+		return stockItem{}, err
+	}
+	var product models.Product
+	opts := options.FindOne().SetProjection(bson.M{"variants.variant_id": 1})
+	err = prodCollection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&product)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+		}
+		return stockItem{}, ErrCantFindProduct
+	}
+	if _, err = pickVariant(product, variant); err != nil {
+		return stockItem{}, err
+	}
+	return itemOf(id, variant), nil
+}
+
+// cartItem returns the product, or its variant, as it goes in a cart.
+// Inactive variants cannot be bought.
+func cartItem(ctx context.Context, prodCollection *mongo.Collection, productID, variantID primitive.ObjectID) (models.ProductUser, error) {
+	var product models.Product
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.ProductUser{}, ErrCantFindProduct
+	}
+	if err != nil {
+		log.Println(err)
+		return models.ProductUser{}, ErrCantDecodeProduct
+	}
+	variant, err := pickVariant(product, variantID)
+	if err != nil {
+		return models.ProductUser{}, err
+	}
+	if variant != nil && !variant.Active {
+		return models.ProductUser{}, ErrCantFindVariant
+	}
+	return product.CartItem(variant), nil
+}
+
+// VariantsRequest replaces the options and variants of a product. Variants
+// keep their id when it is sent back; those sent without one are new.
+type VariantsRequest struct {
+	Options  []models.ProductOption `json:"options"`
+	Variants []models.Variant       `json:"variants"`
+}
+
+// SetProductVariants replaces the options and variants of a product. Every
+// variant picks one value of each option, no two pick the same ones, and
+// each has its own SKU. A variant, or the product itself when it gains its
+// first variants, can only be dropped once none of its stock is left;
+// variants that should no longer be sold can be made inactive instead.
+func SetProductVariants(ctx context.Context, prodCollection, inventoryCollection *mongo.Collection, productID string, request VariantsRequest) (models.Product, error) {
+	id, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return models.Product{}, ErrCantFindProduct
+	}
+	var product models.Product
+	if err = prodCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&product); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+		}
+		return models.Product{}, ErrCantFindProduct
+	}
+	if err = normalizeVariants(product, &request); err != nil {
+		return models.Product{}, err
+	}
+
+	// Stock of anything the new variants leave out would be stranded.
+	stranded := bson.M{
+		"product_id": id,
+		"$or":        bson.A{bson.M{"on_hand": bson.M{"$ne": 0}}, bson.M{"reserved": bson.M{"$ne": 0}}},
+	}
+	if len(request.Variants) == 0 {
+		stranded["variant_id"] = bson.M{"$exists": true}
+	} else {
+		kept := make([]primitive.ObjectID, len(request.Variants))
+		for i, variant := range request.Variants {
+			kept[i] = variant.VariantID
+		}
+		stranded["variant_id"] = bson.M{"$nin": kept}
+	}
+	held, err := inventoryCollection.CountDocuments(ctx, stranded)
+	if err != nil {
+		log.Println(err)
+		return models.Product{}, ErrCantUpdateVariants
+	}
+	if held > 0 {
+		return models.Product{}, ErrVariantHasStock
+	}
+
+	update := bson.M{"$set": bson.M{"options": request.Options, "variants": request.Variants}}
+	if len(request.Variants) == 0 {
+		update = bson.M{"$unset": bson.M{"options": "", "variants": ""}}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = prodCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&product)
+	if mongo.IsDuplicateKeyError(err) {
+		return models.Product{}, ErrDuplicateSKU
+	}
+	if err != nil {
+		log.Println(err)
+		return models.Product{}, ErrCantUpdateVariants
+	}
+	return product, nil
+}
+
+// normalizeVariants trims the options and variants of request, checks them
+// against each other and gives new variants an id. Variants sent back with
+// an id must already be variants of product.
+func normalizeVariants(product models.Product, request *VariantsRequest) error {
+	values := make(map[string]map[string]bool, len(request.Options))
+	for i := range request.Options {
+		option := &request.Options[i]
+		option.Name = strings.TrimSpace(option.Name)
+		if option.Name == "" || values[option.Name] != nil || len(option.Values) == 0 {
+			return ErrInvalidVariants
+		}
+		values[option.Name] = make(map[string]bool, len(option.Values))
+		for j, value := range option.Values {
+			value = strings.TrimSpace(value)
+			if value == "" || values[option.Name][value] {
+				return ErrInvalidVariants
+			}
+			option.Values[j] = value
+			values[option.Name][value] = true
+		}
+	}
+	if (len(request.Options) == 0) != (len(request.Variants) == 0) {
+		return ErrInvalidVariants
+	}
+
+	combinations := make(map[string]bool, len(request.Variants))
+	skus := make(map[string]bool, len(request.Variants))
+	ids := make(map[primitive.ObjectID]bool, len(request.Variants))
+	for i := range request.Variants {
+		variant := &request.Variants[i]
+		variant.SKU = strings.ToUpper(strings.TrimSpace(variant.SKU))
+		variant.Barcode = strings.TrimSpace(variant.Barcode)
+		if variant.SKU == "" || skus[variant.SKU] || len(variant.Options) != len(request.Options) ||
+			variant.Price.IsNegative() {
+			return ErrInvalidVariants
+		}
+		skus[variant.SKU] = true
+
+		picked := make([]string, len(request.Options))
+		for j, option := range request.Options {
+			value := strings.TrimSpace(variant.Options[option.Name])
+			if !values[option.Name][value] {
+				return ErrInvalidVariants
+			}
+			variant.Options[option.Name] = value
+			picked[j] = value
+		}
+		combination := strings.Join(picked, "\x00")
+		if combinations[combination] {
+			return ErrInvalidVariants
+		}
+		combinations[combination] = true
+
+		if variant.VariantID.IsZero() {
+			variant.VariantID = primitive.NewObjectID()
+		} else if _, ok := product.Variant(variant.VariantID); !ok || ids[variant.VariantID] {
+			return ErrCantFindVariant
+		}
+		ids[variant.VariantID] = true
+	}
+	return nil
+}
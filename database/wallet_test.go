@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/payments"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// walletFixture is a customer buying store credit through the fake
+// provider.
+type walletFixture struct {
+	db       *mongo.Database
+	intents  *mongo.Collection
+	wallets  WalletCollections
+	provider *payments.FakeProvider
+	userID   string
+}
+
+func newWalletFixture(t *testing.T) walletFixture {
+	t.Helper()
+	db := testDatabase(t)
+	f := walletFixture{
+		db:       db,
+		intents:  db.Collection("PaymentIntents"),
+		wallets:  NewWalletCollections(db),
+		provider: payments.NewFakeProvider(testWebhookSecret),
+		userID:   "customer",
+	}
+	payments.Register(f.provider)
+	for _, name := range []string{"PaymentIntents", "Wallets", "WalletEntries", "GiftCards"} {
+		if err := db.CreateCollection(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func (f walletFixture) purchase(major int64) models.StoredValuePurchase {
+	return models.StoredValuePurchase{
+		Amount:       money.FromMajor(major, models.DefaultCurrency),
+		Provider:     f.provider.Name(),
+		PaymentToken: payments.FakeTokenSuccess,
+	}
+}
+
+// refundedOnce checks the charge behind intent was paid back in full, and
+// that its intent is marked so.
+func (f walletFixture) refundedOnce(t *testing.T, intent models.PaymentIntent) {
+	t.Helper()
+	var stored models.PaymentIntent
+	if err := f.intents.FindOne(context.Background(), bson.M{"_id": intent.ID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.IntentRefunded || stored.Refunded.Cmp(stored.Captured) != 0 {
+		t.Errorf("intent %s with %s of %s refunded, want refunded in full", stored.Status, stored.Refunded, stored.Captured)
+	}
+	// Nothing is left to refund, and the intent's key pays nothing more.
+	if _, err := f.provider.Refund(context.Background(), stored.Reference, money.New(1, stored.Captured.Currency), "another"); !errors.Is(err, payments.ErrInvalidRequest) {
+		t.Errorf("refund after the charge was paid back err = %v, want %v", err, payments.ErrInvalidRequest)
+	}
+	if _, err := f.provider.Refund(context.Background(), stored.Reference, stored.Captured, stored.ID.Hex()); err != nil {
+		t.Errorf("repeated refund under the intent's key: %v", err)
+	}
+}
+
+func TestTopUpWallet(t *testing.T) {
+	f := newWalletFixture(t)
+
+	wallet, intent, err := TopUpWallet(context.Background(), f.intents, f.wallets, f.userID, f.purchase(100))
+	if err != nil {
+		t.Fatalf("top-up: %v", err)
+	}
+	if intent.Status != models.IntentCaptured {
+		t.Errorf("intent status = %s, want %s", intent.Status, models.IntentCaptured)
+	}
+	if want := money.FromMajor(100, models.DefaultCurrency); wallet.Balance.Cmp(want) != 0 {
+		t.Errorf("balance = %s, want %s", wallet.Balance, want)
+	}
+}
+
+func TestTopUpWalletRefundsWhenNotCredited(t *testing.T) {
+	f := newWalletFixture(t)
+	// A wallet holds one currency, so a top-up in another cannot be
+	// credited.
+	_, err := CreditWallet(context.Background(), f.wallets, f.userID, money.FromMajor(5, "USD"), models.AccountPromotions, WalletPromotion, "welcome")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, intent, err := TopUpWallet(context.Background(), f.intents, f.wallets, f.userID, f.purchase(100))
+	if !errors.Is(err, ErrInvalidAmount) {
+		t.Fatalf("top-up err = %v, want %v", err, ErrInvalidAmount)
+	}
+	f.refundedOnce(t, intent)
+	wallet, err := GetWallet(context.Background(), f.wallets, f.userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := money.FromMajor(5, "USD"); wallet.Balance.Cmp(want) != 0 {
+		t.Errorf("balance = %s, want %s", wallet.Balance, want)
+	}
+}
+
+func TestPurchaseGiftCardRefundsWhenNotIssued(t *testing.T) {
+	f := newWalletFixture(t)
+	// Reject every gift card, so the card fails after the charge.
+	err := f.db.RunCommand(context.Background(), bson.D{
+		{Key: "collMod", Value: "GiftCards"},
+		{Key: "validator", Value: bson.M{"code": bson.M{"$exists": false}}},
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, intent, err := PurchaseGiftCard(context.Background(), f.intents, f.wallets, f.userID, f.purchase(100))
+	if err == nil {
+		t.Fatal("gift card issued despite the validator")
+	}
+	f.refundedOnce(t, intent)
+	n, err := f.wallets.Entries.CountDocuments(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("wallet entries = %d, want 0", n)
+	}
+}
@@ -0,0 +1,359 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindWarehouse         = errors.New("cannot find the requested warehouse")
+	ErrInvalidWarehouse          = errors.New("warehouse is not valid")
+	ErrDuplicateWarehouse        = errors.New("a warehouse with this code already exists")
+	ErrCantUpdateWarehouse       = errors.New("cannot update warehouse")
+	ErrCantListWarehouses        = errors.New("cannot list warehouses")
+	ErrCantFindTransfer          = errors.New("cannot find the requested transfer")
+	ErrInvalidTransfer           = errors.New("transfer is not valid")
+	ErrIllegalTransferTransition = errors.New("transfer is no longer in transit")
+	ErrCantUpdateTransfer        = errors.New("cannot update transfer")
+	ErrCantListTransfers         = errors.New("cannot list transfers")
+)
+
+// warehouses is the collection warehouses are kept in, next to the stock
+// they hold.
+func warehouses(db *mongo.Database) *mongo.Collection {
+	return db.Collection("Warehouses")
+}
+
+// activeWarehouses returns the warehouses that fulfil orders, oldest
+// first.
+func activeWarehouses(ctx context.Context, db *mongo.Database) ([]models.Warehouse, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := warehouses(db).Find(ctx, bson.M{"active": true}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var active []models.Warehouse
+	err = cursor.All(ctx, &active)
+	return active, err
+}
+
+func GetWarehouse(ctx context.Context, warehouseCollection *mongo.Collection, warehouseID string) (models.Warehouse, error) {
+	var warehouse models.Warehouse
+	id, err := primitive.ObjectIDFromHex(warehouseID)
+	if err != nil {
+		return warehouse, ErrCantFindWarehouse
+	}
+	err = warehouseCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&warehouse)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return warehouse, ErrCantFindWarehouse
+	}
+	if err != nil {
+		log.Println(err)
+		return warehouse, ErrCantFindWarehouse
+	}
+	return warehouse, nil
+}
+
+// CreateWarehouse validates and stores a new warehouse.
+func CreateWarehouse(ctx context.Context, warehouseCollection *mongo.Collection, warehouse models.Warehouse) (models.Warehouse, error) {
+	if err := normalizeWarehouse(&warehouse); err != nil {
+		return warehouse, err
+	}
+	warehouse.ID = primitive.NewObjectID()
+	warehouse.CreatedAt = time.Now()
+	warehouse.UpdatedAt = warehouse.CreatedAt
+
+	_, err := warehouseCollection.InsertOne(ctx, warehouse)
+	if mongo.IsDuplicateKeyError(err) {
+		return warehouse, ErrDuplicateWarehouse
+	}
+	if err != nil {
+		log.Println(err)
+		return warehouse, ErrCantUpdateWarehouse
+	}
+	return warehouse, nil
+}
+
+// UpdateWarehouse replaces a warehouse's code, name, address and whether
+// it is active. Lines already routed to it stay there.
+func UpdateWarehouse(ctx context.Context, warehouseCollection *mongo.Collection, warehouseID string, warehouse models.Warehouse) (models.Warehouse, error) {
+	id, err := primitive.ObjectIDFromHex(warehouseID)
+	if err != nil {
+		return warehouse, ErrCantFindWarehouse
+	}
+	if err = normalizeWarehouse(&warehouse); err != nil {
+		return warehouse, err
+	}
+	warehouse.UpdatedAt = time.Now()
+	update := bson.M{"$set": bson.M{
+		"code":       warehouse.Code,
+		"name":       warehouse.Name,
+		"address":    warehouse.Address,
+		"active":     warehouse.Active,
+		"updated_at": warehouse.UpdatedAt,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = warehouseCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&warehouse)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return warehouse, ErrCantFindWarehouse
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return warehouse, ErrDuplicateWarehouse
+	}
+	if err != nil {
+		log.Println(err)
+		return warehouse, ErrCantUpdateWarehouse
+	}
+	return warehouse, nil
+}
+
+// ListWarehouses returns one page (1-based) of warehouses, oldest first,
+// together with the total number of warehouses.
+func ListWarehouses(ctx context.Context, warehouseCollection *mongo.Collection, page, limit int64) ([]models.Warehouse, int64, error) {
+	total, err := warehouseCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListWarehouses
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := warehouseCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListWarehouses
+	}
+	defer cursor.Close(ctx)
+
+	list := make([]models.Warehouse, 0)
+	if err = cursor.All(ctx, &list); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListWarehouses
+	}
+	return list, total, nil
+}
+
+// normalizeWarehouse upper-cases warehouse's code and country and checks
+// it has what routing needs: a postal code to measure distance from.
+func normalizeWarehouse(warehouse *models.Warehouse) error {
+	warehouse.Code = strings.ToUpper(strings.TrimSpace(warehouse.Code))
+	warehouse.Name = strings.TrimSpace(warehouse.Name)
+	warehouse.Address.Country = strings.ToUpper(strings.TrimSpace(warehouse.Address.Country))
+	if warehouse.Code == "" || warehouse.Name == "" || warehouse.Address.PostalCode() == "" {
+		return ErrInvalidWarehouse
+	}
+	if warehouse.Address.Country != "" && len(warehouse.Address.Country) != 2 {
+		return ErrInvalidWarehouse
+	}
+	return nil
+}
+
+// TransferRequest asks to move Quantity units of a product, or of its
+// variant VariantID, between two warehouses.
+type TransferRequest struct {
+	ProductID       string `json:"product_id"`
+	VariantID       string `json:"variant_id"`
+	FromWarehouseID string `json:"from_warehouse_id"`
+	ToWarehouseID   string `json:"to_warehouse_id"`
+	Quantity        int64  `json:"quantity"`
+	Note            string `json:"note"`
+}
+
+// CreateTransfer takes the units of request out of the source warehouse's
+// available stock and records them in transit to the destination.
+func CreateTransfer(ctx context.Context, inventoryCollection, transferCollection *mongo.Collection, request TransferRequest, actor string) (models.Transfer, error) {
+	productID, err := primitive.ObjectIDFromHex(request.ProductID)
+	if err != nil {
+		return models.Transfer{}, ErrCantFindProduct
+	}
+	variantID, err := parseVariantID(request.VariantID)
+	if err != nil {
+		return models.Transfer{}, err
+	}
+	if request.Quantity <= 0 || request.FromWarehouseID == request.ToWarehouseID {
+		return models.Transfer{}, ErrInvalidTransfer
+	}
+	db := inventoryCollection.Database()
+	from, err := GetWarehouse(ctx, warehouses(db), request.FromWarehouseID)
+	if err != nil {
+		return models.Transfer{}, err
+	}
+	to, err := GetWarehouse(ctx, warehouses(db), request.ToWarehouseID)
+	if err != nil {
+		return models.Transfer{}, err
+	}
+
+	var transfer models.Transfer
+	err = runInTransaction(ctx, db.Client(), func(sessCtx mongo.SessionContext) error {
+		now := time.Now()
+		seq, err := nextSequence(sessCtx, db, "transfer_number")
+		if err != nil {
+			return err
+		}
+		transfer = models.Transfer{
+			ID:              primitive.NewObjectID(),
+			TransferNumber:  fmt.Sprintf("TRF-%08d", seq),
+			ProductID:       productID,
+			VariantID:       variantID,
+			FromWarehouseID: from.ID,
+			ToWarehouseID:   to.ID,
+			Quantity:        request.Quantity,
+			Status:          models.TransferInTransit,
+			Actor:           actor,
+			Note:            request.Note,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		}
+
+		out := models.StockMovement{
+			ProductID:   productID,
+			VariantID:   variantID,
+			WarehouseID: from.ID,
+			Kind:        models.MovementTransfer,
+			Quantity:    -request.Quantity,
+			Reference:   transfer.TransferNumber,
+			Actor:       actor,
+			Reason:      "transfer to " + to.Code,
+			At:          now,
+		}
+		guard := bson.M{"$expr": bson.M{"$gte": bson.A{bson.M{"$subtract": bson.A{"$on_hand", "$reserved"}}, request.Quantity}}}
+		_, applied, err := moveStock(sessCtx, db, out, 0, guard)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return ErrOutOfStock
+		}
+		_, err = transferCollection.InsertOne(sessCtx, transfer)
+		return err
+	})
+	return transfer, transferError(err)
+}
+
+// ReceiveTransfer adds a transfer's units to the destination warehouse's
+// stock, which starts tracking the product there if need be.
+func ReceiveTransfer(ctx context.Context, inventoryCollection, transferCollection *mongo.Collection, transferID string, actor string) (models.Transfer, error) {
+	return settleTransfer(ctx, inventoryCollection, transferCollection, transferID, models.TransferReceived, actor)
+}
+
+// CancelTransfer puts the units of a transfer still in transit back into
+// the source warehouse's stock.
+func CancelTransfer(ctx context.Context, inventoryCollection, transferCollection *mongo.Collection, transferID string, actor string) (models.Transfer, error) {
+	return settleTransfer(ctx, inventoryCollection, transferCollection, transferID, models.TransferCancelled, actor)
+}
+
+// settleTransfer moves a transfer in transit to status, crediting its
+// units to the destination when received or to the source when
+// cancelled.
+func settleTransfer(ctx context.Context, inventoryCollection, transferCollection *mongo.Collection, transferID string, status models.TransferStatus, actor string) (models.Transfer, error) {
+	id, err := primitive.ObjectIDFromHex(transferID)
+	if err != nil {
+		return models.Transfer{}, ErrCantFindTransfer
+	}
+	var transfer models.Transfer
+	err = runInTransaction(ctx, inventoryCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		now := time.Now()
+		set := bson.M{"status": status, "updated_at": now}
+		if status == models.TransferReceived {
+			set["received_at"] = now
+		} else {
+			set["cancelled_at"] = now
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := transferCollection.FindOneAndUpdate(sessCtx,
+			bson.M{"_id": id, "status": models.TransferInTransit},
+			bson.M{"$set": set}, opts).Decode(&transfer)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			count, err := transferCollection.CountDocuments(sessCtx, bson.M{"_id": id})
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				return ErrCantFindTransfer
+			}
+			return ErrIllegalTransferTransition
+		}
+		if err != nil {
+			return err
+		}
+
+		in := models.StockMovement{
+			ProductID:   transfer.ProductID,
+			VariantID:   transfer.VariantID,
+			WarehouseID: transfer.ToWarehouseID,
+			Kind:        models.MovementTransfer,
+			Quantity:    transfer.Quantity,
+			Reference:   transfer.TransferNumber,
+			Actor:       actor,
+			Reason:      "transfer received",
+			At:          now,
+		}
+		if status == models.TransferCancelled {
+			in.WarehouseID = transfer.FromWarehouseID
+			in.Reason = "transfer cancelled"
+		}
+		_, _, err = moveStock(sessCtx, inventoryCollection.Database(), in, 0, nil)
+		return err
+	})
+	return transfer, transferError(err)
+}
+
+// ListTransfers returns one page (1-based) of transfers, newest first,
+// together with the total number matching. A non-empty status lists only
+// transfers in it.
+func ListTransfers(ctx context.Context, transferCollection *mongo.Collection, status string, page, limit int64) ([]models.Transfer, int64, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	total, err := transferCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListTransfers
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := transferCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListTransfers
+	}
+	defer cursor.Close(ctx)
+
+	transfers := make([]models.Transfer, 0)
+	if err = cursor.All(ctx, &transfers); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListTransfers
+	}
+	return transfers, total, nil
+}
+
+// transferError passes the known transfer errors through and hides
+// anything else behind ErrCantUpdateTransfer.
+func transferError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, known := range []error{ErrOutOfStock, ErrCantFindTransfer, ErrIllegalTransferTransition} {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+	log.Println(err)
+	return ErrCantUpdateTransfer
+}
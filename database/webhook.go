@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/payments"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrDuplicateEvent   = errors.New("webhook event was already received")
+	ErrCantStoreEvent   = errors.New("cannot store webhook event")
+	ErrUnknownEventType = errors.New("unknown webhook event type")
+)
+
+// ReceivePaymentWebhook verifies payload against providerName's signature
+// scheme, stores the raw event and processes it. A redelivered event is
+// stored only once and reported as ErrDuplicateEvent.
+func ReceivePaymentWebhook(ctx context.Context, orderCollection, intentCollection, ledgerCollection, eventCollection *mongo.Collection, providerName string, payload []byte, signature string) (models.PaymentEvent, error) {
+	provider, err := payments.Lookup(providerName)
+	if err != nil {
+		return models.PaymentEvent{}, err
+	}
+	verified, err := provider.VerifyWebhook(payload, signature)
+	if err != nil {
+		return models.PaymentEvent{}, err
+	}
+
+	event := models.PaymentEvent{
+		ID:         primitive.NewObjectID(),
+		Provider:   provider.Name(),
+		EventID:    verified.ID,
+		Type:       verified.Type,
+		Reference:  verified.Reference,
+		Amount:     verified.Amount,
+		Payload:    string(payload),
+		Signature:  signature,
+		ReceivedAt: time.Now(),
+	}
+	if _, err = eventCollection.InsertOne(ctx, event); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return event, ErrDuplicateEvent
+		}
+		log.Println(err)
+		return event, ErrCantStoreEvent
+	}
+
+	return event, ProcessPaymentEvent(ctx, orderCollection, intentCollection, ledgerCollection, eventCollection, &event)
+}
+
+// ProcessPaymentEvent applies a stored event to its payment intent and
+// order and records the attempt on the event. Processing is idempotent, so
+// an event can safely be replayed after a fix.
+func ProcessPaymentEvent(ctx context.Context, orderCollection, intentCollection, ledgerCollection, eventCollection *mongo.Collection, event *models.PaymentEvent) error {
+	processErr := applyPaymentEvent(ctx, orderCollection, intentCollection, ledgerCollection, event)
+
+	event.Attempts++
+	set := bson.M{"attempts": event.Attempts}
+	if processErr != nil {
+		event.ProcessError = processErr.Error()
+		set["process_error"] = event.ProcessError
+	} else {
+		event.ProcessError = ""
+		event.ProcessedAt = time.Now()
+		set["process_error"] = ""
+		set["processed_at"] = event.ProcessedAt
+	}
+	if _, err := eventCollection.UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": set}); err != nil {
+		log.Println(err)
+	}
+	return processErr
+}
+
+func applyPaymentEvent(ctx context.Context, orderCollection, intentCollection, ledgerCollection *mongo.Collection, event *models.PaymentEvent) error {
+	intent, err := findIntent(ctx, intentCollection, bson.M{"provider": event.Provider, "reference": event.Reference})
+	if err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case payments.EventPaymentAuthorized:
+		if intent.Status != models.IntentProcessing && intent.Status != models.IntentRequiresAction {
+			return nil
+		}
+		provider, err := payments.Lookup(intent.Provider)
+		if err != nil {
+			return err
+		}
+		return settleAuthorization(ctx, orderCollection, intentCollection, ledgerCollection, provider, &intent, payments.Result{Status: payments.StatusAuthorized}, nil)
+	case payments.EventPaymentCaptured:
+		if intent.Status == models.IntentCaptured {
+			return nil
+		}
+		amount := event.Amount
+		if amount.IsZero() {
+			amount = intent.Amount
+		}
+		if !amount.SameCurrency(intent.Amount) {
+			return payments.ErrInvalidRequest
+		}
+		// Only what the intent was for, less anything captured already,
+		// can be booked.
+		uncaptured := intent.Amount.Sub(intent.Captured)
+		if !amount.IsPositive() || amount.GreaterThan(uncaptured) {
+			return fmt.Errorf("%w: captured %s, %s left to capture", payments.ErrInvalidRequest, amount, uncaptured)
+		}
+		return capturePayment(ctx, orderCollection, intentCollection, ledgerCollection, &intent, amount)
+	case payments.EventPaymentFailed:
+		if intent.Status == models.IntentCaptured || intent.Status == models.IntentFailed {
+			return nil
+		}
+		err := failPayment(ctx, orderCollection, intentCollection, &intent, "reported by provider")
+		if errors.Is(err, ErrPaymentDeclined) {
+			return nil
+		}
+		return err
+	case payments.EventRefundSucceeded, payments.EventRefundFailed:
+		// Refunds are recorded when they are issued; the event is kept for
+		// the audit trail only.
+		return nil
+	default:
+		return ErrUnknownEventType
+	}
+}
+
+// PaymentEventFilter selects stored events to replay. Zero values match
+// everything.
+type PaymentEventFilter struct {
+	Provider   string
+	EventID    string
+	Since      time.Time
+	FailedOnly bool
+}
+
+func FindPaymentEvents(ctx context.Context, eventCollection *mongo.Collection, filter PaymentEventFilter) ([]models.PaymentEvent, error) {
+	query := bson.M{}
+	if filter.Provider != "" {
+		query["provider"] = filter.Provider
+	}
+	if filter.EventID != "" {
+		query["event_id"] = filter.EventID
+	}
+	if !filter.Since.IsZero() {
+		query["received_at"] = bson.M{"$gte": filter.Since}
+	}
+	if filter.FailedOnly {
+		query["process_error"] = bson.M{"$nin": bson.A{"", nil}}
+	}
+
+	events := make([]models.PaymentEvent, 0)
+	cursor, err := eventCollection.Find(ctx, query, options.Find().SetSort(bson.D{{Key: "received_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
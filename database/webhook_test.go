@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/payments"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// webhookFixture is an online payment the provider timed out on, so its
+// outcome is waiting to arrive by webhook.
+type webhookFixture struct {
+	paymentFixture
+	provider *payments.FakeProvider
+	events   *mongo.Collection
+}
+
+func newWebhookFixture(t *testing.T) webhookFixture {
+	t.Helper()
+	provider := payments.NewFakeProvider(testWebhookSecret)
+	f := webhookFixture{paymentFixture: newPaymentFixture(t, provider), provider: provider}
+	f.events = f.db.Collection("PaymentEvents")
+	// The unique index on provider and event id is what deduplicates.
+	if err := EnsureIndexes(context.Background(), f.db); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.pay(payments.FakeTokenTimeout); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	return f
+}
+
+// payload is a webhook body for the fixture's payment as the fake provider
+// sends it.
+func (f webhookFixture) payload(t *testing.T, id, eventType string, amount money.Money) []byte {
+	t.Helper()
+	payload, err := json.Marshal(struct {
+		ID        string      `json:"id"`
+		Type      string      `json:"type"`
+		Reference string      `json:"reference"`
+		Amount    money.Money `json:"amount"`
+	}{id, eventType, f.intent(t).Reference, amount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func (f webhookFixture) deliver(providerName string, payload []byte, signature string) error {
+	_, err := ReceivePaymentWebhook(context.Background(), f.orders, f.intents, f.ledger, f.events, providerName, payload, signature)
+	return err
+}
+
+// paidOnce checks the order was paid in full and the payment booked once.
+func (f webhookFixture) paidOnce(t *testing.T) {
+	t.Helper()
+	order := f.stored(t)
+	if order.Status != models.OrderPaid || order.PaidAmount.Cmp(order.Total) != 0 {
+		t.Errorf("order %s with %s paid, want paid in full (%s)", order.Status, order.PaidAmount, order.Total)
+	}
+	if got := f.count(t, f.ledger, bson.M{"order_id": order.ID, "type": models.LedgerPaymentCaptured}); got != 1 {
+		t.Errorf("payments booked = %d, want 1", got)
+	}
+}
+
+func TestPaymentWebhookSignature(t *testing.T) {
+	f := newWebhookFixture(t)
+	payload := f.payload(t, "evt_1", payments.EventPaymentCaptured, money.Money{})
+	other := f.payload(t, "evt_2", payments.EventPaymentCaptured, money.Money{})
+
+	tests := []struct {
+		name      string
+		signature string
+	}{
+		{"missing", ""},
+		{"not hex", "not-a-signature"},
+		{"another secret", payments.NewFakeProvider("whsec_other").Sign(payload)},
+		{"another payload", f.provider.Sign(other)},
+	}
+	for _, tt := range tests {
+		if err := f.deliver(f.provider.Name(), payload, tt.signature); !errors.Is(err, payments.ErrBadSignature) {
+			t.Errorf("%s signature: err = %v, want %v", tt.name, err, payments.ErrBadSignature)
+		}
+	}
+	if got := f.count(t, f.events, bson.M{}); got != 0 {
+		t.Errorf("events stored = %d, want 0", got)
+	}
+	if intent := f.intent(t); intent.Status != models.IntentProcessing {
+		t.Errorf("intent status = %s, want %s", intent.Status, models.IntentProcessing)
+	}
+
+	if err := f.deliver(f.provider.Name(), payload, f.provider.Sign(payload)); err != nil {
+		t.Fatalf("signed event: %v", err)
+	}
+	f.paidOnce(t)
+}
+
+func TestPaymentWebhookDeduplicates(t *testing.T) {
+	f := newWebhookFixture(t)
+	payload := f.payload(t, "evt_1", payments.EventPaymentCaptured, money.Money{})
+	signature := f.provider.Sign(payload)
+
+	if err := f.deliver(f.provider.Name(), payload, signature); err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+	if err := f.deliver(f.provider.Name(), payload, signature); !errors.Is(err, ErrDuplicateEvent) {
+		t.Errorf("redelivery err = %v, want %v", err, ErrDuplicateEvent)
+	}
+	if got := f.count(t, f.events, bson.M{}); got != 1 {
+		t.Errorf("events stored = %d, want 1", got)
+	}
+	f.paidOnce(t)
+
+	// Event ids are only unique per provider.
+	another := &captureFailingProvider{FakeProvider: payments.NewFakeProvider(testWebhookSecret)}
+	payments.Register(another)
+	if err := f.deliver(another.Name(), payload, another.Sign(payload)); errors.Is(err, ErrDuplicateEvent) {
+		t.Errorf("same event id from another provider reported as a duplicate")
+	}
+	if got := f.count(t, f.events, bson.M{}); got != 2 {
+		t.Errorf("events stored = %d, want 2", got)
+	}
+}
+
+func TestPaymentWebhookReplay(t *testing.T) {
+	f := newWebhookFixture(t)
+	intent := f.intent(t)
+	payload := f.payload(t, "evt_1", payments.EventPaymentCaptured, money.Money{})
+
+	// The event cannot be matched to its payment when it first arrives.
+	_, err := f.intents.UpdateOne(context.Background(), bson.M{"_id": intent.ID}, bson.M{"$unset": bson.M{"reference": ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.deliver(f.provider.Name(), payload, f.provider.Sign(payload)); !errors.Is(err, ErrCantFindIntent) {
+		t.Fatalf("delivery err = %v, want %v", err, ErrCantFindIntent)
+	}
+	_, err = f.intents.UpdateOne(context.Background(), bson.M{"_id": intent.ID}, bson.M{"$set": bson.M{"reference": intent.Reference}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := FindPaymentEvents(context.Background(), f.events, PaymentEventFilter{FailedOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 {
+		t.Fatalf("failed events = %d, want 1", len(failed))
+	}
+	// Replaying is safe to repeat.
+	for i := 0; i < 2; i++ {
+		if err := ProcessPaymentEvent(context.Background(), f.orders, f.intents, f.ledger, f.events, &failed[0]); err != nil {
+			t.Fatalf("replay %d: %v", i+1, err)
+		}
+	}
+	f.paidOnce(t)
+
+	events, err := FindPaymentEvents(context.Background(), f.events, PaymentEventFilter{EventID: "evt_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Attempts != 3 || events[0].ProcessError != "" || events[0].ProcessedAt.IsZero() {
+		t.Errorf("stored event = %+v, want processed on the third attempt", events)
+	}
+}
+
+func TestPaymentWebhookCaptureAmount(t *testing.T) {
+	f := newWebhookFixture(t)
+	authorized := f.intent(t).Amount
+
+	tests := []struct {
+		eventID string
+		amount  money.Money
+	}{
+		{"evt_negative", money.New(-100, authorized.Currency)},
+		{"evt_too_much", authorized.Add(money.New(1, authorized.Currency))},
+		{"evt_dollars", money.New(100, "USD")},
+	}
+	for _, tt := range tests {
+		payload := f.payload(t, tt.eventID, payments.EventPaymentCaptured, tt.amount)
+		if err := f.deliver(f.provider.Name(), payload, f.provider.Sign(payload)); !errors.Is(err, payments.ErrInvalidRequest) {
+			t.Errorf("capture of %s: err = %v, want %v", tt.amount, err, payments.ErrInvalidRequest)
+		}
+	}
+	if order := f.stored(t); order.PaidAmount.IsPositive() {
+		t.Fatalf("paid %s after rejected captures, want nothing", order.PaidAmount)
+	}
+
+	payload := f.payload(t, "evt_ok", payments.EventPaymentCaptured, authorized)
+	if err := f.deliver(f.provider.Name(), payload, f.provider.Sign(payload)); err != nil {
+		t.Fatalf("capture of the authorized amount: %v", err)
+	}
+	f.paidOnce(t)
+}
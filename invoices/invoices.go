@@ -0,0 +1,99 @@
+// Package invoices holds what goes on an invoice besides the order: the
+// seller's details, the fiscal year invoices are numbered in, and the
+// template they are printed from.
+package invoices
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/tax"
+)
+
+// fiscalZone is the time zone fiscal years and invoice dates are reckoned
+// in. India has no daylight saving, so a fixed offset is exact.
+var fiscalZone = time.FixedZone("IST", 5*60*60+30*60)
+
+var (
+	seller           = models.Seller{Name: "ecomm-go", Country: "IN"}
+	fiscalYearStarts = time.April
+)
+
+// ConfigureFromEnv reads the seller printed on invoices from SELLER_NAME,
+// SELLER_ADDRESS and SELLER_TAX_ID (the GSTIN or VAT number), with the
+// seller's state and country taken from the tax configuration, so call it
+// after tax.ConfigureFromEnv. INVOICE_TEMPLATE names a file to print
+// invoices from instead of the built-in template, and FISCAL_YEAR_START
+// the month (1-12) the fiscal year starts in, April by default.
+func ConfigureFromEnv() {
+	config := tax.Current()
+	Configure(models.Seller{
+		Name:    os.Getenv("SELLER_NAME"),
+		Address: os.Getenv("SELLER_ADDRESS"),
+		TaxID:   os.Getenv("SELLER_TAX_ID"),
+		State:   config.State,
+		Country: config.Country,
+	})
+	if month := os.Getenv("FISCAL_YEAR_START"); month != "" {
+		var m int
+		if _, err := fmt.Sscan(month, &m); err != nil || m < 1 || m > 12 {
+			log.Fatalf("FISCAL_YEAR_START must be a month from 1 to 12, got %q", month)
+		}
+		fiscalYearStarts = time.Month(m)
+	}
+	if path := os.Getenv("INVOICE_TEMPLATE"); path != "" {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := SetTemplate(string(text)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Configure replaces the seller printed on invoices issued from now on.
+func Configure(s models.Seller) {
+	if s.Name == "" {
+		s.Name = "ecomm-go"
+	}
+	seller = s
+}
+
+// Seller returns the seller printed on invoices issued now.
+func Seller() models.Seller {
+	return seller
+}
+
+// FiscalYear returns the fiscal year at falls in, written the Indian way
+// as "2026-27"; a fiscal year starting in January is written "2026".
+func FiscalYear(at time.Time) string {
+	at = at.In(fiscalZone)
+	year := at.Year()
+	if fiscalYearStarts == time.January {
+		return fmt.Sprint(year)
+	}
+	if at.Month() < fiscalYearStarts {
+		year--
+	}
+	return fmt.Sprintf("%d-%02d", year, (year+1)%100)
+}
+
+// Number formats the seq'th document of kind in fiscalYear, such as
+// "INV/2026-27/000042".
+func Number(kind string, fiscalYear string, seq int64) string {
+	prefix := "INV"
+	if kind == models.InvoiceTypeCreditNote {
+		prefix = "CN"
+	}
+	return fmt.Sprintf("%s/%s/%06d", prefix, fiscalYear, seq)
+}
+
+// Filename is the name an invoice is downloaded as.
+func Filename(invoice models.Invoice) string {
+	return strings.ReplaceAll(invoice.Number, "/", "-") + ".pdf"
+}
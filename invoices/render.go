@@ -0,0 +1,109 @@
+package invoices
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"github.com/kshzz24/ecomm-go/pdf"
+)
+
+// defaultTemplate lays an invoice out as fixed-width text, which Render
+// sets in Courier. Lines are kept within pdf.Columns characters.
+const defaultTemplate = `{{.Seller.Name}}
+{{with .Seller.Address}}{{.}}
+{{end}}{{with .Seller.TaxID}}Tax ID: {{.}}
+{{end}}{{with .Seller.State}}State: {{.}}, {{end}}{{.Seller.Country}}
+{{rule}}
+{{if eq .Type "credit_note"}}CREDIT NOTE{{else}}TAX INVOICE{{end}}
+Number:  {{.Number}}
+Date:    {{date .IssuedAt}}
+Order:   {{.OrderNumber}}
+{{with .OriginalInvoice}}Against invoice: {{.}}
+{{end}}{{with .Reason}}Reason:  {{.}}
+{{end}}
+Bill and ship to:
+{{with .BillTo}}{{with .House}}{{.}}{{end}}{{with .Street}} {{.}}{{end}}
+{{with .City}}{{.}}{{end}}{{with .Pincode}} {{.}}{{end}}
+{{with .State}}{{.}}, {{end}}{{.Country}}
+{{else}}(no address)
+{{end}}{{rule}}
+{{left 36 "Item"}} {{right 4 "Qty"}} {{right 12 "Unit price"}} {{right 11 "Discount"}} {{right 12 "Taxable"}} {{right 12 "Amount"}}
+{{rule}}
+{{range .Lines}}{{left 36 .Description}} {{right 4 (print .Quantity)}} {{right 12 (amount .UnitPrice)}} {{right 11 (amount .Discount)}} {{right 12 (amount .Taxable)}} {{right 12 (amount .Amount)}}
+{{range .Taxes}}{{left 36 ""}}   {{.Name}} {{rate .Rate}}: {{amount .Amount}}
+{{end}}{{end}}{{rule}}
+{{left 70 "Taxable value"}} {{right 20 (amount .Taxable)}}
+{{range .TaxBreakdown}}{{left 70 (print .Name " " (rate .Rate) " " .Jurisdiction)}} {{right 20 (amount .Amount)}}
+{{end}}{{left 70 "Total tax"}} {{right 20 (amount .Tax)}}
+{{left 70 (print "Total (" .Currency ")")}} {{right 20 (amount .Total)}}
+{{rule}}
+This is a computer-generated document and needs no signature.
+`
+
+var invoiceTemplate = mustParse(defaultTemplate)
+
+var templateFuncs = template.FuncMap{
+	"rule": func() string { return strings.Repeat("-", pdf.Columns) },
+	"date": func(t time.Time) string { return t.In(fiscalZone).Format("02 Jan 2006") },
+	"left": func(width int, s string) string {
+		s = truncate(s, width)
+		return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+	},
+	"right": func(width int, s string) string {
+		s = truncate(s, width)
+		return strings.Repeat(" ", width-utf8.RuneCountInString(s)) + s
+	},
+	"amount": func(m money.Money) string { return m.Major() },
+	"rate": func(basisPoints uint) string {
+		return strings.TrimSuffix(strings.TrimSuffix(fmt.Sprintf("%d.%02d", basisPoints/100, basisPoints%100), "00"), ".") + "%"
+	},
+}
+
+func mustParse(text string) *template.Template {
+	t, err := parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func parse(text string) (*template.Template, error) {
+	return template.New("invoice").Funcs(templateFuncs).Parse(text)
+}
+
+// SetTemplate replaces the template invoices are printed from. It is
+// executed with a models.Invoice and may use the functions rule, date,
+// left, right, amount and rate.
+func SetTemplate(text string) error {
+	t, err := parse(text)
+	if err != nil {
+		return err
+	}
+	invoiceTemplate = t
+	return nil
+}
+
+// Render prints invoice from the template and returns it as a PDF.
+func Render(invoice models.Invoice) ([]byte, error) {
+	var text strings.Builder
+	if err := invoiceTemplate.Execute(&text, invoice); err != nil {
+		return nil, err
+	}
+	doc := pdf.New(invoice.Number)
+	doc.AddText(text.String())
+	return doc.Bytes(), nil
+}
+
+// truncate shortens s to width characters, marking that it was cut.
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "~"
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/kshzz24/ecomm-go/carriers"
 	"github.com/kshzz24/ecomm-go/controllers"
 	"github.com/kshzz24/ecomm-go/database"
+	"github.com/kshzz24/ecomm-go/invoices"
 	middleware "github.com/kshzz24/ecomm-go/middlewares"
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/notify"
+	"github.com/kshzz24/ecomm-go/payments"
+	"github.com/kshzz24/ecomm-go/tax"
 
 	"github.com/kshzz24/ecomm-go/routes"
 
@@ -23,18 +31,136 @@ func main() {
 	if port == "" {
 		port = "8000"
 	}
+	if ttl, err := time.ParseDuration(os.Getenv("RESERVATION_TTL")); err == nil && ttl > 0 {
+		database.ReservationTTL = ttl
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := database.EnsureIndexes(ctx, database.Client.Database("Ecommerce")); err != nil {
+		log.Fatal(err)
+	}
+	cancel()
+
+	payments.RegisterFromEnv()
+	carriers.RegisterFromEnv()
+	tax.ConfigureFromEnv()
+	invoices.ConfigureFromEnv()
+	notify.ConfigureFromEnv()
+
 	app := controllers.NewApplication(database.ProductData(database.Client, "Products"), database.UserData(database.Client, "Users"))
+	go app.RunJobs(context.Background(), 5*time.Minute)
 
 	router := gin.New()
 	router.Use(gin.Logger())
+	// Money panics on mixed-currency arithmetic; answer 500 rather than
+	// taking the server down.
+	router.Use(gin.Recovery())
 
 	routes.UserRoutes(router)
+	router.POST("/webhooks/payments/:provider", app.PaymentWebhook())
+	router.POST("/webhooks/carriers/:carrier", app.CarrierWebhook())
 	router.Use(middleware.Authentication())
 
 	router.GET("/addtocard", app.AddToCart())
 	router.GET("/removeitem", app.RemoveItem())
+	router.GET("/listcart", controllers.GetItemFromCart())
 	router.GET("/chartcheckout", app.BuyFromCart())
+	router.POST("/chartcheckout", app.BuyFromCart())
 	router.GET("/instantbuy", app.Instantbuy())
+	router.POST("/instantbuy", app.Instantbuy())
+	router.GET("/shipping/rates", app.ShippingRates())
+	router.GET("/orders", app.ListOrders())
+	router.GET("/orders/:id", app.GetOrder())
+	router.POST("/orders/:id/cancel", app.CancelOrder())
+	router.POST("/orders/:id/returns", app.CreateReturn())
+	router.GET("/orders/:id/invoices", app.ListInvoices())
+	router.GET("/invoices/:id", app.DownloadInvoice())
+	router.GET("/returns", app.ListReturns())
+	router.GET("/returns/:id", app.GetReturn())
+	router.GET("/payments/:id", app.GetPayment())
+	router.POST("/payments/:id/confirm", app.ConfirmPayment())
+	router.PUT("/users/currency", app.SetCurrency())
+	router.GET("/wallet", app.GetWallet())
+	router.POST("/wallet/top-up", app.TopUpWallet())
+	router.POST("/gift-cards", app.PurchaseGiftCard())
+	router.GET("/gift-cards/:code", app.GetGiftCard())
+	router.POST("/gift-cards/:code/redeem", app.RedeemGiftCard())
+
+	admin := router.Group("/admin", middleware.Authorize(models.UserTypeAdmin))
+	admin.GET("/orders", app.SearchOrders())
+	admin.GET("/orders/:id", app.AdminGetOrder())
+	admin.POST("/orders/:id/status", app.UpdateOrderStatus())
+	admin.POST("/orders/:id/cancel", app.AdminCancelOrder())
+	admin.POST("/orders/:id/refunds", app.IssueRefund())
+	admin.GET("/orders/:id/refunds", app.ListRefunds())
+	admin.GET("/orders/:id/ledger", app.OrderLedger())
+	admin.GET("/orders/:id/invoices", app.AdminListInvoices())
+	admin.POST("/orders/:id/invoice", app.IssueInvoice())
+	admin.GET("/invoices/:id", app.AdminDownloadInvoice())
+	admin.GET("/return-windows", app.ListReturnWindows())
+	admin.PUT("/return-windows/:category", app.SetReturnWindow())
+	admin.POST("/gift-cards", app.IssueGiftCard())
+	admin.POST("/wallets/:user/credit", app.CreditWallet())
+	admin.GET("/exchange-rates", app.ListExchangeRates())
+	admin.PUT("/products/:id/prices", app.SetProductPrices())
+	admin.PUT("/products/:id/variants", app.SetProductVariants())
+	admin.PUT("/products/:id/categories", app.SetProductCategories())
+	admin.POST("/categories", app.CreateCategory())
+	admin.PUT("/categories/:id", app.UpdateCategory())
+	admin.DELETE("/categories/:id", app.DeleteCategory())
+	admin.PUT("/products/:id/brand", app.SetProductBrand())
+	admin.POST("/brands", app.CreateBrand())
+	admin.PUT("/brands/:id", app.UpdateBrand())
+	admin.GET("/collections", app.AdminListCollections())
+	admin.POST("/collections", app.CreateCollection())
+	admin.PUT("/collections/:id", app.UpdateCollection())
+	admin.DELETE("/collections/:id", app.DeleteCollection())
+	admin.GET("/collections/:id/products", app.PreviewCollection())
+	admin.POST("/collections/:id/publish", app.SetCollectionPublished(true))
+	admin.POST("/collections/:id/unpublish", app.SetCollectionPublished(false))
+	admin.GET("/inventory", app.ListInventory())
+	admin.GET("/products/:id/stock", app.GetStock())
+	admin.GET("/products/:id/stock/movements", app.ListMovements())
+	admin.PUT("/products/:id/reorder", app.SetReorderPolicy())
+	admin.GET("/reorder-suggestions", app.ListReorderSuggestions())
+	admin.GET("/suppliers", app.ListSuppliers())
+	admin.POST("/suppliers", app.CreateSupplier())
+	admin.PUT("/suppliers/:id", app.UpdateSupplier())
+	admin.GET("/purchase-orders", app.ListPurchaseOrders())
+	admin.POST("/purchase-orders", app.CreatePurchaseOrder())
+	admin.PUT("/purchase-orders/:id", app.UpdatePurchaseOrder())
+	admin.POST("/purchase-orders/:id/send", app.SetPurchaseOrderStatus(models.PurchaseOrderSent))
+	admin.POST("/purchase-orders/:id/close", app.SetPurchaseOrderStatus(models.PurchaseOrderClosed))
+	admin.GET("/warehouses", app.ListWarehouses())
+	admin.POST("/warehouses", app.CreateWarehouse())
+	admin.PUT("/warehouses/:id", app.UpdateWarehouse())
+	admin.GET("/transfers", app.ListTransfers())
+	admin.POST("/transfers", app.CreateTransfer())
+	admin.GET("/promotions", app.ListPromotions())
+	admin.POST("/promotions", app.CreatePromotion())
+	admin.POST("/promotions/:id/activate", app.SetPromotionActive(true))
+	admin.POST("/promotions/:id/deactivate", app.SetPromotionActive(false))
+	admin.GET("/shipping-zones", app.ListShippingZones())
+	admin.POST("/shipping-zones", app.CreateShippingZone())
+	admin.PUT("/shipping-zones/:id", app.UpdateShippingZone())
+	admin.DELETE("/shipping-zones/:id", app.DeleteShippingZone())
+
+	warehouse := router.Group("/admin", middleware.Authorize(models.UserTypeAdmin, models.UserTypeStaff))
+	warehouse.POST("/orders/:id/shipments", app.CreateShipment())
+	warehouse.PUT("/orders/:id/backorders", app.SetBackorders())
+	warehouse.POST("/transfers/:id/receive", app.SettleTransfer(models.TransferReceived))
+	warehouse.POST("/transfers/:id/cancel", app.SettleTransfer(models.TransferCancelled))
+	warehouse.POST("/products/:id/stock/movements", app.RecordMovement())
+	warehouse.POST("/warehouses/:id/counts", app.CountStock())
+	warehouse.GET("/warehouses/:id/counts", app.ListCycleCounts())
+	warehouse.GET("/purchase-orders/:id", app.GetPurchaseOrder())
+	warehouse.POST("/purchase-orders/:id/receive", app.ReceivePurchaseOrder())
+	warehouse.GET("/returns", app.SearchReturns())
+	warehouse.GET("/returns/:id", app.AdminGetReturn())
+	warehouse.POST("/returns/:id/approve", app.AdvanceReturn(models.ReturnApproved))
+	warehouse.POST("/returns/:id/reject", app.AdvanceReturn(models.ReturnRejected))
+	warehouse.POST("/returns/:id/receive", app.AdvanceReturn(models.ReturnReceived))
+	warehouse.POST("/returns/:id/inspect", app.InspectReturn())
+	warehouse.POST("/returns/:id/resolve", app.ResolveReturn())
 
 	log.Fatal(router.Run(":" + port))
 
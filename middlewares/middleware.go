@@ -24,6 +24,23 @@ func Authentication() gin.HandlerFunc {
 		}
 		c.Set("email", claims.Email)
 		c.Set("uid", claims.Uid)
+		c.Set("user_type", claims.UserType)
 		c.Next()
 	}
 }
+
+// Authorize only lets through requests whose authenticated user has one of
+// userTypes. It must run after Authentication.
+func Authorize(userTypes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userType := c.GetString("user_type")
+		for _, allowed := range userTypes {
+			if userType == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to access this resource"})
+		c.Abort()
+	}
+}
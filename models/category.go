@@ -0,0 +1,52 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Category is a node of the category tree. Path holds the slugs from the
+// root down to the category itself, as in "/computers/laptops/", so the
+// descendants of a category are the categories whose Path starts with its
+// own. Depth is 0 for root categories. Attributes describe what products
+// in the category tell about themselves, such as the RAM of a laptop; a
+// category also has the attributes of its ancestors.
+type Category struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Slug       string             `bson:"slug,omitempty" json:"slug,omitempty"`
+	Name       string             `bson:"name,omitempty" json:"name,omitempty"`
+	ParentID   primitive.ObjectID `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	Path       string             `bson:"path,omitempty" json:"path,omitempty"`
+	Depth      int                `bson:"depth" json:"depth"`
+	Attributes []AttributeSchema  `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt  time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// Lineage returns the slugs on the category's path, root first.
+func (c Category) Lineage() []string {
+	return strings.Split(strings.Trim(c.Path, "/"), "/")
+}
+
+type AttributeType string
+
+const (
+	AttributeText    AttributeType = "text"
+	AttributeNumber  AttributeType = "number"
+	AttributeBoolean AttributeType = "boolean"
+	AttributeEnum    AttributeType = "enum"
+)
+
+// AttributeSchema is one attribute products of a category carry. Values
+// lists the choices of an enum attribute, and Unit, such as "GB", is what
+// a number attribute is measured in. Products must give Required
+// attributes.
+type AttributeSchema struct {
+	Name     string        `bson:"name" json:"name"`
+	Type     AttributeType `bson:"type" json:"type"`
+	Unit     string        `bson:"unit,omitempty" json:"unit,omitempty"`
+	Values   []string      `bson:"values,omitempty" json:"values,omitempty"`
+	Required bool          `bson:"required,omitempty" json:"required,omitempty"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CollectionKind string
+
+const (
+	CollectionManual CollectionKind = "manual"
+	CollectionRule   CollectionKind = "rule"
+)
+
+// Collection is a curated list of products. A manual collection holds the
+// products in ProductIDs; a rule collection holds every product its Rule
+// selects, such as "rating >= 4 and price < 1000". Pinned products come
+// first, in the order given, whether or not they are otherwise in the
+// collection. The rest are ranked by their boost, highest first, then by
+// rating and name.
+//
+// Customers see a collection from PublishAt until UnpublishAt; without a
+// PublishAt it is a draft. Published is worked out when the collection is
+// read and is not stored.
+type Collection struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"_id,omitempty"`
+	Slug        string               `bson:"slug,omitempty" json:"slug,omitempty"`
+	Title       string               `bson:"title,omitempty" json:"title,omitempty"`
+	Description string               `bson:"description,omitempty" json:"description,omitempty"`
+	Image       string               `bson:"image,omitempty" json:"image,omitempty"`
+	Kind        CollectionKind       `bson:"kind,omitempty" json:"kind,omitempty"`
+	ProductIDs  []primitive.ObjectID `bson:"product_ids,omitempty" json:"product_ids,omitempty"`
+	Rule        string               `bson:"rule,omitempty" json:"rule,omitempty"`
+	Pinned      []primitive.ObjectID `bson:"pinned,omitempty" json:"pinned,omitempty"`
+	Boosts      []ProductBoost       `bson:"boosts,omitempty" json:"boosts,omitempty"`
+	PublishAt   *time.Time           `bson:"publish_at,omitempty" json:"publish_at,omitempty"`
+	UnpublishAt *time.Time           `bson:"unpublish_at,omitempty" json:"unpublish_at,omitempty"`
+	Published   bool                 `bson:"-" json:"published"`
+	CreatedAt   time.Time            `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt   time.Time            `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// LiveAt reports whether customers see the collection at now.
+func (c Collection) LiveAt(now time.Time) bool {
+	return c.PublishAt != nil && !c.PublishAt.After(now) &&
+		(c.UnpublishAt == nil || c.UnpublishAt.After(now))
+}
+
+// ProductBoost ranks a product of a collection above those with a lower
+// Score.
+type ProductBoost struct {
+	ProductID primitive.ObjectID `bson:"product_id" json:"product_id"`
+	Score     int                `bson:"score" json:"score"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CurrencyHeader is the request header a client picks the currency to be
+// priced in with. Without it a signed-in user's preferred currency is used,
+// and then DefaultCurrency.
+const CurrencyHeader = "X-Currency"
+
+// SupportedCurrencies are the currencies the catalog is sold in.
+var SupportedCurrencies = []string{money.DefaultCurrency, "USD", "EUR"}
+
+// IsSupportedCurrency reports whether currency is one of
+// SupportedCurrencies.
+func IsSupportedCurrency(currency string) bool {
+	for _, supported := range SupportedCurrencies {
+		if currency == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// ExchangeRateTable is a set of exchange rates in force from EffectiveDate
+// until the next table. Rates maps a currency to how much of it one unit of
+// Base buys; rates are decimal strings so they convert exactly.
+type ExchangeRateTable struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Base          string             `bson:"base,omitempty" json:"base,omitempty"`
+	EffectiveDate time.Time          `bson:"effective_date,omitempty" json:"effective_date,omitempty"`
+	Rates         map[string]string  `bson:"rates,omitempty" json:"rates,omitempty"`
+	Source        string             `bson:"source,omitempty" json:"source,omitempty"`
+	ImportedAt    time.Time          `bson:"imported_at,omitempty" json:"imported_at,omitempty"`
+}
+
+// AppliedRate is an exchange rate an order's prices were converted with,
+// locked onto the order at checkout: one unit of From bought Rate of To.
+type AppliedRate struct {
+	From          string             `bson:"from,omitempty" json:"from,omitempty"`
+	To            string             `bson:"to,omitempty" json:"to,omitempty"`
+	Rate          string             `bson:"rate,omitempty" json:"rate,omitempty"`
+	TableID       primitive.ObjectID `bson:"table_id,omitempty" json:"table_id,omitempty"`
+	EffectiveDate time.Time          `bson:"effective_date,omitempty" json:"effective_date,omitempty"`
+}
+
+// CurrencyPreference is the body of a request to change the user's
+// preferred currency.
+type CurrencyPreference struct {
+	Currency string `json:"currency"`
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Stock is how many units of a product one warehouse holds. Products with
+// variants are stocked per variant, which VariantID names. Reserved units
+// are held for orders that have not been paid yet and cannot be sold again.
+// Products without a stock record in any warehouse are not tracked and
+// never run out.
+//
+// OnHand only changes together with a StockMovement recording why, and
+// Seq counts the movements recorded against the record so far. The
+// movements are the source of truth: OnHand caches what they add up to.
+type Stock struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	ProductID   primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID   primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	WarehouseID primitive.ObjectID `bson:"warehouse_id,omitempty" json:"warehouse_id,omitempty"`
+	OnHand      int64              `bson:"on_hand" json:"on_hand"`
+	Reserved    int64              `bson:"reserved" json:"reserved"`
+	Seq         int64              `bson:"seq" json:"seq"`
+	UpdatedAt   time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// Available is how many units can still be sold.
+func (s Stock) Available() int64 {
+	return s.OnHand - s.Reserved
+}
+
+// Availability is how many units of a product can be sold across the
+// active warehouses. Available is nil for products whose stock is not
+// tracked, which are always in stock.
+type Availability struct {
+	InStock   bool   `json:"in_stock"`
+	Available *int64 `json:"available,omitempty"`
+}
+
+type MovementKind string
+
+const (
+	MovementReceipt    MovementKind = "receipt"
+	MovementSale       MovementKind = "sale"
+	MovementReturn     MovementKind = "return"
+	MovementAdjustment MovementKind = "adjustment"
+	MovementTransfer   MovementKind = "transfer"
+	MovementDamage     MovementKind = "damage"
+)
+
+// Manual reports whether staff may record movements of kind k by hand.
+// The others are recorded by the orders, returns and transfers that cause
+// them.
+func (k MovementKind) Manual() bool {
+	return k == MovementReceipt || k == MovementAdjustment || k == MovementDamage
+}
+
+// StockMovement is one change to the units a warehouse has on hand.
+// Quantity is signed: negative movements take units out. Seq is the
+// stock record's Seq once the movement is applied and OnHand its units
+// on hand then. Movements are never changed or removed; a mistake is put
+// right by another movement. Reference names what caused it, such as an
+// order, return, transfer or purchase order number. Receipts against a
+// purchase order carry the landed UnitCost of the units received.
+type StockMovement struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	ProductID   primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID   primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	WarehouseID primitive.ObjectID `bson:"warehouse_id,omitempty" json:"warehouse_id,omitempty"`
+	Kind        MovementKind       `bson:"kind,omitempty" json:"kind,omitempty"`
+	Quantity    int64              `bson:"quantity" json:"quantity"`
+	Seq         int64              `bson:"seq" json:"seq"`
+	OnHand      int64              `bson:"on_hand" json:"on_hand"`
+	Reference   string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	Actor       string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	Reason      string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	UnitCost    money.Money        `bson:"unit_cost,omitempty" json:"unit_cost,omitempty"`
+	At          time.Time          `bson:"at,omitempty" json:"at,omitempty"`
+}
+
+// StockSnapshot is the units a warehouse had on hand of a product once the
+// movements up to Seq were applied. Stock is derived from the latest
+// snapshot and the movements after it.
+type StockSnapshot struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	ProductID   primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID   primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	WarehouseID primitive.ObjectID `bson:"warehouse_id,omitempty" json:"warehouse_id,omitempty"`
+	OnHand      int64              `bson:"on_hand" json:"on_hand"`
+	Seq         int64              `bson:"seq" json:"seq"`
+	TakenAt     time.Time          `bson:"taken_at,omitempty" json:"taken_at,omitempty"`
+}
+
+// CycleCount is a physical count of some products in a warehouse,
+// reconciled against the ledger. When Applied, every discrepancy was
+// recorded as an adjustment.
+type CycleCount struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	WarehouseID primitive.ObjectID `bson:"warehouse_id,omitempty" json:"warehouse_id,omitempty"`
+	Lines       []CountLine        `bson:"lines,omitempty" json:"lines,omitempty"`
+	Applied     bool               `bson:"applied,omitempty" json:"applied,omitempty"`
+	Actor       string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	Reason      string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	CountedAt   time.Time          `bson:"counted_at,omitempty" json:"counted_at,omitempty"`
+}
+
+// CountLine is the count of one product. Expected is what should be on the
+// shelf: the units on hand by the ledger, plus AwaitingShipment units of
+// paid orders that have not been picked up yet. Discrepancy is Counted less
+// Expected. Drift is set when the stock record disagrees with the ledger;
+// applying the count resets the record to the ledger and records the reset
+// as an adjustment of no units.
+type CountLine struct {
+	ProductID        primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID        primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	Counted          int64              `bson:"counted" json:"counted"`
+	LedgerOnHand     int64              `bson:"ledger_on_hand" json:"ledger_on_hand"`
+	RecordedOnHand   int64              `bson:"recorded_on_hand" json:"recorded_on_hand"`
+	AwaitingShipment int64              `bson:"awaiting_shipment" json:"awaiting_shipment"`
+	Expected         int64              `bson:"expected" json:"expected"`
+	Discrepancy      int64              `bson:"discrepancy" json:"discrepancy"`
+	Drift            bool               `bson:"drift,omitempty" json:"drift,omitempty"`
+}
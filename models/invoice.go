@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	InvoiceTypeInvoice    = "invoice"
+	InvoiceTypeCreditNote = "credit_note"
+)
+
+// Invoice is a numbered tax document: the invoice raised when an order is
+// paid, or a credit note against it when money is refunded. Everything
+// printed on it is copied in when it is issued, so later changes to the
+// order, the address book or the seller's details do not change it.
+// Numbers run without gaps within each type and fiscal year.
+type Invoice struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Number          string             `bson:"number,omitempty" json:"number,omitempty"`
+	Type            string             `bson:"type,omitempty" json:"type,omitempty"`
+	FiscalYear      string             `bson:"fiscal_year,omitempty" json:"fiscal_year,omitempty"`
+	Sequence        int64              `bson:"sequence,omitempty" json:"sequence,omitempty"`
+	OrderID         primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	OrderNumber     string             `bson:"order_number,omitempty" json:"order_number,omitempty"`
+	RefundID        primitive.ObjectID `bson:"refund_id,omitempty" json:"refund_id,omitempty"`
+	OriginalInvoice string             `bson:"original_invoice,omitempty" json:"original_invoice,omitempty"`
+	UserID          string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Seller          Seller             `bson:"seller,omitempty" json:"seller,omitempty"`
+	BillTo          *Address           `bson:"bill_to,omitempty" json:"bill_to,omitempty"`
+	Currency        string             `bson:"currency,omitempty" json:"currency,omitempty"`
+	Lines           []InvoiceLine      `bson:"lines,omitempty" json:"lines,omitempty"`
+	Taxable         money.Money        `bson:"taxable,omitempty" json:"taxable,omitempty"`
+	Tax             money.Money        `bson:"tax,omitempty" json:"tax,omitempty"`
+	TaxBreakdown    []TaxComponent     `bson:"tax_breakdown,omitempty" json:"tax_breakdown,omitempty"`
+	Total           money.Money        `bson:"total,omitempty" json:"total,omitempty"`
+	Reason          string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	IssuedAt        time.Time          `bson:"issued_at,omitempty" json:"issued_at,omitempty"`
+	PDF             []byte             `bson:"pdf,omitempty" json:"-"`
+}
+
+// InvoiceLine is one line of an invoice. Amount is what the customer paid
+// for it: Taxable plus Tax.
+type InvoiceLine struct {
+	Description string         `bson:"description,omitempty" json:"description,omitempty"`
+	Quantity    uint           `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	UnitPrice   money.Money    `bson:"unit_price,omitempty" json:"unit_price,omitempty"`
+	Discount    money.Money    `bson:"discount,omitempty" json:"discount,omitempty"`
+	Taxable     money.Money    `bson:"taxable,omitempty" json:"taxable,omitempty"`
+	Tax         money.Money    `bson:"tax,omitempty" json:"tax,omitempty"`
+	Taxes       []TaxComponent `bson:"taxes,omitempty" json:"taxes,omitempty"`
+	Amount      money.Money    `bson:"amount,omitempty" json:"amount,omitempty"`
+}
+
+// Seller is who issues the invoices, as printed on them.
+type Seller struct {
+	Name    string `bson:"name,omitempty" json:"name,omitempty"`
+	Address string `bson:"address,omitempty" json:"address,omitempty"`
+	TaxID   string `bson:"tax_id,omitempty" json:"tax_id,omitempty"`
+	State   string `bson:"state,omitempty" json:"state,omitempty"`
+	Country string `bson:"country,omitempty" json:"country,omitempty"`
+}
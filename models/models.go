@@ -1,8 +1,10 @@
 package models
 
 import (
+	"strconv"
 	"time"
 
+	"github.com/kshzz24/ecomm-go/money"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -18,28 +20,69 @@ type User struct {
 	CreatedAt      time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
 	UpdatedAt      time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
 	UserID         string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	UserType       string             `bson:"user_type,omitempty" json:"user_type,omitempty"`
 	UserCart       []ProductUser      `bson:"usercart,omitempty" json:"usercart,omitempty"`
 	AddressDetails []Address          `bson:"address,omitempty" json:"address,omitempty"`
-	OrderStatus    []Order            `bson:"orders,omitempty" json:"orders,omitempty"`
+	Currency       string             `bson:"currency,omitempty" json:"currency,omitempty"`
 }
+
+const (
+	UserTypeAdmin = "ADMIN"
+	UserTypeStaff = "STAFF"
+	UserTypeUser  = "USER"
+)
+
 type LoginInput struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=8"`
 }
+
+// Product is a catalog entry. Price is its base price; Prices holds the
+// prices set explicitly for other currencies, and any currency without one
+// is converted from Price through the exchange-rate table. Weight, in
+// grams, and Dimensions are of the product as packed for shipping.
+// A product sold in several sizes or colours lists them in Options and
+// has one Variant per combination; a product without variants is sold as
+// it is. CategoryIDs are the categories the product is listed in, the
+// first being its primary category, whose slug Category holds; Attributes
+// follow the attribute schemas of those categories. BrandID is the brand
+// the product is sold under. Availability is filled in from the inventory
+// when products are listed and is not stored.
 type Product struct {
-	ProductID   primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
-	ProductName string             `bson:"product_name,omitempty" json:"product_name,omitempty"`
-	Price       uint64             `bson:"price,omitempty" json:"price,omitempty"`
-	Rating      uint               `bson:"rating,omitempty" json:"rating,omitempty"`
-	Image       string             `bson:"image,omitempty" json:"image,omitempty"`
+	ProductID    primitive.ObjectID     `bson:"_id,omitempty" json:"_id,omitempty"`
+	ProductName  string                 `bson:"product_name,omitempty" json:"product_name,omitempty"`
+	Price        money.Money            `bson:"price,omitempty" json:"price,omitempty"`
+	Prices       []money.Money          `bson:"prices,omitempty" json:"prices,omitempty"`
+	Rating       uint                   `bson:"rating,omitempty" json:"rating,omitempty"`
+	Image        string                 `bson:"image,omitempty" json:"image,omitempty"`
+	Category     string                 `bson:"category,omitempty" json:"category,omitempty"`
+	CategoryIDs  []primitive.ObjectID   `bson:"category_ids,omitempty" json:"category_ids,omitempty"`
+	Attributes   map[string]interface{} `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	BrandID      primitive.ObjectID     `bson:"brand_id,omitempty" json:"brand_id,omitempty"`
+	TaxClass     string                 `bson:"tax_class,omitempty" json:"tax_class,omitempty"`
+	Weight       uint                   `bson:"weight_grams,omitempty" json:"weight_grams,omitempty"`
+	Dimensions   *Dimensions            `bson:"dimensions,omitempty" json:"dimensions,omitempty"`
+	Options      []ProductOption        `bson:"options,omitempty" json:"options,omitempty"`
+	Variants     []Variant              `bson:"variants,omitempty" json:"variants,omitempty"`
+	Availability *Availability          `bson:"-" json:"availability,omitempty"`
 }
 
+// ProductUser is a unit of a product in a user's cart. VariantID, SKU and
+// VariantName name the variant picked, for products that have them.
 type ProductUser struct {
 	ProductID   primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID   primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	SKU         string             `bson:"sku,omitempty" json:"sku,omitempty"`
 	ProductName string             `bson:"product_name,omitempty" json:"product_name,omitempty"`
-	Price       uint64             `bson:"price,omitempty" json:"price,omitempty"`
+	VariantName string             `bson:"variant_name,omitempty" json:"variant_name,omitempty"`
+	Price       money.Money        `bson:"price,omitempty" json:"price,omitempty"`
+	Prices      []money.Money      `bson:"prices,omitempty" json:"prices,omitempty"`
 	Rating      uint               `bson:"rating,omitempty" json:"rating,omitempty"`
 	Image       string             `bson:"image,omitempty" json:"image,omitempty"`
+	Category    string             `bson:"category,omitempty" json:"category,omitempty"`
+	TaxClass    string             `bson:"tax_class,omitempty" json:"tax_class,omitempty"`
+	Weight      uint               `bson:"weight_grams,omitempty" json:"weight_grams,omitempty"`
+	Dimensions  *Dimensions        `bson:"dimensions,omitempty" json:"dimensions,omitempty"`
 }
 
 type Address struct {
@@ -48,18 +91,19 @@ type Address struct {
 	Street    string             `bson:"street_name,omitempty" json:"street_name,omitempty"`
 	City      string             `bson:"city_name,omitempty" json:"city_name,omitempty"`
 	Pincode   uint16             `bson:"pin_code,omitempty" json:"pin_code,omitempty"`
+	State     string             `bson:"state,omitempty" json:"state,omitempty"`
+	Country   string             `bson:"country,omitempty" json:"country,omitempty"`
+	Postcode  string             `bson:"postal_code,omitempty" json:"postal_code,omitempty"`
 }
 
-type Order struct {
-	OrderID       primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
-	OrderCart     []ProductUser      `bson:"order_list,omitempty" json:"order_list,omitempty"`
-	OrderedAt     time.Time          `bson:"ordered_at,omitempty" json:"ordered_at,omitempty"`
-	Price         uint64             `bson:"total_price,omitempty" json:"total_price,omitempty"`
-	Discount      uint64             `bson:"discount,omitempty" json:"discount,omitempty"`
-	PaymentMethod Payment            `bson:"payment_method,omitempty" json:"payment_method,omitempty"`
-}
-
-type Payment struct {
-	Digital bool `bson:"digital,omitempty" json:"digital,omitempty"`
-	COD     bool `bson:"cod,omitempty" json:"cod,omitempty"`
+// PostalCode is the address's postal code: Postcode, which holds codes of
+// any country, or the older numeric Pincode.
+func (a Address) PostalCode() string {
+	if a.Postcode != "" {
+		return a.Postcode
+	}
+	if a.Pincode != 0 {
+		return strconv.FormatUint(uint64(a.Pincode), 10)
+	}
+	return ""
 }
@@ -0,0 +1,354 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OrderStatus string
+
+const (
+	OrderPendingPayment   OrderStatus = "pending_payment"
+	OrderPaid             OrderStatus = "paid"
+	OrderPacked           OrderStatus = "packed"
+	OrderPartiallyShipped OrderStatus = "partially_shipped"
+	OrderShipped          OrderStatus = "shipped"
+	OrderDelivered        OrderStatus = "delivered"
+	OrderCancelled        OrderStatus = "cancelled"
+	OrderReturned         OrderStatus = "returned"
+	OrderRefunded         OrderStatus = "refunded"
+)
+
+// orderTransitions lists, for every status, the statuses an order may move
+// to next. It is the single source of truth for the order lifecycle; a
+// status missing from the map is terminal.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPendingPayment:   {OrderPaid, OrderCancelled},
+	OrderPaid:             {OrderPacked, OrderCancelled},
+	OrderPacked:           {OrderPartiallyShipped, OrderShipped, OrderCancelled},
+	OrderPartiallyShipped: {OrderShipped, OrderCancelled},
+	OrderShipped:          {OrderDelivered, OrderReturned, OrderCancelled},
+	OrderDelivered:        {OrderReturned},
+	OrderCancelled:        {OrderRefunded},
+	OrderReturned:         {OrderRefunded},
+}
+
+// CustomerCancellable reports whether a customer may still cancel an order
+// in status s themselves. Once an order is packed only an admin can.
+func (s OrderStatus) CustomerCancellable() bool {
+	return s == OrderPendingPayment || s == OrderPaid
+}
+
+// AdminCancellable reports whether an admin may cancel an order in status s,
+// which is any time before delivery.
+func (s OrderStatus) AdminCancellable() bool {
+	return s.CanTransitionTo(OrderCancelled)
+}
+
+// Valid reports whether s is one of the known order statuses.
+func (s OrderStatus) Valid() bool {
+	if _, ok := orderTransitions[s]; ok {
+		return true
+	}
+	return s == OrderRefunded
+}
+
+// Manual reports whether an admin may move an order to s by setting its
+// status. Cancelled, returned and refunded are reached only through the
+// cancel, return and refund flows, which also release stock and repay the
+// customer.
+func (s OrderStatus) Manual() bool {
+	return s != OrderCancelled && s != OrderReturned && s != OrderRefunded
+}
+
+// CanTransitionTo reports whether an order in status s may move to next.
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Order is a placed order. Orders live in their own collection; line items
+// and the shipping address are copied in at checkout so later product or
+// address edits do not change what was bought.
+type Order struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	OrderNumber      string             `bson:"order_number,omitempty" json:"order_number,omitempty"`
+	UserID           string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Currency         string             `bson:"currency,omitempty" json:"currency,omitempty"`
+	ExchangeRates    []AppliedRate      `bson:"exchange_rates,omitempty" json:"exchange_rates,omitempty"`
+	Items            []OrderItem        `bson:"items,omitempty" json:"items,omitempty"`
+	ShippingAddress  *Address           `bson:"shipping_address,omitempty" json:"shipping_address,omitempty"`
+	Subtotal         money.Money        `bson:"subtotal,omitempty" json:"subtotal,omitempty"`
+	Discount         money.Money        `bson:"discount,omitempty" json:"discount,omitempty"`
+	Promotions       []AppliedPromotion `bson:"promotions,omitempty" json:"promotions,omitempty"`
+	ShippingMethod   string             `bson:"shipping_method,omitempty" json:"shipping_method,omitempty"`
+	ShippingZone     string             `bson:"shipping_zone,omitempty" json:"shipping_zone,omitempty"`
+	Weight           uint               `bson:"weight_grams,omitempty" json:"weight_grams,omitempty"`
+	Shipping         money.Money        `bson:"shipping,omitempty" json:"shipping,omitempty"`
+	ShippingTax      money.Money        `bson:"shipping_tax,omitempty" json:"shipping_tax,omitempty"`
+	ShippingTaxes    []TaxComponent     `bson:"shipping_taxes,omitempty" json:"shipping_taxes,omitempty"`
+	Tax              money.Money        `bson:"tax,omitempty" json:"tax,omitempty"`
+	TaxInclusive     bool               `bson:"tax_inclusive,omitempty" json:"tax_inclusive,omitempty"`
+	TaxBreakdown     []TaxComponent     `bson:"tax_breakdown,omitempty" json:"tax_breakdown,omitempty"`
+	Total            money.Money        `bson:"total_price,omitempty" json:"total_price,omitempty"`
+	Status           OrderStatus        `bson:"status,omitempty" json:"status,omitempty"`
+	StatusHistory    []StatusChange     `bson:"status_history,omitempty" json:"status_history,omitempty"`
+	Cancellations    []Cancellation     `bson:"cancellations,omitempty" json:"cancellations,omitempty"`
+	CancelledAmount  money.Money        `bson:"cancelled_amount,omitempty" json:"cancelled_amount,omitempty"`
+	Tenders          []Tender           `bson:"tenders,omitempty" json:"tenders,omitempty"`
+	PaidAmount       money.Money        `bson:"paid_amount,omitempty" json:"paid_amount,omitempty"`
+	RefundedAmount   money.Money        `bson:"refunded_amount,omitempty" json:"refunded_amount,omitempty"`
+	RefundedShipping money.Money        `bson:"refunded_shipping,omitempty" json:"refunded_shipping,omitempty"`
+	PaymentMethod    Payment            `bson:"payment_method,omitempty" json:"payment_method,omitempty"`
+	InvoiceNumber    string             `bson:"invoice_number,omitempty" json:"invoice_number,omitempty"`
+	NothingToInvoice bool               `bson:"nothing_to_invoice,omitempty" json:"nothing_to_invoice,omitempty"`
+	ReservedUntil    time.Time          `bson:"reserved_until,omitempty" json:"reserved_until,omitempty"`
+	OrderedAt        time.Time          `bson:"ordered_at,omitempty" json:"ordered_at,omitempty"`
+	UpdatedAt        time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// OrderItem is one line of an order: a product, or one variant of it, and
+// how many were bought at the unit price in force at checkout. Tax is the
+// tax on the line after its discount; with TaxInclusive it is part of
+// LineTotal rather than charged on top of it. Weight is what one unit is
+// charged for shipping, in grams.
+//
+// Allocated counts the units put in a shipment, Shipped those of them a
+// carrier has picked up and Delivered those that arrived. Backordered
+// units are waiting for stock and are left out of new shipments until an
+// admin ships them explicitly.
+//
+// Reserved counts the units holding stock for the order until it is paid,
+// and Deducted those taken out of stock since, both in the warehouse
+// WarehouseID picked to fulfil the line. All three stay zero for products
+// whose stock is not tracked.
+type OrderItem struct {
+	LineID       primitive.ObjectID `bson:"line_id,omitempty" json:"line_id,omitempty"`
+	ProductID    primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID    primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	SKU          string             `bson:"sku,omitempty" json:"sku,omitempty"`
+	ProductName  string             `bson:"product_name,omitempty" json:"product_name,omitempty"`
+	VariantName  string             `bson:"variant_name,omitempty" json:"variant_name,omitempty"`
+	Image        string             `bson:"image,omitempty" json:"image,omitempty"`
+	Price        money.Money        `bson:"price,omitempty" json:"price,omitempty"`
+	Quantity     uint               `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	LineTotal    money.Money        `bson:"line_total,omitempty" json:"line_total,omitempty"`
+	Discount     money.Money        `bson:"discount,omitempty" json:"discount,omitempty"`
+	Category     string             `bson:"category,omitempty" json:"category,omitempty"`
+	TaxClass     string             `bson:"tax_class,omitempty" json:"tax_class,omitempty"`
+	Weight       uint               `bson:"weight_grams,omitempty" json:"weight_grams,omitempty"`
+	Tax          money.Money        `bson:"tax,omitempty" json:"tax,omitempty"`
+	Taxes        []TaxComponent     `bson:"taxes,omitempty" json:"taxes,omitempty"`
+	TaxInclusive bool               `bson:"tax_inclusive,omitempty" json:"tax_inclusive,omitempty"`
+	Cancelled    uint               `bson:"cancelled_quantity,omitempty" json:"cancelled_quantity,omitempty"`
+	Allocated    uint               `bson:"allocated_quantity,omitempty" json:"allocated_quantity,omitempty"`
+	Shipped      uint               `bson:"shipped_quantity,omitempty" json:"shipped_quantity,omitempty"`
+	Delivered    uint               `bson:"delivered_quantity,omitempty" json:"delivered_quantity,omitempty"`
+	Backordered  uint               `bson:"backordered_quantity,omitempty" json:"backordered_quantity,omitempty"`
+	Reserved     uint               `bson:"reserved_quantity,omitempty" json:"reserved_quantity,omitempty"`
+	Deducted     uint               `bson:"deducted_quantity,omitempty" json:"deducted_quantity,omitempty"`
+	WarehouseID  primitive.ObjectID `bson:"warehouse_id,omitempty" json:"warehouse_id,omitempty"`
+	Returned     uint               `bson:"returned_quantity,omitempty" json:"returned_quantity,omitempty"`
+	Refunded     uint               `bson:"refunded_quantity,omitempty" json:"refunded_quantity,omitempty"`
+}
+
+// Name is the line's product as it is shown on invoices and packing
+// lists, with the variant picked, such as "T-shirt (M / Blue)".
+func (item OrderItem) Name() string {
+	if item.VariantName == "" {
+		return item.ProductName
+	}
+	return item.ProductName + " (" + item.VariantName + ")"
+}
+
+// NetAmount is what quantity units of the line cost after the line's
+// share of the order's discounts, tax included.
+func (item OrderItem) NetAmount(quantity uint) money.Money {
+	net := item.LineTotal.Sub(item.Discount)
+	if !item.TaxInclusive {
+		net = net.Add(item.Tax)
+	}
+	if quantity == item.Quantity || item.Quantity == 0 {
+		return net
+	}
+	return net.MulFrac(int64(quantity), int64(item.Quantity), money.HalfUp)
+}
+
+// ActiveQuantity is how many units of the line are still to be fulfilled.
+func (item OrderItem) ActiveQuantity() uint {
+	return item.Quantity - item.Cancelled
+}
+
+// UnallocatedQuantity is how many active units of the line are not in a
+// shipment yet.
+func (item OrderItem) UnallocatedQuantity() uint {
+	if item.Allocated >= item.ActiveQuantity() {
+		return 0
+	}
+	return item.ActiveQuantity() - item.Allocated
+}
+
+// ShippableQuantity is how many units of the line a new shipment takes by
+// default: those not in a shipment yet and not backordered.
+func (item OrderItem) ShippableQuantity() uint {
+	unallocated := item.UnallocatedQuantity()
+	if item.Backordered >= unallocated {
+		return 0
+	}
+	return unallocated - item.Backordered
+}
+
+// Fulfilment summarizes where the line's units are.
+func (item OrderItem) Fulfilment() LineFulfilment {
+	active := item.ActiveQuantity()
+	fulfilment := LineFulfilment{
+		LineID:      item.LineID,
+		WarehouseID: item.WarehouseID,
+		Quantity:    active,
+		Allocated:   min(item.Allocated, active),
+		Shipped:     min(item.Shipped, active),
+		Delivered:   min(item.Delivered, active),
+		Backordered: min(item.Backordered, item.UnallocatedQuantity()),
+	}
+	switch {
+	case active == 0:
+		fulfilment.Status = LineCancelled
+	case fulfilment.Delivered == active:
+		fulfilment.Status = LineDelivered
+	case fulfilment.Shipped == active:
+		fulfilment.Status = LineShipped
+	case fulfilment.Shipped > 0:
+		fulfilment.Status = LinePartiallyShipped
+	case fulfilment.Backordered > 0:
+		fulfilment.Status = LineBackordered
+	case fulfilment.Allocated > 0:
+		fulfilment.Status = LineAwaitingPickup
+	default:
+		fulfilment.Status = LineUnfulfilled
+	}
+	return fulfilment
+}
+
+// ReturnableQuantity is how many units of the line are not cancelled and
+// not already part of an open or accepted return.
+func (item OrderItem) ReturnableQuantity() uint {
+	return item.ActiveQuantity() - item.Returned
+}
+
+// ShippingCharged is the order's shipping less what promotions took off
+// it, before tax.
+func (order Order) ShippingCharged() money.Money {
+	discount := order.Discount.In(order.Currency)
+	for _, item := range order.Items {
+		discount = discount.Sub(item.Discount)
+	}
+	return money.Max(order.Shipping.In(order.Currency).Sub(discount), money.Zero(order.Currency))
+}
+
+// Fulfilment summarizes where the units of each of the order's lines are.
+func (order Order) Fulfilment() []LineFulfilment {
+	lines := make([]LineFulfilment, 0, len(order.Items))
+	for _, item := range order.Items {
+		lines = append(lines, item.Fulfilment())
+	}
+	return lines
+}
+
+// FulfilledStatus is the status the order's shipments put it in: delivered
+// once every active unit has arrived, shipped once all of them are on
+// their way and partially shipped once some are. It is empty while no
+// unit has left yet.
+func (order Order) FulfilledStatus() OrderStatus {
+	var active, shipped, delivered uint
+	for _, item := range order.Items {
+		quantity := item.ActiveQuantity()
+		active += quantity
+		shipped += min(item.Shipped, quantity)
+		delivered += min(item.Delivered, quantity)
+	}
+	switch {
+	case active == 0 || shipped == 0:
+		return ""
+	case delivered == active:
+		return OrderDelivered
+	case shipped == active:
+		return OrderShipped
+	default:
+		return OrderPartiallyShipped
+	}
+}
+
+// AmountDue is how much of the order is still to be paid.
+func (order Order) AmountDue() money.Money {
+	due := order.Total.Sub(order.CancelledAmount).Sub(order.PaidAmount)
+	return money.Max(due, money.Zero(order.Currency))
+}
+
+// RefundableAmount is how much of what was paid for the order has not been
+// refunded yet.
+func (order Order) RefundableAmount() money.Money {
+	return order.PaidAmount.Sub(order.RefundedAmount).In(order.Currency)
+}
+
+// DeliveredAt is when the order was last marked delivered, or the zero time
+// if it never was.
+func (order Order) DeliveredAt() time.Time {
+	for i := len(order.StatusHistory) - 1; i >= 0; i-- {
+		if order.StatusHistory[i].Status == OrderDelivered {
+			return order.StatusHistory[i].At
+		}
+	}
+	return time.Time{}
+}
+
+const (
+	RefundNotRequired = "not_required"
+	RefundPending     = "pending"
+	RefundCompleted   = "refunded"
+	RefundPartial     = "partially_refunded"
+	RefundFailed      = "failed"
+)
+
+// Cancellation records one cancellation of some or all of an order's lines.
+// RefundStatus is pending when money had already been taken for the order
+// and has to be paid back.
+type Cancellation struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Lines        []CancelLine       `bson:"lines,omitempty" json:"lines,omitempty"`
+	Amount       money.Money        `bson:"amount,omitempty" json:"amount,omitempty"`
+	Actor        string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	Reason       string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	RefundStatus string             `bson:"refund_status,omitempty" json:"refund_status,omitempty"`
+	RefundAmount money.Money        `bson:"refund_amount,omitempty" json:"refund_amount,omitempty"`
+	At           time.Time          `bson:"at,omitempty" json:"at,omitempty"`
+}
+
+// CancelLine is a quantity of one order line to cancel.
+type CancelLine struct {
+	LineID   primitive.ObjectID `bson:"line_id,omitempty" json:"line_id,omitempty"`
+	Quantity uint               `bson:"quantity,omitempty" json:"quantity,omitempty"`
+}
+
+// StatusChange records one step of an order's lifecycle. Actor is the id of
+// the user who made the change, or "system" for automatic transitions.
+type StatusChange struct {
+	Status OrderStatus `bson:"status,omitempty" json:"status,omitempty"`
+	At     time.Time   `bson:"at,omitempty" json:"at,omitempty"`
+	Actor  string      `bson:"actor,omitempty" json:"actor,omitempty"`
+	Note   string      `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+// OrderFilter narrows an admin order search. Zero values are ignored.
+type OrderFilter struct {
+	Status      OrderStatus
+	UserID      string
+	WarehouseID primitive.ObjectID
+	From        time.Time
+	To          time.Time
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PaymentCOD    = "cod"
+	PaymentOnline = "online"
+
+	// DefaultCurrency is the currency of base prices and of orders placed
+	// without a currency being picked.
+	DefaultCurrency = money.DefaultCurrency
+)
+
+// Payment is how an order is paid for: cash on delivery, or online through
+// Provider, in which case IntentID points at the PaymentIntent.
+type Payment struct {
+	Method   string             `bson:"method,omitempty" json:"method,omitempty"`
+	Provider string             `bson:"provider,omitempty" json:"provider,omitempty"`
+	IntentID primitive.ObjectID `bson:"intent_id,omitempty" json:"intent_id,omitempty"`
+}
+
+type IntentStatus string
+
+const (
+	IntentCreated        IntentStatus = "created"
+	IntentRequiresAction IntentStatus = "requires_action"
+	IntentProcessing     IntentStatus = "processing"
+	IntentAuthorized     IntentStatus = "authorized"
+	IntentCaptured       IntentStatus = "captured"
+	IntentFailed         IntentStatus = "failed"
+	IntentVoided         IntentStatus = "voided"
+	IntentRefunded       IntentStatus = "refunded"
+)
+
+// PaymentIntent tracks one attempt to collect an order's payment through a
+// provider. Reference is the provider's own id for the payment. An intent
+// left processing (the provider timed out) is settled by a webhook.
+type PaymentIntent struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	OrderID       primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	UserID        string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Provider      string             `bson:"provider,omitempty" json:"provider,omitempty"`
+	Reference     string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	Amount        money.Money        `bson:"amount,omitempty" json:"amount,omitempty"`
+	Currency      string             `bson:"currency,omitempty" json:"currency,omitempty"`
+	Status        IntentStatus       `bson:"status,omitempty" json:"status,omitempty"`
+	ActionURL     string             `bson:"action_url,omitempty" json:"action_url,omitempty"`
+	FailureReason string             `bson:"failure_reason,omitempty" json:"failure_reason,omitempty"`
+	Captured      money.Money        `bson:"captured,omitempty" json:"captured,omitempty"`
+	Refunded      money.Money        `bson:"refunded,omitempty" json:"refunded,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt     time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// CheckoutRequest carries the customer's choices at checkout. It binds from
+// the query string or a JSON body. Currency, when empty, is taken from the
+// CurrencyHeader or the user's preference. ShippingMethod defaults to
+// standard delivery.
+type CheckoutRequest struct {
+	AddressID      string      `form:"addressId" json:"address_id"`
+	PaymentMethod  string      `form:"payment_method" json:"payment_method"`
+	Provider       string      `form:"provider" json:"provider"`
+	PaymentToken   string      `form:"payment_token" json:"payment_token"`
+	GiftCardCode   string      `form:"gift_card" json:"gift_card"`
+	WalletAmount   money.Money `form:"wallet_amount" json:"wallet_amount"`
+	Currency       string      `form:"currency" json:"currency"`
+	Coupons        []string    `form:"coupon" json:"coupons"`
+	ShippingMethod string      `form:"shipping_method" json:"shipping_method"`
+}
+
+// PaymentEvent is a verified provider webhook, stored verbatim for audit
+// and so it can be replayed. Provider and EventID are unique together,
+// which is what deduplicates redelivered webhooks.
+type PaymentEvent struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Provider     string             `bson:"provider,omitempty" json:"provider,omitempty"`
+	EventID      string             `bson:"event_id,omitempty" json:"event_id,omitempty"`
+	Type         string             `bson:"type,omitempty" json:"type,omitempty"`
+	Reference    string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	Amount       money.Money        `bson:"amount,omitempty" json:"amount,omitempty"`
+	Payload      string             `bson:"payload,omitempty" json:"payload,omitempty"`
+	Signature    string             `bson:"signature,omitempty" json:"signature,omitempty"`
+	ReceivedAt   time.Time          `bson:"received_at,omitempty" json:"received_at,omitempty"`
+	ProcessedAt  time.Time          `bson:"processed_at,omitempty" json:"processed_at,omitempty"`
+	Attempts     int                `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	ProcessError string             `bson:"process_error,omitempty" json:"process_error,omitempty"`
+}
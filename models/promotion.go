@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PromotionType string
+
+const (
+	// PromotionPercentOff takes PercentOff percent off the lines in scope.
+	PromotionPercentOff PromotionType = "percent_off"
+	// PromotionAmountOff takes AmountOff off the lines in scope.
+	PromotionAmountOff PromotionType = "amount_off"
+	// PromotionBuyXGetY discounts the GetQuantity cheapest of every
+	// BuyQuantity+GetQuantity units in scope by GetPercent percent.
+	PromotionBuyXGetY PromotionType = "buy_x_get_y"
+	// PromotionFreeShipping waives the shipping charge.
+	PromotionFreeShipping PromotionType = "free_shipping"
+)
+
+// Promotion is a discount rule. One with a Code is a coupon and applies
+// only when the customer enters it; one without is automatic and applies to
+// every cart it qualifies for.
+//
+// ProductIDs and Categories scope the discount to matching lines; leaving
+// both empty scopes it to the whole cart. Amounts in a currency other than
+// the cart's are converted at the exchange rate in force. UsageLimit caps
+// the orders the promotion is used on and PerUserLimit the orders of each
+// customer; zero means no limit. A Stackable promotion combines with other
+// stackable ones; any other promotion is used on its own.
+type Promotion struct {
+	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"_id,omitempty"`
+	Name         string               `bson:"name,omitempty" json:"name,omitempty"`
+	Code         string               `bson:"code,omitempty" json:"code,omitempty"`
+	Type         PromotionType        `bson:"type,omitempty" json:"type,omitempty"`
+	PercentOff   uint                 `bson:"percent_off,omitempty" json:"percent_off,omitempty"`
+	AmountOff    money.Money          `bson:"amount_off,omitempty" json:"amount_off,omitempty"`
+	BuyQuantity  uint                 `bson:"buy_quantity,omitempty" json:"buy_quantity,omitempty"`
+	GetQuantity  uint                 `bson:"get_quantity,omitempty" json:"get_quantity,omitempty"`
+	GetPercent   uint                 `bson:"get_percent,omitempty" json:"get_percent,omitempty"`
+	ProductIDs   []primitive.ObjectID `bson:"product_ids,omitempty" json:"product_ids,omitempty"`
+	Categories   []string             `bson:"categories,omitempty" json:"categories,omitempty"`
+	MinSubtotal  money.Money          `bson:"min_subtotal,omitempty" json:"min_subtotal,omitempty"`
+	UsageLimit   uint                 `bson:"usage_limit,omitempty" json:"usage_limit,omitempty"`
+	PerUserLimit uint                 `bson:"per_user_limit,omitempty" json:"per_user_limit,omitempty"`
+	Uses         uint                 `bson:"uses" json:"uses"`
+	Stackable    bool                 `bson:"stackable" json:"stackable"`
+	Priority     int                  `bson:"priority,omitempty" json:"priority,omitempty"`
+	Active       bool                 `bson:"active" json:"active"`
+	StartsAt     time.Time            `bson:"starts_at,omitempty" json:"starts_at,omitempty"`
+	EndsAt       time.Time            `bson:"ends_at,omitempty" json:"ends_at,omitempty"`
+	CreatedBy    string               `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt    time.Time            `bson:"created_at,omitempty" json:"created_at,omitempty"`
+}
+
+// Live reports whether p is active and inside its validity window at now.
+func (p Promotion) Live(now time.Time) bool {
+	if !p.Active {
+		return false
+	}
+	if !p.StartsAt.IsZero() && now.Before(p.StartsAt) {
+		return false
+	}
+	return p.EndsAt.IsZero() || now.Before(p.EndsAt)
+}
+
+// AppliedPromotion is a promotion as used on an order, with what it took
+// off.
+type AppliedPromotion struct {
+	PromotionID  primitive.ObjectID `bson:"promotion_id,omitempty" json:"promotion_id,omitempty"`
+	Name         string             `bson:"name,omitempty" json:"name,omitempty"`
+	Code         string             `bson:"code,omitempty" json:"code,omitempty"`
+	Type         PromotionType      `bson:"type,omitempty" json:"type,omitempty"`
+	Amount       money.Money        `bson:"amount,omitempty" json:"amount,omitempty"`
+	FreeShipping bool               `bson:"free_shipping,omitempty" json:"free_shipping,omitempty"`
+}
+
+// PromotionRedemption records that a promotion was used on an order; the
+// per-customer limits are counted from these.
+type PromotionRedemption struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	PromotionID primitive.ObjectID `bson:"promotion_id,omitempty" json:"promotion_id,omitempty"`
+	UserID      string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	OrderID     primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	Amount      money.Money        `bson:"amount,omitempty" json:"amount,omitempty"`
+	At          time.Time          `bson:"at,omitempty" json:"at,omitempty"`
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Supplier is who stock is bought from. Products lists the supplier's cost
+// price for each product they sell us, in Currency; a purchase order line
+// without a unit cost is priced from it. LeadTimeDays is how long the
+// supplier usually takes to deliver.
+type Supplier struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Code         string             `bson:"code,omitempty" json:"code,omitempty"`
+	Name         string             `bson:"name,omitempty" json:"name,omitempty"`
+	Email        string             `bson:"email,omitempty" json:"email,omitempty"`
+	Phone        string             `bson:"phone,omitempty" json:"phone,omitempty"`
+	Address      Address            `bson:"address,omitempty" json:"address,omitempty"`
+	Currency     string             `bson:"currency,omitempty" json:"currency,omitempty"`
+	LeadTimeDays int64              `bson:"lead_time_days,omitempty" json:"lead_time_days,omitempty"`
+	Products     []SupplierProduct  `bson:"products,omitempty" json:"products,omitempty"`
+	Active       bool               `bson:"active" json:"active"`
+	CreatedAt    time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt    time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// SupplierProduct is what a supplier charges for one product, and the
+// fewest units they take an order for. Without a VariantID it covers every
+// variant of the product that has no entry of its own.
+type SupplierProduct struct {
+	ProductID        primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID        primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	SupplierSKU      string             `bson:"supplier_sku,omitempty" json:"supplier_sku,omitempty"`
+	Cost             money.Money        `bson:"cost,omitempty" json:"cost,omitempty"`
+	MinOrderQuantity int64              `bson:"min_order_quantity,omitempty" json:"min_order_quantity,omitempty"`
+}
+
+// CostOf returns what s charges for productID, or its variant variantID,
+// and whether s sells it.
+func (s Supplier) CostOf(productID, variantID primitive.ObjectID) (SupplierProduct, bool) {
+	var offer SupplierProduct
+	found := false
+	for _, product := range s.Products {
+		if product.ProductID != productID {
+			continue
+		}
+		if product.VariantID == variantID {
+			return product, true
+		}
+		if product.VariantID.IsZero() {
+			offer, found = product, true
+		}
+	}
+	return offer, found
+}
+
+type PurchaseOrderStatus string
+
+const (
+	PurchaseOrderDraft             PurchaseOrderStatus = "draft"
+	PurchaseOrderSent              PurchaseOrderStatus = "sent"
+	PurchaseOrderPartiallyReceived PurchaseOrderStatus = "partially_received"
+	PurchaseOrderReceived          PurchaseOrderStatus = "received"
+	PurchaseOrderClosed            PurchaseOrderStatus = "closed"
+)
+
+// PurchaseOrderTransitions lists the statuses a purchase order may move to
+// by hand from each status. Receiving moves a sent order on to
+// partially_received and received by itself.
+var PurchaseOrderTransitions = map[PurchaseOrderStatus][]PurchaseOrderStatus{
+	PurchaseOrderDraft:             {PurchaseOrderSent, PurchaseOrderClosed},
+	PurchaseOrderSent:              {PurchaseOrderClosed},
+	PurchaseOrderPartiallyReceived: {PurchaseOrderClosed},
+	PurchaseOrderReceived:          {PurchaseOrderClosed},
+}
+
+// CanTransition reports whether a purchase order in from may be moved to
+// to by hand.
+func (from PurchaseOrderStatus) CanTransition(to PurchaseOrderStatus) bool {
+	for _, next := range PurchaseOrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Open reports whether goods can still be received against a purchase
+// order in s.
+func (s PurchaseOrderStatus) Open() bool {
+	return s == PurchaseOrderSent || s == PurchaseOrderPartiallyReceived
+}
+
+// PurchaseOrder buys stock from a supplier for delivery to one warehouse
+// by ExpectedAt. Its lines are priced in the supplier's currency.
+// AdditionalCosts are the freight, duty and other charges of the whole
+// order; they are spread over the lines by value into each line's
+// LandedUnitCost, which is what a unit really cost us.
+type PurchaseOrder struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"_id,omitempty"`
+	PONumber        string              `bson:"po_number,omitempty" json:"po_number,omitempty"`
+	SupplierID      primitive.ObjectID  `bson:"supplier_id,omitempty" json:"supplier_id,omitempty"`
+	WarehouseID     primitive.ObjectID  `bson:"warehouse_id,omitempty" json:"warehouse_id,omitempty"`
+	Currency        string              `bson:"currency,omitempty" json:"currency,omitempty"`
+	Status          PurchaseOrderStatus `bson:"status,omitempty" json:"status,omitempty"`
+	Lines           []PurchaseOrderLine `bson:"lines,omitempty" json:"lines,omitempty"`
+	Subtotal        money.Money         `bson:"subtotal,omitempty" json:"subtotal,omitempty"`
+	AdditionalCosts money.Money         `bson:"additional_costs,omitempty" json:"additional_costs,omitempty"`
+	Total           money.Money         `bson:"total,omitempty" json:"total,omitempty"`
+	ExpectedAt      time.Time           `bson:"expected_at,omitempty" json:"expected_at,omitempty"`
+	Receipts        []PurchaseReceipt   `bson:"receipts,omitempty" json:"receipts,omitempty"`
+	Note            string              `bson:"note,omitempty" json:"note,omitempty"`
+	Actor           string              `bson:"actor,omitempty" json:"actor,omitempty"`
+	CreatedAt       time.Time           `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	SentAt          time.Time           `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+	ReceivedAt      time.Time           `bson:"received_at,omitempty" json:"received_at,omitempty"`
+	ClosedAt        time.Time           `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	UpdatedAt       time.Time           `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// PurchaseOrderLine is a quantity of one product, or one variant of it, on
+// a purchase order, and how much of it has arrived.
+type PurchaseOrderLine struct {
+	LineID           primitive.ObjectID `bson:"line_id,omitempty" json:"line_id,omitempty"`
+	ProductID        primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID        primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	SKU              string             `bson:"sku,omitempty" json:"sku,omitempty"`
+	ProductName      string             `bson:"product_name,omitempty" json:"product_name,omitempty"`
+	SupplierSKU      string             `bson:"supplier_sku,omitempty" json:"supplier_sku,omitempty"`
+	Quantity         int64              `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	ReceivedQuantity int64              `bson:"received_quantity" json:"received_quantity"`
+	UnitCost         money.Money        `bson:"unit_cost,omitempty" json:"unit_cost,omitempty"`
+	LineTotal        money.Money        `bson:"line_total,omitempty" json:"line_total,omitempty"`
+	LandedUnitCost   money.Money        `bson:"landed_unit_cost,omitempty" json:"landed_unit_cost,omitempty"`
+}
+
+// Outstanding is how many units of the line are still to arrive.
+func (l PurchaseOrderLine) Outstanding() int64 {
+	return max(l.Quantity-l.ReceivedQuantity, 0)
+}
+
+// PurchaseReceipt records one delivery against a purchase order.
+type PurchaseReceipt struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Lines      []ReceiptLine      `bson:"lines,omitempty" json:"lines,omitempty"`
+	Actor      string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	Note       string             `bson:"note,omitempty" json:"note,omitempty"`
+	ReceivedAt time.Time          `bson:"received_at,omitempty" json:"received_at,omitempty"`
+}
+
+// ReceiptLine is a quantity of one purchase order line that arrived.
+type ReceiptLine struct {
+	LineID   primitive.ObjectID `bson:"line_id,omitempty" json:"line_id,omitempty"`
+	Quantity int64              `bson:"quantity,omitempty" json:"quantity,omitempty"`
+}
+
+// PurchaseOrderFilter narrows a purchase order search. Zero values are
+// ignored. Overdue keeps open orders past their expected delivery.
+type PurchaseOrderFilter struct {
+	Status     PurchaseOrderStatus
+	SupplierID primitive.ObjectID
+	ProductID  primitive.ObjectID
+	Overdue    bool
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RefundFull     = "full"
+	RefundLines    = "lines"
+	RefundShipping = "shipping"
+	RefundGoodwill = "goodwill"
+)
+
+// Refund is money paid back against an order's original payment. Status
+// uses the same values as Cancellation.RefundStatus: pending while the
+// provider calls are in flight, then refunded or failed, or partially
+// refunded when some payouts were paid before another failed.
+type Refund struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	OrderID    primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	IntentID   primitive.ObjectID `bson:"intent_id,omitempty" json:"intent_id,omitempty"`
+	Kind       string             `bson:"kind,omitempty" json:"kind,omitempty"`
+	Lines      []RefundLine       `bson:"lines,omitempty" json:"lines,omitempty"`
+	Shipping   money.Money        `bson:"shipping,omitempty" json:"shipping,omitempty"`
+	Amount     money.Money        `bson:"amount,omitempty" json:"amount,omitempty"`
+	Method     string             `bson:"method,omitempty" json:"method,omitempty"`
+	Payouts    []RefundPayout     `bson:"payouts,omitempty" json:"payouts,omitempty"`
+	Status     string             `bson:"status,omitempty" json:"status,omitempty"`
+	Reason     string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	Source     string             `bson:"source,omitempty" json:"source,omitempty"`
+	Actor      string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	CreditNote string             `bson:"credit_note,omitempty" json:"credit_note,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt  time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// RefundPayout is the part of a refund paid back against one of the
+// order's tenders, by index. Money taken from a gift card or the wallet is
+// paid back to the wallet, as is everything when the refund asked for
+// store credit. Status is pending until the payout is paid or has failed.
+type RefundPayout struct {
+	Tender int         `bson:"tender" json:"tender"`
+	Method string      `bson:"method,omitempty" json:"method,omitempty"`
+	Amount money.Money `bson:"amount,omitempty" json:"amount,omitempty"`
+	Status string      `bson:"status,omitempty" json:"status,omitempty"`
+}
+
+// PaidAmount is how much of the refund has been paid out.
+func (refund Refund) PaidAmount() money.Money {
+	paid := money.Zero(refund.Amount.Currency)
+	for _, payout := range refund.Payouts {
+		if payout.Status == RefundCompleted {
+			paid = paid.Add(payout.Amount)
+		}
+	}
+	return paid
+}
+
+// RefundLine is a quantity of one order line being refunded at the price it
+// was bought for.
+type RefundLine struct {
+	LineID   primitive.ObjectID `bson:"line_id,omitempty" json:"line_id,omitempty"`
+	Quantity uint               `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	Amount   money.Money        `bson:"amount,omitempty" json:"amount,omitempty"`
+}
+
+// RefundRequest asks for a refund of Kind. Lines is used by RefundLines and
+// Amount by RefundGoodwill; full and shipping refunds work the amount out
+// themselves. ToWallet pays the refund out as store credit instead of
+// back to the original payment.
+type RefundRequest struct {
+	Kind     string       `json:"kind"`
+	Lines    []RefundLine `json:"lines"`
+	Amount   money.Money  `json:"amount"`
+	Reason   string       `json:"reason"`
+	ToWallet bool         `json:"to_wallet"`
+}
+
+const (
+	LedgerPaymentCaptured = "payment_captured"
+	LedgerRefund          = "refund"
+)
+
+// LedgerEntry is one money movement on an order. Entries are only ever
+// inserted; Amount is positive for money received and negative for money
+// paid back.
+type LedgerEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	OrderID   primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	Type      string             `bson:"type,omitempty" json:"type,omitempty"`
+	Amount    money.Money        `bson:"amount,omitempty" json:"amount,omitempty"`
+	Currency  string             `bson:"currency,omitempty" json:"currency,omitempty"`
+	Method    string             `bson:"method,omitempty" json:"method,omitempty"`
+	Reference primitive.ObjectID `bson:"reference,omitempty" json:"reference,omitempty"`
+	Actor     string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	At        time.Time          `bson:"at,omitempty" json:"at,omitempty"`
+}
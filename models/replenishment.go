@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Replenishment is how a product, or one variant of a product with
+// variants, is restocked. Buyers set ReorderPoint,
+// LeadTimeDays and MinOrderQuantity; a zero reorder point is worked out
+// from the product's sales over its lead time. The rest is what the stock
+// level job last found: Velocity is units sold per day, Available the
+// units the active warehouses can still sell and DaysOfStock how long they
+// last at that rate. OnOrder is what open purchase orders are still
+// waiting for. A product is Low once Available falls to its reorder point,
+// and SuggestedQuantity is then how many more to order on top of OnOrder.
+type Replenishment struct {
+	ID                    primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	ProductID             primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID             primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	SKU                   string             `bson:"sku,omitempty" json:"sku,omitempty"`
+	ProductName           string             `bson:"product_name,omitempty" json:"product_name,omitempty"`
+	ReorderPoint          int64              `bson:"reorder_point,omitempty" json:"reorder_point,omitempty"`
+	LeadTimeDays          int64              `bson:"lead_time_days,omitempty" json:"lead_time_days,omitempty"`
+	MinOrderQuantity      int64              `bson:"min_order_quantity,omitempty" json:"min_order_quantity,omitempty"`
+	Velocity              float64            `bson:"velocity" json:"velocity"`
+	Available             int64              `bson:"available" json:"available"`
+	OnOrder               int64              `bson:"on_order" json:"on_order"`
+	EffectiveReorderPoint int64              `bson:"effective_reorder_point" json:"effective_reorder_point"`
+	DaysOfStock           *float64           `bson:"days_of_stock,omitempty" json:"days_of_stock,omitempty"`
+	SuggestedQuantity     int64              `bson:"suggested_quantity" json:"suggested_quantity"`
+	Low                   bool               `bson:"low" json:"low"`
+	LowSince              time.Time          `bson:"low_since,omitempty" json:"low_since,omitempty"`
+	NotifiedAt            time.Time          `bson:"notified_at,omitempty" json:"notified_at,omitempty"`
+	ComputedAt            time.Time          `bson:"computed_at,omitempty" json:"computed_at,omitempty"`
+	UpdatedAt             time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReturnStatus string
+
+const (
+	ReturnRequested ReturnStatus = "requested"
+	ReturnApproved  ReturnStatus = "approved"
+	ReturnReceived  ReturnStatus = "received"
+	ReturnInspected ReturnStatus = "inspected"
+	ReturnRefunded  ReturnStatus = "refunded"
+	ReturnReplaced  ReturnStatus = "replaced"
+	ReturnRejected  ReturnStatus = "rejected"
+)
+
+// returnTransitions is the RMA lifecycle: staff approve or reject a request,
+// receive the goods, inspect them and then settle the return by refunding,
+// replacing or rejecting it.
+var returnTransitions = map[ReturnStatus][]ReturnStatus{
+	ReturnRequested: {ReturnApproved, ReturnRejected},
+	ReturnApproved:  {ReturnReceived, ReturnRejected},
+	ReturnReceived:  {ReturnInspected},
+	ReturnInspected: {ReturnRefunded, ReturnReplaced, ReturnRejected},
+}
+
+// CanTransitionTo reports whether a return in status s may move to next.
+func (s ReturnStatus) CanTransitionTo(next ReturnStatus) bool {
+	for _, allowed := range returnTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether s is one of the known return statuses.
+func (s ReturnStatus) Valid() bool {
+	switch s {
+	case ReturnRequested, ReturnApproved, ReturnReceived, ReturnInspected,
+		ReturnRefunded, ReturnReplaced, ReturnRejected:
+		return true
+	}
+	return false
+}
+
+// ReturnReasons are the reason codes a customer can give for a return.
+var ReturnReasons = map[string]bool{
+	"damaged":          true,
+	"defective":        true,
+	"wrong_item":       true,
+	"not_as_described": true,
+	"size_or_fit":      true,
+	"changed_mind":     true,
+}
+
+// Return is a return merchandise authorisation (RMA) raised by a customer
+// against some of the lines of a delivered order.
+type Return struct {
+	ID                 primitive.ObjectID   `bson:"_id,omitempty" json:"_id,omitempty"`
+	RMANumber          string               `bson:"rma_number,omitempty" json:"rma_number,omitempty"`
+	OrderID            primitive.ObjectID   `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	OrderNumber        string               `bson:"order_number,omitempty" json:"order_number,omitempty"`
+	UserID             string               `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Lines              []ReturnLine         `bson:"lines,omitempty" json:"lines,omitempty"`
+	Status             ReturnStatus         `bson:"status,omitempty" json:"status,omitempty"`
+	StatusHistory      []ReturnStatusChange `bson:"status_history,omitempty" json:"status_history,omitempty"`
+	RefundAmount       money.Money          `bson:"refund_amount,omitempty" json:"refund_amount,omitempty"`
+	RefundStatus       string               `bson:"refund_status,omitempty" json:"refund_status,omitempty"`
+	ReplacementOrderID primitive.ObjectID   `bson:"replacement_order_id,omitempty" json:"replacement_order_id,omitempty"`
+	CreatedAt          time.Time            `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt          time.Time            `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// ReturnLine is a quantity of one order line being sent back. Accepted and
+// Restock are filled in by warehouse staff at inspection.
+type ReturnLine struct {
+	LineID      primitive.ObjectID `bson:"line_id,omitempty" json:"line_id,omitempty"`
+	ProductID   primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	ProductName string             `bson:"product_name,omitempty" json:"product_name,omitempty"`
+	Price       money.Money        `bson:"price,omitempty" json:"price,omitempty"`
+	Quantity    uint               `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	ReasonCode  string             `bson:"reason_code,omitempty" json:"reason_code,omitempty"`
+	Comment     string             `bson:"comment,omitempty" json:"comment,omitempty"`
+	Photos      []string           `bson:"photos,omitempty" json:"photos,omitempty"`
+	Accepted    uint               `bson:"accepted_quantity,omitempty" json:"accepted_quantity,omitempty"`
+	Restock     bool               `bson:"restock,omitempty" json:"restock,omitempty"`
+}
+
+type ReturnStatusChange struct {
+	Status ReturnStatus `bson:"status,omitempty" json:"status,omitempty"`
+	At     time.Time    `bson:"at,omitempty" json:"at,omitempty"`
+	Actor  string       `bson:"actor,omitempty" json:"actor,omitempty"`
+	Note   string       `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+// ReturnWindow is how many days after delivery products of a category can
+// be returned. Zero days makes the category non-returnable.
+type ReturnWindow struct {
+	Category string `bson:"_id" json:"category"`
+	Days     uint   `bson:"days" json:"days"`
+}
+
+// DefaultReturnWindowDays applies to categories without a ReturnWindow.
+const DefaultReturnWindowDays = 7
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ShipmentStatus string
+
+const (
+	ShipmentLabelCreated   ShipmentStatus = "label_created"
+	ShipmentPickedUp       ShipmentStatus = "picked_up"
+	ShipmentInTransit      ShipmentStatus = "in_transit"
+	ShipmentOutForDelivery ShipmentStatus = "out_for_delivery"
+	ShipmentDeliveryFailed ShipmentStatus = "delivery_failed"
+	ShipmentDelivered      ShipmentStatus = "delivered"
+	ShipmentReturned       ShipmentStatus = "returned_to_origin"
+)
+
+// InTransit reports whether a shipment in status s has left the warehouse.
+func (s ShipmentStatus) InTransit() bool {
+	switch s {
+	case ShipmentPickedUp, ShipmentInTransit, ShipmentOutForDelivery, ShipmentDeliveryFailed, ShipmentDelivered:
+		return true
+	}
+	return false
+}
+
+// Final reports whether a shipment in status s will see no more tracking
+// events.
+func (s ShipmentStatus) Final() bool {
+	return s == ShipmentDelivered || s == ShipmentReturned
+}
+
+// Valid reports whether s is one of the known shipment statuses.
+func (s ShipmentStatus) Valid() bool {
+	switch s {
+	case ShipmentLabelCreated, ShipmentPickedUp, ShipmentInTransit, ShipmentOutForDelivery,
+		ShipmentDeliveryFailed, ShipmentDelivered, ShipmentReturned:
+		return true
+	}
+	return false
+}
+
+const (
+	LineUnfulfilled      = "unfulfilled"
+	LineBackordered      = "backordered"
+	LineAwaitingPickup   = "awaiting_pickup"
+	LinePartiallyShipped = "partially_shipped"
+	LineShipped          = "shipped"
+	LineDelivered        = "delivered"
+	LineCancelled        = "cancelled"
+)
+
+// LineFulfilment is where the active units of one order line are, and
+// which warehouse they are picked from.
+type LineFulfilment struct {
+	LineID      primitive.ObjectID `json:"line_id"`
+	WarehouseID primitive.ObjectID `json:"warehouse_id,omitempty"`
+	Status      string             `json:"status"`
+	Quantity    uint               `json:"quantity"`
+	Allocated   uint               `json:"allocated_quantity"`
+	Shipped     uint               `json:"shipped_quantity"`
+	Delivered   uint               `json:"delivered_quantity"`
+	Backordered uint               `json:"backordered_quantity"`
+}
+
+// Shipment is a parcel handed to a carrier for an order. AWB is the
+// carrier's air waybill or tracking number. Events is the tracking
+// timeline, oldest first, and Status is the status of its latest event.
+//
+// Lines are the order lines in the parcel. ShippedAt, DeliveredAt and
+// ReturnedAt record when the parcel's lines were counted as shipped,
+// delivered or back in stock on the order, so each is counted once.
+type Shipment struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	OrderID     primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	OrderNumber string             `bson:"order_number,omitempty" json:"order_number,omitempty"`
+	UserID      string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Carrier     string             `bson:"carrier,omitempty" json:"carrier,omitempty"`
+	AWB         string             `bson:"awb,omitempty" json:"awb,omitempty"`
+	TrackingURL string             `bson:"tracking_url,omitempty" json:"tracking_url,omitempty"`
+	Status      ShipmentStatus     `bson:"status,omitempty" json:"status,omitempty"`
+	Lines       []ShipmentLine     `bson:"lines,omitempty" json:"lines,omitempty"`
+	Events      []TrackingEvent    `bson:"events,omitempty" json:"events,omitempty"`
+	Actor       string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	ShippedAt   time.Time          `bson:"shipped_at,omitempty" json:"shipped_at,omitempty"`
+	DeliveredAt time.Time          `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	ReturnedAt  time.Time          `bson:"returned_at,omitempty" json:"returned_at,omitempty"`
+	PolledAt    time.Time          `bson:"polled_at,omitempty" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt   time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// ShipmentLine is a quantity of one order line packed in a shipment.
+type ShipmentLine struct {
+	LineID      primitive.ObjectID `bson:"line_id,omitempty" json:"line_id,omitempty"`
+	ProductName string             `bson:"product_name,omitempty" json:"product_name,omitempty"`
+	Quantity    uint               `bson:"quantity,omitempty" json:"quantity,omitempty"`
+}
+
+// TrackingEvent is one scan or status update reported by a carrier.
+// EventID is unique per shipment, so an event reported twice is only
+// recorded once.
+type TrackingEvent struct {
+	EventID     string         `bson:"event_id,omitempty" json:"event_id,omitempty"`
+	Status      ShipmentStatus `bson:"status,omitempty" json:"status,omitempty"`
+	Location    string         `bson:"location,omitempty" json:"location,omitempty"`
+	Description string         `bson:"description,omitempty" json:"description,omitempty"`
+	At          time.Time      `bson:"at,omitempty" json:"at,omitempty"`
+}
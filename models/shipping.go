@@ -0,0 +1,81 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ShippingStandard = "standard"
+	ShippingExpress  = "express"
+	ShippingPickup   = "pickup"
+)
+
+// ShippingMethods are the delivery options a customer can choose from.
+var ShippingMethods = []string{ShippingStandard, ShippingExpress, ShippingPickup}
+
+// IsShippingMethod reports whether method is one of ShippingMethods.
+func IsShippingMethod(method string) bool {
+	for _, known := range ShippingMethods {
+		if method == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Dimensions are a product's packed size in millimetres.
+type Dimensions struct {
+	Length uint `bson:"length_mm,omitempty" json:"length_mm,omitempty"`
+	Width  uint `bson:"width_mm,omitempty" json:"width_mm,omitempty"`
+	Height uint `bson:"height_mm,omitempty" json:"height_mm,omitempty"`
+}
+
+// ShippingZone is an area orders ship to at the same rates. An address is
+// in the zone if its country is one of Countries and, when PostalPrefixes
+// is set, its postal code starts with one of them. A zone without
+// countries covers everywhere no other zone does. Of the zones an address
+// is in, the one with the longest matching prefix wins.
+//
+// FreeOver makes standard shipping free for orders whose subtotal, before
+// discounts, reaches it.
+type ShippingZone struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Name           string             `bson:"name,omitempty" json:"name,omitempty"`
+	Countries      []string           `bson:"countries,omitempty" json:"countries,omitempty"`
+	PostalPrefixes []string           `bson:"postal_prefixes,omitempty" json:"postal_prefixes,omitempty"`
+	Rates          []ShippingRate     `bson:"rates,omitempty" json:"rates,omitempty"`
+	FreeOver       money.Money        `bson:"free_over,omitempty" json:"free_over,omitempty"`
+	Active         bool               `bson:"active" json:"active"`
+	CreatedAt      time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt      time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// ShippingRate is one row of a zone's rate table: what Method costs for
+// orders weighing up to MaxWeight grams and with a subtotal from
+// MinSubtotal up to, but not including, MaxSubtotal. Zero bounds are
+// open. Past FirstWeight grams, every started kilogram adds PerKg.
+type ShippingRate struct {
+	Method       string      `bson:"method,omitempty" json:"method,omitempty"`
+	MinWeight    uint        `bson:"min_weight_grams,omitempty" json:"min_weight_grams,omitempty"`
+	MaxWeight    uint        `bson:"max_weight_grams,omitempty" json:"max_weight_grams,omitempty"`
+	MinSubtotal  money.Money `bson:"min_subtotal,omitempty" json:"min_subtotal,omitempty"`
+	MaxSubtotal  money.Money `bson:"max_subtotal,omitempty" json:"max_subtotal,omitempty"`
+	Price        money.Money `bson:"price,omitempty" json:"price,omitempty"`
+	FirstWeight  uint        `bson:"first_weight_grams,omitempty" json:"first_weight_grams,omitempty"`
+	PerKg        money.Money `bson:"per_kg,omitempty" json:"per_kg,omitempty"`
+	DeliveryDays uint        `bson:"delivery_days,omitempty" json:"delivery_days,omitempty"`
+}
+
+// ShippingQuote is what a shipping method costs for a cart.
+type ShippingQuote struct {
+	Method       string             `bson:"method,omitempty" json:"method,omitempty"`
+	ZoneID       primitive.ObjectID `bson:"zone_id,omitempty" json:"zone_id,omitempty"`
+	Zone         string             `bson:"zone,omitempty" json:"zone,omitempty"`
+	Price        money.Money        `bson:"price,omitempty" json:"price,omitempty"`
+	Free         bool               `bson:"free,omitempty" json:"free,omitempty"`
+	Weight       uint               `bson:"weight_grams,omitempty" json:"weight_grams,omitempty"`
+	DeliveryDays uint               `bson:"delivery_days,omitempty" json:"delivery_days,omitempty"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "github.com/kshzz24/ecomm-go/money"
+
+// Tax classes a product can be in. The rate each class is taxed at depends
+// on where the order ships to; products without a class are standard.
+const (
+	TaxClassStandard     = "standard"
+	TaxClassReduced      = "reduced"
+	TaxClassSuperReduced = "super_reduced"
+	TaxClassLuxury       = "luxury"
+	TaxClassZero         = "zero"
+	TaxClassExempt       = "exempt"
+)
+
+// TaxComponent is one tax charged on an amount, such as CGST at 9% in
+// Karnataka. Rate is in basis points (1800 is 18%) and Taxable is the
+// amount before tax the rate was applied to.
+type TaxComponent struct {
+	Name         string      `bson:"name,omitempty" json:"name,omitempty"`
+	Jurisdiction string      `bson:"jurisdiction,omitempty" json:"jurisdiction,omitempty"`
+	Rate         uint        `bson:"rate" json:"rate"`
+	Taxable      money.Money `bson:"taxable,omitempty" json:"taxable,omitempty"`
+	Amount       money.Money `bson:"amount,omitempty" json:"amount,omitempty"`
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductOption is one way a product's variants differ, such as "Size"
+// with the values "S", "M" and "L".
+type ProductOption struct {
+	Name   string   `bson:"name,omitempty" json:"name,omitempty"`
+	Values []string `bson:"values,omitempty" json:"values,omitempty"`
+}
+
+// Variant is one sellable version of a product, picking a value of each of
+// the product's options. It is what stock is kept of and what carts and
+// orders hold. A variant without a Price is sold at the product's price,
+// and one without Images or Weight shows and ships like the product.
+// Inactive variants stay on past orders but cannot be bought.
+type Variant struct {
+	VariantID    primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	SKU          string             `bson:"sku,omitempty" json:"sku,omitempty"`
+	Barcode      string             `bson:"barcode,omitempty" json:"barcode,omitempty"`
+	Options      map[string]string  `bson:"options,omitempty" json:"options,omitempty"`
+	Price        money.Money        `bson:"price,omitempty" json:"price,omitempty"`
+	Prices       []money.Money      `bson:"prices,omitempty" json:"prices,omitempty"`
+	Images       []string           `bson:"images,omitempty" json:"images,omitempty"`
+	Weight       uint               `bson:"weight_grams,omitempty" json:"weight_grams,omitempty"`
+	Active       bool               `bson:"active" json:"active"`
+	Availability *Availability      `bson:"-" json:"availability,omitempty"`
+}
+
+// Variant returns the variant of p with id variantID, and whether p has
+// one.
+func (p Product) Variant(variantID primitive.ObjectID) (Variant, bool) {
+	for _, variant := range p.Variants {
+		if variant.VariantID == variantID {
+			return variant, true
+		}
+	}
+	return Variant{}, false
+}
+
+// CartItem is p, or its variant when variant is not nil, as it is put in
+// a cart: the variant's price, image and weight stand in for the
+// product's where it has its own.
+func (p Product) CartItem(variant *Variant) ProductUser {
+	item := ProductUser{
+		ProductID:   p.ProductID,
+		ProductName: p.ProductName,
+		Price:       p.Price,
+		Prices:      p.Prices,
+		Rating:      p.Rating,
+		Image:       p.Image,
+		Category:    p.Category,
+		TaxClass:    p.TaxClass,
+		Weight:      p.Weight,
+		Dimensions:  p.Dimensions,
+	}
+	if variant == nil {
+		return item
+	}
+	item.VariantID = variant.VariantID
+	item.SKU = variant.SKU
+	item.VariantName = variantName(p.Options, variant.Options)
+	if !variant.Price.IsZero() {
+		item.Price, item.Prices = variant.Price, variant.Prices
+	}
+	if len(variant.Images) > 0 {
+		item.Image = variant.Images[0]
+	}
+	if variant.Weight > 0 {
+		item.Weight = variant.Weight
+	}
+	return item
+}
+
+// variantName names a variant by its option values in the order of the
+// product's options, such as "M / Blue".
+func variantName(options []ProductOption, values map[string]string) string {
+	parts := make([]string, 0, len(values))
+	for _, option := range options {
+		if value, ok := values[option.Name]; ok {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, " / ")
+}
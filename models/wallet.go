@@ -0,0 +1,100 @@
+package models
+
+import (
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Accounts of the store-credit ledger. Customer wallets and gift cards are
+// accounts of their own (see WalletAccount and GiftCardAccount); the system
+// accounts are where money enters and leaves stored value.
+const (
+	AccountTopUps        = "system:topups"
+	AccountRefunds       = "system:refunds"
+	AccountPromotions    = "system:promotions"
+	AccountSales         = "system:sales"
+	AccountGiftCardSales = "system:giftcard_sales"
+	AccountBreakage      = "system:giftcard_breakage"
+)
+
+func WalletAccount(userID string) string {
+	return "wallet:" + userID
+}
+
+func GiftCardAccount(code string) string {
+	return "giftcard:" + code
+}
+
+// Wallet is a customer's store-credit balance. Balance is a projection of
+// the customer's WalletEntry rows kept in step with them transactionally.
+type Wallet struct {
+	UserID    string      `bson:"_id" json:"user_id"`
+	Balance   money.Money `bson:"balance" json:"balance"`
+	UpdatedAt time.Time   `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// WalletEntry is one leg of a double-entry store-credit transaction. The
+// entries sharing a TransactionID always sum to zero; Amount is positive
+// for the account receiving value and negative for the one giving it.
+type WalletEntry struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	TransactionID primitive.ObjectID `bson:"transaction_id,omitempty" json:"transaction_id,omitempty"`
+	Account       string             `bson:"account,omitempty" json:"account,omitempty"`
+	Amount        money.Money        `bson:"amount,omitempty" json:"amount,omitempty"`
+	Type          string             `bson:"type,omitempty" json:"type,omitempty"`
+	Reference     string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	At            time.Time          `bson:"at,omitempty" json:"at,omitempty"`
+}
+
+const (
+	GiftCardActive   = "active"
+	GiftCardRedeemed = "redeemed"
+	GiftCardExpired  = "expired"
+)
+
+// GiftCard is a prepaid code. Balance is a projection of the card's
+// WalletEntry rows, like Wallet.Balance.
+type GiftCard struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Code         string             `bson:"code,omitempty" json:"code,omitempty"`
+	InitialValue money.Money        `bson:"initial_value,omitempty" json:"initial_value,omitempty"`
+	Balance      money.Money        `bson:"balance" json:"balance"`
+	Currency     string             `bson:"currency,omitempty" json:"currency,omitempty"`
+	Status       string             `bson:"status,omitempty" json:"status,omitempty"`
+	PurchasedBy  string             `bson:"purchased_by,omitempty" json:"purchased_by,omitempty"`
+	IssuedBy     string             `bson:"issued_by,omitempty" json:"issued_by,omitempty"`
+	ExpiresAt    time.Time          `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+}
+
+// Usable reports whether the card can still be spent at now.
+func (card GiftCard) Usable(now time.Time) bool {
+	return card.Status == GiftCardActive && card.Balance.IsPositive() && now.Before(card.ExpiresAt)
+}
+
+// Tender methods for value taken from store credit.
+const (
+	TenderWallet   = "wallet"
+	TenderGiftCard = "gift_card"
+)
+
+// Tender is one source of money an order was paid with: a gift card, the
+// wallet, cash on delivery or a payment provider (by name).
+type Tender struct {
+	Method    string      `bson:"method,omitempty" json:"method,omitempty"`
+	Amount    money.Money `bson:"amount,omitempty" json:"amount,omitempty"`
+	Refunded  money.Money `bson:"refunded,omitempty" json:"refunded,omitempty"`
+	Reference string      `bson:"reference,omitempty" json:"reference,omitempty"`
+}
+
+// StoredValuePurchase is the body of a wallet top-up or gift card purchase,
+// both of which are paid straight away through a provider.
+type StoredValuePurchase struct {
+	Amount        money.Money `json:"amount"`
+	Provider      string      `json:"provider"`
+	PaymentToken  string      `json:"payment_token"`
+	ExpiresInDays uint        `json:"expires_in_days"`
+	Reason        string      `json:"reason"`
+}
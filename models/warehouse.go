@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Warehouse is a location stock is kept in and orders are shipped from.
+// Orders are routed to warehouses by the country and postal code of
+// Address. Inactive warehouses keep their stock but fulfil no new orders
+// and count towards no product's availability.
+type Warehouse struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Code      string             `bson:"code,omitempty" json:"code,omitempty"`
+	Name      string             `bson:"name,omitempty" json:"name,omitempty"`
+	Address   Address            `bson:"address,omitempty" json:"address,omitempty"`
+	Active    bool               `bson:"active" json:"active"`
+	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+type TransferStatus string
+
+const (
+	TransferInTransit TransferStatus = "in_transit"
+	TransferReceived  TransferStatus = "received"
+	TransferCancelled TransferStatus = "cancelled"
+)
+
+// Transfer moves units of a product from one warehouse to another. The
+// units leave the source's stock when the transfer is created and join the
+// destination's when it is received; cancelling a transfer in transit puts
+// them back at the source.
+type Transfer struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	TransferNumber  string             `bson:"transfer_number,omitempty" json:"transfer_number,omitempty"`
+	ProductID       primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	VariantID       primitive.ObjectID `bson:"variant_id,omitempty" json:"variant_id,omitempty"`
+	FromWarehouseID primitive.ObjectID `bson:"from_warehouse_id,omitempty" json:"from_warehouse_id,omitempty"`
+	ToWarehouseID   primitive.ObjectID `bson:"to_warehouse_id,omitempty" json:"to_warehouse_id,omitempty"`
+	Quantity        int64              `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	Status          TransferStatus     `bson:"status,omitempty" json:"status,omitempty"`
+	Actor           string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	Note            string             `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	ReceivedAt      time.Time          `bson:"received_at,omitempty" json:"received_at,omitempty"`
+	CancelledAt     time.Time          `bson:"cancelled_at,omitempty" json:"cancelled_at,omitempty"`
+	UpdatedAt       time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
@@ -0,0 +1,156 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+type jsonMoney struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency,omitempty"`
+	Display  string `json:"display,omitempty"`
+}
+
+// MarshalJSON writes m as {"amount": 149950, "currency": "INR",
+// "display": "1499.50"}; amount is in minor units.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMoney{Amount: m.Amount, Currency: m.Currency, Display: m.Major()})
+}
+
+// UnmarshalJSON reads the object MarshalJSON writes (display is ignored).
+// A bare number or numeric string is taken as major units of
+// DefaultCurrency, which is how clients sent prices before amounts had a
+// currency. Amounts come from clients, so negative amounts and currencies
+// not in the currency table are rejected.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var parsed Money
+	if err := parsed.unmarshalJSON(data); err != nil {
+		return err
+	}
+	if parsed.IsNegative() {
+		return fmt.Errorf("%w: %s is negative", ErrInvalidAmount, data)
+	}
+	if _, ok := exponents[parsed.Currency]; !ok && parsed.Currency != "" {
+		return fmt.Errorf("%w: unknown currency %q", ErrInvalidAmount, parsed.Currency)
+	}
+	*m = parsed
+	return nil
+}
+
+func (m *Money) unmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '{':
+		var v jsonMoney
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*m = New(v.Amount, v.Currency)
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		s, err := strconv.Unquote(string(data))
+		if err != nil {
+			return err
+		}
+		parsed, err := Parse(s, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	default:
+		parsed, err := Parse(string(data), DefaultCurrency)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, data)
+		}
+		*m = parsed
+		return nil
+	}
+}
+
+type bsonMoney struct {
+	Amount   int64  `bson:"amount"`
+	Currency string `bson:"currency,omitempty"`
+}
+
+// MarshalBSONValue stores m as an embedded document {amount, currency}, so
+// queries and updates can address "<field>.amount".
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	doc, err := bson.Marshal(bsonMoney{Amount: m.Amount, Currency: m.Currency})
+	return bson.TypeEmbeddedDocument, doc, err
+}
+
+// UnmarshalBSONValue reads the document MarshalBSONValue writes. Amounts
+// stored as plain numbers predate Money and were whole major units of
+// DefaultCurrency, so they are scaled up to minor units.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bson.TypeEmbeddedDocument:
+		var v bsonMoney
+		if err := bson.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*m = New(v.Amount, v.Currency)
+	case bson.TypeInt32:
+		v, _, ok := bsoncore.ReadInt32(data)
+		if !ok {
+			return ErrInvalidAmount
+		}
+		return m.fromMajor(int64(v))
+	case bson.TypeInt64:
+		v, _, ok := bsoncore.ReadInt64(data)
+		if !ok {
+			return ErrInvalidAmount
+		}
+		return m.fromMajor(v)
+	case bson.TypeDouble:
+		v, _, ok := bsoncore.ReadDouble(data)
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			return ErrInvalidAmount
+		}
+		minor := math.Round(v * float64(pow10(Exponent(DefaultCurrency))))
+		// float64(math.MaxInt64) rounds up to 2^63, which is already out
+		// of range.
+		if minor >= math.MaxInt64 || minor < math.MinInt64 {
+			return fmt.Errorf("%w: %v", ErrInvalidAmount, v)
+		}
+		*m = New(int64(minor), DefaultCurrency)
+	case bson.TypeNull, bson.TypeUndefined:
+		*m = Money{}
+	default:
+		return fmt.Errorf("%w: cannot decode %s", ErrInvalidAmount, t)
+	}
+	return nil
+}
+
+// fromMajor sets m to major units of DefaultCurrency, the way amounts
+// stored as plain numbers are read. Amounts too large to hold in minor
+// units are rejected rather than overflowing.
+func (m *Money) fromMajor(major int64) error {
+	scale := pow10(Exponent(DefaultCurrency))
+	if major > math.MaxInt64/scale || major < math.MinInt64/scale {
+		return fmt.Errorf("%w: %d", ErrInvalidAmount, major)
+	}
+	*m = FromMajor(major, DefaultCurrency)
+	return nil
+}
+
+// UnmarshalParam lets gin bind m from a query or form parameter, given in
+// major units of DefaultCurrency.
+func (m *Money) UnmarshalParam(param string) error {
+	parsed, err := Parse(param, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,327 @@
+// Package money provides Money, an amount in the minor units of an ISO 4217
+// currency, so amounts are never mixed across currencies or rounded
+// through floating point.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("money: currencies do not match")
+	ErrOverflow         = errors.New("money: amount out of range")
+	ErrInvalidAmount    = errors.New("money: amount is not valid")
+)
+
+// DefaultCurrency is the currency of amounts stored before amounts carried
+// one.
+const DefaultCurrency = "INR"
+
+// exponents holds the number of minor units digits of the currencies we
+// know about; anything else is assumed to have two.
+var exponents = map[string]int{
+	"INR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+}
+
+// Exponent is the number of decimal digits of currency's minor unit.
+func Exponent(currency string) int {
+	if exp, ok := exponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// Money is Amount minor units (paise, cents) of Currency. The zero value
+// is zero in no particular currency and combines with any currency.
+//
+// Arithmetic between two non-zero amounts of different currencies, and
+// arithmetic that overflows int64, is a programming error and panics with
+// ErrCurrencyMismatch or ErrOverflow; amounts are converted explicitly
+// before they are combined.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns amount minor units of currency.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Zero returns nothing of currency.
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// Parse reads a decimal amount in major units, such as "1499.50", of
+// currency. More decimals than the currency has are rejected.
+func Parse(s string, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, _ := strings.Cut(s, ".")
+	exp := Exponent(currency)
+	if whole == "" || len(frac) > exp {
+		return Money{}, ErrInvalidAmount
+	}
+	digits := whole + frac + strings.Repeat("0", exp-len(frac))
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return Money{}, ErrInvalidAmount
+		}
+	}
+	amount, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, ErrOverflow
+	}
+	if negative {
+		amount = -amount
+	}
+	return New(amount, currency), nil
+}
+
+// FromMajor converts a whole number of major units (rupees, dollars) of
+// currency.
+func FromMajor(major int64, currency string) Money {
+	return New(major, currency).Mul(pow10(Exponent(currency)))
+}
+
+func (m Money) IsZero() bool     { return m.Amount == 0 }
+func (m Money) IsPositive() bool { return m.Amount > 0 }
+func (m Money) IsNegative() bool { return m.Amount < 0 }
+
+// In returns m with currency set when m has none yet.
+func (m Money) In(currency string) Money {
+	if m.Currency == "" {
+		m.Currency = currency
+	}
+	return m
+}
+
+// SameCurrency reports whether m and o can be combined.
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency == o.Currency || m.Currency == "" || o.Currency == ""
+}
+
+func (m Money) currencyWith(o Money) string {
+	if !m.SameCurrency(o) {
+		panic(fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency))
+	}
+	if m.Currency != "" {
+		return m.Currency
+	}
+	return o.Currency
+}
+
+func (m Money) Add(o Money) Money {
+	currency := m.currencyWith(o)
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		panic(ErrOverflow)
+	}
+	return New(sum, currency)
+}
+
+func (m Money) Sub(o Money) Money {
+	return m.Add(o.Neg())
+}
+
+func (m Money) Neg() Money {
+	if m.Amount == math.MinInt64 {
+		panic(ErrOverflow)
+	}
+	return New(-m.Amount, m.Currency)
+}
+
+// Mul multiplies m by a whole quantity.
+func (m Money) Mul(n int64) Money {
+	if n == 0 || m.Amount == 0 {
+		return Zero(m.Currency)
+	}
+	product := m.Amount * n
+	if product/n != m.Amount {
+		panic(ErrOverflow)
+	}
+	return New(product, m.Currency)
+}
+
+// Cmp compares m and o, returning -1, 0 or +1.
+func (m Money) Cmp(o Money) int {
+	m.currencyWith(o)
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (m Money) LessThan(o Money) bool    { return m.Cmp(o) < 0 }
+func (m Money) GreaterThan(o Money) bool { return m.Cmp(o) > 0 }
+
+// Min returns the smaller of a and b.
+func Min(a, b Money) Money {
+	if b.LessThan(a) {
+		return b.In(a.Currency)
+	}
+	return a.In(b.Currency)
+}
+
+// Max returns the larger of a and b.
+func Max(a, b Money) Money {
+	if b.GreaterThan(a) {
+		return b.In(a.Currency)
+	}
+	return a.In(b.Currency)
+}
+
+// Sum adds up amounts, which must share a currency.
+func Sum(amounts ...Money) Money {
+	var total Money
+	for _, amount := range amounts {
+		total = total.Add(amount)
+	}
+	return total
+}
+
+// RoundingMode decides which way a fraction of a minor unit goes.
+type RoundingMode int
+
+const (
+	// HalfUp rounds halves away from zero, as prices and taxes usually
+	// are.
+	HalfUp RoundingMode = iota
+	// HalfEven rounds halves to the even neighbour (banker's rounding),
+	// which does not bias sums of many rounded amounts.
+	HalfEven
+	// Down truncates towards zero.
+	Down
+)
+
+// MulFrac multiplies m by num/den, rounding the result to a whole minor
+// unit with mode. It is what percentages and rates are applied with:
+// 18% is MulFrac(18, 100, HalfUp).
+func (m Money) MulFrac(num, den int64, mode RoundingMode) Money {
+	if den == 0 {
+		panic(ErrInvalidAmount)
+	}
+	r := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(num)), big.NewInt(den))
+	return New(roundRat(r, mode), m.Currency)
+}
+
+// MulRat multiplies m by r, rounding like MulFrac. Exchange rates, which
+// rarely fit a small fraction, are applied with it.
+func (m Money) MulRat(r *big.Rat, mode RoundingMode) Money {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), r)
+	return New(roundRat(product, mode), m.Currency)
+}
+
+func roundRat(r *big.Rat, mode RoundingMode) int64 {
+	quo, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Sign() != 0 && mode != Down {
+		// Compare twice the remainder with the denominator to tell
+		// below, at and above half.
+		twice := new(big.Int).Abs(rem)
+		twice.Lsh(twice, 1)
+		half := twice.Cmp(r.Denom())
+		if half > 0 || (half == 0 && (mode == HalfUp || quo.Bit(0) == 1)) {
+			quo.Add(quo, big.NewInt(int64(r.Sign())))
+		}
+	}
+	if !quo.IsInt64() {
+		panic(ErrOverflow)
+	}
+	return quo.Int64()
+}
+
+// Allocate splits m in proportion to weights without losing or inventing a
+// minor unit: the parts always add up to m, leftovers going one unit at a
+// time to the first parts. Discounts and taxes are spread over order lines
+// with it.
+func (m Money) Allocate(weights ...int64) []Money {
+	parts := make([]Money, len(weights))
+	var total int64
+	for _, weight := range weights {
+		total += weight
+	}
+	if total == 0 {
+		for i := range parts {
+			parts[i] = Zero(m.Currency)
+		}
+		if len(parts) > 0 {
+			parts[0] = m
+		}
+		return parts
+	}
+
+	left := m
+	for i, weight := range weights {
+		parts[i] = m.MulFrac(weight, total, Down)
+		left = left.Sub(parts[i])
+	}
+	step := int64(1)
+	if left.IsNegative() {
+		step = -1
+	}
+	for i := 0; !left.IsZero() && len(parts) > 0; i = (i + 1) % len(parts) {
+		if weights[i] == 0 {
+			continue
+		}
+		parts[i].Amount += step
+		left.Amount -= step
+	}
+	return parts
+}
+
+// Major formats m in major units with the currency's decimals, e.g.
+// "1499.50".
+func (m Money) Major() string {
+	exp := Exponent(m.Currency)
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+	}
+	digits := strconv.FormatUint(absUint(amount), 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// String formats m as "INR 1499.50".
+func (m Money) String() string {
+	if m.Currency == "" {
+		return m.Major()
+	}
+	return m.Currency + " " + m.Major()
+}
+
+func absUint(n int64) uint64 {
+	if n < 0 {
+		return uint64(-(n + 1)) + 1
+	}
+	return uint64(n)
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
@@ -0,0 +1,276 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// mustPanic runs f and fails unless it panics with an error matching want.
+func mustPanic(t *testing.T, want error, f func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		err, ok := r.(error)
+		if !ok || !errors.Is(err, want) {
+			t.Errorf("panic = %v, want %v", r, want)
+		}
+	}()
+	f()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     Money
+		err      error
+	}{
+		{"1499.50", "INR", New(149950, "INR"), nil},
+		{"1499.5", "INR", New(149950, "INR"), nil},
+		{"1499", "INR", New(149900, "INR"), nil},
+		{"1499.", "INR", New(149900, "INR"), nil},
+		{" 0.05 ", "USD", New(5, "USD"), nil},
+		{"-0.05", "USD", New(-5, "USD"), nil},
+		{"1500", "JPY", New(1500, "JPY"), nil},
+		{"1500.5", "JPY", Money{}, ErrInvalidAmount},
+		{"1.005", "INR", Money{}, ErrInvalidAmount},
+		{"", "INR", Money{}, ErrInvalidAmount},
+		{".50", "INR", Money{}, ErrInvalidAmount},
+		{"1e3", "INR", Money{}, ErrInvalidAmount},
+		{"+5", "INR", Money{}, ErrInvalidAmount},
+		{"1,000", "INR", Money{}, ErrInvalidAmount},
+		{"92233720368547758.07", "USD", New(math.MaxInt64, "USD"), nil},
+		{"92233720368547758.08", "USD", Money{}, ErrOverflow},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in, tt.currency)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q, %s) err = %v, want %v", tt.in, tt.currency, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q, %s) = %v, want %v", tt.in, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestMulFrac(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		num, den int64
+		mode     RoundingMode
+		want     int64
+	}{
+		{1999, 18, 100, HalfUp, 360},
+		{1999, 18, 100, HalfEven, 360},
+		{1999, 18, 100, Down, 359},
+		// Exact halves are where the modes differ.
+		{250, 1, 100, HalfUp, 3},
+		{250, 1, 100, HalfEven, 2},
+		{250, 1, 100, Down, 2},
+		{350, 1, 100, HalfUp, 4},
+		{350, 1, 100, HalfEven, 4},
+		{-250, 1, 100, HalfUp, -3},
+		{-250, 1, 100, HalfEven, -2},
+		{-250, 1, 100, Down, -2},
+		{-350, 1, 100, HalfEven, -4},
+		// Just either side of a half.
+		{249, 1, 100, HalfUp, 2},
+		{251, 1, 100, HalfEven, 3},
+		{100, 1, 3, HalfUp, 33},
+		{200, 1, 3, HalfUp, 67},
+		{200, 1, 3, Down, 66},
+		{1000, 0, 7, HalfUp, 0},
+		{math.MaxInt64, 3, 3, HalfUp, math.MaxInt64},
+	}
+	for _, tt := range tests {
+		got := New(tt.amount, "INR").MulFrac(tt.num, tt.den, tt.mode)
+		if got != New(tt.want, "INR") {
+			t.Errorf("%d * %d/%d (mode %d) = %v, want %d", tt.amount, tt.num, tt.den, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  int64
+		weights []int64
+		want    []int64
+	}{
+		{"even", 100, []int64{1, 1}, []int64{50, 50}},
+		{"remainder to the first parts", 100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"two units left", 101, []int64{1, 1, 1}, []int64{34, 34, 33}},
+		{"proportional", 1000, []int64{1, 2, 7}, []int64{100, 200, 700}},
+		{"zero weight gets nothing", 10, []int64{1, 0, 2}, []int64{4, 0, 6}},
+		{"negative", -100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{"all weights zero", 100, []int64{0, 0}, []int64{100, 0}},
+		{"single part", 99, []int64{5}, []int64{99}},
+		{"no parts", 99, nil, []int64{}},
+	}
+	for _, tt := range tests {
+		m := New(tt.amount, "INR")
+		parts := m.Allocate(tt.weights...)
+		if len(parts) != len(tt.want) {
+			t.Errorf("%s: got %d parts, want %d", tt.name, len(parts), len(tt.want))
+			continue
+		}
+		sum := Zero("INR")
+		for i, part := range parts {
+			if part != New(tt.want[i], "INR") {
+				t.Errorf("%s: part %d = %v, want %d", tt.name, i, part, tt.want[i])
+			}
+			sum = sum.Add(part)
+		}
+		if len(parts) > 0 && sum != m {
+			t.Errorf("%s: parts add up to %v, want %v", tt.name, sum, m)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Money
+		want Money
+	}{
+		{"add", New(150, "INR").Add(New(50, "INR")), New(200, "INR")},
+		{"sub below zero", New(50, "INR").Sub(New(150, "INR")), New(-100, "INR")},
+		{"zero value takes the other currency", Money{}.Add(New(5, "USD")), New(5, "USD")},
+		{"mul", New(1999, "INR").Mul(3), New(5997, "INR")},
+		{"mul by zero keeps currency", New(1999, "INR").Mul(0), Zero("INR")},
+		{"from major", FromMajor(15, "INR"), New(1500, "INR")},
+		{"from major without decimals", FromMajor(15, "JPY"), New(15, "JPY")},
+		{"sum", Sum(New(1, "EUR"), New(2, "EUR"), Money{}), New(3, "EUR")},
+		{"min", Min(New(5, "INR"), Money{}), Zero("INR")},
+		{"max", Max(New(5, "INR"), Money{}), New(5, "INR")},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestPanics(t *testing.T) {
+	inr, usd := New(100, "INR"), New(100, "USD")
+	tests := []struct {
+		name string
+		want error
+		f    func()
+	}{
+		{"add across currencies", ErrCurrencyMismatch, func() { inr.Add(usd) }},
+		{"sub across currencies", ErrCurrencyMismatch, func() { inr.Sub(usd) }},
+		{"compare across currencies", ErrCurrencyMismatch, func() { inr.Cmp(usd) }},
+		{"sum across currencies", ErrCurrencyMismatch, func() { Sum(inr, usd) }},
+		{"add overflow", ErrOverflow, func() { New(math.MaxInt64, "INR").Add(New(1, "INR")) }},
+		{"sub overflow", ErrOverflow, func() { New(math.MinInt64+1, "INR").Sub(New(2, "INR")) }},
+		{"neg overflow", ErrOverflow, func() { New(math.MinInt64, "INR").Neg() }},
+		{"mul overflow", ErrOverflow, func() { New(math.MaxInt64/2+1, "INR").Mul(2) }},
+		{"from major overflow", ErrOverflow, func() { FromMajor(math.MaxInt64/10, "INR") }},
+		{"mulfrac overflow", ErrOverflow, func() { New(math.MaxInt64, "INR").MulFrac(3, 2, HalfUp) }},
+		{"mulfrac by zero denominator", ErrInvalidAmount, func() { inr.MulFrac(1, 0, HalfUp) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mustPanic(t, tt.want, tt.f)
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{New(149950, "INR"), "INR 1499.50"},
+		{New(5, "USD"), "USD 0.05"},
+		{New(-5, "USD"), "USD -0.05"},
+		{New(0, "EUR"), "EUR 0.00"},
+		{New(1500, "JPY"), "JPY 1500"},
+		{New(123, ""), "1.23"},
+		{New(math.MinInt64, "USD"), "USD -92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		err  error
+	}{
+		{`{"amount": 149950, "currency": "INR", "display": "1499.50"}`, New(149950, "INR"), nil},
+		{`{"amount": 1500, "currency": "JPY"}`, New(1500, "JPY"), nil},
+		{`{"amount": 0}`, Money{}, nil},
+		{`1499.50`, New(149950, "INR"), nil},
+		{`"1499.50"`, New(149950, "INR"), nil},
+		{`null`, Money{}, nil},
+		{`{"amount": -100, "currency": "INR"}`, Money{}, ErrInvalidAmount},
+		{`-1499.50`, Money{}, ErrInvalidAmount},
+		{`"-5"`, Money{}, ErrInvalidAmount},
+		{`{"amount": 100, "currency": "XYZ"}`, Money{}, ErrInvalidAmount},
+		{`{"amount": 100, "currency": "inr"}`, Money{}, ErrInvalidAmount},
+		{`"12abc"`, Money{}, ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("unmarshal %s err = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("unmarshal %s = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalBSONValue(t *testing.T) {
+	doc, err := bson.Marshal(bson.M{"amount": int64(-500), "currency": "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		t    bsontype.Type
+		data []byte
+		want Money
+		err  error
+	}{
+		// Stored amounts, refunds and ledger entries included, may be
+		// negative.
+		{"document", bson.TypeEmbeddedDocument, doc, New(-500, "USD"), nil},
+		{"int32", bson.TypeInt32, bsoncore.AppendInt32(nil, 1499), New(149900, "INR"), nil},
+		{"int64", bson.TypeInt64, bsoncore.AppendInt64(nil, -1499), New(-149900, "INR"), nil},
+		{"double", bson.TypeDouble, bsoncore.AppendDouble(nil, 1499.5), New(149950, "INR"), nil},
+		{"int64 too large", bson.TypeInt64, bsoncore.AppendInt64(nil, math.MaxInt64/10), Money{}, ErrInvalidAmount},
+		{"int64 too small", bson.TypeInt64, bsoncore.AppendInt64(nil, math.MinInt64), Money{}, ErrInvalidAmount},
+		{"double too large", bson.TypeDouble, bsoncore.AppendDouble(nil, 1e17), Money{}, ErrInvalidAmount},
+		{"double too small", bson.TypeDouble, bsoncore.AppendDouble(nil, -1e17), Money{}, ErrInvalidAmount},
+		{"double not a number", bson.TypeDouble, bsoncore.AppendDouble(nil, math.NaN()), Money{}, ErrInvalidAmount},
+		{"string", bson.TypeString, bsoncore.AppendString(nil, "1499"), Money{}, ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := got.UnmarshalBSONValue(tt.t, tt.data)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package notify sends operational alerts, such as products running low
+// on stock, to the channel the store has configured: a webhook, or the
+// server log when there is none.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is one alert. Topic names the kind of alert, such as
+// "low_stock", so a receiver can route it; Lines are its details.
+type Message struct {
+	Topic string   `json:"topic"`
+	Title string   `json:"title"`
+	Lines []string `json:"lines,omitempty"`
+}
+
+// Text is the message as plain text, one line per detail.
+func (m Message) Text() string {
+	if len(m.Lines) == 0 {
+		return m.Title
+	}
+	return m.Title + "\n" + strings.Join(m.Lines, "\n")
+}
+
+// Notifier is implemented by every channel alerts can be sent to.
+type Notifier interface {
+	Notify(ctx context.Context, m Message) error
+}
+
+// LogNotifier writes alerts to the server log.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, m Message) error {
+	log.Printf("[%s] %s", m.Topic, m.Text())
+	return nil
+}
+
+// WebhookNotifier posts alerts as JSON to URL. Besides the message's own
+// fields the body carries it as "text", which chat tools' incoming
+// webhooks display as is.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w WebhookNotifier) Notify(ctx context.Context, m Message) error {
+	body, err := json.Marshal(struct {
+		Message
+		Text string `json:"text"`
+	}{m, m.Text()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+var (
+	mu       sync.RWMutex
+	notifier Notifier = LogNotifier{}
+)
+
+// ConfigureFromEnv sends alerts to ALERT_WEBHOOK_URL when it is set, and to
+// the log otherwise.
+func ConfigureFromEnv() {
+	if url := os.Getenv("ALERT_WEBHOOK_URL"); url != "" {
+		Configure(WebhookNotifier{URL: url})
+		return
+	}
+	Configure(LogNotifier{})
+}
+
+// Configure replaces the channel alerts are sent to.
+func Configure(n Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	notifier = n
+}
+
+// Notify sends m to the configured channel.
+func Notify(ctx context.Context, m Message) error {
+	mu.RLock()
+	n := notifier
+	mu.RUnlock()
+	return n.Notify(ctx, m)
+}
@@ -0,0 +1,196 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tokens understood by FakeProvider. Any other token behaves like
+// FakeTokenSuccess.
+const (
+	FakeTokenSuccess = "tok_success"
+	FakeTokenDecline = "tok_decline"
+	FakeToken3DS     = "tok_3ds"
+	FakeTokenTimeout = "tok_timeout"
+
+	// FakeChallengePass is the challenge response that passes 3-D Secure.
+	FakeChallengePass = "pass"
+)
+
+// FakeProvider is an in-memory provider for local development and tests.
+// The payment token picks the outcome: success, decline, a 3-D Secure
+// challenge, or a timeout after Delay.
+type FakeProvider struct {
+	Secret string
+	Delay  time.Duration
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+	refunds  map[string]Result
+}
+
+type fakePayment struct {
+	status     Status
+	authorized money.Money
+	captured   money.Money
+	refunded   money.Money
+}
+
+func NewFakeProvider(secret string) *FakeProvider {
+	return &FakeProvider{
+		Secret:   secret,
+		payments: make(map[string]*fakePayment),
+		refunds:  make(map[string]Result),
+	}
+}
+
+func (f *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *FakeProvider) Authorize(ctx context.Context, req AuthorizeRequest) (Result, error) {
+	if !req.Amount.IsPositive() || req.Amount.Currency == "" {
+		return Result{}, ErrInvalidRequest
+	}
+	reference := "fake_" + primitive.NewObjectID().Hex()
+	payment := &fakePayment{authorized: req.Amount}
+	result := Result{Reference: reference}
+
+	switch req.Token {
+	case FakeTokenDecline:
+		payment.status = StatusDeclined
+		result.DeclineReason = "card_declined"
+	case FakeToken3DS:
+		payment.status = StatusRequiresAction
+		result.ActionURL = "https://fake-3ds.local/challenge/" + reference
+	case FakeTokenTimeout:
+		f.store(reference, payment)
+		select {
+		case <-time.After(f.Delay):
+		case <-ctx.Done():
+		}
+		return result, ErrTimeout
+	default:
+		payment.status = StatusAuthorized
+	}
+
+	f.store(reference, payment)
+	result.Status = payment.status
+	return result, nil
+}
+
+func (f *FakeProvider) Confirm(ctx context.Context, reference string, response string) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payment, ok := f.payments[reference]
+	if !ok || payment.status != StatusRequiresAction {
+		return Result{}, ErrInvalidRequest
+	}
+	result := Result{Reference: reference}
+	if response == FakeChallengePass {
+		payment.status = StatusAuthorized
+	} else {
+		payment.status = StatusDeclined
+		result.DeclineReason = "authentication_failed"
+	}
+	result.Status = payment.status
+	return result, nil
+}
+
+func (f *FakeProvider) Capture(ctx context.Context, reference string, amount money.Money) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payment, ok := f.payments[reference]
+	if !ok || payment.status != StatusAuthorized || !amount.IsPositive() || !amount.SameCurrency(payment.authorized) || amount.GreaterThan(payment.authorized) {
+		return Result{}, ErrInvalidRequest
+	}
+	payment.status = StatusCaptured
+	payment.captured = amount
+	return Result{Reference: reference, Status: payment.status}, nil
+}
+
+func (f *FakeProvider) Void(ctx context.Context, reference string) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payment, ok := f.payments[reference]
+	if !ok || (payment.status != StatusAuthorized && payment.status != StatusRequiresAction) {
+		return Result{}, ErrInvalidRequest
+	}
+	payment.status = StatusVoided
+	return Result{Reference: reference, Status: payment.status}, nil
+}
+
+func (f *FakeProvider) Refund(ctx context.Context, reference string, amount money.Money, idempotencyKey string) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if result, ok := f.refunds[idempotencyKey]; ok && idempotencyKey != "" {
+		return result, nil
+	}
+	payment, ok := f.payments[reference]
+	if !ok || !amount.IsPositive() || !amount.SameCurrency(payment.captured) || payment.refunded.Add(amount).GreaterThan(payment.captured) {
+		return Result{}, ErrInvalidRequest
+	}
+	payment.refunded = payment.refunded.Add(amount)
+	if payment.refunded.Cmp(payment.captured) == 0 {
+		payment.status = StatusRefunded
+	}
+	result := Result{Reference: reference, Status: StatusRefunded}
+	if idempotencyKey != "" {
+		f.refunds[idempotencyKey] = result
+	}
+	return result, nil
+}
+
+// fakeWebhook is the JSON body FakeProvider sends to the webhook endpoint.
+type fakeWebhook struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Reference string      `json:"reference"`
+	Amount    money.Money `json:"amount"`
+}
+
+// VerifyWebhook checks that signature is the hex HMAC-SHA256 of payload
+// under Secret and decodes the event.
+func (f *FakeProvider) VerifyWebhook(payload []byte, signature string) (WebhookEvent, error) {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || f.Secret == "" || !hmac.Equal(expected, f.sign(payload)) {
+		return WebhookEvent{}, ErrBadSignature
+	}
+	var body fakeWebhook
+	if err := json.Unmarshal(payload, &body); err != nil || body.ID == "" {
+		return WebhookEvent{}, ErrInvalidRequest
+	}
+	return WebhookEvent{
+		ID:        body.ID,
+		Type:      body.Type,
+		Reference: body.Reference,
+		Amount:    body.Amount,
+		Payload:   payload,
+	}, nil
+}
+
+// Sign returns the signature FakeProvider expects on payload, for building
+// webhook requests by hand.
+func (f *FakeProvider) Sign(payload []byte) string {
+	return hex.EncodeToString(f.sign(payload))
+}
+
+func (f *FakeProvider) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(f.Secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (f *FakeProvider) store(reference string, payment *fakePayment) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.payments[reference] = payment
+}
@@ -0,0 +1,119 @@
+// Package payments defines the interface every payment provider implements
+// and a registry the rest of the application looks providers up in.
+package payments
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/kshzz24/ecomm-go/money"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown payment provider")
+	ErrTimeout         = errors.New("payment provider did not respond in time")
+	ErrInvalidRequest  = errors.New("payment provider rejected the request")
+	ErrBadSignature    = errors.New("webhook signature is not valid")
+)
+
+type Status string
+
+const (
+	StatusAuthorized     Status = "authorized"
+	StatusRequiresAction Status = "requires_action"
+	StatusDeclined       Status = "declined"
+	StatusCaptured       Status = "captured"
+	StatusVoided         Status = "voided"
+	StatusRefunded       Status = "refunded"
+)
+
+// AuthorizeRequest asks a provider to reserve Amount on the payment method
+// behind Token.
+type AuthorizeRequest struct {
+	IntentID string
+	Amount   money.Money
+	Token    string
+}
+
+// Result is a provider's answer. Reference identifies the payment at the
+// provider and is passed back on every later call. ActionURL is set when
+// Status is StatusRequiresAction, e.g. for a 3-D Secure challenge.
+type Result struct {
+	Reference     string
+	Status        Status
+	ActionURL     string
+	DeclineReason string
+}
+
+// Webhook event types providers report. Providers translate their own event
+// names into these in VerifyWebhook.
+const (
+	EventPaymentAuthorized = "payment.authorized"
+	EventPaymentCaptured   = "payment.captured"
+	EventPaymentFailed     = "payment.failed"
+	EventRefundSucceeded   = "refund.succeeded"
+	EventRefundFailed      = "refund.failed"
+)
+
+// SignatureHeader is the request header webhook signatures are sent in.
+const SignatureHeader = "X-Webhook-Signature"
+
+// WebhookEvent is a verified notification sent by a provider.
+type WebhookEvent struct {
+	ID        string
+	Type      string
+	Reference string
+	Amount    money.Money
+	Payload   []byte
+}
+
+// PaymentProvider is implemented by every payment gateway integration.
+// Calls that time out return ErrTimeout; the outcome then arrives later
+// through a webhook.
+type PaymentProvider interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (Result, error)
+	// Confirm completes a payment left in StatusRequiresAction with the
+	// customer's response to the challenge.
+	Confirm(ctx context.Context, reference string, response string) (Result, error)
+	Capture(ctx context.Context, reference string, amount money.Money) (Result, error)
+	Void(ctx context.Context, reference string) (Result, error)
+	// Refund pays amount back. Calls repeated with the same idempotency
+	// key refund only once.
+	Refund(ctx context.Context, reference string, amount money.Money, idempotencyKey string) (Result, error)
+	VerifyWebhook(payload []byte, signature string) (WebhookEvent, error)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = make(map[string]PaymentProvider)
+)
+
+// Register makes p available under p.Name(), replacing any provider
+// registered under the same name.
+func Register(p PaymentProvider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[p.Name()] = p
+}
+
+func Lookup(name string) (PaymentProvider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// RegisterFromEnv registers the providers enabled in the environment.
+// FAKE_PAYMENTS=true enables FakeProvider, signing webhooks with
+// FAKE_PAYMENTS_WEBHOOK_SECRET.
+func RegisterFromEnv() {
+	if os.Getenv("FAKE_PAYMENTS") == "true" {
+		Register(NewFakeProvider(os.Getenv("FAKE_PAYMENTS_WEBHOOK_SECRET")))
+	}
+}
@@ -0,0 +1,121 @@
+// Package pdf writes plain PDF documents of monospaced text: enough for
+// invoices and other printable records without pulling in a layout engine.
+// Text is set in the standard Courier font, which every PDF reader has, so
+// nothing is embedded. Characters outside printable ASCII are replaced.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	pageWidth  = 595 // A4, in points
+	pageHeight = 842
+	margin     = 40
+	fontSize   = 9
+	leading    = 11
+)
+
+// LinesPerPage is how many lines of text fit on a page.
+const LinesPerPage = (pageHeight - 2*margin) / leading
+
+// Columns is how many characters of Courier fit across a page; wider lines
+// run off the right margin.
+const Columns = (pageWidth - 2*margin) * 10 / (fontSize * 6)
+
+// Document is a PDF being put together page by page.
+type Document struct {
+	title string
+	pages [][]string
+}
+
+// New starts an empty document with title as its metadata title.
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+// AddText adds text to the document, starting on a new page and breaking
+// onto further pages as they fill. A form feed forces a page break.
+func (d *Document) AddText(text string) {
+	for _, page := range strings.Split(text, "\f") {
+		lines := strings.Split(strings.TrimRight(page, "\n"), "\n")
+		for len(lines) > LinesPerPage {
+			d.pages = append(d.pages, lines[:LinesPerPage])
+			lines = lines[LinesPerPage:]
+		}
+		d.pages = append(d.pages, lines)
+	}
+}
+
+// Bytes renders the document. A document without text still gets one
+// blank page, as a PDF needs at least one.
+func (d *Document) Bytes() []byte {
+	pages := d.pages
+	if len(pages) == 0 {
+		pages = [][]string{nil}
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	// Objects 1 to 4 are the catalog, page tree, font and info; each page
+	// then takes two objects, the page and its content stream.
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (ecomm-go) >>", escape(d.title)))
+	for i, lines := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+2*i))
+		content := pageContent(lines)
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+func pageContent(lines []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", fontSize, leading, margin, pageHeight-margin-fontSize)
+	for _, line := range lines {
+		fmt.Fprintf(&b, "(%s) Tj T*\n", escape(line))
+	}
+	b.WriteString("ET")
+	return b.String()
+}
+
+// escape makes s safe inside a PDF string literal.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r < 0x20 || r > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
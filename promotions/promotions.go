@@ -0,0 +1,344 @@
+// Package promotions works out which promotions apply to a cart and what
+// they take off it. It does not touch the database: the caller loads the
+// candidate promotions, leaves out those whose usage limits are spent and
+// records the ones that were used.
+package promotions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUnknownCoupon       = errors.New("coupon code is not valid")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to this cart")
+	ErrCouponsDontStack    = errors.New("coupons cannot be combined")
+)
+
+// Line is a cart line as promotions see it.
+type Line struct {
+	ProductID primitive.ObjectID
+	Category  string
+	UnitPrice money.Money
+	Quantity  uint
+}
+
+// Cart is what promotions are evaluated against. Codes are the coupon
+// codes the customer entered. Convert brings a promotion's amounts into
+// Currency; without it, promotions with amounts in another currency do not
+// apply.
+type Cart struct {
+	Currency string
+	Lines    []Line
+	Shipping money.Money
+	Codes    []string
+	Now      time.Time
+	Convert  func(money.Money) (money.Money, error)
+}
+
+// Result is what the chosen promotions take off a cart: each line's share
+// of the discount, in the order of Cart.Lines, and the shipping waived.
+type Result struct {
+	Applied          []models.AppliedPromotion
+	LineDiscounts    []money.Money
+	ShippingDiscount money.Money
+}
+
+// Discount is everything the promotions take off the cart.
+func (r Result) Discount() money.Money {
+	return money.Sum(r.LineDiscounts...).Add(r.ShippingDiscount)
+}
+
+// NormalizeCode is how coupon codes are stored and compared.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Evaluate picks the promotions among candidates that apply to cart and
+// works out what they take off.
+//
+// Every coupon entered must apply, or Evaluate fails. A coupon that does
+// not stack is used on its own; stackable coupons are combined with the
+// stackable automatic promotions. Without coupons, the cart gets whichever
+// is worth more: all stackable automatic promotions together, or the best
+// automatic promotion that does not stack.
+func Evaluate(cart Cart, candidates []models.Promotion) (Result, error) {
+	if cart.Now.IsZero() {
+		cart.Now = time.Now()
+	}
+
+	var coupons []models.Promotion
+	seen := make(map[string]bool)
+	for _, code := range cart.Codes {
+		code = NormalizeCode(code)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		coupon, ok := findCoupon(candidates, code)
+		if !ok || !coupon.Live(cart.Now) {
+			return Result{}, fmt.Errorf("%w: %s", ErrUnknownCoupon, code)
+		}
+		qualifies, err := cart.qualifies(coupon)
+		if err != nil {
+			return Result{}, err
+		}
+		if !qualifies {
+			return Result{}, fmt.Errorf("%w: %s", ErrCouponNotApplicable, code)
+		}
+		coupons = append(coupons, coupon)
+	}
+
+	var stackable, exclusive []models.Promotion
+	for _, promotion := range candidates {
+		if promotion.Code != "" || !promotion.Live(cart.Now) {
+			continue
+		}
+		// Automatic promotions that cannot be priced in the cart's
+		// currency are left out rather than failing the cart.
+		if qualifies, err := cart.qualifies(promotion); err != nil || !qualifies {
+			continue
+		}
+		if promotion.Stackable {
+			stackable = append(stackable, promotion)
+		} else {
+			exclusive = append(exclusive, promotion)
+		}
+	}
+
+	for _, coupon := range coupons {
+		if !coupon.Stackable {
+			if len(coupons) > 1 {
+				return Result{}, ErrCouponsDontStack
+			}
+			return cart.apply(coupons)
+		}
+	}
+	if len(coupons) > 0 {
+		return cart.apply(append(coupons, stackable...))
+	}
+
+	best, err := cart.apply(stackable)
+	if err != nil {
+		return Result{}, err
+	}
+	for _, promotion := range exclusive {
+		alone, err := cart.apply([]models.Promotion{promotion})
+		if err != nil {
+			return Result{}, err
+		}
+		if alone.Discount().GreaterThan(best.Discount()) {
+			best = alone
+		}
+	}
+	return best, nil
+}
+
+func findCoupon(candidates []models.Promotion, code string) (models.Promotion, bool) {
+	for _, promotion := range candidates {
+		if promotion.Code != "" && NormalizeCode(promotion.Code) == code {
+			return promotion, true
+		}
+	}
+	return models.Promotion{}, false
+}
+
+func (cart Cart) subtotal() money.Money {
+	total := money.Zero(cart.Currency)
+	for _, line := range cart.Lines {
+		total = total.Add(line.UnitPrice.Mul(int64(line.Quantity)))
+	}
+	return total
+}
+
+// convert brings amount into the cart's currency.
+func (cart Cart) convert(amount money.Money) (money.Money, error) {
+	if amount.Currency == "" || amount.Currency == cart.Currency {
+		return amount.In(cart.Currency), nil
+	}
+	if cart.Convert == nil {
+		return money.Money{}, fmt.Errorf("%w: %s and %s", money.ErrCurrencyMismatch, amount.Currency, cart.Currency)
+	}
+	return cart.Convert(amount)
+}
+
+// qualifies reports whether promotion, used on its own, meets its minimum
+// and takes something off the cart.
+func (cart Cart) qualifies(promotion models.Promotion) (bool, error) {
+	if !promotion.MinSubtotal.IsZero() {
+		minimum, err := cart.convert(promotion.MinSubtotal)
+		if err != nil {
+			return false, err
+		}
+		if cart.subtotal().LessThan(minimum) {
+			return false, nil
+		}
+	}
+	result, err := cart.apply([]models.Promotion{promotion})
+	if err != nil {
+		return false, err
+	}
+	return len(result.Applied) > 0, nil
+}
+
+// apply uses promotions on the cart one after another, highest priority
+// first, each on what the ones before left of every line.
+func (cart Cart) apply(promotions []models.Promotion) (Result, error) {
+	promotions = append([]models.Promotion(nil), promotions...)
+	sort.SliceStable(promotions, func(i, j int) bool {
+		return promotions[i].Priority > promotions[j].Priority
+	})
+
+	result := Result{
+		LineDiscounts:    make([]money.Money, len(cart.Lines)),
+		ShippingDiscount: money.Zero(cart.Currency),
+	}
+	remaining := make([]money.Money, len(cart.Lines))
+	for i, line := range cart.Lines {
+		remaining[i] = line.UnitPrice.Mul(int64(line.Quantity)).In(cart.Currency)
+		result.LineDiscounts[i] = money.Zero(cart.Currency)
+	}
+	shipping := cart.Shipping.In(cart.Currency)
+
+	for _, promotion := range promotions {
+		off, err := cart.lineDiscounts(promotion, remaining)
+		if err != nil {
+			return Result{}, err
+		}
+		applied := models.AppliedPromotion{
+			PromotionID: promotion.ID,
+			Name:        promotion.Name,
+			Code:        promotion.Code,
+			Type:        promotion.Type,
+			Amount:      money.Zero(cart.Currency),
+		}
+		for i := range off {
+			remaining[i] = remaining[i].Sub(off[i])
+			result.LineDiscounts[i] = result.LineDiscounts[i].Add(off[i])
+			applied.Amount = applied.Amount.Add(off[i])
+		}
+		if promotion.Type == models.PromotionFreeShipping && cart.inScope(promotion) {
+			applied.FreeShipping = true
+			applied.Amount = applied.Amount.Add(shipping)
+			result.ShippingDiscount = result.ShippingDiscount.Add(shipping)
+			shipping = money.Zero(cart.Currency)
+		}
+		if applied.Amount.IsPositive() || applied.FreeShipping {
+			result.Applied = append(result.Applied, applied)
+		}
+	}
+	return result, nil
+}
+
+// inScope reports whether any line of the cart is in promotion's scope.
+func (cart Cart) inScope(promotion models.Promotion) bool {
+	for _, line := range cart.Lines {
+		if lineInScope(promotion, line) {
+			return true
+		}
+	}
+	return false
+}
+
+func lineInScope(promotion models.Promotion, line Line) bool {
+	if len(promotion.ProductIDs) == 0 && len(promotion.Categories) == 0 {
+		return true
+	}
+	for _, id := range promotion.ProductIDs {
+		if id == line.ProductID {
+			return true
+		}
+	}
+	for _, category := range promotion.Categories {
+		if strings.EqualFold(category, line.Category) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineDiscounts works out what promotion takes off each line, given what
+// is left of the lines.
+func (cart Cart) lineDiscounts(promotion models.Promotion, remaining []money.Money) ([]money.Money, error) {
+	off := make([]money.Money, len(cart.Lines))
+	weights := make([]int64, len(cart.Lines))
+	base := money.Zero(cart.Currency)
+	for i, line := range cart.Lines {
+		off[i] = money.Zero(cart.Currency)
+		if lineInScope(promotion, line) && remaining[i].IsPositive() {
+			weights[i] = remaining[i].Amount
+			base = base.Add(remaining[i])
+		}
+	}
+	if !base.IsPositive() {
+		return off, nil
+	}
+
+	switch promotion.Type {
+	case models.PromotionPercentOff:
+		if promotion.PercentOff == 0 || promotion.PercentOff > 100 {
+			return off, nil
+		}
+		return base.MulFrac(int64(promotion.PercentOff), 100, money.HalfUp).Allocate(weights...), nil
+	case models.PromotionAmountOff:
+		amount, err := cart.convert(promotion.AmountOff)
+		if err != nil {
+			return nil, err
+		}
+		if !amount.IsPositive() {
+			return off, nil
+		}
+		return money.Min(amount, base).Allocate(weights...), nil
+	case models.PromotionBuyXGetY:
+		return cart.buyXGetY(promotion, remaining, weights, off), nil
+	default:
+		return off, nil
+	}
+}
+
+// buyXGetY discounts the cheapest GetQuantity units of every
+// BuyQuantity+GetQuantity units in scope.
+func (cart Cart) buyXGetY(promotion models.Promotion, remaining []money.Money, weights []int64, off []money.Money) []money.Money {
+	group := promotion.BuyQuantity + promotion.GetQuantity
+	if promotion.BuyQuantity == 0 || promotion.GetQuantity == 0 {
+		return off
+	}
+	percent := promotion.GetPercent
+	if percent == 0 || percent > 100 {
+		percent = 100
+	}
+
+	type unit struct {
+		line  int
+		price money.Money
+	}
+	var units []unit
+	for i, line := range cart.Lines {
+		if weights[i] == 0 || line.Quantity == 0 {
+			continue
+		}
+		price := remaining[i].MulFrac(1, int64(line.Quantity), money.Down)
+		for n := uint(0); n < line.Quantity; n++ {
+			units = append(units, unit{line: i, price: price})
+		}
+	}
+	sort.SliceStable(units, func(i, j int) bool {
+		return units[i].price.LessThan(units[j].price)
+	})
+
+	discounted := uint(len(units)) / group * promotion.GetQuantity
+	for _, u := range units[:discounted] {
+		off[u.line] = off[u.line].Add(u.price.MulFrac(int64(percent), 100, money.HalfUp))
+	}
+	for i := range off {
+		off[i] = money.Min(off[i], remaining[i])
+	}
+	return off
+}
@@ -0,0 +1,262 @@
+package promotions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func inr(amount int64) money.Money { return money.New(amount, "INR") }
+
+// testCart is two shoes at 10.00 and a pair of socks at 5.00, with 1.00
+// shipping.
+func testCart(codes ...string) Cart {
+	return Cart{
+		Currency: "INR",
+		Lines: []Line{
+			{ProductID: primitive.NewObjectID(), Category: "shoes", UnitPrice: inr(1000), Quantity: 2},
+			{ProductID: primitive.NewObjectID(), Category: "socks", UnitPrice: inr(500), Quantity: 1},
+		},
+		Shipping: inr(100),
+		Codes:    codes,
+		Now:      now,
+	}
+}
+
+func percentOff(name string, percent uint) models.Promotion {
+	return models.Promotion{ID: primitive.NewObjectID(), Name: name, Type: models.PromotionPercentOff, PercentOff: percent, Active: true}
+}
+
+func amountOff(name string, amount money.Money) models.Promotion {
+	return models.Promotion{ID: primitive.NewObjectID(), Name: name, Type: models.PromotionAmountOff, AmountOff: amount, Active: true}
+}
+
+func coupon(p models.Promotion, code string) models.Promotion {
+	p.Code = code
+	return p
+}
+
+func stackable(p models.Promotion, priority int) models.Promotion {
+	p.Stackable = true
+	p.Priority = priority
+	return p
+}
+
+func TestEvaluate(t *testing.T) {
+	socksOnly := percentOff("socks 10%", 10)
+	socksOnly.Categories = []string{"SOCKS"}
+	tooSmall := percentOff("big spender", 10)
+	tooSmall.MinSubtotal = inr(5000)
+	expired := coupon(percentOff("last season", 10), "OLD")
+	expired.EndsAt = now.Add(-time.Hour)
+	inactive := percentOff("paused", 50)
+	inactive.Active = false
+	notYet := percentOff("tomorrow", 50)
+	notYet.StartsAt = now.Add(24 * time.Hour)
+	freeShipping := models.Promotion{ID: primitive.NewObjectID(), Name: "free shipping", Type: models.PromotionFreeShipping, Active: true}
+	threeForTwo := models.Promotion{ID: primitive.NewObjectID(), Name: "3 for 2", Type: models.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Active: true}
+	halfOffThird := threeForTwo
+	halfOffThird.Name = "third at half price"
+	halfOffThird.GetPercent = 50
+
+	tests := []struct {
+		name       string
+		codes      []string
+		candidates []models.Promotion
+		lines      []int64
+		shipping   int64
+		applied    []string
+		err        error
+	}{
+		{
+			name:  "nothing to apply",
+			lines: []int64{0, 0},
+		},
+		{
+			name:       "percent off is spread by line value",
+			candidates: []models.Promotion{percentOff("10%", 10)},
+			lines:      []int64{200, 50},
+			applied:    []string{"10%"},
+		},
+		{
+			name:       "scoped to a category, ignoring case",
+			candidates: []models.Promotion{socksOnly},
+			lines:      []int64{0, 50},
+			applied:    []string{"socks 10%"},
+		},
+		{
+			name:       "amount off is capped at the cart",
+			candidates: []models.Promotion{amountOff("huge", inr(300000))},
+			lines:      []int64{2000, 500},
+			applied:    []string{"huge"},
+		},
+		{
+			name:       "minimum subtotal not met",
+			candidates: []models.Promotion{tooSmall},
+			lines:      []int64{0, 0},
+		},
+		{
+			name:       "promotions that are not live are left out",
+			candidates: []models.Promotion{inactive, notYet},
+			lines:      []int64{0, 0},
+		},
+		{
+			name: "best exclusive promotion beats the stackable ones",
+			candidates: []models.Promotion{
+				stackable(percentOff("5%", 5), 0),
+				stackable(amountOff("1 off", inr(100)), 0),
+				percentOff("20%", 20),
+			},
+			lines:   []int64{400, 100},
+			applied: []string{"20%"},
+		},
+		{
+			name: "stackable promotions together beat an exclusive one",
+			candidates: []models.Promotion{
+				stackable(percentOff("10%", 10), 0),
+				stackable(percentOff("15%", 15), 1),
+				percentOff("20%", 20),
+			},
+			// 15% first, then 10% of what is left, rounded half up.
+			lines:   []int64{471, 117},
+			applied: []string{"15%", "10%"},
+		},
+		{
+			name:       "free shipping",
+			candidates: []models.Promotion{freeShipping},
+			lines:      []int64{0, 0},
+			shipping:   100,
+			applied:    []string{"free shipping"},
+		},
+		{
+			name:       "buy two get the cheapest free",
+			candidates: []models.Promotion{threeForTwo},
+			lines:      []int64{0, 500},
+			applied:    []string{"3 for 2"},
+		},
+		{
+			name:       "buy two get the cheapest at half price",
+			candidates: []models.Promotion{halfOffThird},
+			lines:      []int64{0, 250},
+			applied:    []string{"third at half price"},
+		},
+		{
+			name:       "coupon codes are normalized and counted once",
+			codes:      []string{" save10 ", "SAVE10"},
+			candidates: []models.Promotion{coupon(percentOff("save 10", 10), "SAVE10")},
+			lines:      []int64{200, 50},
+			applied:    []string{"save 10"},
+		},
+		{
+			name:  "a coupon that does not stack is used alone",
+			codes: []string{"SAVE10"},
+			candidates: []models.Promotion{
+				coupon(percentOff("save 10", 10), "SAVE10"),
+				stackable(amountOff("1 off", inr(100)), 0),
+			},
+			lines:   []int64{200, 50},
+			applied: []string{"save 10"},
+		},
+		{
+			name:  "a stackable coupon joins the stackable promotions",
+			codes: []string{"SHIP"},
+			candidates: []models.Promotion{
+				stackable(coupon(freeShipping, "SHIP"), 0),
+				stackable(amountOff("1 off", inr(100)), 0),
+				percentOff("20%", 20),
+			},
+			lines:    []int64{80, 20},
+			shipping: 100,
+			applied:  []string{"free shipping", "1 off"},
+		},
+		{
+			name:       "unknown coupon",
+			codes:      []string{"NOPE"},
+			candidates: []models.Promotion{percentOff("10%", 10)},
+			err:        ErrUnknownCoupon,
+		},
+		{
+			name:       "expired coupon",
+			codes:      []string{"OLD"},
+			candidates: []models.Promotion{expired},
+			err:        ErrUnknownCoupon,
+		},
+		{
+			name:       "coupon below its minimum",
+			codes:      []string{"BIG"},
+			candidates: []models.Promotion{coupon(tooSmall, "BIG")},
+			err:        ErrCouponNotApplicable,
+		},
+		{
+			name:  "coupons that do not stack",
+			codes: []string{"A", "B"},
+			candidates: []models.Promotion{
+				coupon(percentOff("a", 10), "A"),
+				stackable(coupon(percentOff("b", 10), "B"), 0),
+			},
+			err: ErrCouponsDontStack,
+		},
+		{
+			name:       "automatic promotion in another currency is left out",
+			candidates: []models.Promotion{amountOff("5 dollars", money.New(500, "USD"))},
+			lines:      []int64{0, 0},
+		},
+		{
+			name:       "coupon in another currency fails",
+			codes:      []string{"USD5"},
+			candidates: []models.Promotion{coupon(amountOff("5 dollars", money.New(500, "USD")), "USD5")},
+			err:        money.ErrCurrencyMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Evaluate(testCart(tt.codes...), tt.candidates)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if len(result.LineDiscounts) != len(tt.lines) {
+				t.Fatalf("line discounts = %v, want %v", result.LineDiscounts, tt.lines)
+			}
+			for i, want := range tt.lines {
+				if result.LineDiscounts[i] != inr(want) {
+					t.Errorf("line %d discount = %v, want %d", i, result.LineDiscounts[i], want)
+				}
+			}
+			if result.ShippingDiscount != inr(tt.shipping) {
+				t.Errorf("shipping discount = %v, want %d", result.ShippingDiscount, tt.shipping)
+			}
+			if len(result.Applied) != len(tt.applied) {
+				t.Fatalf("applied %+v, want %v", result.Applied, tt.applied)
+			}
+			for i, name := range tt.applied {
+				if result.Applied[i].Name != name {
+					t.Errorf("applied[%d] = %q, want %q", i, result.Applied[i].Name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateConvertsAmounts(t *testing.T) {
+	cart := testCart()
+	// One dollar buys 80 rupees.
+	cart.Convert = func(amount money.Money) (money.Money, error) {
+		return money.New(amount.Amount*80, "INR"), nil
+	}
+	result, err := Evaluate(cart, []models.Promotion{amountOff("5 dollars", money.New(5, "USD"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Discount(); got != inr(400) {
+		t.Errorf("discount = %v, want INR 4.00", got)
+	}
+}
@@ -11,5 +11,12 @@ func UserRoutes(incomingRoutes *gin.Engine) {
 	// incomingRoutes.POST("/admin/addproduct", controllers.ProductViewerAdmin())
 	incomingRoutes.GET("/users/productview", controllers.SearchProduct())
 	incomingRoutes.GET("/users/search", controllers.SearchProductByQuery())
+	incomingRoutes.GET("/users/categories", controllers.ListCategories())
+	incomingRoutes.GET("/users/categories/:slug", controllers.GetCategory())
+	incomingRoutes.GET("/users/categories/:slug/products", controllers.BrowseCategory())
+	incomingRoutes.GET("/users/brands", controllers.ListBrands())
+	incomingRoutes.GET("/users/brands/:slug/products", controllers.BrowseBrand())
+	incomingRoutes.GET("/users/collections", controllers.ListCollections())
+	incomingRoutes.GET("/users/collections/:slug/products", controllers.BrowseCollection())
 
 }
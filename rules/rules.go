@@ -0,0 +1,505 @@
+// Package rules parses the rules rule-based collections pick their products
+// by, such as "rating >= 4 and price < 1000", and turns them into MongoDB
+// filters over the fields of models.Product. It does not touch the
+// database: the caller resolves the categories and brands a rule names.
+//
+// A rule compares fields with values and joins the comparisons with and, or
+// and not, grouped with parentheses:
+//
+//	brand in (apple, samsung) and not (category = refurbished)
+//	category = laptops and attributes.RAM >= 16
+//
+// The fields are name, price, rating, weight, tax_class, category, brand
+// and attributes.<name>. Prices are in major units of
+// money.DefaultCurrency. A category takes in the categories below it.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kshzz24/ecomm-go/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidRule = errors.New("collection rule is not valid")
+
+// Resolver looks up what a rule names by slug. Categories returns the ids
+// of the category and of every category below it.
+type Resolver interface {
+	Categories(slug string) ([]primitive.ObjectID, error)
+	Brand(slug string) (primitive.ObjectID, error)
+}
+
+type kind int
+
+const (
+	kindText kind = iota
+	kindNumber
+	kindMoney
+	kindCategory
+	kindBrand
+	kindAttribute
+)
+
+type field struct {
+	path string
+	kind kind
+}
+
+var fields = map[string]field{
+	"name":      {"product_name", kindText},
+	"price":     {"price", kindMoney},
+	"rating":    {"rating", kindNumber},
+	"weight":    {"weight_grams", kindNumber},
+	"tax_class": {"tax_class", kindText},
+	"category":  {"category_ids", kindCategory},
+	"brand":     {"brand_id", kindBrand},
+}
+
+// Rule is a parsed rule.
+type Rule struct {
+	source string
+	root   node
+}
+
+func (r *Rule) String() string {
+	return r.source
+}
+
+// Parse parses rule, checking every comparison against the field it is
+// on.
+func Parse(rule string) (*Rule, error) {
+	tokens, err := lex(rule)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return &Rule{source: strings.TrimSpace(rule), root: root}, nil
+}
+
+// Filter turns the rule into a MongoDB filter on products, resolving the
+// categories and brands it names through resolve.
+func (r *Rule) Filter(resolve Resolver) (bson.M, error) {
+	return r.root.filter(resolve)
+}
+
+type node interface {
+	filter(resolve Resolver) (bson.M, error)
+}
+
+type and []node
+type or []node
+
+type not struct {
+	operand node
+}
+
+func (n and) filter(resolve Resolver) (bson.M, error) {
+	filters, err := filterAll(n, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$and": filters}, nil
+}
+
+func (n or) filter(resolve Resolver) (bson.M, error) {
+	filters, err := filterAll(n, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$or": filters}, nil
+}
+
+func (n not) filter(resolve Resolver) (bson.M, error) {
+	operand, err := n.operand.filter(resolve)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$nor": bson.A{operand}}, nil
+}
+
+func filterAll(nodes []node, resolve Resolver) (bson.A, error) {
+	filters := make(bson.A, len(nodes))
+	for i, n := range nodes {
+		f, err := n.filter(resolve)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = f
+	}
+	return filters, nil
+}
+
+// value is a literal of a rule: a number, text or true or false.
+type value struct {
+	text   string
+	number *float64
+	bool   *bool
+}
+
+func (v value) native() interface{} {
+	switch {
+	case v.number != nil:
+		return *v.number
+	case v.bool != nil:
+		return *v.bool
+	default:
+		return v.text
+	}
+}
+
+// comparison compares a field with one value, or with a list of them for
+// in.
+type comparison struct {
+	field  field
+	name   string
+	path   string
+	op     string
+	values []value
+}
+
+var mongoOps = map[string]string{
+	"=": "$eq", "!=": "$ne", "<": "$lt", "<=": "$lte", ">": "$gt", ">=": "$gte", "in": "$in",
+}
+
+func (c comparison) filter(resolve Resolver) (bson.M, error) {
+	switch c.field.kind {
+	case kindMoney:
+		price, err := money.Parse(c.values[0].text, money.DefaultCurrency)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not a price", ErrInvalidRule, c.values[0].text)
+		}
+		return bson.M{
+			c.path + ".currency": price.Currency,
+			c.path + ".amount":   bson.M{mongoOps[c.op]: price.Amount},
+		}, nil
+	case kindCategory:
+		var ids []primitive.ObjectID
+		for _, v := range c.values {
+			found, err := resolve.Categories(v.text)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, found...)
+		}
+		return inFilter(c.path, c.op, ids), nil
+	case kindBrand:
+		var ids []primitive.ObjectID
+		for _, v := range c.values {
+			id, err := resolve.Brand(v.text)
+			if err != nil {
+				return nil, err
+			}
+			if !id.IsZero() {
+				ids = append(ids, id)
+			}
+		}
+		return inFilter(c.path, c.op, ids), nil
+	}
+	if c.op == "in" {
+		values := make(bson.A, len(c.values))
+		for i, v := range c.values {
+			values[i] = v.native()
+		}
+		return bson.M{c.path: bson.M{"$in": values}}, nil
+	}
+	return bson.M{c.path: bson.M{mongoOps[c.op]: c.values[0].native()}}, nil
+}
+
+// inFilter matches documents whose path holds one of ids, or with != none
+// of them.
+func inFilter(path, op string, ids []primitive.ObjectID) bson.M {
+	if ids == nil {
+		ids = []primitive.ObjectID{}
+	}
+	if op == "!=" {
+		return bson.M{path: bson.M{"$nin": ids}}
+	}
+	return bson.M{path: bson.M{"$in": ids}}
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at column %d", ErrInvalidRule, fmt.Sprintf(format, args...), t.column)
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	nodes := or{left}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	nodes := and{left}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.keyword("not") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand}, nil
+	}
+	if t := p.peek(); t.kind == tokenPunct && t.text == "(" {
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenPunct || t.text != ")" {
+			return nil, p.errorf(t, "expected )")
+		}
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, p.errorf(t, "expected a field")
+	}
+	c := comparison{name: t.text, path: t.text}
+	if name, ok := strings.CutPrefix(t.text, "attributes."); ok && name != "" {
+		c.field = field{path: t.text, kind: kindAttribute}
+	} else if f, ok := fields[strings.ToLower(t.text)]; ok {
+		c.field = f
+		c.path = f.path
+	} else {
+		return nil, p.errorf(t, "unknown field %s", t.text)
+	}
+
+	opToken := p.next()
+	switch {
+	case opToken.kind == tokenOp:
+		c.op = opToken.text
+		if c.op == "==" {
+			c.op = "="
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		c.values = []value{v}
+	case opToken.kind == tokenWord && strings.EqualFold(opToken.text, "in"):
+		c.op = "in"
+		values, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		c.values = values
+	default:
+		return nil, p.errorf(opToken, "expected a comparison")
+	}
+	if err := c.check(); err != nil {
+		return nil, p.errorf(t, "%s", err)
+	}
+	return c, nil
+}
+
+// check reports comparisons the field cannot make.
+func (c comparison) check() error {
+	ordered := c.op != "=" && c.op != "!=" && c.op != "in"
+	for _, v := range c.values {
+		switch c.field.kind {
+		case kindNumber, kindMoney:
+			if v.number == nil {
+				return fmt.Errorf("%s compares with numbers", c.name)
+			}
+		case kindText, kindCategory, kindBrand:
+			if v.number != nil || v.bool != nil {
+				return fmt.Errorf("%s compares with names", c.name)
+			}
+			if ordered {
+				return fmt.Errorf("%s can only be compared with =, != and in", c.name)
+			}
+		case kindAttribute:
+			if ordered && v.number == nil {
+				return fmt.Errorf("only numbers can be compared with %s", c.op)
+			}
+		}
+	}
+	if c.field.kind == kindMoney && c.op == "in" {
+		return fmt.Errorf("%s cannot be compared with in", c.name)
+	}
+	return nil
+}
+
+func (p *parser) value() (value, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return value{}, p.errorf(t, "%s is not a number", t.text)
+		}
+		return value{text: t.text, number: &n}, nil
+	case tokenString:
+		return value{text: t.text}, nil
+	case tokenWord:
+		if strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false") {
+			b := strings.EqualFold(t.text, "true")
+			return value{text: t.text, bool: &b}, nil
+		}
+		return value{text: t.text}, nil
+	}
+	return value{}, p.errorf(t, "expected a value")
+}
+
+func (p *parser) list() ([]value, error) {
+	if t := p.next(); t.kind != tokenPunct || t.text != "(" {
+		return nil, p.errorf(t, "expected (")
+	}
+	var values []value
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		t := p.next()
+		if t.kind == tokenPunct && t.text == ")" {
+			return values, nil
+		}
+		if t.kind != tokenPunct || t.text != "," {
+			return nil, p.errorf(t, "expected , or )")
+		}
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenNumber
+	tokenString
+	tokenOp
+	tokenPunct
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	column int
+}
+
+func lex(rule string) ([]token, error) {
+	var tokens []token
+	runes := []rune(rule)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(' || r == ')' || r == ',':
+			i++
+			tokens = append(tokens, token{tokenPunct, string(r), start + 1})
+		case strings.ContainsRune("=!<>", r):
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, fmt.Errorf("%w: expected != at column %d", ErrInvalidRule, start+1)
+			}
+			tokens = append(tokens, token{tokenOp, op, start + 1})
+		case r == '"' || r == '\'':
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("%w: unterminated text at column %d", ErrInvalidRule, start+1)
+			}
+			i++
+			tokens = append(tokens, token{tokenString, string(runes[start+1 : i-1]), start + 1})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i]), start + 1})
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune("_.-", runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[start:i]), start + 1})
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q at column %d", ErrInvalidRule, r, start+1)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: rule is empty", ErrInvalidRule)
+	}
+	return append(tokens, token{tokenEnd, "end of rule", len(runes) + 1}), nil
+}
@@ -0,0 +1,190 @@
+package rules
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errUnknownSlug = errors.New("unknown slug")
+
+// fakeResolver resolves from maps. A category or brand missing from them
+// is an error, unless it is in gone, when it matches nothing.
+type fakeResolver struct {
+	categories map[string][]primitive.ObjectID
+	brands     map[string]primitive.ObjectID
+	gone       map[string]bool
+}
+
+func (r fakeResolver) Categories(slug string) ([]primitive.ObjectID, error) {
+	if ids, ok := r.categories[slug]; ok {
+		return ids, nil
+	}
+	if r.gone[slug] {
+		return nil, nil
+	}
+	return nil, errUnknownSlug
+}
+
+func (r fakeResolver) Brand(slug string) (primitive.ObjectID, error) {
+	if id, ok := r.brands[slug]; ok {
+		return id, nil
+	}
+	if r.gone[slug] {
+		return primitive.NilObjectID, nil
+	}
+	return primitive.NilObjectID, errUnknownSlug
+}
+
+func TestFilter(t *testing.T) {
+	laptops, gaming := primitive.NewObjectID(), primitive.NewObjectID()
+	refurbished := primitive.NewObjectID()
+	apple, samsung := primitive.NewObjectID(), primitive.NewObjectID()
+	resolve := fakeResolver{
+		categories: map[string][]primitive.ObjectID{
+			"laptops":     {laptops, gaming},
+			"refurbished": {refurbished},
+		},
+		brands: map[string]primitive.ObjectID{"apple": apple, "samsung": samsung},
+		gone:   map[string]bool{"discontinued": true},
+	}
+	rating := func(op string, n float64) bson.M { return bson.M{"rating": bson.M{op: n}} }
+
+	tests := []struct {
+		rule string
+		want bson.M
+	}{
+		{"rating >= 4", rating("$gte", 4)},
+		{"Rating == 5", rating("$eq", 5)},
+		{"rating != 0", rating("$ne", 0)},
+		{"weight <= 500", bson.M{"weight_grams": bson.M{"$lte": 500.0}}},
+		{"price < 1000", bson.M{"price.currency": "INR", "price.amount": bson.M{"$lt": int64(100000)}}},
+		{"price >= 10.5", bson.M{"price.currency": "INR", "price.amount": bson.M{"$gte": int64(1050)}}},
+		{`name = "Blue Shirt"`, bson.M{"product_name": bson.M{"$eq": "Blue Shirt"}}},
+		{"tax_class in (reduced, 'zero')", bson.M{"tax_class": bson.M{"$in": bson.A{"reduced", "zero"}}}},
+		{"attributes.color = red", bson.M{"attributes.color": bson.M{"$eq": "red"}}},
+		{"attributes.wireless = true", bson.M{"attributes.wireless": bson.M{"$eq": true}}},
+		{"attributes.RAM >= 16", bson.M{"attributes.RAM": bson.M{"$gte": 16.0}}},
+		{"attributes.size in (8, 9.5)", bson.M{"attributes.size": bson.M{"$in": bson.A{8.0, 9.5}}}},
+		{"category = laptops", bson.M{"category_ids": bson.M{"$in": []primitive.ObjectID{laptops, gaming}}}},
+		{"category != refurbished", bson.M{"category_ids": bson.M{"$nin": []primitive.ObjectID{refurbished}}}},
+		{"category in (laptops, refurbished)", bson.M{"category_ids": bson.M{"$in": []primitive.ObjectID{laptops, gaming, refurbished}}}},
+		{"category = discontinued", bson.M{"category_ids": bson.M{"$in": []primitive.ObjectID{}}}},
+		{"brand in (apple, samsung)", bson.M{"brand_id": bson.M{"$in": []primitive.ObjectID{apple, samsung}}}},
+		{"brand in (apple, discontinued)", bson.M{"brand_id": bson.M{"$in": []primitive.ObjectID{apple}}}},
+		{"brand = discontinued", bson.M{"brand_id": bson.M{"$in": []primitive.ObjectID{}}}},
+		{
+			"rating >= 4 and price < 1000",
+			bson.M{"$and": bson.A{rating("$gte", 4), bson.M{"price.currency": "INR", "price.amount": bson.M{"$lt": int64(100000)}}}},
+		},
+		{
+			// and binds tighter than or.
+			"rating = 1 or rating = 2 and rating = 3",
+			bson.M{"$or": bson.A{rating("$eq", 1), bson.M{"$and": bson.A{rating("$eq", 2), rating("$eq", 3)}}}},
+		},
+		{
+			"(rating = 1 or rating = 2) AND rating = 3",
+			bson.M{"$and": bson.A{bson.M{"$or": bson.A{rating("$eq", 1), rating("$eq", 2)}}, rating("$eq", 3)}},
+		},
+		{
+			"brand = apple and not (category = refurbished)",
+			bson.M{"$and": bson.A{
+				bson.M{"brand_id": bson.M{"$in": []primitive.ObjectID{apple}}},
+				bson.M{"$nor": bson.A{bson.M{"category_ids": bson.M{"$in": []primitive.ObjectID{refurbished}}}}},
+			}},
+		},
+		{"not not rating > 3", bson.M{"$nor": bson.A{bson.M{"$nor": bson.A{rating("$gt", 3)}}}}},
+		{"rating > -1", rating("$gt", -1)},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.rule)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+			continue
+		}
+		got, err := rule.Filter(resolve)
+		if err != nil {
+			t.Errorf("Filter(%q): %v", tt.rule, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Filter(%q) = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		rule    string
+		message string
+	}{
+		{"", "rule is empty"},
+		{"   ", "rule is empty"},
+		{"colour = red", "unknown field colour at column 1"},
+		{"rating >= high", "rating compares with numbers"},
+		{"price = cheap", "price compares with numbers"},
+		{"name = 5", "name compares with names"},
+		{"name > a", "name can only be compared with =, != and in"},
+		{"brand < apple", "brand can only be compared with =, != and in"},
+		{"price in (1, 2)", "price cannot be compared with in"},
+		{"attributes.color > red", "only numbers can be compared with >"},
+		{"rating >= 4 and", "expected a field at column 16"},
+		{"(rating > 3", "expected ) at column 12"},
+		{"rating ! 3", "expected != at column 8"},
+		{"name = 'open", "unterminated text at column 8"},
+		{"rating 4", "expected a comparison at column 8"},
+		{"rating >= 4 extra", `unexpected "extra" at column 13`},
+		{"brand in (apple samsung)", "expected , or ) at column 17"},
+		{"brand in apple", "expected ( at column 10"},
+		{"rating # 3", `unexpected '#' at column 8`},
+		{"rating = 1.2.3", "1.2.3 is not a number"},
+		{"attributes. = 1", "unknown field attributes."},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.rule)
+		if !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("Parse(%q) err = %v, want %v", tt.rule, err, ErrInvalidRule)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("Parse(%q) err = %q, want it to mention %q", tt.rule, err, tt.message)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	resolve := fakeResolver{}
+	tests := []struct {
+		rule string
+		want error
+	}{
+		{"price < 10.555", ErrInvalidRule},
+		{"category = nowhere", errUnknownSlug},
+		{"rating > 3 and brand = nobody", errUnknownSlug},
+		{"not (brand in (nobody))", errUnknownSlug},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.rule)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+			continue
+		}
+		if _, err := rule.Filter(resolve); !errors.Is(err, tt.want) {
+			t.Errorf("Filter(%q) err = %v, want %v", tt.rule, err, tt.want)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	rule, err := Parse("  rating >= 4 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rule.String(); got != "rating >= 4" {
+		t.Errorf("String() = %q, want %q", got, "rating >= 4")
+	}
+}
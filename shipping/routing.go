@@ -0,0 +1,79 @@
+package shipping
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+// RankWarehouses orders the active warehouses among warehouses from the
+// nearest to an address to the farthest. Warehouses in the address's
+// country come first. Among them, the longer the postal code a warehouse
+// shares with the address the nearer it is: Indian PIN codes narrow down
+// from region to sorting district digit by digit. Numeric codes sharing
+// as much are then compared by how far apart they are. Otherwise the
+// order of warehouses is kept.
+func RankWarehouses(warehouses []models.Warehouse, country, postalCode string) []models.Warehouse {
+	country = normalize(country)
+	postal := normalizePostal(postalCode)
+
+	type ranked struct {
+		warehouse   models.Warehouse
+		sameCountry bool
+		shared      int
+		gap         uint64
+	}
+	var candidates []ranked
+	for _, warehouse := range warehouses {
+		if !warehouse.Active {
+			continue
+		}
+		theirs := normalizePostal(warehouse.Address.PostalCode())
+		candidates = append(candidates, ranked{
+			warehouse:   warehouse,
+			sameCountry: country == "" || normalize(warehouse.Address.Country) == "" || normalize(warehouse.Address.Country) == country,
+			shared:      sharedPrefix(postal, theirs),
+			gap:         numericGap(postal, theirs),
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.sameCountry != b.sameCountry {
+			return a.sameCountry
+		}
+		if a.shared != b.shared {
+			return a.shared > b.shared
+		}
+		return a.gap < b.gap
+	})
+
+	nearest := make([]models.Warehouse, len(candidates))
+	for i, candidate := range candidates {
+		nearest[i] = candidate.warehouse
+	}
+	return nearest
+}
+
+// sharedPrefix is how many leading characters a and b have in common.
+func sharedPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// numericGap is how far apart two numeric postal codes of the same length
+// are, or the largest gap if they cannot be compared.
+func numericGap(a, b string) uint64 {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	if errA != nil || errB != nil || len(a) != len(b) {
+		return ^uint64(0)
+	}
+	if x > y {
+		return x - y
+	}
+	return y - x
+}
@@ -0,0 +1,61 @@
+package shipping
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kshzz24/ecomm-go/models"
+)
+
+func warehouse(code, country, postcode string, active bool) models.Warehouse {
+	return models.Warehouse{
+		Code:    code,
+		Address: models.Address{Country: country, Postcode: postcode},
+		Active:  active,
+	}
+}
+
+func TestRankWarehouses(t *testing.T) {
+	warehouses := []models.Warehouse{
+		warehouse("DEL", "IN", "110001", true),
+		warehouse("MUM", "in", "400001", true),
+		warehouse("BLR2", "IN", "560100", true),
+		warehouse("OFF", "IN", "560002", false),
+		warehouse("BLR", "IN", "560001", true),
+		warehouse("LON", "GB", "SW1A 1AA", true),
+		warehouse("ANY", "", "", true),
+	}
+
+	tests := []struct {
+		name       string
+		country    string
+		postalCode string
+		want       string
+	}{
+		{"longest shared PIN prefix first, then nearest PIN", "IN", "560002", "BLR BLR2 MUM DEL ANY LON"},
+		{"nearest PIN among those sharing nothing", "IN", "400070", "MUM BLR BLR2 DEL ANY LON"},
+		{"codes are compared without case or spaces", " in ", "560 002", "BLR BLR2 MUM DEL ANY LON"},
+		{"other countries come last", "GB", "sw1a 2aa", "LON ANY DEL MUM BLR2 BLR"},
+		{"no address keeps the order", "", "", "DEL MUM BLR2 BLR LON ANY"},
+		{"country without a postal code", "GB", "", "LON ANY DEL MUM BLR2 BLR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranked := RankWarehouses(warehouses, tt.country, tt.postalCode)
+			codes := make([]string, len(ranked))
+			for i, w := range ranked {
+				codes[i] = w.Code
+			}
+			if got := strings.Join(codes, " "); got != tt.want {
+				t.Errorf("RankWarehouses(%q, %q) = %s, want %s", tt.country, tt.postalCode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankWarehousesNoneActive(t *testing.T) {
+	ranked := RankWarehouses([]models.Warehouse{warehouse("OFF", "IN", "560001", false)}, "IN", "560001")
+	if len(ranked) != 0 {
+		t.Errorf("ranked %d warehouses, want none", len(ranked))
+	}
+}
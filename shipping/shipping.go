@@ -0,0 +1,228 @@
+// Package shipping works out what delivering an order costs with each
+// shipping method, from the zone its address falls in and that zone's rate
+// table, and which warehouse is nearest to that address. Like package
+// promotions it does not touch the database: the caller loads the zones
+// and warehouses and stores what it picks.
+package shipping
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+)
+
+var (
+	ErrNotShippable      = errors.New("cannot ship to this address")
+	ErrMethodUnavailable = errors.New("shipping method is not available for this address")
+	ErrUnknownMethod     = errors.New("unknown shipping method")
+)
+
+// VolumetricDivisor is the cubic centimetres carriers count as a
+// kilogram. A parcel is charged by its volumetric weight when that is more
+// than what it actually weighs.
+const VolumetricDivisor = 5000
+
+// UnitWeight is the weight, in grams, one unit of a product is charged
+// for: its actual weight or its volumetric weight, whichever is more.
+func UnitWeight(weight uint, dimensions *models.Dimensions) uint {
+	if dimensions == nil {
+		return weight
+	}
+	// Millimetres cubed over the divisor in cubic centimetres per kilogram
+	// comes out in grams.
+	volumetric := uint64(dimensions.Length) * uint64(dimensions.Width) * uint64(dimensions.Height) / VolumetricDivisor
+	if volumetric > uint64(weight) {
+		return uint(volumetric)
+	}
+	return weight
+}
+
+// Parcel is an order as shipping sees it. Subtotal is what its lines cost
+// before discounts, and its currency is the one quotes are given in.
+// Convert brings rates in other currencies into it; without it, rates in
+// another currency are skipped.
+type Parcel struct {
+	Country    string
+	PostalCode string
+	Weight     uint
+	Subtotal   money.Money
+	Convert    func(money.Money) (money.Money, error)
+}
+
+// Zone returns the zone among zones that parcel ships to: the one with
+// the longest postal prefix matching it, then one that covers its whole
+// country, then one without countries. Inactive zones are skipped.
+func Zone(zones []models.ShippingZone, parcel Parcel) (models.ShippingZone, bool) {
+	country := normalize(parcel.Country)
+	postal := normalizePostal(parcel.PostalCode)
+	best, bestScore := models.ShippingZone{}, -1
+	for _, zone := range zones {
+		if !zone.Active {
+			continue
+		}
+		score := match(zone, country, postal)
+		if score > bestScore {
+			best, bestScore = zone, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// match scores how specifically zone covers an address, or returns -1 if
+// it does not cover it.
+func match(zone models.ShippingZone, country, postal string) int {
+	if len(zone.Countries) == 0 {
+		return 0
+	}
+	inCountry := false
+	for _, c := range zone.Countries {
+		if normalize(c) == country {
+			inCountry = true
+			break
+		}
+	}
+	if !inCountry {
+		return -1
+	}
+	if len(zone.PostalPrefixes) == 0 {
+		return 1
+	}
+	score := -1
+	for _, prefix := range zone.PostalPrefixes {
+		prefix = normalizePostal(prefix)
+		if prefix != "" && strings.HasPrefix(postal, prefix) && 1+len(prefix) > score {
+			score = 1 + len(prefix)
+		}
+	}
+	return score
+}
+
+// Quote prices every shipping method the zone parcel ships to offers for
+// it, in the order of models.ShippingMethods. A method is priced by the
+// first row of the zone's rate table that it fits.
+func Quote(zones []models.ShippingZone, parcel Parcel) ([]models.ShippingQuote, error) {
+	zone, ok := Zone(zones, parcel)
+	if !ok {
+		return nil, ErrNotShippable
+	}
+	free := false
+	if zone.FreeOver.IsPositive() {
+		threshold, err := parcel.convert(zone.FreeOver)
+		if err == nil && !parcel.Subtotal.LessThan(threshold) {
+			free = true
+		}
+	}
+
+	var quotes []models.ShippingQuote
+	for _, method := range models.ShippingMethods {
+		for _, rate := range zone.Rates {
+			if rate.Method != method {
+				continue
+			}
+			price, fits, err := parcel.price(rate)
+			if err != nil || !fits {
+				continue
+			}
+			quote := models.ShippingQuote{
+				Method:       method,
+				ZoneID:       zone.ID,
+				Zone:         zone.Name,
+				Price:        price,
+				Weight:       parcel.Weight,
+				DeliveryDays: rate.DeliveryDays,
+			}
+			if free && method == models.ShippingStandard {
+				quote.Price = money.Zero(parcel.Subtotal.Currency)
+				quote.Free = true
+			}
+			quotes = append(quotes, quote)
+			break
+		}
+	}
+	if len(quotes) == 0 {
+		return nil, ErrNotShippable
+	}
+	return quotes, nil
+}
+
+// Select picks the quote for method, standard delivery when method is
+// empty.
+func Select(quotes []models.ShippingQuote, method string) (models.ShippingQuote, error) {
+	if method == "" {
+		method = models.ShippingStandard
+	}
+	if !models.IsShippingMethod(method) {
+		return models.ShippingQuote{}, fmt.Errorf("%w: %s", ErrUnknownMethod, method)
+	}
+	for _, quote := range quotes {
+		if quote.Method == method {
+			return quote, nil
+		}
+	}
+	return models.ShippingQuote{}, fmt.Errorf("%w: %s", ErrMethodUnavailable, method)
+}
+
+// price reports whether parcel fits rate and what rate charges for it.
+func (parcel Parcel) price(rate models.ShippingRate) (money.Money, bool, error) {
+	if parcel.Weight < rate.MinWeight || (rate.MaxWeight > 0 && parcel.Weight > rate.MaxWeight) {
+		return money.Money{}, false, nil
+	}
+	if !rate.MinSubtotal.IsZero() {
+		minimum, err := parcel.convert(rate.MinSubtotal)
+		if err != nil {
+			return money.Money{}, false, err
+		}
+		if parcel.Subtotal.LessThan(minimum) {
+			return money.Money{}, false, nil
+		}
+	}
+	if !rate.MaxSubtotal.IsZero() {
+		maximum, err := parcel.convert(rate.MaxSubtotal)
+		if err != nil {
+			return money.Money{}, false, err
+		}
+		if !parcel.Subtotal.LessThan(maximum) {
+			return money.Money{}, false, nil
+		}
+	}
+
+	price, err := parcel.convert(rate.Price)
+	if err != nil {
+		return money.Money{}, false, err
+	}
+	if rate.PerKg.IsPositive() && parcel.Weight > rate.FirstWeight {
+		perKg, err := parcel.convert(rate.PerKg)
+		if err != nil {
+			return money.Money{}, false, err
+		}
+		// Every started kilogram past the first weight is charged.
+		extra := (parcel.Weight - rate.FirstWeight + 999) / 1000
+		price = price.Add(perKg.Mul(int64(extra)))
+	}
+	return price, true, nil
+}
+
+// convert brings amount into the parcel's currency.
+func (parcel Parcel) convert(amount money.Money) (money.Money, error) {
+	currency := parcel.Subtotal.Currency
+	if amount.Currency == "" || amount.Currency == currency {
+		return amount.In(currency), nil
+	}
+	if parcel.Convert == nil {
+		return money.Money{}, fmt.Errorf("%w: %s and %s", money.ErrCurrencyMismatch, amount.Currency, currency)
+	}
+	return parcel.Convert(amount)
+}
+
+func normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// normalizePostal compares postal codes without case or spaces, so
+// "SW1A 1AA" starts with "SW1A".
+func normalizePostal(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
@@ -0,0 +1,77 @@
+package tax
+
+import "github.com/kshzz24/ecomm-go/models"
+
+// gstRates are the Indian GST slabs, in basis points, by tax class.
+var gstRates = map[string]uint{
+	models.TaxClassStandard:     1800,
+	models.TaxClassReduced:      1200,
+	models.TaxClassSuperReduced: 500,
+	models.TaxClassLuxury:       2800,
+	models.TaxClassZero:         0,
+	models.TaxClassExempt:       0,
+}
+
+// vatRate holds a member state's VAT rates in basis points. A country
+// without a super-reduced rate charges its reduced rate instead, and one
+// without a reduced rate its standard rate.
+type vatRate struct {
+	standard     uint
+	reduced      uint
+	superReduced uint
+}
+
+// vatRates are the VAT rates of the EU member states, charged on sales to
+// consumers at the rate of the country the goods ship to. Keep them in step
+// with the rates the member states publish.
+var vatRates = map[string]vatRate{
+	"AT": {standard: 2000, reduced: 1000},
+	"BE": {standard: 2100, reduced: 600},
+	"BG": {standard: 2000, reduced: 900},
+	"CY": {standard: 1900, reduced: 500},
+	"CZ": {standard: 2100, reduced: 1200},
+	"DE": {standard: 1900, reduced: 700},
+	"DK": {standard: 2500},
+	"EE": {standard: 2200, reduced: 900},
+	"ES": {standard: 2100, reduced: 1000, superReduced: 400},
+	"FI": {standard: 2550, reduced: 1400, superReduced: 1000},
+	"FR": {standard: 2000, reduced: 550, superReduced: 210},
+	"GR": {standard: 2400, reduced: 1300, superReduced: 600},
+	"HR": {standard: 2500, reduced: 1300, superReduced: 500},
+	"HU": {standard: 2700, reduced: 1800, superReduced: 500},
+	"IE": {standard: 2300, reduced: 1350, superReduced: 480},
+	"IT": {standard: 2200, reduced: 1000, superReduced: 400},
+	"LT": {standard: 2100, reduced: 900, superReduced: 500},
+	"LU": {standard: 1700, reduced: 800, superReduced: 300},
+	"LV": {standard: 2100, reduced: 1200, superReduced: 500},
+	"MT": {standard: 1800, reduced: 700, superReduced: 500},
+	"NL": {standard: 2100, reduced: 900},
+	"PL": {standard: 2300, reduced: 800, superReduced: 500},
+	"PT": {standard: 2300, reduced: 1300, superReduced: 600},
+	"RO": {standard: 1900, reduced: 900, superReduced: 500},
+	"SE": {standard: 2500, reduced: 1200, superReduced: 600},
+	"SI": {standard: 2200, reduced: 950, superReduced: 500},
+	"SK": {standard: 2300, reduced: 1900, superReduced: 500},
+}
+
+// rate returns the rate of class in the member state.
+func (r vatRate) rate(class string) uint {
+	reduced := r.reduced
+	if reduced == 0 {
+		reduced = r.standard
+	}
+	superReduced := r.superReduced
+	if superReduced == 0 {
+		superReduced = reduced
+	}
+	switch class {
+	case models.TaxClassReduced:
+		return reduced
+	case models.TaxClassSuperReduced:
+		return superReduced
+	case models.TaxClassZero, models.TaxClassExempt:
+		return 0
+	default:
+		return r.standard
+	}
+}
@@ -0,0 +1,233 @@
+// Package tax works out the tax on an order's lines and shipping from each
+// product's tax class and where the order ships to: Indian GST, split into
+// CGST and SGST within the seller's state and charged as IGST across
+// states, and EU VAT at the rate of the member state the goods ship to.
+// Anything else is an export and is not taxed.
+package tax
+
+import (
+	"os"
+	"strings"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+)
+
+// Config is where the seller is and whether catalog prices already include
+// tax. Country is an ISO 3166-1 code and State the ISO 3166-2 subdivision
+// code without the country, such as "KA"; addresses are compared the same
+// way.
+type Config struct {
+	Country          string
+	State            string
+	PricesIncludeTax bool
+}
+
+var config = Config{Country: "IN", PricesIncludeTax: true}
+
+// ConfigureFromEnv reads the seller's location from SELLER_COUNTRY
+// (default IN) and SELLER_STATE, and PRICES_INCLUDE_TAX=false makes tax be
+// charged on top of catalog prices rather than taken out of them.
+func ConfigureFromEnv() {
+	c := Config{
+		Country:          normalize(os.Getenv("SELLER_COUNTRY")),
+		State:            normalize(os.Getenv("SELLER_STATE")),
+		PricesIncludeTax: os.Getenv("PRICES_INCLUDE_TAX") != "false",
+	}
+	if c.Country == "" {
+		c.Country = "IN"
+	}
+	Configure(c)
+}
+
+// Configure replaces the seller configuration.
+func Configure(c Config) {
+	config = c
+}
+
+// Current returns the seller configuration in use.
+func Current() Config {
+	return config
+}
+
+// Line is an amount to be taxed: a line's total after discounts.
+type Line struct {
+	Class  string
+	Amount money.Money
+}
+
+// Result is the tax on each line, in the order of the lines given, and on
+// shipping, together with the order's breakdown by tax and rate.
+type Result struct {
+	Inclusive   bool
+	Lines       [][]models.TaxComponent
+	LineTax     []money.Money
+	Shipping    []models.TaxComponent
+	ShippingTax money.Money
+	Breakdown   []models.TaxComponent
+	Total       money.Money
+}
+
+// Calculate taxes lines and shipping, in currency, for an order shipping
+// to address. Orders without an address, and addresses without a country
+// or state, are taxed as if they shipped within the seller's state.
+// Shipping is taxed at the highest rate of the lines, as the service
+// follows the goods it delivers.
+func Calculate(currency string, address *models.Address, lines []Line, shipping money.Money) Result {
+	j := destination(address)
+	result := Result{
+		Inclusive:   config.PricesIncludeTax,
+		Lines:       make([][]models.TaxComponent, len(lines)),
+		LineTax:     make([]money.Money, len(lines)),
+		ShippingTax: money.Zero(currency),
+		Total:       money.Zero(currency),
+	}
+
+	var highest uint
+	taxed := false
+	for i, line := range lines {
+		rate, ok := j.rate(line.Class)
+		result.LineTax[i] = money.Zero(currency)
+		if !ok {
+			continue
+		}
+		taxed = true
+		if rate > highest {
+			highest = rate
+		}
+		result.Lines[i] = j.components(rate, line.Amount.In(currency), result.Inclusive)
+		for _, component := range result.Lines[i] {
+			result.LineTax[i] = result.LineTax[i].Add(component.Amount)
+		}
+		result.Breakdown = addToBreakdown(result.Breakdown, result.Lines[i])
+	}
+	if taxed && shipping.IsPositive() {
+		result.Shipping = j.components(highest, shipping.In(currency), result.Inclusive)
+		for _, component := range result.Shipping {
+			result.ShippingTax = result.ShippingTax.Add(component.Amount)
+		}
+		result.Breakdown = addToBreakdown(result.Breakdown, result.Shipping)
+	}
+	for _, line := range result.LineTax {
+		result.Total = result.Total.Add(line)
+	}
+	result.Total = result.Total.Add(result.ShippingTax)
+	return result
+}
+
+// jurisdiction is the tax regime an order falls under.
+type jurisdiction struct {
+	kind  string
+	code  string
+	split bool
+	vat   vatRate
+}
+
+const (
+	kindGST    = "gst"
+	kindVAT    = "vat"
+	kindExport = "export"
+)
+
+func destination(address *models.Address) jurisdiction {
+	country, state := config.Country, config.State
+	if address != nil && address.Country != "" {
+		country = normalize(address.Country)
+		state = normalize(address.State)
+	} else if address != nil && address.State != "" {
+		state = normalize(address.State)
+	}
+	if state == "" && country == config.Country {
+		state = config.State
+	}
+
+	if country == "IN" && config.Country == "IN" {
+		return jurisdiction{kind: kindGST, code: "IN-" + state, split: state == config.State}
+	}
+	if rates, ok := vatRates[country]; ok {
+		return jurisdiction{kind: kindVAT, code: country, vat: rates}
+	}
+	return jurisdiction{kind: kindExport, code: country}
+}
+
+// rate returns the rate class is taxed at in j, and false if j does not
+// tax it at all.
+func (j jurisdiction) rate(class string) (uint, bool) {
+	if class == "" {
+		class = models.TaxClassStandard
+	}
+	switch j.kind {
+	case kindGST:
+		rate, ok := gstRates[class]
+		if !ok {
+			rate = gstRates[models.TaxClassStandard]
+		}
+		return rate, true
+	case kindVAT:
+		return j.vat.rate(class), true
+	default:
+		return 0, false
+	}
+}
+
+// components taxes amount at rate. An inclusive amount has the tax taken
+// out of it; otherwise the tax is added on top.
+func (j jurisdiction) components(rate uint, amount money.Money, inclusive bool) []models.TaxComponent {
+	var tax money.Money
+	if inclusive {
+		tax = amount.MulFrac(int64(rate), 10000+int64(rate), money.HalfUp)
+	} else {
+		tax = amount.MulFrac(int64(rate), 10000, money.HalfUp)
+	}
+	taxable := amount
+	if inclusive {
+		taxable = amount.Sub(tax)
+	}
+
+	switch {
+	case j.kind == kindVAT:
+		return []models.TaxComponent{{Name: "VAT", Jurisdiction: j.code, Rate: rate, Taxable: taxable, Amount: tax}}
+	case j.split:
+		halves := tax.Allocate(1, 1)
+		return []models.TaxComponent{
+			{Name: "CGST", Jurisdiction: j.code, Rate: rate / 2, Taxable: taxable, Amount: halves[0]},
+			{Name: "SGST", Jurisdiction: j.code, Rate: rate / 2, Taxable: taxable, Amount: halves[1]},
+		}
+	default:
+		return []models.TaxComponent{{Name: "IGST", Jurisdiction: j.code, Rate: rate, Taxable: taxable, Amount: tax}}
+	}
+}
+
+// Summarize totals components per tax, jurisdiction and rate, the way an
+// order's breakdown is kept.
+func Summarize(components ...[]models.TaxComponent) []models.TaxComponent {
+	var breakdown []models.TaxComponent
+	for _, c := range components {
+		breakdown = addToBreakdown(breakdown, c)
+	}
+	return breakdown
+}
+
+// addToBreakdown adds components to the totals kept per tax, jurisdiction
+// and rate.
+func addToBreakdown(breakdown []models.TaxComponent, components []models.TaxComponent) []models.TaxComponent {
+	for _, component := range components {
+		found := false
+		for i := range breakdown {
+			if breakdown[i].Name == component.Name && breakdown[i].Jurisdiction == component.Jurisdiction && breakdown[i].Rate == component.Rate {
+				breakdown[i].Taxable = breakdown[i].Taxable.Add(component.Taxable)
+				breakdown[i].Amount = breakdown[i].Amount.Add(component.Amount)
+				found = true
+				break
+			}
+		}
+		if !found {
+			breakdown = append(breakdown, component)
+		}
+	}
+	return breakdown
+}
+
+func normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,194 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/kshzz24/ecomm-go/models"
+	"github.com/kshzz24/ecomm-go/money"
+)
+
+// withConfig runs the test with c as the seller configuration.
+func withConfig(t *testing.T, c Config) {
+	t.Helper()
+	previous := Current()
+	Configure(c)
+	t.Cleanup(func() { Configure(previous) })
+}
+
+func inr(amount int64) money.Money { return money.New(amount, "INR") }
+func eur(amount int64) money.Money { return money.New(amount, "EUR") }
+
+// component is the part of a models.TaxComponent the tests compare.
+type component struct {
+	name    string
+	code    string
+	rate    uint
+	taxable int64
+	amount  int64
+}
+
+func components(list []models.TaxComponent) []component {
+	out := make([]component, len(list))
+	for i, c := range list {
+		out[i] = component{c.Name, c.Jurisdiction, c.Rate, c.Taxable.Amount, c.Amount.Amount}
+	}
+	return out
+}
+
+func equalComponents(a, b []component) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCalculateLines(t *testing.T) {
+	karnataka := &models.Address{Country: "IN", State: "KA"}
+	maharashtra := &models.Address{Country: "IN", State: "mh "}
+
+	tests := []struct {
+		name      string
+		inclusive bool
+		currency  string
+		address   *models.Address
+		line      Line
+		want      []component
+	}{
+		{
+			name: "within the seller's state, inclusive", inclusive: true, currency: "INR", address: karnataka,
+			line: Line{Class: models.TaxClassStandard, Amount: inr(118000)},
+			want: []component{{"CGST", "IN-KA", 900, 100000, 9000}, {"SGST", "IN-KA", 900, 100000, 9000}},
+		},
+		{
+			name: "no address is the seller's state", inclusive: true, currency: "INR",
+			line: Line{Amount: inr(118000)},
+			want: []component{{"CGST", "IN-KA", 900, 100000, 9000}, {"SGST", "IN-KA", 900, 100000, 9000}},
+		},
+		{
+			name: "odd paisa goes to CGST", currency: "INR", address: karnataka,
+			line: Line{Class: models.TaxClassStandard, Amount: inr(10005)},
+			want: []component{{"CGST", "IN-KA", 900, 10005, 901}, {"SGST", "IN-KA", 900, 10005, 900}},
+		},
+		{
+			name: "across states, exclusive", currency: "INR", address: maharashtra,
+			line: Line{Class: models.TaxClassStandard, Amount: inr(100000)},
+			want: []component{{"IGST", "IN-MH", 1800, 100000, 18000}},
+		},
+		{
+			name: "luxury", currency: "INR", address: maharashtra,
+			line: Line{Class: models.TaxClassLuxury, Amount: inr(100000)},
+			want: []component{{"IGST", "IN-MH", 2800, 100000, 28000}},
+		},
+		{
+			name: "unknown class is standard", currency: "INR", address: maharashtra,
+			line: Line{Class: "gadgets", Amount: inr(100000)},
+			want: []component{{"IGST", "IN-MH", 1800, 100000, 18000}},
+		},
+		{
+			name: "exempt", currency: "INR", address: maharashtra,
+			line: Line{Class: models.TaxClassExempt, Amount: inr(100000)},
+			want: []component{{"IGST", "IN-MH", 0, 100000, 0}},
+		},
+		{
+			name: "half a paisa rounds up", currency: "INR", address: maharashtra,
+			line: Line{Class: models.TaxClassStandard, Amount: inr(25)},
+			want: []component{{"IGST", "IN-MH", 1800, 25, 5}},
+		},
+		{
+			name: "EU reduced rate", currency: "EUR", address: &models.Address{Country: "DE"},
+			line: Line{Class: models.TaxClassReduced, Amount: eur(10000)},
+			want: []component{{"VAT", "DE", 700, 10000, 700}},
+		},
+		{
+			name: "EU inclusive", inclusive: true, currency: "EUR", address: &models.Address{Country: "DE"},
+			line: Line{Class: models.TaxClassStandard, Amount: eur(11900)},
+			want: []component{{"VAT", "DE", 1900, 10000, 1900}},
+		},
+		{
+			name: "EU super-reduced", currency: "EUR", address: &models.Address{Country: "FR"},
+			line: Line{Class: models.TaxClassSuperReduced, Amount: eur(10000)},
+			want: []component{{"VAT", "FR", 210, 10000, 210}},
+		},
+		{
+			name: "no super-reduced rate falls back to reduced", currency: "EUR", address: &models.Address{Country: "DE"},
+			line: Line{Class: models.TaxClassSuperReduced, Amount: eur(10000)},
+			want: []component{{"VAT", "DE", 700, 10000, 700}},
+		},
+		{
+			name: "no reduced rate falls back to standard", currency: "EUR", address: &models.Address{Country: "DK"},
+			line: Line{Class: models.TaxClassReduced, Amount: eur(10000)},
+			want: []component{{"VAT", "DK", 2500, 10000, 2500}},
+		},
+		{
+			name: "export is not taxed", currency: "USD", address: &models.Address{Country: "US", State: "CA"},
+			line: Line{Class: models.TaxClassStandard, Amount: money.New(10000, "USD")},
+			want: []component{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, Config{Country: "IN", State: "KA", PricesIncludeTax: tt.inclusive})
+			result := Calculate(tt.currency, tt.address, []Line{tt.line}, money.Zero(tt.currency))
+
+			if got := components(result.Lines[0]); !equalComponents(got, tt.want) {
+				t.Errorf("components = %+v, want %+v", got, tt.want)
+			}
+			var want int64
+			for _, c := range tt.want {
+				want += c.amount
+			}
+			if result.LineTax[0].Amount != want || result.Total.Amount != want {
+				t.Errorf("line tax = %v, total = %v, want %d", result.LineTax[0], result.Total, want)
+			}
+			if result.Total.Currency != tt.currency {
+				t.Errorf("total currency = %q, want %q", result.Total.Currency, tt.currency)
+			}
+		})
+	}
+}
+
+func TestCalculateShipping(t *testing.T) {
+	withConfig(t, Config{Country: "IN", State: "KA"})
+	maharashtra := &models.Address{Country: "IN", State: "MH"}
+
+	t.Run("taxed at the highest line rate", func(t *testing.T) {
+		lines := []Line{
+			{Class: models.TaxClassReduced, Amount: inr(10000)},
+			{Class: models.TaxClassStandard, Amount: inr(20000)},
+		}
+		result := Calculate("INR", maharashtra, lines, inr(5000))
+
+		if got, want := components(result.Shipping), []component{{"IGST", "IN-MH", 1800, 5000, 900}}; !equalComponents(got, want) {
+			t.Errorf("shipping components = %+v, want %+v", got, want)
+		}
+		wantBreakdown := []component{
+			{"IGST", "IN-MH", 1200, 10000, 1200},
+			{"IGST", "IN-MH", 1800, 25000, 4500},
+		}
+		if got := components(result.Breakdown); !equalComponents(got, wantBreakdown) {
+			t.Errorf("breakdown = %+v, want %+v", got, wantBreakdown)
+		}
+		if result.Total != inr(5700) {
+			t.Errorf("total = %v, want INR 57.00", result.Total)
+		}
+	})
+
+	t.Run("not taxed on an export", func(t *testing.T) {
+		result := Calculate("USD", &models.Address{Country: "US"}, []Line{{Amount: money.New(10000, "USD")}}, money.New(500, "USD"))
+		if len(result.Shipping) != 0 || !result.ShippingTax.IsZero() || !result.Total.IsZero() {
+			t.Errorf("export taxed: shipping %+v, total %v", result.Shipping, result.Total)
+		}
+	})
+
+	t.Run("free shipping has no tax", func(t *testing.T) {
+		result := Calculate("INR", maharashtra, []Line{{Amount: inr(10000)}}, inr(0))
+		if len(result.Shipping) != 0 || !result.ShippingTax.IsZero() {
+			t.Errorf("free shipping taxed: %+v", result.Shipping)
+		}
+	})
+}
@@ -19,18 +19,20 @@ type SignedDetails struct {
 	FirstName string
 	LastName  string
 	Uid       string
+	UserType  string
 	jwt.StandardClaims
 }
 
 var SECRET_KEY = os.Getenv("SECRET_KEY")
 var UserData *mongo.Collection = database.UserData(database.Client, "Users")
 
-func TokenGenerator(email string, firstName string, lastName string, uid string) (signedToken string, signedRefreshToken string, err error) {
+func TokenGenerator(email string, firstName string, lastName string, uid string, userType string) (signedToken string, signedRefreshToken string, err error) {
 	claims := &SignedDetails{
 		Email:     email,
 		FirstName: firstName,
 		LastName:  lastName,
 		Uid:       uid,
+		UserType:  userType,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
 		},